@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// An age identity is the X25519 encryption subkey's secret scalar, and
+// its recipient the public key, each encoded with Bech32 (BIP 173).
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{
+		0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3,
+	}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := uint(0); i < 5; i++ {
+			if top>>i&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// Encode data with a lowercase human-readable part. Unlike BIP 173,
+// there's no length limit, as in age.
+func bech32Encode(hrp string, data []byte) string {
+	// Regroup 8-bit bytes into 5-bit values, zero padded
+	var values []byte
+	var acc, bits uint
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		for bits += 8; bits >= 5; bits -= 5 {
+			values = append(values, byte(acc>>(bits-5)&31))
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits)&31))
+	}
+
+	var check []byte
+	for _, c := range []byte(hrp) {
+		check = append(check, c>>5)
+	}
+	check = append(check, 0)
+	for _, c := range []byte(hrp) {
+		check = append(check, c&31)
+	}
+	check = append(check, values...)
+	mod := bech32Polymod(append(check, 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := uint(0); i < 6; i++ {
+		values = append(values, byte(mod>>(5*(5-i))&31))
+	}
+
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+	return b.String()
+}
+
+func ageRecipient(pub []byte) string {
+	return bech32Encode("age", pub)
+}
+
+// Write an identity file as age-keygen does, but dated by the subkey.
+func secAge(pub, sec []byte, created int64) []byte {
+	var b strings.Builder
+	date := time.Unix(created, 0).UTC().Format(time.RFC3339)
+	b.WriteString("# created: " + date + "\n")
+	b.WriteString("# public key: " + ageRecipient(pub) + "\n")
+	b.WriteString(strings.ToUpper(bech32Encode("age-secret-key-", sec)))
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+func pubAge(pub []byte) []byte {
+	return []byte(ageRecipient(pub) + "\n")
+}
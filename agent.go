@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// More SSH agent protocol message numbers (see addSSHAgent).
+const (
+	sshAgentRequestIdentities = 11
+	sshAgentIdentitiesAnswer  = 12
+	sshAgentSignRequest       = 13
+	sshAgentSignResponse      = 14
+
+	sshAgentMaxMessage = 256 * 1024
+)
+
+// sshAgent answers SSH agent requests with a single Ed25519 key. It
+// only lists the key and signs with it. Everything else, such as
+// adding or removing keys, fails.
+type sshAgent struct {
+	key     ed25519.PrivateKey
+	comment []byte
+	verbose func(format string, args ...interface{})
+}
+
+// Serve agent connections until the listener is closed.
+func (a *sshAgent) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			a.handle(conn)
+		}()
+	}
+}
+
+// The public key blob identifying the key.
+func (a *sshAgent) blob() []byte {
+	var b bytes.Buffer
+	sshPut(&b, []byte("ssh-ed25519"))
+	sshPut(&b, a.key.Public().(ed25519.PublicKey))
+	return b.Bytes()
+}
+
+// Handle requests on one connection until it's closed.
+func (a *sshAgent) handle(rw io.ReadWriter) error {
+	for {
+		var n [4]byte
+		if _, err := io.ReadFull(rw, n[:]); err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(n[:])
+		if size == 0 || size > sshAgentMaxMessage {
+			return errors.New("invalid SSH agent message length")
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(rw, msg); err != nil {
+			return err
+		}
+
+		var reply bytes.Buffer
+		a.respond(&reply, msg)
+		binary.BigEndian.PutUint32(n[:], uint32(reply.Len()))
+		if _, err := rw.Write(append(n[:], reply.Bytes()...)); err != nil {
+			return err
+		}
+	}
+}
+
+// Write the reply to a single request message.
+func (a *sshAgent) respond(reply *bytes.Buffer, msg []byte) {
+	switch msg[0] {
+	case sshAgentRequestIdentities:
+		reply.WriteByte(sshAgentIdentitiesAnswer)
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], 1)
+		reply.Write(n[:])
+		sshPut(reply, a.blob())
+		sshPut(reply, a.comment)
+		return
+
+	case sshAgentSignRequest:
+		blob, rest, ok := sshGet(msg[1:])
+		if !ok || !bytes.Equal(blob, a.blob()) {
+			break
+		}
+		data, _, ok := sshGet(rest)
+		if !ok {
+			break
+		}
+		if a.verbose != nil {
+			a.verbose("agent: signing %d bytes\n", len(data))
+		}
+		var sig bytes.Buffer
+		sshPut(&sig, []byte("ssh-ed25519"))
+		sshPut(&sig, ed25519.Sign(a.key, data))
+		reply.WriteByte(sshAgentSignResponse)
+		sshPut(reply, sig.Bytes())
+		return
+	}
+	reply.WriteByte(sshAgentFailure)
+}
+
+// Append a length-prefixed SSH string.
+func sshPut(b *bytes.Buffer, field []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(field)))
+	b.Write(n[:])
+	b.Write(field)
+}
+
+// Split a length-prefixed SSH string from the front of a message.
+func sshGet(msg []byte) (field, rest []byte, ok bool) {
+	if len(msg) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(msg)
+	if uint64(n) > uint64(len(msg)-4) {
+		return nil, nil, false
+	}
+	return msg[4 : 4+n], msg[4+n:], true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Seeds cached by --cache live in the operating system's credential
+// store, named by a hash of every derivation input but the passphrase,
+// with an expiration time in the stored value, "EXPIRES:SEED" (decimal
+// Unix time, hexadecimal seed). Stores lacking their own expiration
+// rely on this time, and a stale entry is deleted when next seen.
+
+var errCacheMiss = errors.New("not in credential store")
+
+// Name the cache entry for the derivation configured.
+func cacheName(config *config) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %+v %x %q",
+		config.uids[0], config.kdf, config.keyfile, config.fido2)
+	return fmt.Sprintf("passphrase2pgp-%x", h.Sum(nil)[:16])
+}
+
+// Encode a seed and its expiration time as a cache value.
+func cacheEncode(seed []byte, expires int64) []byte {
+	value := make([]byte, 0, 32+2*len(seed))
+	lockMemory(value[:cap(value)])
+	value = strconv.AppendInt(value, expires, 10)
+	value = append(value, ':')
+	return appendHex(value, seed)
+}
+
+// Decode a cache value into its seed and expiration time.
+func cacheDecode(value []byte) (seed []byte, expires int64, ok bool) {
+	i := bytes.IndexByte(value, ':')
+	if i < 0 {
+		return nil, 0, false
+	}
+	expires, err := strconv.ParseInt(string(value[:i]), 10, 64)
+	if err != nil {
+		return nil, 0, false
+	}
+	seed = make([]byte, hex.DecodedLen(len(value)-i-1))
+	keepSecret(seed)
+	if _, err := hex.Decode(seed, value[i+1:]); err != nil || len(seed) == 0 {
+		wipe(seed)
+		return nil, 0, false
+	}
+	return seed, expires, true
+}
+
+// Load a cached seed, returning nil if absent or expired.
+func cacheLoad(name string) ([]byte, error) {
+	value, err := credentialLoad(name)
+	if err == errCacheMiss {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	seed, expires, ok := cacheDecode(value)
+	wipe(value)
+	if !ok || time.Now().Unix() >= expires {
+		wipe(seed)
+		return nil, cacheClear(name)
+	}
+	return seed, nil
+}
+
+// Cache a seed for the given time.
+func cacheStore(name string, seed []byte, ttl time.Duration) error {
+	value := cacheEncode(seed, time.Now().Add(ttl).Unix())
+	defer wipe(value)
+	return credentialStore(name, value, ttl)
+}
+
+// Remove a cached seed, if any.
+func cacheClear(name string) error {
+	err := credentialDelete(name)
+	if err == errCacheMiss {
+		return nil
+	}
+	return err
+}
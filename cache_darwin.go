@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// On macOS the credential store is the login keychain, accessed with
+// the security tool. The keychain has no expiration of its own.
+
+const keychainService = "passphrase2pgp"
+
+// Run security, returning its output or its error message.
+func security(stdin []byte, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == 44 {
+		return nil, errCacheMiss // errSecItemNotFound
+	} else if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func credentialStore(name string, value []byte, ttl time.Duration) error {
+	// Passed on standard input ("interactive" mode) so that the secret
+	// never appears in a command line
+	command := make([]byte, 0, 256)
+	lockMemory(command[:cap(command)])
+	command = append(command, "add-generic-password -U -s "+
+		keychainService+" -a "+name+" -w "...)
+	command = append(command, value...)
+	command = append(command, '\n')
+	defer wipe(command)
+	_, err := security(command, "-i")
+	return err
+}
+
+func credentialLoad(name string) ([]byte, error) {
+	out, err := security(nil, "find-generic-password",
+		"-s", keychainService, "-a", name, "-w")
+	if err != nil {
+		return nil, err
+	}
+	lockMemory(out)
+	return bytes.TrimRight(out, "\n"), nil
+}
+
+func credentialDelete(name string) error {
+	_, err := security(nil, "delete-generic-password",
+		"-s", keychainService, "-a", name)
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// On Linux the credential store is the kernel's per-user keyring, which
+// holds keys only in memory and expires them itself.
+
+const (
+	keySpecUserKeyring = -4
+
+	keyctlSetPerm    = 5
+	keyctlRead       = 11
+	keyctlSearch     = 10
+	keyctlSetTimeout = 15
+	keyctlInvalidate = 21
+
+	// Possessor and user may do anything (view, read, write, search,
+	// link, setattr). The user keyring isn't always possessed.
+	keyPerm = 0x3f3f0000
+)
+
+func keyctl(op int, args ...uintptr) (uintptr, error) {
+	var a [4]uintptr
+	copy(a[:], args)
+	r, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL,
+		uintptr(op), a[0], a[1], a[2], a[3], 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// Find a "user" key by description in the user keyring.
+func keySearch(name string) (uintptr, error) {
+	typ, _ := syscall.BytePtrFromString("user")
+	desc, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	ring := keySpecUserKeyring
+	id, err := keyctl(keyctlSearch, uintptr(ring),
+		uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)), 0)
+	switch err {
+	case syscall.ENOKEY, syscall.EKEYEXPIRED, syscall.EKEYREVOKED:
+		// Invalidated keys linger as revoked until garbage collected
+		return 0, errCacheMiss
+	}
+	return id, err
+}
+
+func credentialStore(name string, value []byte, ttl time.Duration) error {
+	typ, _ := syscall.BytePtrFromString("user")
+	desc, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	ring := keySpecUserKeyring
+	id, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY,
+		uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(desc)),
+		uintptr(unsafe.Pointer(&value[0])), uintptr(len(value)),
+		uintptr(ring), 0)
+	if errno != 0 {
+		return errno
+	}
+	if _, err := keyctl(keyctlSetPerm, id, keyPerm); err != nil {
+		return err
+	}
+	secs := (ttl + time.Second - 1) / time.Second
+	if secs < 1 {
+		secs = 1 // zero means never expire
+	}
+	_, err = keyctl(keyctlSetTimeout, id, uintptr(secs))
+	return err
+}
+
+func credentialLoad(name string) ([]byte, error) {
+	id, err := keySearch(name)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, 256)
+	lockMemory(value)
+	n, err := keyctl(keyctlRead, id,
+		uintptr(unsafe.Pointer(&value[0])), uintptr(len(value)))
+	if err == syscall.EKEYEXPIRED || err == syscall.EKEYREVOKED {
+		return nil, errCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	if int(n) > len(value) {
+		wipe(value)
+		return nil, syscall.EMSGSIZE // not one of ours
+	}
+	return value[:n], nil
+}
+
+func credentialDelete(name string) error {
+	id, err := keySearch(name)
+	if err != nil {
+		return err
+	}
+	_, err = keyctl(keyctlInvalidate, id)
+	return err
+}
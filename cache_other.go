@@ -0,0 +1,56 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Elsewhere the credential store is the Secret Service (GNOME Keyring,
+// KWallet, etc.) through libsecret's secret-tool, which has no
+// expiration of its own.
+
+// Run secret-tool, returning its output or its error message.
+func secretTool(stdin []byte, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func credentialStore(name string, value []byte, ttl time.Duration) error {
+	_, err := secretTool(value, "store", "--label=passphrase2pgp",
+		"service", "passphrase2pgp", "account", name)
+	return err
+}
+
+func credentialLoad(name string) ([]byte, error) {
+	out, err := secretTool(nil, "lookup",
+		"service", "passphrase2pgp", "account", name)
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil, errCacheMiss // exits quietly when not found
+	} else if err != nil {
+		return nil, err
+	}
+	lockMemory(out)
+	return out, nil
+}
+
+func credentialDelete(name string) error {
+	_, err := secretTool(nil, "clear",
+		"service", "passphrase2pgp", "account", name)
+	return err
+}
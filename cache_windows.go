@@ -0,0 +1,102 @@
+package main
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// On Windows the credential store is the Credential Manager, with each
+// entry kept only for the logon session. It has no expiration of its
+// own.
+
+var (
+	advapi32   = syscall.NewLazyDLL("advapi32.dll")
+	credWrite  = advapi32.NewProc("CredWriteW")
+	credRead   = advapi32.NewProc("CredReadW")
+	credDelete = advapi32.NewProc("CredDeleteW")
+	credFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric    = 1
+	credPersistSession = 1
+	errorNotFound      = 1168
+)
+
+// CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// Convert a failed call's error, mapping "not found" to a cache miss.
+func credentialErr(err error) error {
+	if errno, ok := err.(syscall.Errno); ok && errno == errorNotFound {
+		return errCacheMiss
+	}
+	return err
+}
+
+func credentialStore(name string, value []byte, ttl time.Duration) error {
+	target, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(value)),
+		CredentialBlob:     &value[0],
+		Persist:            credPersistSession,
+	}
+	r, _, err := credWrite.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func credentialLoad(name string) ([]byte, error) {
+	target, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	var cred *credential
+	r, _, err := credRead.Call(uintptr(unsafe.Pointer(target)),
+		credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		return nil, credentialErr(err)
+	}
+	defer credFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))
+	value := make([]byte, cred.CredentialBlobSize)
+	lockMemory(value)
+	copy(value, blob[:len(value):len(value)])
+	wipe(blob[:len(value):len(value)])
+	return value, nil
+}
+
+func credentialDelete(name string) error {
+	target, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	r, _, err := credDelete.Call(uintptr(unsafe.Pointer(target)),
+		credTypeGeneric, 0)
+	if r == 0 {
+		return credentialErr(err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OpenPGP cards are reached through GnuPG's scdaemon by way of
+// gpg-agent, which owns the PC/SC connection and prompts for the
+// card's Admin PIN. gpg-agent only moves keys to a card from its own
+// key store, so each key is written there briefly and removed as soon
+// as it's on the card.
+
+// A key destined for one of the card's three slots.
+type cardKey struct {
+	ref     string // scdaemon key reference
+	tag     byte   // Data Object holding the slot's fingerprint
+	key     agentKey
+	fpr     []byte
+	created int64
+}
+
+// The keys for each card slot: signing, encryption, and authentication.
+// With a signing subkey, the primary key stays off the card.
+func (k *completeKey) cardKeys(config *config) []cardKey {
+	signer := k.key
+	if config.signSub {
+		signer = k.signsub
+	}
+	keys := []cardKey{{
+		"OPENPGP.1", 0xc7, newAgentSignKey(signer),
+		signer.KeyID(), signer.Created(),
+	}}
+	if config.subkey {
+		keys = append(keys, cardKey{
+			"OPENPGP.2", 0xc8, newAgentEncryptKey(k.subkey),
+			k.subkey.KeyID(), k.subkey.Created(),
+		})
+	}
+	if config.auth {
+		keys = append(keys, cardKey{
+			"OPENPGP.3", 0xc9, newAgentSignKey(k.authkey),
+			k.authkey.KeyID(), k.authkey.Created(),
+		})
+	}
+	return keys
+}
+
+// Write the keys to the inserted OpenPGP card, returning its serial
+// number.
+func (k *completeKey) toCard(config *config) (string, error) {
+	home, err := gpgconfDir("homedir")
+	if err != nil {
+		return "", err
+	}
+	agent, err := dialGPGAgent()
+	if err != nil {
+		return "", err
+	}
+	defer agent.Close()
+
+	keys := k.cardKeys(config)
+	defer func() {
+		for _, key := range keys {
+			wipe(key.key.file)
+		}
+	}()
+	return writeCard(agent, filepath.Join(home, "private-keys-v1.d"), keys)
+}
+
+// Move each key to the card through gpg-agent's key store in dir.
+func writeCard(agent *gpgAgent, dir string, keys []cardKey) (string, error) {
+	_, status, err := agent.transact("SCD SERIALNO openpgp")
+	if err != nil {
+		return "", err
+	}
+	var serial string
+	for _, line := range status {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "SERIALNO" {
+			serial = fields[1]
+		}
+	}
+	if serial == "" {
+		return "", errors.New("no OpenPGP card found")
+	}
+
+	for _, key := range keys {
+		path, err := key.key.write(dir)
+		if err != nil {
+			return serial, err
+		}
+		err = keyToCard(agent, serial, &key)
+		if rerr := os.Remove(path); err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return serial, fmt.Errorf("%s: %s", key.ref, err)
+		}
+	}
+
+	// Restart scdaemon to drop the fingerprints it cached, then have
+	// gpg-agent record (as stubs) which keys now live on the card
+	if _, _, err := agent.transact("SCD KILLSCD"); err != nil {
+		return serial, err
+	}
+	_, _, err = agent.transact("LEARN --force")
+	return serial, err
+}
+
+// Move one key from gpg-agent's key store to its card slot. Existing
+// card keys are never replaced.
+func keyToCard(agent *gpgAgent, serial string, key *cardKey) error {
+	created := time.Unix(key.created, 0).UTC().Format("20060102T150405")
+	command := fmt.Sprintf("KEYTOCARD %X %s %s %s",
+		key.key.grip, serial, key.ref, created)
+	if _, _, err := agent.transact(command); err != nil {
+		return err
+	}
+
+	// scdaemon computes the fingerprint itself, assuming AES-128 key
+	// wrapping for encryption keys, so store the real one (PUT DATA)
+	apdu := fmt.Sprintf("SCD APDU 00DA00%02X%02X%X", key.tag, len(key.fpr), key.fpr)
+	resp, _, err := agent.transact(apdu)
+	if err != nil {
+		return err
+	}
+	if n := len(resp); n < 2 || resp[n-2] != 0x90 || resp[n-1] != 0x00 {
+		return fmt.Errorf("card rejected fingerprint (status %X)", resp)
+	}
+	return nil
+}
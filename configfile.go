@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nullprogram.com/x/optparse"
+)
+
+// The config file holds default options, one long option per line,
+// written without dashes as "name = value", or just "name" for options
+// without an argument. Blank lines and lines starting with # are
+// ignored:
+//
+//     # ~/.config/passphrase2pgp/config
+//     uid = Real Name <name@example.com>
+//     time = 1577836800
+//     kdf-memory = 2048
+//     armor
+//
+// An option given on the command line replaces all of its config file
+// entries, so a --uid there replaces every configured uid.
+
+// Path to the config file, or empty if there's no place for one.
+func configPath() string {
+	if path := os.Getenv("PASSPHRASE2PGP_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "passphrase2pgp", "config")
+}
+
+// Convert the lines of a config file to command line arguments.
+func configArgs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var args []string
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, value := line, ""
+		i := strings.IndexByte(line, '=')
+		if i >= 0 {
+			name = strings.TrimSpace(line[:i])
+			value = strings.TrimSpace(line[i+1:])
+		}
+		if name == "" || strings.HasPrefix(name, "-") {
+			return nil, fmt.Errorf("%s:%d: invalid option %q", path, n, name)
+		}
+		if i >= 0 {
+			args = append(args, "--"+name+"="+value)
+		} else {
+			args = append(args, "--"+name)
+		}
+	}
+	return args, s.Err()
+}
+
+// Parse the options in the config file, if it exists. Commands and
+// options that only make sense once cannot be configured.
+func configOptions(options []optparse.Option) []optparse.Result {
+	path := configPath()
+	if path == "" {
+		return nil
+	}
+	args, err := configArgs(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		fatal("%s", err)
+	}
+
+	results, rest, err := optparse.Parse(options, append([]string{""}, args...))
+	if err != nil {
+		fatalUsage("%s: %s", path, err)
+	}
+	if len(rest) > 0 {
+		fatalUsage("%s: invalid option %q", path, rest[0])
+	}
+	for _, result := range results {
+		if _, ok := subcommands[result.Long]; ok {
+			fatalUsage("%s: %s is a command, not an option", path, result.Long)
+		}
+		switch result.Long {
+		case "input", "output", "load", "seed", "seed-file":
+			fatalUsage("%s: --%s cannot be configured", path, result.Long)
+		}
+	}
+	return results
+}
+
+// Combine config file and command line options, the latter overriding.
+func mergeOptions(config, cmdline []optparse.Result) []optparse.Result {
+	given := make(map[string]bool)
+	for _, result := range cmdline {
+		given[result.Long] = true
+	}
+	var merged []optparse.Result
+	for _, result := range config {
+		if !given[result.Long] {
+			merged = append(merged, result)
+		}
+	}
+	return append(merged, cmdline...)
+}
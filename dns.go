@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"nullprogram.com/x/passphrase2pgp/openpgp"
+)
+
+// Returns the RFC 7929 OPENPGPKEY owner name for an email address: the
+// SHA-256 hash of the local part, truncated to 28 octets, under the
+// _openpgpkey subdomain. Like GnuPG, the local part is lowercased.
+func dnsOwner(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	local := strings.ToLower(email[:at])
+	domain := strings.ToLower(email[at+1:])
+	sum := sha256.Sum256([]byte(local))
+	return hex.EncodeToString(sum[:28]) + "._openpgpkey." + domain + "."
+}
+
+// Format an OPENPGPKEY zone file record of a binary public key for each
+// user ID with an email address.
+func dnsRecords(key []byte, userids []openpgp.UserID) ([]string, error) {
+	var records []string
+	rdata := base64.StdEncoding.EncodeToString(key)
+	for _, userid := range userids {
+		email := uidEmail(string(userid.ID))
+		if email == "" {
+			continue
+		}
+		record := fmt.Sprintf("%s IN OPENPGPKEY %s", dnsOwner(email), rdata)
+		records = append(records, record)
+	}
+	if records == nil {
+		return nil, errors.New("no user ID has an email address")
+	}
+	return records, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FIDO2 security keys as a second factor, driven through libfido2's
+// command line tools (fido2-token, fido2-cred, fido2-assert). A token's
+// hmac-secret extension maps a salt to a secret that only that token
+// can compute, but each token computes a different one. So the secret
+// mixed into derivation is random, and a token file keeps a copy of it
+// for each registered token, encrypted (XOR) with its hmac-secret:
+//
+//     # passphrase2pgp FIDO2 tokens
+//     CREDENTIAL-ID WRAPPED-SECRET
+//
+// Both fields are base64. The file is useless without a registered
+// token, but the key cannot be derived without the file.
+
+const fido2RP = "passphrase2pgp" // relying party ID
+
+var fido2Salt = sha256.Sum256([]byte("passphrase2pgp hmac-secret"))
+
+type fido2Token struct {
+	credential []byte
+	wrapped    []byte
+}
+
+// Parse the tokens in a token file.
+func parseFido2Tokens(text string) ([]fido2Token, error) {
+	var tokens []fido2Token
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: invalid token", i+1)
+		}
+		credential, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(wrapped) != 32 {
+			return nil, fmt.Errorf("line %d: invalid secret", i+1)
+		}
+		tokens = append(tokens, fido2Token{credential, wrapped})
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no tokens registered")
+	}
+	return tokens, nil
+}
+
+// String encodes the token as a line of a token file.
+func (t fido2Token) String() string {
+	return base64.StdEncoding.EncodeToString(t.credential) + " " +
+		base64.StdEncoding.EncodeToString(t.wrapped) + "\n"
+}
+
+// Run a libfido2 tool, passing it lines on standard input and returning
+// its output lines. It prompts on the terminal for a PIN if needed.
+func fido2Tool(name string, input []string, args ...string) ([]string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(strings.Join(input, "\n") + "\n")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return strings.Split(strings.TrimRight(string(out), "\n"), "\n"), nil
+}
+
+// List the paths of the connected FIDO2 devices.
+func fido2Devices() ([]string, error) {
+	lines, err := fido2Tool("fido2-token", nil, "-L")
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, line := range lines {
+		// "PATH: vendor=0x1050, product=0x0407 (...)"
+		if i := strings.Index(line, ": "); i > 0 {
+			devices = append(devices, line[:i])
+		}
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no FIDO2 security key found")
+	}
+	return devices, nil
+}
+
+// A random client data hash, needed by the protocol but not used since
+// signatures go unchecked.
+func fido2Challenge() string {
+	var cdh [32]byte
+	if _, err := rand.Read(cdh[:]); err != nil {
+		panic(err) // should never happen
+	}
+	return base64.StdEncoding.EncodeToString(cdh[:])
+}
+
+// Create a credential with hmac-secret on a device, returning its ID.
+func fido2MakeCredential(device string) ([]byte, error) {
+	var user [32]byte
+	if _, err := rand.Read(user[:]); err != nil {
+		panic(err) // should never happen
+	}
+	input := []string{
+		fido2Challenge(),
+		fido2RP,
+		fido2RP, // user name
+		base64.StdEncoding.EncodeToString(user[:]),
+	}
+	lines, err := fido2Tool("fido2-cred", input, "-M", "-h", device)
+	if err != nil {
+		return nil, err
+	}
+	// client data hash, RP ID, format, auth data, credential ID, ...
+	if len(lines) < 5 {
+		return nil, errors.New("fido2-cred: truncated output")
+	}
+	return base64.StdEncoding.DecodeString(lines[4])
+}
+
+// Compute a credential's hmac-secret on a device.
+func fido2HMAC(device string, credential []byte) ([]byte, error) {
+	input := []string{
+		fido2Challenge(),
+		fido2RP,
+		base64.StdEncoding.EncodeToString(credential),
+		base64.StdEncoding.EncodeToString(fido2Salt[:]),
+	}
+	lines, err := fido2Tool("fido2-assert", input, "-G", "-h", device)
+	if err != nil {
+		return nil, err
+	}
+	// The hmac-secret is the last line
+	secret, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(secret) != 32 {
+		return nil, errors.New("fido2-assert: invalid hmac-secret")
+	}
+	lockMemory(secret)
+	return secret, nil
+}
+
+// XOR the hmac-secret into dst.
+func fido2Xor(dst, hmac []byte) {
+	for i := range dst {
+		dst[i] ^= hmac[i]
+	}
+}
+
+// Recover the secret from a token file with any connected token
+// registered in it. The notify function is called before each attempt.
+func fido2Secret(filename string, notify func(device string)) ([]byte, error) {
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := parseFido2Tokens(string(text))
+	if err != nil {
+		return nil, err
+	}
+	devices, err := fido2Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, device := range devices {
+		for _, token := range tokens {
+			notify(device)
+			hmac, err := fido2HMAC(device, token.credential)
+			if err != nil {
+				continue // not registered with this token
+			}
+			secret := make([]byte, len(token.wrapped))
+			lockMemory(secret)
+			copy(secret, token.wrapped)
+			fido2Xor(secret, hmac)
+			wipe(hmac)
+			return secret, nil
+		}
+	}
+	return nil, errors.New("no registered security key found")
+}
+
+// Register every connected token in a token file, creating it with a
+// new random secret if it doesn't exist. Returns the number registered.
+func fido2Register(filename string, notify func(device string)) (int, error) {
+	var secret []byte
+	var buf bytes.Buffer
+	_, err := os.Stat(filename)
+	switch {
+	case err == nil:
+		// Extend the existing file with its secret
+		secret, err = fido2Secret(filename, notify)
+		if err != nil {
+			return 0, err
+		}
+	case os.IsNotExist(err):
+		buf.WriteString("# passphrase2pgp FIDO2 tokens\n")
+		secret = make([]byte, 32)
+		lockMemory(secret)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err) // should never happen
+		}
+	default:
+		return 0, err
+	}
+	defer wipe(secret)
+
+	devices, err := fido2Devices()
+	if err != nil {
+		return 0, err
+	}
+	for _, device := range devices {
+		notify(device)
+		credential, err := fido2MakeCredential(device)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", device, err)
+		}
+		notify(device)
+		hmac, err := fido2HMAC(device, credential)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", device, err)
+		}
+		wrapped := append([]byte(nil), secret...)
+		fido2Xor(wrapped, hmac)
+		wipe(hmac)
+		buf.WriteString(fido2Token{credential, wrapped}.String())
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	f, err := os.OpenFile(filename, flags, 0600)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return len(devices), f.Close()
+}
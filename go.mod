@@ -4,5 +4,7 @@ go 1.14
 
 require (
 	golang.org/x/crypto v0.0.0-20220919173607-35f4265a4bc0
+	golang.org/x/term v0.10.0 // indirect
+	golang.org/x/text v0.13.0
 	nullprogram.com/x/optparse v1.0.0
 )
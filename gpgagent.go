@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"nullprogram.com/x/passphrase2pgp/openpgp"
+)
+
+// gpg-agent identifies keys by keygrip, libgcrypt's SHA-1 hash of the
+// curve parameters and public key, and stores each secret key as
+// private-keys-v1.d/KEYGRIP.key in its "extended" S-expression format.
+
+// errAgentProtocol means gpg-agent responded incorrectly.
+var errAgentProtocol = errors.New("gpg-agent protocol error")
+
+// Curve parameters hashed into a keygrip, in order: p, a, b, g, n.
+// Negative parameters, as libgcrypt defines Ed25519's a and b, are
+// hashed by magnitude.
+var (
+	keygripEd25519 = [5]string{
+		"7FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFED",
+		"01",
+		"2DFC9311D490018C7338BF8688861767FF8FF5B2BEBE27548A14B235ECA6874A",
+		"04" +
+			"216936D3CD6E53FEC0A4E231FDD6DC5C692CC7609525A7B2C9562D608F25D51A" +
+			"6666666666666666666666666666666666666666666666666666666666666658",
+		"1000000000000000000000000000000014DEF9DEA2F79CD65812631A5CF5D3ED",
+	}
+	keygripCurve25519 = [5]string{
+		"7FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFED",
+		"01DB41",
+		"01",
+		"04" +
+			"0000000000000000000000000000000000000000000000000000000000000009" +
+			"20AE19A1B8A086B4E01EDD2C7748D14C923D4D7E6D7C61B229E9C5A27ECED3D9",
+		"1000000000000000000000000000000014DEF9DEA2F79CD65812631A5CF5D3ED",
+	}
+)
+
+// Compute the keygrip of a public key (without 0x40 prefix) on a curve.
+func keygrip(params *[5]string, pub []byte) []byte {
+	h := sha1.New()
+	for i, name := range "pabgn" {
+		v, _ := new(big.Int).SetString(params[i], 16)
+		raw := v.Bytes()
+		if name == 'g' {
+			raw = make([]byte, 65) // keep the leading 04
+			v.FillBytes(raw)
+		}
+		fmt.Fprintf(h, "(1:%c%d:", name, len(raw))
+		h.Write(raw)
+		h.Write([]byte(")"))
+	}
+	fmt.Fprintf(h, "(1:q%d:", len(pub))
+	h.Write(pub)
+	h.Write([]byte(")"))
+	return h.Sum(nil)
+}
+
+// Format a secret key for private-keys-v1.d, laid out as GnuPG does.
+// The secret is as encoded in an OpenPGP secret key packet.
+func agentKeyFile(curve, flags string, pub, sec []byte) []byte {
+	buf := make([]byte, 0, 256)
+	lockMemory(buf[:cap(buf)])
+	buf = append(buf, "Key: (private-key (ecc (curve "+curve+
+		")(flags "+flags+")(q\n  #40"...)
+	buf = appendHex(buf, pub)
+	buf = append(buf, "#)\n (d #"...)
+	buf = appendHex(buf, sec)
+	return append(buf, "#)\n ))\n"...)
+}
+
+// Append the upper case hexadecimal encoding of src.
+func appendHex(dst, src []byte) []byte {
+	const digits = "0123456789ABCDEF"
+	for _, b := range src {
+		dst = append(dst, digits[b>>4], digits[b&15])
+	}
+	return dst
+}
+
+// A secret key in gpg-agent's format, identified by its keygrip.
+type agentKey struct {
+	grip []byte
+	file []byte // contents of KEYGRIP.key
+}
+
+func newAgentSignKey(key *openpgp.SignKey) agentKey {
+	return agentKey{
+		keygrip(&keygripEd25519, key.Pubkey()),
+		agentKeyFile("Ed25519", "eddsa", key.Pubkey(), key.Seckey()),
+	}
+}
+
+func newAgentEncryptKey(key *openpgp.EncryptKey) agentKey {
+	// Like OpenPGP, gpg-agent stores the scalar big endian
+	seckey := key.Seckey()
+	sec := make([]byte, len(seckey))
+	lockMemory(sec)
+	for i, b := range seckey {
+		sec[len(sec)-1-i] = b
+	}
+	defer wipe(sec)
+	return agentKey{
+		keygrip(&keygripCurve25519, key.Pubkey()),
+		agentKeyFile("Curve25519", "djb-tweak", key.Pubkey(), sec),
+	}
+}
+
+// Write the key into a private-keys-v1.d directory, returning its path.
+// An existing file is never overwritten since gpg-agent may have since
+// protected it or moved its key to a smartcard.
+func (k *agentKey) write(dir string) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%X.key", k.grip))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(k.file); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// The secret keys in the order GnuPG lists them.
+func (k *completeKey) agentKeys(config *config) []agentKey {
+	keys := []agentKey{newAgentSignKey(k.key)}
+	if config.subkey {
+		keys = append(keys, newAgentEncryptKey(k.subkey))
+	}
+	if config.signSub {
+		keys = append(keys, newAgentSignKey(k.signsub))
+	}
+	if config.auth {
+		keys = append(keys, newAgentSignKey(k.authkey))
+	}
+	return keys
+}
+
+// Write each secret key into a gpg-agent private-keys-v1.d directory,
+// returning the keygrips.
+func (k *completeKey) agentExport(dir string, config *config) ([][]byte, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	keys := k.agentKeys(config)
+	defer func() {
+		for _, key := range keys {
+			wipe(key.file)
+		}
+	}()
+
+	var grips [][]byte
+	for _, key := range keys {
+		if _, err := key.write(dir); err != nil {
+			return grips, err
+		}
+		grips = append(grips, key.grip)
+	}
+	return grips, nil
+}
+
+// Look up a GnuPG directory or socket by name using gpgconf.
+func gpgconfDir(name string) (string, error) {
+	out, err := exec.Command("gpgconf", "--list-dirs", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("gpgconf: %s", err)
+	}
+	dir, ok := pinentryDecode(strings.TrimRight(string(out), "\r\n"))
+	if !ok || len(dir) == 0 {
+		return "", errors.New("gpgconf: invalid output")
+	}
+	return string(dir), nil
+}
+
+// gpgAgent is a connection to gpg-agent, which speaks the same Assuan
+// protocol as pinentry.
+type gpgAgent struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+}
+
+// Connect to the user's gpg-agent, starting it if necessary.
+func dialGPGAgent() (*gpgAgent, error) {
+	path, err := gpgconfDir("agent-socket")
+	if err != nil {
+		return nil, err
+	}
+	if err := exec.Command("gpgconf", "--launch", "gpg-agent").Run(); err != nil {
+		return nil, fmt.Errorf("gpgconf: %s", err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		// On Windows the "socket" is a file with a TCP port and a nonce
+		buf, rerr := ioutil.ReadFile(path)
+		i := bytes.IndexByte(buf, '\n')
+		if rerr != nil || i < 0 || len(buf)-i-1 != 16 {
+			return nil, err
+		}
+		conn, err = net.Dial("tcp", "127.0.0.1:"+string(buf[:i]))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(buf[i+1:]); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	agent, err := newGPGAgent(conn)
+	if err != nil {
+		return nil, err
+	}
+	// Tell gpg-agent where to display its prompts (PINs, etc.)
+	options := []struct{ name, env string }{
+		{"ttyname", "GPG_TTY"},
+		{"ttytype", "TERM"},
+		{"display", "DISPLAY"},
+	}
+	for _, option := range options {
+		if value := os.Getenv(option.env); value != "" {
+			_, _, err := agent.transact("OPTION " + option.name + "=" + value)
+			if err != nil {
+				agent.Close()
+				return nil, err
+			}
+		}
+	}
+	return agent, nil
+}
+
+// Start a session over an established connection.
+func newGPGAgent(conn io.ReadWriteCloser) (*gpgAgent, error) {
+	agent := &gpgAgent{conn, bufio.NewReader(conn)}
+	if _, _, err := agent.wait(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return agent, nil
+}
+
+// Send a command and wait for its response, returning any data and
+// status lines. Inquiries are canceled since none are expected.
+func (a *gpgAgent) transact(command string) (data []byte, status []string, err error) {
+	if _, err := io.WriteString(a.conn, command+"\n"); err != nil {
+		return nil, nil, err
+	}
+	return a.wait()
+}
+
+// Read lines up to the next "OK" or "ERR".
+func (a *gpgAgent) wait() (data []byte, status []string, err error) {
+	for {
+		line, err := a.r.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data, status, nil
+		case strings.HasPrefix(line, "ERR "):
+			// "ERR code description"
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) == 3 {
+				return nil, nil, errors.New(fields[2])
+			}
+			return nil, nil, errors.New(line)
+		case strings.HasPrefix(line, "D "):
+			decoded, ok := pinentryDecode(line[2:])
+			if !ok {
+				return nil, nil, errAgentProtocol
+			}
+			data = append(data, decoded...)
+		case strings.HasPrefix(line, "S "):
+			status = append(status, line[2:])
+		case strings.HasPrefix(line, "INQUIRE "):
+			if _, err := io.WriteString(a.conn, "CAN\n"); err != nil {
+				return nil, nil, err
+			}
+		case strings.HasPrefix(line, "#"):
+			// comment
+		default:
+			return nil, nil, errAgentProtocol
+		}
+	}
+}
+
+// Close the connection.
+func (a *gpgAgent) Close() error {
+	return a.conn.Close()
+}
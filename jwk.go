@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// JSON Web Keys for Ed25519 and X25519 are octet key pairs (RFC 8037),
+// identified by their RFC 7638 thumbprint.
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	D   string `json:"d,omitempty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// Describe a key pair, omitting the private key if sec is nil.
+func newJWK(crv, use, alg string, pub, sec []byte) jwk {
+	b64 := base64.RawURLEncoding
+	x := b64.EncodeToString(pub)
+
+	// The thumbprint hashes the required members in lexicographic order
+	thumbprint := sha256.Sum256([]byte(
+		`{"crv":"` + crv + `","kty":"OKP","x":"` + x + `"}`))
+
+	k := jwk{
+		Kty: "OKP",
+		Crv: crv,
+		X:   x,
+		Use: use,
+		Alg: alg,
+		Kid: b64.EncodeToString(thumbprint[:]),
+	}
+	if sec != nil {
+		k.D = b64.EncodeToString(sec)
+	}
+	return k
+}
+
+func encodeJWKSet(keys []jwk) []byte {
+	b, err := json.MarshalIndent(jwkSet{keys}, "", "  ")
+	if err != nil {
+		panic(err) // should never happen
+	}
+	return append(b, '\n')
+}
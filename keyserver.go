@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const keyserverTimeout = 30 * time.Second
+
+// Convert a keyserver, as given to GnuPG, into its HKP submission URL.
+// A bare host name means HKPS, and plain HKP defaults to port 11371.
+func keyserverURL(server string) (string, error) {
+	if !strings.Contains(server, "://") {
+		server = "hkps://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid keyserver: %s", server)
+	}
+	switch u.Scheme {
+	case "hkps":
+		u.Scheme = "https"
+	case "hkp":
+		u.Scheme = "http"
+		if u.Port() == "" {
+			u.Host += ":11371"
+		}
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported keyserver scheme: %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/pks/add"
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// Submit an armored public key to a keyserver per the HKP protocol.
+func sendKey(server string, armored []byte) error {
+	addr, err := keyserverURL(server)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"keytext": {string(armored)}}
+	body := strings.NewReader(form.Encode())
+	client := http.Client{Timeout: keyserverTimeout}
+	resp, err := client.Post(addr, "application/x-www-form-urlencoded", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		// Keyservers usually explain a rejection in the body
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		msg = bytes.TrimSpace(msg)
+		if i := bytes.IndexByte(msg, '\n'); i >= 0 {
+			msg = msg[:i]
+		}
+		if len(msg) > 0 {
+			return fmt.Errorf("%s: %s: %s", addr, resp.Status, msg)
+		}
+		return fmt.Errorf("%s: %s", addr, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// Lock the memory holding a secret so that it's never written to swap.
+// This is only a precaution, often limited by RLIMIT_MEMLOCK, so
+// failure is silently ignored.
+func lockMemory(buf []byte) {
+	if len(buf) > 0 {
+		syscall.Mlock(buf)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var virtualLock = syscall.NewLazyDLL("kernel32.dll").NewProc("VirtualLock")
+
+// Lock the memory holding a secret so that it's never paged out. This
+// is only a precaution, limited by the process working set size, so
+// failure is silently ignored.
+func lockMemory(buf []byte) {
+	if len(buf) > 0 {
+		ptr := uintptr(unsafe.Pointer(&buf[0]))
+		virtualLock.Call(ptr, uintptr(len(buf)))
+	}
+}
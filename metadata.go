@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"nullprogram.com/x/passphrase2pgp/openpgp"
+)
+
+// Machine-readable description of a generated key for --json. Key IDs
+// and fingerprints are upper case hexadecimal, and times are Unix
+// epoch seconds.
+
+type jsonKey struct {
+	Fingerprint string `json:"fingerprint"`
+	KeyID       string `json:"key_id"`
+	Algorithm   string `json:"algorithm"`
+	Version     int    `json:"version"`
+	Created     int64  `json:"created"`
+	Expires     int64  `json:"expires,omitempty"`
+	Usage       string `json:"usage"`
+}
+
+type jsonKDF struct {
+	Algorithm  string `json:"algorithm"`
+	Version    int    `json:"version"`
+	Time       uint32 `json:"time,omitempty"`
+	MemoryKiB  uint32 `json:"memory_kib,omitempty"`
+	Threads    uint8  `json:"threads,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Index      int    `json:"index,omitempty"`
+	Keyfile    bool   `json:"keyfile,omitempty"`
+	FIDO2      bool   `json:"fido2,omitempty"`
+}
+
+type jsonOutput struct {
+	Name  string `json:"name"` // "-" for standard output
+	Bytes int    `json:"bytes"`
+}
+
+type jsonMetadata struct {
+	jsonKey
+	UserIDs []string  `json:"user_ids"`
+	Subkeys []jsonKey `json:"subkeys"`
+	// Derivation is null when the key wasn't derived from a passphrase
+	Derivation *jsonKDF     `json:"derivation"`
+	Outputs    []jsonOutput `json:"outputs"`
+}
+
+// Describe a fingerprint (the full Key ID in the openpgp package).
+func newJSONKey(fpr []byte, algorithm string, version int, created, expires int64, usage string) jsonKey {
+	// The Key ID is the low 64 bits of a version 4 fingerprint, but the
+	// high 64 bits of a version 6 fingerprint
+	keyid := fpr[len(fpr)-8:]
+	if version == 6 {
+		keyid = fpr[:8]
+	}
+	return jsonKey{
+		Fingerprint: fmt.Sprintf("%X", fpr),
+		KeyID:       fmt.Sprintf("%X", keyid),
+		Algorithm:   algorithm,
+		Version:     version,
+		Created:     created,
+		Expires:     expires,
+		Usage:       usage,
+	}
+}
+
+// Gather the metadata for a generated key.
+func (k *completeKey) metadata(config *config, outputs []jsonOutput) *jsonMetadata {
+	key := k.key
+	version := key.Version()
+	sign, encrypt := "ed25519", "cv25519"
+	if version == 6 {
+		encrypt = "x25519"
+	}
+	switch key.Algorithm() {
+	case openpgp.Curve448:
+		sign, encrypt = "ed448", "cv448"
+		if version == 6 {
+			encrypt = "x448"
+		}
+	case openpgp.P256:
+		sign, encrypt = "nistp256", "nistp256"
+	case openpgp.P384:
+		sign, encrypt = "nistp384", "nistp384"
+	case openpgp.RSA2048:
+		sign, encrypt = "rsa2048", "rsa2048"
+	case openpgp.RSA4096:
+		sign, encrypt = "rsa4096", "rsa4096"
+	}
+	m := &jsonMetadata{
+		jsonKey: newJSONKey(key.KeyID(), sign, version,
+			key.Created(), key.Expires(), "certify,sign"),
+		Subkeys: []jsonKey{},
+		Outputs: outputs,
+	}
+	for _, userid := range k.userids {
+		m.UserIDs = append(m.UserIDs, string(userid.ID))
+	}
+
+	if config.subkey {
+		m.Subkeys = append(m.Subkeys, newJSONKey(k.subkey.KeyID(),
+			encrypt, version, k.subkey.Created(), k.subkey.Expires(),
+			"encrypt"))
+	}
+	subkey := func(sub *openpgp.SignKey, usage string) {
+		m.Subkeys = append(m.Subkeys, newJSONKey(sub.KeyID(), sign,
+			version, sub.Created(), sub.Expires(), usage))
+	}
+	if config.signSub {
+		subkey(k.signsub, "sign")
+	}
+	if config.auth {
+		subkey(k.authkey, "authenticate")
+	}
+
+	if config.derived {
+		p := config.kdf
+		kdf := &jsonKDF{
+			Algorithm: p.algorithm,
+			Version:   p.version,
+			Keyfile:   config.keyfile != nil,
+			FIDO2:     config.fido2 != "",
+		}
+		switch p.algorithm {
+		case kdfPBKDF2:
+			kdf.Iterations = p.iterations
+		case kdfScrypt:
+			kdf.MemoryKiB = p.memory
+			kdf.Threads = p.threads
+		default:
+			kdf.Time = p.time
+			kdf.MemoryKiB = p.memory
+			kdf.Threads = p.threads
+		}
+		if p.version >= 3 {
+			kdf.Index = p.index
+		}
+		m.Derivation = kdf
+	}
+	return m
+}
+
+// Write the metadata as JSON to the configured file descriptor.
+func writeJSON(config *config, m *jsonMetadata) {
+	out := os.NewFile(uintptr(config.jsonFd), "json-fd")
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false) // user IDs have angle brackets
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		fatal("--json: %s", err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// Split an RFC 5322 message into its header fields, each including its
+// folded continuation lines, and its body. Input that doesn't begin
+// with a header field is all body. Line endings become LF.
+func splitMessage(msg []byte) (fields []string, body string) {
+	text := strings.ReplaceAll(string(msg), "\r\n", "\n")
+	for rest := text; rest != ""; {
+		line := rest
+		next := ""
+		if nl := strings.IndexByte(rest, '\n'); nl >= 0 {
+			line, next = rest[:nl], rest[nl+1:]
+		}
+		switch {
+		case line == "":
+			return fields, next
+		case (line[0] == ' ' || line[0] == '\t') && fields != nil:
+			fields[len(fields)-1] += "\n" + line
+		case isHeaderField(line):
+			fields = append(fields, line)
+		case fields == nil:
+			return nil, text
+		default:
+			// Malformed, so consider the header finished
+			return fields, rest
+		}
+		rest = next
+	}
+	return fields, ""
+}
+
+// Reports if the line begins a header field: a name of printable
+// characters followed by a colon.
+func isHeaderField(line string) bool {
+	colon := strings.IndexByte(line, ':')
+	if colon < 1 {
+		return false
+	}
+	for _, c := range line[:colon] {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the lowercase name of a header field.
+func fieldName(field string) string {
+	return strings.ToLower(field[:strings.IndexByte(field, ':')])
+}
+
+// Reports if a body survives any mail transport unchanged, as RFC 3156
+// requires of signed content: 7-bit lines of at most 998 octets, none
+// with trailing whitespace or beginning with "From ".
+func mailSafe(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if len(line) > 998 || strings.HasPrefix(line, "From ") {
+			return false
+		}
+		if strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t") {
+			return false
+		}
+		for i := 0; i < len(line); i++ {
+			if line[i] == 0 || line[i] == '\r' || line[i] > 127 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Encode a body as quoted-printable, also protecting "From " lines.
+func quotePrintable(body string) string {
+	var b strings.Builder
+	w := quotedprintable.NewWriter(&b)
+	w.Write([]byte(body))
+	w.Close()
+	text := strings.ReplaceAll(b.String(), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\nFrom ", "\n=46rom ")
+	if strings.HasPrefix(text, "From ") {
+		text = "=46" + text[1:]
+	}
+	return text
+}
+
+// Wrap a message, or just a body, as an RFC 3156 multipart/signed
+// PGP/MIME message. The Content-* fields move into the signed part,
+// which is re-encoded as quoted-printable if it isn't mail-safe. Its
+// CRLF canonical form is passed to sign, which returns the armored
+// detached signature made with the micalg hash, e.g. "pgp-sha256".
+// Lines end with LF, as sendmail expects.
+func pgpMIME(msg []byte, micalg string,
+	sign func(part []byte) ([]byte, error)) ([]byte, error) {
+	fields, body := splitMessage(msg)
+	var outer, inner []string
+	encoding := ""
+	for _, field := range fields {
+		switch name := fieldName(field); {
+		case name == "mime-version":
+			// Added back to the outer message
+		case name == "content-transfer-encoding":
+			value := field[strings.IndexByte(field, ':')+1:]
+			encoding = strings.ToLower(strings.TrimSpace(value))
+		case strings.HasPrefix(name, "content-"):
+			inner = append(inner, field)
+		default:
+			outer = append(outer, field)
+		}
+	}
+	if inner == nil {
+		inner = append(inner, "Content-Type: text/plain; charset=utf-8")
+	}
+	switch {
+	case encoding == "quoted-printable" || encoding == "base64":
+		// Already encoded for transport
+	case !mailSafe(body):
+		encoding = "quoted-printable"
+		body = quotePrintable(body)
+	}
+	if encoding != "" {
+		inner = append(inner, "Content-Transfer-Encoding: "+encoding)
+	}
+
+	part := strings.Join(inner, "\n") + "\n\n" + body
+	canonical := []byte(strings.ReplaceAll(part, "\n", "\r\n"))
+	sig, err := sign(canonical)
+	if err != nil {
+		return nil, err
+	}
+	// Derived from the content so the output is reproducible, and "=_"
+	// can't appear in quoted-printable or base64 text
+	sum := sha256.Sum256(canonical)
+	boundary := fmt.Sprintf("=_%x", sum[:12])
+
+	var b strings.Builder
+	for _, field := range outer {
+		b.WriteString(field + "\n")
+	}
+	b.WriteString("MIME-Version: 1.0\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/signed; micalg=%s;\n", micalg)
+	b.WriteString("\tprotocol=\"application/pgp-signature\";\n")
+	fmt.Fprintf(&b, "\tboundary=\"%s\"\n\n", boundary)
+	b.WriteString("This is an OpenPGP/MIME signed message (RFC 4880 and 3156)\n")
+	fmt.Fprintf(&b, "--%s\n%s\n--%s\n", boundary, part, boundary)
+	b.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\n")
+	b.WriteString("Content-Description: OpenPGP digital signature\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\n\n")
+	b.Write(sig)
+	fmt.Fprintf(&b, "\n--%s--\n", boundary)
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Minisign and signify share a format: an "untrusted comment:" line
+// followed by a base64 line, starting with the algorithm "Ed" and an
+// 8-byte key number tying signatures to keys. Both tools pick a random
+// key number, but here it's a truncated SHA-256 digest of the public
+// key so that it's as deterministic as the key.
+
+func minisignKeynum(pub []byte) []byte {
+	h := sha256.Sum256(pub)
+	return h[:8]
+}
+
+// Minisign displays a key number as a little endian integer.
+func minisignKeyID(keynum []byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(keynum))
+}
+
+func minisignFile(comment string, parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("untrusted comment: " + comment + "\n")
+	buf.WriteString(base64.StdEncoding.EncodeToString(bytes.Join(parts, nil)))
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// Choose scrypt parameters from minisign's (libsodium's) opslimit and
+// memlimit.
+func minisignScryptParams(opslimit, memlimit uint64) (n, r, p int) {
+	if opslimit < 32768 {
+		opslimit = 32768
+	}
+	r = 8
+	var maxN uint64
+	if opslimit < memlimit/32 {
+		p = 1
+		maxN = opslimit / uint64(r*4)
+	} else {
+		maxN = memlimit / uint64(r*128)
+	}
+	logN := uint(1)
+	for ; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	if p == 0 {
+		maxrp := opslimit / 4 / (uint64(1) << logN)
+		if maxrp > 0x3fffffff {
+			maxrp = 0x3fffffff
+		}
+		p = int(maxrp) / r
+	}
+	return 1 << logN, r, p
+}
+
+func secMinisign(pub, sec, password []byte, opslimit, memlimit uint64) []byte {
+	keynum := minisignKeynum(pub)
+	seckey := ed25519.NewKeyFromSeed(sec[:32])
+
+	// key number, secret key, BLAKE2b checksum
+	var body []byte
+	body = append(body, keynum...)
+	body = append(body, seckey...)
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte("Ed"))
+	h.Write(body)
+	body = h.Sum(body)
+
+	var salt [32]byte
+	var limits [16]byte
+	kdf := []byte{0, 0}
+	comment := "minisign secret key"
+	if password != nil {
+		kdf = []byte("Sc")
+		comment = "minisign encrypted secret key"
+		if _, err := rand.Read(salt[:]); err != nil {
+			panic(err)
+		}
+		binary.LittleEndian.PutUint64(limits[0:], opslimit)
+		binary.LittleEndian.PutUint64(limits[8:], memlimit)
+		n, r, p := minisignScryptParams(opslimit, memlimit)
+		stream, err := scrypt.Key(password, salt[:], n, r, p, len(body))
+		if err != nil {
+			panic(err) // parameters are always valid
+		}
+		for i := range body {
+			body[i] ^= stream[i]
+		}
+	}
+	return minisignFile(comment,
+		[]byte("Ed"), kdf, []byte("B2"), salt[:], limits[:], body)
+}
+
+func pubMinisign(pub []byte) []byte {
+	keynum := minisignKeynum(pub)
+	comment := "minisign public key " + minisignKeyID(keynum)
+	return minisignFile(comment, []byte("Ed"), keynum, pub)
+}
+
+// Create a prehashed minisign signature, with a trusted comment that's
+// also signed.
+func sigMinisign(pub, sec []byte, trusted string, src io.Reader) ([]byte, error) {
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, src); err != nil {
+		return nil, err
+	}
+	seckey := ed25519.NewKeyFromSeed(sec[:32])
+	sig := ed25519.Sign(seckey, h.Sum(nil))
+	global := ed25519.Sign(seckey, append(sig[:len(sig):len(sig)], trusted...))
+
+	out := minisignFile("signature from minisign secret key",
+		[]byte("ED"), minisignKeynum(pub), sig)
+	out = append(out, "trusted comment: "+trusted+"\n"...)
+	out = append(out, base64.StdEncoding.EncodeToString(global)...)
+	return append(out, '\n'), nil
+}
+
+func secSignify(pub, sec, password []byte, rounds uint32) []byte {
+	seckey := ed25519.NewKeyFromSeed(sec[:32])
+	sum := sha512.Sum512(seckey)
+	checksum := sum[:8]
+
+	var salt [16]byte
+	if password != nil {
+		if _, err := rand.Read(salt[:]); err != nil {
+			panic(err)
+		}
+		stream := bcryptPBKDF(password, salt[:], len(seckey), rounds)
+		for i := range seckey {
+			seckey[i] ^= stream[i]
+		}
+	} else {
+		rounds = 0
+	}
+	var r [4]byte
+	binary.BigEndian.PutUint32(r[:], rounds)
+	return minisignFile("signify secret key", []byte("EdBK"), r[:],
+		salt[:], checksum, minisignKeynum(pub), seckey)
+}
+
+func pubSignify(pub []byte) []byte {
+	return minisignFile("signify public key",
+		[]byte("Ed"), minisignKeynum(pub), pub)
+}
+
+// Create a signify signature, which signs the whole message.
+func sigSignify(pub, sec []byte, src io.Reader) ([]byte, error) {
+	message, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	seckey := ed25519.NewKeyFromSeed(sec[:32])
+	sig := ed25519.Sign(seckey, message)
+	return minisignFile("signature from signify secret key",
+		[]byte("Ed"), minisignKeynum(pub), sig), nil
+}
@@ -0,0 +1,609 @@
+package openpgp
+
+import (
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Ed448 signatures (RFC 8032) and X448 key agreement (RFC 7748), which
+// neither the standard library nor x/crypto provides. Both work over
+// the field of p = 2^448 - 2^224 - 1. Elements are sixteen 28-bit limbs
+// so that products accumulate in 64-bit words without overflow, and
+// secret scalars only select between values, never branch. Arithmetic
+// on scalars modulo the group order is likewise fixed-width, since the
+// secret scalar and nonce pass through it, and math/big only handles
+// public values.
+
+const (
+	ed448SeedSize = 57
+	ed448SigSize  = 114
+	x448Size      = 56
+)
+
+// A field element in little-endian 28-bit limbs. Operations keep limbs
+// at most 2^28, not necessarily reduced below p.
+type fe448 [16]uint64
+
+const fe448Mask = 1<<28 - 1
+
+var (
+	fe448Zero = fe448{}
+	fe448One  = fe448{1}
+
+	// p, limb by limb
+	fe448P = fe448{
+		fe448Mask, fe448Mask, fe448Mask, fe448Mask,
+		fe448Mask, fe448Mask, fe448Mask, fe448Mask,
+		fe448Mask - 1, fe448Mask, fe448Mask, fe448Mask,
+		fe448Mask, fe448Mask, fe448Mask, fe448Mask,
+	}
+
+	p448 = new(big.Int).Sub(
+		new(big.Int).Lsh(big.NewInt(1), 448),
+		new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 224), big.NewInt(1)),
+	)
+	p448Minus2 = new(big.Int).Sub(p448, big.NewInt(2)).Bytes()
+	p448Sqrt   = new(big.Int).Rsh(p448, 2).Bytes() // (p - 3) / 4
+)
+
+// Propagate carries so that every limb is at most 2^28, folding the
+// overflow back in since 2^448 = 2^224 + 1 (mod p).
+func (z *fe448) carry() *fe448 {
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < 15; i++ {
+			z[i+1] += z[i] >> 28
+			z[i] &= fe448Mask
+		}
+		c := z[15] >> 28
+		z[15] &= fe448Mask
+		z[0] += c
+		z[8] += c
+	}
+	return z
+}
+
+func (z *fe448) add(x, y *fe448) *fe448 {
+	for i := range z {
+		z[i] = x[i] + y[i]
+	}
+	return z.carry()
+}
+
+func (z *fe448) sub(x, y *fe448) *fe448 {
+	// Add 2p first so that no limb goes negative
+	for i := range z {
+		z[i] = x[i] + 2*fe448P[i] - y[i]
+	}
+	return z.carry()
+}
+
+func (z *fe448) mul(x, y *fe448) *fe448 {
+	var c [31]uint64
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			c[i+j] += x[i] * y[j]
+		}
+	}
+	// Fold the high limbs down, highest first, since each folds into
+	// a limb eight lower that may itself need folding
+	for k := 30; k >= 16; k-- {
+		c[k-16] += c[k]
+		c[k-8] += c[k]
+	}
+	copy(z[:], c[:16])
+	return z.carry()
+}
+
+func (z *fe448) square(x *fe448) *fe448 {
+	return z.mul(x, x)
+}
+
+// Set z to x raised to a public exponent, given big-endian.
+func (z *fe448) pow(x *fe448, e []byte) *fe448 {
+	r := fe448One
+	b := *x
+	for _, v := range e {
+		for bit := 7; bit >= 0; bit-- {
+			r.square(&r)
+			if v>>uint(bit)&1 == 1 {
+				r.mul(&r, &b)
+			}
+		}
+	}
+	*z = r
+	return z
+}
+
+func (z *fe448) invert(x *fe448) *fe448 {
+	return z.pow(x, p448Minus2)
+}
+
+// Swap x and y when bit is 1, without branching on it.
+func fe448Swap(x, y *fe448, bit uint64) {
+	mask := -bit
+	for i := range x {
+		t := mask & (x[i] ^ y[i])
+		x[i] ^= t
+		y[i] ^= t
+	}
+}
+
+// Set z from 56 little-endian bytes, which may encode a value up to
+// 2^448 - 1, i.e. not fully reduced.
+func (z *fe448) setBytes(b []byte) *fe448 {
+	for i := 0; i < 8; i++ {
+		var v uint64
+		for j := 6; j >= 0; j-- {
+			v = v<<8 | uint64(b[7*i+j])
+		}
+		z[2*i] = v & fe448Mask
+		z[2*i+1] = v >> 28
+	}
+	return z
+}
+
+// Returns the canonical 56-byte little-endian encoding of x.
+func (x *fe448) bytes() []byte {
+	// Normalize x, and x - p, with signed carries, then choose x - p
+	// unless it went negative
+	var w, u fe448
+	var cw, cu int64
+	for i := range x {
+		vw := int64(x[i]) + cw
+		vu := int64(x[i]) - int64(fe448P[i]) + cu
+		w[i] = uint64(vw) & fe448Mask
+		u[i] = uint64(vu) & fe448Mask
+		cw = vw >> 28
+		cu = vu >> 28
+	}
+	mask := uint64(cu) // all ones when negative
+	b := make([]byte, x448Size)
+	for i := 0; i < 8; i++ {
+		lo := w[2*i]&mask | u[2*i]&^mask
+		hi := w[2*i+1]&mask | u[2*i+1]&^mask
+		v := lo | hi<<28
+		for j := 0; j < 7; j++ {
+			b[7*i+j] = byte(v >> uint(8*j))
+		}
+	}
+	return b
+}
+
+func (x *fe448) equal(y *fe448) bool {
+	a, b := x.bytes(), y.bytes()
+	var d byte
+	for i := range a {
+		d |= a[i] ^ b[i]
+	}
+	return d == 0
+}
+
+// Returns a field element for a small constant.
+func fe448Int(n uint64) fe448 {
+	return fe448{n}
+}
+
+// Returns a field element from a big-endian big.Int.
+func fe448Big(n *big.Int) fe448 {
+	var z fe448
+	z.setBytes(reverse(n.FillBytes(make([]byte, x448Size))))
+	return z
+}
+
+// X448 computes the RFC 7748 function on a 56-byte scalar and a 56-byte
+// u-coordinate.
+func x448(scalar, u []byte) []byte {
+	k := make([]byte, x448Size)
+	copy(k, scalar)
+	k[0] &= 252
+	k[55] |= 128
+
+	var x1, x2, z2, x3, z3 fe448
+	x1.setBytes(u)
+	x2, z2, x3, z3 = fe448One, fe448Zero, x1, fe448One
+	a24 := fe448Int(39081)
+
+	var a, aa, b, bb, e, c, d, da, cb fe448
+	var swap uint64
+	for t := 447; t >= 0; t-- {
+		bit := uint64(k[t/8]>>uint(t%8)) & 1
+		swap ^= bit
+		fe448Swap(&x2, &x3, swap)
+		fe448Swap(&z2, &z3, swap)
+		swap = bit
+
+		a.add(&x2, &z2)
+		aa.square(&a)
+		b.sub(&x2, &z2)
+		bb.square(&b)
+		e.sub(&aa, &bb)
+		c.add(&x3, &z3)
+		d.sub(&x3, &z3)
+		da.mul(&d, &a)
+		cb.mul(&c, &b)
+		x3.add(&da, &cb)
+		x3.square(&x3)
+		z3.sub(&da, &cb)
+		z3.square(&z3)
+		z3.mul(&z3, &x1)
+		x2.mul(&aa, &bb)
+		z2.mul(&a24, &e)
+		z2.add(&z2, &aa)
+		z2.mul(&z2, &e)
+	}
+	fe448Swap(&x2, &x3, swap)
+	fe448Swap(&z2, &z3, swap)
+
+	z2.invert(&z2)
+	x2.mul(&x2, &z2)
+	wipe(k)
+	return x2.bytes()
+}
+
+// Returns the X448 public key for a 56-byte secret key.
+func x448Base(scalar []byte) []byte {
+	base := make([]byte, x448Size)
+	base[0] = 5
+	return x448(scalar, base)
+}
+
+// A point on the Ed448 curve, x^2 + y^2 = 1 + d*x^2*y^2, in projective
+// coordinates.
+type ed448Point struct {
+	x, y, z fe448
+}
+
+var (
+	ed448D fe448 // -39081
+	ed448B ed448Point
+
+	// Order of the base point, 2^446 - c
+	ed448L = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 446),
+		bigInt("13818066809895115352007386748515426880336692474882178"+
+			"609894547503885"))
+)
+
+func init() {
+	c := fe448Int(39081)
+	ed448D.sub(&fe448Zero, &c)
+
+	bx := bigInt("224580040295924300187604334099896036246789641632564" +
+		"1342461254616869504154674060329090291928693579532825780320751" +
+		"46446173674602635247710")
+	by := bigInt("298819210078481492676017930443930673437544040154080" +
+		"2420959282413723315061898358760035368786554187847339823032335" +
+		"03462500531545062832660")
+	ed448B = ed448Point{fe448Big(bx), fe448Big(by), fe448One}
+}
+
+// Returns an integer from its decimal representation.
+func bigInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("openpgp: invalid integer constant")
+	}
+	return n
+}
+
+func ed448Identity() ed448Point {
+	return ed448Point{fe448Zero, fe448One, fe448One}
+}
+
+// Set r to p + q with the complete addition law (RFC 8032, 5.2.4).
+func (r *ed448Point) add(p, q *ed448Point) *ed448Point {
+	var a, b, c, d, e, f, g, h, t fe448
+	a.mul(&p.z, &q.z)
+	b.square(&a)
+	c.mul(&p.x, &q.x)
+	d.mul(&p.y, &q.y)
+	e.mul(&c, &d)
+	e.mul(&e, &ed448D)
+	f.sub(&b, &e)
+	g.add(&b, &e)
+	h.add(&p.x, &p.y)
+	t.add(&q.x, &q.y)
+	h.mul(&h, &t)
+
+	h.sub(&h, &c)
+	h.sub(&h, &d)
+	r.x.mul(&a, &f)
+	r.x.mul(&r.x, &h)
+	d.sub(&d, &c)
+	r.y.mul(&a, &g)
+	r.y.mul(&r.y, &d)
+	r.z.mul(&f, &g)
+	return r
+}
+
+// Set r to p + p.
+func (r *ed448Point) double(p *ed448Point) *ed448Point {
+	var b, c, d, e, h, j fe448
+	b.add(&p.x, &p.y)
+	b.square(&b)
+	c.square(&p.x)
+	d.square(&p.y)
+	e.add(&c, &d)
+	h.square(&p.z)
+	h.add(&h, &h)
+	j.sub(&e, &h)
+	b.sub(&b, &e)
+	r.x.mul(&b, &j)
+	c.sub(&c, &d)
+	r.y.mul(&e, &c)
+	r.z.mul(&e, &j)
+	return r
+}
+
+// Set r to k*p for a 56-byte little-endian scalar, in constant time.
+func (r *ed448Point) scalarMult(k []byte, p *ed448Point) *ed448Point {
+	acc := ed448Identity()
+	var t ed448Point
+	for i := 447; i >= 0; i-- {
+		acc.double(&acc)
+		t.add(&acc, p)
+		bit := uint64(k[i/8]>>uint(i%8)) & 1
+		fe448Swap(&acc.x, &t.x, bit)
+		fe448Swap(&acc.y, &t.y, bit)
+		fe448Swap(&acc.z, &t.z, bit)
+	}
+	*r = acc
+	return r
+}
+
+// Returns the 57-byte encoding of a point: y, then the sign of x.
+func (p *ed448Point) bytes() []byte {
+	var zinv, x, y fe448
+	zinv.invert(&p.z)
+	x.mul(&p.x, &zinv)
+	y.mul(&p.y, &zinv)
+	b := append(y.bytes(), 0)
+	b[56] = x.bytes()[0] & 1 << 7
+	return b
+}
+
+// Decode a 57-byte point encoding, reporting whether it's valid.
+func (p *ed448Point) setBytes(b []byte) bool {
+	if len(b) != ed448SeedSize || b[56]&0x7f != 0 {
+		return false
+	}
+	var y fe448
+	y.setBytes(b[:56])
+	if string(y.bytes()) != string(b[:56]) {
+		return false // not reduced
+	}
+
+	// x^2 = (y^2 - 1) / (d*y^2 - 1)
+	var u, v, x, t fe448
+	u.square(&y)
+	v.mul(&u, &ed448D)
+	u.sub(&u, &fe448One)
+	v.sub(&v, &fe448One)
+
+	// x = u^3 v (u^5 v^3)^((p-3)/4)
+	var u3v, u5v3 fe448
+	u3v.square(&u)
+	u3v.mul(&u3v, &u)
+	u3v.mul(&u3v, &v)
+	u5v3.square(&u)
+	u5v3.mul(&u5v3, &u3v)
+	u5v3.mul(&u5v3, &v)
+	u5v3.mul(&u5v3, &v)
+	x.pow(&u5v3, p448Sqrt)
+	x.mul(&x, &u3v)
+
+	t.square(&x)
+	t.mul(&t, &v)
+	if !t.equal(&u) {
+		return false
+	}
+	sign := b[56] >> 7
+	if x.equal(&fe448Zero) && sign == 1 {
+		return false
+	}
+	if x.bytes()[0]&1 != sign {
+		x.sub(&fe448Zero, &x)
+	}
+	*p = ed448Point{x, y, fe448One}
+	return true
+}
+
+// Reports whether p and q are the same point.
+func (p *ed448Point) equal(q *ed448Point) bool {
+	var a, b fe448
+	a.mul(&p.x, &q.z)
+	b.mul(&q.x, &p.z)
+	if !a.equal(&b) {
+		return false
+	}
+	a.mul(&p.y, &q.z)
+	b.mul(&q.y, &p.z)
+	return a.equal(&b)
+}
+
+// Returns SHAKE256(dom4(0, "") || parts...), 114 bytes, as used for
+// pure Ed448 with an empty context.
+func ed448Hash(parts ...[]byte) []byte {
+	h := sha3.NewShake256()
+	h.Write([]byte("SigEd448\x00\x00"))
+	for _, part := range parts {
+		h.Write(part)
+	}
+	out := make([]byte, ed448SigSize)
+	h.Read(out)
+	return out
+}
+
+// A scalar modulo L in little-endian 32-bit words.
+type sc448 [14]uint32
+
+var sc448L sc448 // L, word by word
+
+func init() {
+	copy(sc448L[:], sc448Words(reverse(ed448L.FillBytes(make([]byte, 56)))))
+}
+
+// Returns little-endian bytes as little-endian 32-bit words, padding
+// the last word with zeros.
+func sc448Words(b []byte) []uint32 {
+	w := make([]uint32, (len(b)+3)/4)
+	for i, v := range b {
+		w[i/4] |= uint32(v) << uint(8*(i%4))
+	}
+	return w
+}
+
+// Returns a little-endian integer in 32-bit words reduced modulo L,
+// one bit at a time so that the time depends only on its length.
+func sc448Reduce(w []uint32) sc448 {
+	var r, t sc448
+	for i := 32*len(w) - 1; i >= 0; i-- {
+		// r = 2r + bit, which fits since r < L < 2^446
+		c := w[i/32] >> uint(i%32) & 1
+		for j := range r {
+			v := r[j]
+			r[j] = v<<1 | c
+			c = v >> 31
+		}
+
+		// Subtract L unless that borrows, i.e. r < L
+		var borrow uint64
+		for j := range r {
+			d := uint64(r[j]) - uint64(sc448L[j]) - borrow
+			t[j] = uint32(d)
+			borrow = d >> 63
+		}
+		mask := uint32(borrow) - 1 // all ones when r >= L
+		for j := range r {
+			r[j] = t[j]&mask | r[j]&^mask
+		}
+	}
+	return r
+}
+
+// Returns the scalar as 56 little-endian bytes.
+func (r *sc448) bytes() []byte {
+	b := make([]byte, x448Size)
+	for i := range b {
+		b[i] = byte(r[i/4] >> uint(8*(i%4)))
+	}
+	return b
+}
+
+// Returns a little-endian integer reduced modulo L, as 56 bytes.
+func ed448Reduce(b []byte) []byte {
+	w := sc448Words(b)
+	r := sc448Reduce(w)
+	for i := range w {
+		w[i] = 0
+	}
+	return r.bytes()
+}
+
+// Returns k*s + r modulo L, as 56 bytes, for 56-byte little-endian k,
+// s, and r, where k and r are already reduced.
+func ed448MulAdd(k, s, r []byte) []byte {
+	a, b, c := sc448Words(k), sc448Words(s), sc448Words(r)
+
+	// The sum is below 2^446 * 2^448 + 2^446, so fits in 28 words
+	var w [28]uint32
+	for i := range a {
+		var carry uint64
+		for j := range b {
+			t := uint64(a[i])*uint64(b[j]) + uint64(w[i+j]) + carry
+			w[i+j] = uint32(t)
+			carry = t >> 32
+		}
+		w[i+len(b)] = uint32(carry)
+	}
+	var carry uint64
+	for i := range w {
+		t := uint64(w[i]) + carry
+		if i < len(c) {
+			t += uint64(c[i])
+		}
+		w[i] = uint32(t)
+		carry = t >> 32
+	}
+
+	sum := sc448Reduce(w[:])
+	out := sum.bytes()
+	for _, v := range [][]uint32{a, b, c, w[:], sum[:]} {
+		for i := range v {
+			v[i] = 0
+		}
+	}
+	return out
+}
+
+// Returns the secret scalar and the prefix for a 57-byte seed.
+func ed448Expand(seed []byte) (s, prefix []byte) {
+	h := sha3.NewShake256()
+	h.Write(seed)
+	out := make([]byte, ed448SigSize)
+	h.Read(out)
+	s = out[:56] // the 57th byte is cleared, so leave it off
+	out[56] = 0
+	s[0] &= 0xfc
+	s[55] |= 0x80
+	return s, out[57:]
+}
+
+// Returns the 57-byte Ed448 public key for a 57-byte seed.
+func ed448Public(seed []byte) []byte {
+	s, prefix := ed448Expand(seed)
+	var a ed448Point
+	a.scalarMult(s, &ed448B)
+	wipe(s)
+	wipe(prefix)
+	return a.bytes()
+}
+
+// Returns the 114-byte Ed448 signature of msg by the key with the
+// given seed and public key.
+func ed448Sign(seed, pub, msg []byte) []byte {
+	s, prefix := ed448Expand(seed)
+	h := ed448Hash(prefix, msg)
+	r := ed448Reduce(h)
+	var rp ed448Point
+	rp.scalarMult(r, &ed448B)
+	encR := rp.bytes()
+
+	k := ed448Reduce(ed448Hash(encR, pub, msg))
+	sig := append(encR, append(ed448MulAdd(k, s, r), 0)...)
+	wipe(s)
+	wipe(prefix)
+	wipe(h)
+	wipe(r)
+	return sig
+}
+
+// Reports whether sig is a valid Ed448 signature of msg by pub.
+func ed448Verify(pub, msg, sig []byte) bool {
+	if len(pub) != ed448SeedSize || len(sig) != ed448SigSize {
+		return false
+	}
+	var a, r ed448Point
+	if !a.setBytes(pub) || !r.setBytes(sig[:57]) {
+		return false
+	}
+	if sig[113] != 0 {
+		return false
+	}
+	s := new(big.Int).SetBytes(reverse(sig[57:113]))
+	if s.Cmp(ed448L) >= 0 {
+		return false
+	}
+	k := ed448Reduce(ed448Hash(sig[:57], pub, msg))
+
+	// [4][S]B = [4]R + [4][k]A
+	var lhs, rhs ed448Point
+	lhs.scalarMult(sig[57:113], &ed448B)
+	rhs.scalarMult(k, &a)
+	rhs.add(&rhs, &r)
+	for i := 0; i < 2; i++ {
+		lhs.double(&lhs)
+		rhs.double(&rhs)
+	}
+	return lhs.equal(&rhs)
+}
@@ -0,0 +1,607 @@
+package openpgp
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/zlib"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+var (
+	// ErrNotRecipient means a message has no session key for this key.
+	ErrNotRecipient = errors.New("message not encrypted to this key")
+
+	// ErrModified means a message failed its integrity check (MDC).
+	ErrModified = errors.New("message has been modified")
+
+	// ErrKeyVersion means the operation isn't supported for the key's
+	// packet version.
+	ErrKeyVersion = errors.New("operation unsupported for key version")
+)
+
+// Encrypt data from src to this key, returning a Public-Key Encrypted
+// Session Key packet followed by a Symmetrically Encrypted Integrity
+// Protected Data packet (with MDC) containing a Literal Data packet.
+func (k *EncryptKey) Encrypt(src io.Reader) ([]byte, error) {
+	if k.version == 6 {
+		return nil, ErrKeyVersion
+	}
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionKey [32]byte
+	if _, err := rand.Read(sessionKey[:]); err != nil {
+		panic(err) // should never happen
+	}
+
+	pkesk := k.pkesk(sessionKey[:])
+	literal := literalPacket(data, time.Now().Unix())
+	seipd := seipdEncrypt(sessionKey[:], literal)
+	return append(pkesk, seipd...), nil
+}
+
+// Returns a Public-Key Encrypted Session Key packet for an AES-256
+// session key using ECDH (RFC 6637), or RSA.
+func (k *EncryptKey) pkesk(sessionKey []byte) []byte {
+	if k.rsa != nil {
+		return k.rsaPKESK(sessionKey)
+	}
+	ephemeral := make([]byte, k.SeedSize())
+	if _, err := rand.Read(ephemeral); err != nil {
+		panic(err) // should never happen
+	}
+	if curve := k.algo.nist(); curve != nil {
+		ephemeral = nistScalar(curve, ephemeral)
+	}
+	pubkey := k.dhPublic(ephemeral)
+	shared, err := k.dh(ephemeral, k.Pubkey())
+	if err != nil {
+		panic(err) // low order point, should never happen
+	}
+	kek := k.ecdhKDF(shared)
+
+	// Session key with algorithm, checksum, and PKCS5 padding
+	m := []byte{9} // AES-256
+	m = append(m, sessionKey...)
+	m = append(m, 0, 0)
+	binary.BigEndian.PutUint16(m[len(m)-2:], checksum(sessionKey))
+	pad := 8 - len(m)%8
+	for i := 0; i < pad; i++ {
+		m = append(m, byte(pad))
+	}
+	wrapped := aesKeyWrap(kek, m)
+
+	body := []byte{3} // version
+	body = append(body, k.KeyID()[12:20]...)
+	body = append(body, 18) // algorithm, ECDH
+	body = append(body, mpi(k.algo.point(pubkey))...)
+	body = append(body, byte(len(wrapped)))
+	body = append(body, wrapped...)
+	p := Packet{Tag: 1, Body: body}
+	return p.Encode()
+}
+
+// Returns a Public-Key Encrypted Session Key packet for an AES-256
+// session key encrypted with RSA (PKCS #1 v1.5).
+func (k *EncryptKey) rsaPKESK(sessionKey []byte) []byte {
+	// Session key with algorithm and checksum
+	m := []byte{9} // AES-256
+	m = append(m, sessionKey...)
+	m = append(m, 0, 0)
+	binary.BigEndian.PutUint16(m[len(m)-2:], checksum(sessionKey))
+	c, err := rsa.EncryptPKCS1v15(rand.Reader, &k.rsa.PublicKey, m)
+	if err != nil {
+		panic(err) // should never happen
+	}
+
+	body := []byte{3} // version
+	body = append(body, k.KeyID()[12:20]...)
+	body = append(body, 1) // algorithm, RSA
+	body = append(body, mpi(c)...)
+	p := Packet{Tag: 1, Body: body}
+	return p.Encode()
+}
+
+// EncryptSymmetric encrypts data from src with a passphrase, returning
+// a Symmetric-Key Encrypted Session Key packet followed by a
+// Symmetrically Encrypted Integrity Protected Data packet (with MDC)
+// containing a Literal Data packet. The AES-256 key is derived from the
+// passphrase with iterated and salted S2K.
+func EncryptSymmetric(passphrase []byte, src io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var salt [8]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		panic(err) // should never happen
+	}
+	key := s2k(crypto.SHA256, passphrase, salt[:], decodeS2K(s2kCount), 32)
+	defer func() {
+		for i := range key {
+			key[i] = 0
+		}
+	}()
+
+	body := []byte{
+		4, // version
+		9, // AES-256
+		3, // Iterated and Salted S2K
+		8, // SHA-256
+	}
+	body = append(body, salt[:]...)
+	body = append(body, s2kCount)
+	skesk := Packet{Tag: 3, Body: body}
+
+	literal := literalPacket(data, time.Now().Unix())
+	seipd := seipdEncrypt(key, literal)
+	return append(skesk.Encode(), seipd...), nil
+}
+
+// DecryptSymmetric decrypts a binary message encrypted with a
+// passphrase, returning the contents of its literal data. The message
+// must be integrity protected (MDC). A wrong passphrase is usually
+// indistinguishable from a modified message (ErrModified).
+func DecryptSymmetric(passphrase, msg []byte) ([]byte, error) {
+	var sessionKey, seipd []byte
+	for len(msg) > 0 {
+		packet, rest, err := ParsePacket(msg)
+		if err != nil {
+			return nil, err
+		}
+		msg = rest
+
+		switch packet.Tag {
+		case 3: // Symmetric-Key Encrypted Session Key Packet
+			if sessionKey == nil {
+				sessionKey, err = skeskKey(packet.Body, passphrase)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case 9: // Symmetrically Encrypted Data Packet (no MDC)
+			return nil, ErrUnsupportedPacket
+		case 18: // Symmetrically Encrypted Integrity Protected Data
+			seipd = packet.Body
+		}
+	}
+	if sessionKey == nil {
+		return nil, ErrNotRecipient
+	}
+	if seipd == nil {
+		return nil, ErrInvalidPacket
+	}
+	return decryptSEIPD(sessionKey, seipd)
+}
+
+// Map OpenPGP symmetric algorithm IDs to supported key sizes.
+var symKeySizes = map[byte]int{
+	2: 24, // TripleDES
+	7: 16, // AES-128
+	8: 24, // AES-192
+	9: 32, // AES-256
+}
+
+// Recover the session key, prefixed with its algorithm, from a
+// Symmetric-Key Encrypted Session Key packet body.
+func skeskKey(body, passphrase []byte) (sessionKey []byte, err error) {
+	defer func() {
+		if recover() != nil {
+			sessionKey = nil
+			err = ErrInvalidPacket
+		}
+	}()
+
+	algo := body[1]
+	size := symKeySizes[algo]
+	hash, ok := hashAlgos[body[3]]
+	if body[0] != 4 || size == 0 || !ok {
+		return nil, ErrUnsupportedPacket
+	}
+
+	var key, rest []byte
+	switch body[2] {
+	case 1: // Salted S2K
+		key = s2k(hash, passphrase, body[4:12], 0, size)
+		rest = body[12:]
+	case 3: // Iterated and Salted S2K
+		key = s2k(hash, passphrase, body[4:12], decodeS2K(body[12]), size)
+		rest = body[13:]
+	default:
+		return nil, ErrUnsupportedPacket
+	}
+	if len(rest) == 0 {
+		// The S2K output is the session key itself
+		return append([]byte{algo}, key...), nil
+	}
+
+	// Otherwise it decrypts the session key, prefixed with its algorithm
+	var block cipher.Block
+	if algo == 2 {
+		block, _ = des.NewTripleDESCipher(key)
+	} else {
+		block, _ = aes.NewCipher(key)
+	}
+	iv := make([]byte, block.BlockSize())
+	sessionKey = make([]byte, len(rest))
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(sessionKey, rest)
+	if symKeySizes[sessionKey[0]] != len(sessionKey)-1 {
+		return nil, ErrDecryptKey // most likely the wrong passphrase
+	}
+	return sessionKey, nil
+}
+
+// Returns the public key for a secret key on this key's curve.
+func (k *EncryptKey) dhPublic(seckey []byte) []byte {
+	switch k.algo {
+	case Curve448:
+		return x448Base(seckey)
+	case P256, P384:
+		return nistPublic(k.algo.nist(), seckey)
+	}
+	pubkey, _ := curve25519.X25519(seckey, curve25519.Basepoint)
+	return pubkey
+}
+
+// Returns the Diffie-Hellman shared secret of a secret key and public
+// key on this key's curve.
+func (k *EncryptKey) dh(seckey, pubkey []byte) ([]byte, error) {
+	if curve := k.algo.nist(); curve != nil {
+		return nistDH(curve, seckey, pubkey)
+	}
+	if k.algo == Curve448 {
+		shared := x448(seckey, pubkey)
+		if bytes.Equal(shared, make([]byte, x448Size)) {
+			return nil, errors.New("bad input point: low order point")
+		}
+		return shared, nil
+	}
+	return curve25519.X25519(seckey, pubkey)
+}
+
+// Derive a key encryption key from an ECDH shared secret per RFC 6637,
+// using the KDF parameters of this key.
+func (k *EncryptKey) ecdhKDF(shared []byte) []byte {
+	params := k.kdfParams()
+	oid := k.oid()
+	h := hashAlgos[params[2]].New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(shared)
+	h.Write([]byte{byte(len(oid))})
+	h.Write(oid)
+	h.Write([]byte{18}) // algorithm, ECDH
+	h.Write(params)     // KDF parameters
+	h.Write([]byte("Anonymous Sender    "))
+	h.Write(k.KeyID())
+	return h.Sum(nil)[:aesKeySizes[params[3]]]
+}
+
+// Returns a binary Literal Data packet with no file name.
+func literalPacket(data []byte, when int64) []byte {
+	body := make([]byte, 6, 6+len(data))
+	body[0] = 'b' // binary
+	body[1] = 0   // file name length
+	binary.BigEndian.PutUint32(body[2:], uint32(when))
+	body = append(body, data...)
+	p := Packet{Tag: 11, Body: body}
+	return p.Encode()
+}
+
+// Returns a Symmetrically Encrypted Integrity Protected Data packet
+// encrypting the plaintext packets with AES-256.
+func seipdEncrypt(key, plaintext []byte) []byte {
+	const bs = aes.BlockSize
+	data := make([]byte, bs+2, bs+2+len(plaintext)+22)
+	if _, err := rand.Read(data[:bs]); err != nil {
+		panic(err) // should never happen
+	}
+	data[bs+0] = data[bs-2]
+	data[bs+1] = data[bs-1]
+	data = append(data, plaintext...)
+
+	// Modification Detection Code packet, itself part of the MDC
+	data = append(data, 0xc0|19, 20)
+	mdc := sha1.Sum(data)
+	data = append(data, mdc[:]...)
+
+	block, _ := aes.NewCipher(key)
+	iv := make([]byte, bs)
+	stream := cipher.NewCFBEncrypter(block, iv)
+	stream.XORKeyStream(data, data)
+
+	p := Packet{Tag: 18, Body: append([]byte{1}, data...)}
+	return p.Encode()
+}
+
+// Decrypt a binary message encrypted to this key, returning the
+// contents of its literal data. The message must be integrity protected
+// (MDC), and any signatures within are ignored.
+func (k *EncryptKey) Decrypt(msg []byte) ([]byte, error) {
+	if k.version == 6 {
+		return nil, ErrKeyVersion
+	}
+	var sessionKey, seipd []byte
+	for len(msg) > 0 {
+		packet, rest, err := ParsePacket(msg)
+		if err != nil {
+			return nil, err
+		}
+		msg = rest
+
+		switch packet.Tag {
+		case 1: // Public-Key Encrypted Session Key Packet
+			if sessionKey == nil {
+				sessionKey = k.unwrap(packet.Body)
+			}
+		case 9: // Symmetrically Encrypted Data Packet (no MDC)
+			return nil, ErrUnsupportedPacket
+		case 18: // Symmetrically Encrypted Integrity Protected Data
+			seipd = packet.Body
+		}
+	}
+	if sessionKey == nil {
+		return nil, ErrNotRecipient
+	}
+	if seipd == nil {
+		return nil, ErrInvalidPacket
+	}
+	return decryptSEIPD(sessionKey, seipd)
+}
+
+// Decrypt an integrity protected packet body with a session key prefixed
+// with its algorithm, returning the literal data. The session key is
+// wiped afterward.
+func decryptSEIPD(sessionKey, seipd []byte) ([]byte, error) {
+	defer func() {
+		for i := range sessionKey {
+			sessionKey[i] = 0
+		}
+	}()
+
+	var block cipher.Block
+	var err error
+	if sessionKey[0] == 2 {
+		block, err = des.NewTripleDESCipher(sessionKey[1:])
+	} else {
+		block, err = aes.NewCipher(sessionKey[1:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := seipdDecrypt(block, seipd)
+	if err != nil {
+		return nil, err
+	}
+	return literalData(plaintext)
+}
+
+// Recover the session key, prefixed with its algorithm, from a
+// Public-Key Encrypted Session Key packet body, or return nil if the
+// packet isn't for this key.
+func (k *EncryptKey) unwrap(body []byte) (sessionKey []byte) {
+	defer func() {
+		if recover() != nil {
+			sessionKey = nil
+		}
+	}()
+
+	keyid := body[1:9]
+	wildcard := bytes.Equal(keyid, make([]byte, 8))
+	if body[0] != 3 || body[9] != k.pkAlgo() {
+		return nil
+	}
+	if !wildcard && !bytes.Equal(keyid, k.KeyID()[12:20]) {
+		return nil
+	}
+
+	var m []byte
+	if k.rsa != nil {
+		m = k.rsaUnwrap(body[10:])
+	} else {
+		m = k.ecdhUnwrap(body[10:])
+	}
+	if m == nil || len(m) < 3 {
+		return nil
+	}
+
+	// Check algorithm and checksum
+	key := m[1 : len(m)-2]
+	switch {
+	case m[0] == 2 && len(key) == 24: // TripleDES
+	case m[0] == 7 && len(key) == 16: // AES-128
+	case m[0] == 8 && len(key) == 24: // AES-192
+	case m[0] == 9 && len(key) == 32: // AES-256
+	default:
+		return nil
+	}
+	if binary.BigEndian.Uint16(m[len(m)-2:]) != checksum(key) {
+		return nil
+	}
+	return m[:len(m)-2]
+}
+
+// Recovers the session key, with its algorithm and checksum, from the
+// algorithm-specific fields of an RSA session key packet.
+func (k *EncryptKey) rsaUnwrap(fields []byte) []byte {
+	c, _ := mpiDecode(fields, 0)
+	if c == nil || k.rsa.D == nil {
+		return nil
+	}
+	m, err := rsa.DecryptPKCS1v15(nil, k.rsa, c)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// Recovers the session key, with its algorithm and checksum, from the
+// algorithm-specific fields of an ECDH session key packet.
+func (k *EncryptKey) ecdhUnwrap(fields []byte) []byte {
+	pubkey, rest := k.algo.decodePoint(fields, k.SeedSize())
+	if pubkey == nil {
+		return nil
+	}
+	shared, err := k.dh(k.Seckey(), pubkey)
+	if err != nil {
+		return nil // low order point
+	}
+	wrapped := rest[1 : 1+int(rest[0])]
+	m := aesKeyUnwrap(k.ecdhKDF(shared), wrapped)
+	if m == nil {
+		return nil
+	}
+
+	// Remove PKCS5 padding
+	pad := int(m[len(m)-1])
+	if pad < 1 || pad > 8 {
+		return nil
+	}
+	return m[:len(m)-pad]
+}
+
+// Decrypt and check a Symmetrically Encrypted Integrity Protected Data
+// packet body, returning the plaintext packets.
+func seipdDecrypt(block cipher.Block, body []byte) ([]byte, error) {
+	bs := block.BlockSize()
+	if len(body) < 1+bs+2+22 {
+		return nil, ErrInvalidPacket
+	}
+	if body[0] != 1 {
+		return nil, ErrUnsupportedPacket
+	}
+
+	data := make([]byte, len(body)-1)
+	iv := make([]byte, bs)
+	stream := cipher.NewCFBDecrypter(block, iv)
+	stream.XORKeyStream(data, body[1:])
+
+	// Don't reveal which check failed (quick check vs. MDC)
+	n := len(data) - 20
+	mdc := sha1.Sum(data[:n])
+	ok := subtle.ConstantTimeCompare(data[bs-2:bs], data[bs:bs+2]) &
+		subtle.ConstantTimeCompare(data[n-2:n], []byte{0xc0 | 19, 20}) &
+		subtle.ConstantTimeCompare(data[n:], mdc[:])
+	if ok != 1 {
+		return nil, ErrModified
+	}
+	return data[bs+2 : n-2], nil
+}
+
+// Returns the contents of the literal data packet among the plaintext
+// packets, decompressing as needed.
+func literalData(buf []byte) ([]byte, error) {
+	for len(buf) > 0 {
+		packet, rest, err := ParsePacket(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+
+		body := packet.Body
+		switch packet.Tag {
+		case 8: // Compressed Data Packet
+			if len(body) < 1 {
+				return nil, ErrInvalidPacket
+			}
+			var r io.Reader
+			switch body[0] {
+			case 0: // Uncompressed
+				r = bytes.NewReader(body[1:])
+			case 1: // ZIP
+				r = flate.NewReader(bytes.NewReader(body[1:]))
+			case 2: // ZLIB
+				r, err = zlib.NewReader(bytes.NewReader(body[1:]))
+				if err != nil {
+					return nil, ErrInvalidPacket
+				}
+			case 3: // BZip2
+				r = bzip2.NewReader(bytes.NewReader(body[1:]))
+			default:
+				return nil, ErrUnsupportedPacket
+			}
+			inner, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, ErrInvalidPacket
+			}
+			return literalData(inner)
+		case 11: // Literal Data Packet
+			if len(body) < 2 || len(body) < 6+int(body[1]) {
+				return nil, ErrInvalidPacket
+			}
+			return body[6+int(body[1]):], nil
+		}
+	}
+	return nil, ErrInvalidPacket
+}
+
+// Wrap a key with a key encryption key per RFC 3394.
+func aesKeyWrap(kek, key []byte) []byte {
+	block, _ := aes.NewCipher(kek)
+	n := len(key) / 8
+	a := []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+	r := make([]byte, len(key))
+	copy(r, key)
+
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(b[:8], a)
+			copy(b[8:], r[i*8:])
+			block.Encrypt(b[:], b[:])
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[i*8:], b[8:])
+		}
+	}
+	return append(a, r...)
+}
+
+// Unwrap a key with a key encryption key per RFC 3394, returning nil if
+// the integrity check fails.
+func aesKeyUnwrap(kek, wrapped []byte) []byte {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil
+	}
+	block, _ := aes.NewCipher(kek)
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped)
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[i*8:])
+			block.Decrypt(b[:], b[:])
+			copy(a, b[:8])
+			copy(r[i*8:], b[8:])
+		}
+	}
+
+	iv := []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+	if subtle.ConstantTimeCompare(a, iv) != 1 {
+		return nil
+	}
+	return r
+}
@@ -2,6 +2,7 @@ package openpgp
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"encoding/binary"
 
 	"golang.org/x/crypto/curve25519"
@@ -10,18 +11,65 @@ import (
 const (
 	// EncryptKeyPubLen is the size of the public part of an OpenPGP packet.
 	EncryptKeyPubLen = 58
+
+	// EncryptKeyPubLenV6 is the size of the public part of a version 6
+	// OpenPGP packet.
+	EncryptKeyPubLenV6 = 44
 )
 
-// EncryptKey represents an X25519 Diffie-Hellman key (ECDH). Implements
-// Bindable.
+// EncryptKey represents an X25519, X448, NIST P-256, or NIST P-384
+// Diffie-Hellman key (ECDH), or an RSA key. Key holds an X25519 secret
+// key followed by its public key, and is empty for the other
+// algorithms, whose keys are only accessed through Seckey and Pubkey.
+// Implements Bindable.
 type EncryptKey struct {
-	Key     []byte
-	created int64
-	expires int64
+	Key      []byte          // X25519 keys only
+	key      []byte          // secret || public key, X448 and NIST
+	rsa      *rsa.PrivateKey // RSA keys only
+	algo     Algorithm
+	created  int64
+	expires  int64
+	kdf      []byte // KDF hash and cipher, or nil for SHA-256 and AES-256
+	keyFlags byte
+	version  byte
+}
+
+// Map OpenPGP symmetric algorithm IDs to AES key sizes.
+var aesKeySizes = map[byte]int{
+	7: 16, // AES-128
+	8: 24, // AES-192
+	9: 32, // AES-256
 }
 
-// Seed sets the 32-byte seed for a sign key.
+// EncryptKeyFromSeed returns an encryption key derived from a 32-byte
+// seed with the given creation date in unix epoch seconds.
+func EncryptKeyFromSeed(seed []byte, created int64) *EncryptKey {
+	k := new(EncryptKey)
+	k.Seed(seed)
+	k.SetCreated(created)
+	return k
+}
+
+// Seed sets the seed for an encryption key, which must be SeedSize
+// bytes. The secret scalar of a NIST curve key is derived from the
+// seed, and an RSA key is generated from it.
 func (k *EncryptKey) Seed(seed []byte) {
+	switch k.algo {
+	case RSA2048, RSA4096:
+		k.setKey(nil)
+		k.rsa = rsaGenerate(seed, k.algo.rsaBits())
+		return
+	case Curve448:
+		seckey := append([]byte{}, seed...)
+		seckey[0] &= 252
+		seckey[55] |= 128
+		k.setKey(append(seckey, x448Base(seckey)...))
+		return
+	case P256, P384:
+		seckey := nistScalar(k.algo.nist(), seed)
+		k.setKey(append(seckey, nistPublic(k.algo.nist(), seckey)...))
+		return
+	}
 	var pubkey [32]byte
 	var seckey [32]byte
 	copy(seckey[:], seed)
@@ -29,7 +77,73 @@ func (k *EncryptKey) Seed(seed []byte) {
 	seckey[31] &= 127
 	seckey[31] |= 64
 	curve25519.ScalarBaseMult(&pubkey, &seckey)
-	k.Key = append(seckey[:], pubkey[:]...)
+	k.setKey(append(seckey[:], pubkey[:]...))
+}
+
+// Sets the secret key followed by the public key, stored in Key for
+// X25519, and clears any RSA key.
+func (k *EncryptKey) setKey(key []byte) {
+	k.Key, k.key, k.rsa = nil, nil, nil
+	if k.algo == Curve25519 {
+		k.Key = key
+	} else {
+		k.key = key
+	}
+}
+
+// Returns the secret key followed by the public key.
+func (k *EncryptKey) keyBytes() []byte {
+	if k.algo == Curve25519 {
+		return k.Key
+	}
+	return k.key
+}
+
+// Wipe overwrites the secret key with zeros.
+func (k *EncryptKey) Wipe() {
+	wipe(k.Key)
+	wipe(k.key)
+	if k.rsa != nil {
+		rsaWipe(k.rsa)
+	}
+}
+
+// SeedSize returns the size of this key's seed: 32 bytes for X25519,
+// P-256, and RSA, 56 bytes for X448, or 48 bytes for P-384.
+func (k *EncryptKey) SeedSize() int {
+	switch k.algo {
+	case Curve448:
+		return x448Size
+	case P256, P384:
+		return nistSize(k.algo.nist())
+	}
+	return 32
+}
+
+// Algorithm returns the key's algorithm, Curve25519 (default),
+// Curve448, P256, P384, RSA2048, or RSA4096.
+func (k *EncryptKey) Algorithm() Algorithm {
+	return k.algo
+}
+
+// SetAlgorithm selects the key's algorithm, which must precede Seed.
+func (k *EncryptKey) SetAlgorithm(algo Algorithm) {
+	k.algo = algo
+}
+
+// Version returns the key packet version, 4 (default) or 6.
+func (k *EncryptKey) Version() int {
+	if k.version == 6 {
+		return 6
+	}
+	return 4
+}
+
+// SetVersion selects the key packet version, 4 (default) or 6. Version
+// 6 keys (RFC 9580) cannot yet encrypt or decrypt messages, nor can
+// they be protected by EncPacket.
+func (k *EncryptKey) SetVersion(version int) {
+	k.version = byte(version)
 }
 
 // Created returns the key's creation date in unix epoch seconds.
@@ -42,6 +156,27 @@ func (k *EncryptKey) SetCreated(time int64) {
 	k.created = time
 }
 
+// KeyFlags returns the key flags set with SetKeyFlags, or zero if the
+// default is used.
+func (k *EncryptKey) KeyFlags() byte {
+	return k.keyFlags
+}
+
+// SetKeyFlags overrides the key flags stated in this subkey's binding
+// signature, by default encrypt communications and storage. Zero
+// restores the default.
+func (k *EncryptKey) SetKeyFlags(flags byte) {
+	k.keyFlags = flags
+}
+
+// Returns this key's key flags, or def if not set.
+func (k *EncryptKey) keyFlagsOr(def byte) byte {
+	if k.keyFlags == 0 {
+		return def
+	}
+	return k.keyFlags
+}
+
 // Expires returns the key's expiration time in unix epoch seconds. A
 // value of zero means the key doesn't expire.
 func (k *SignKey) Expires() int64 {
@@ -54,51 +189,126 @@ func (k *SignKey) SetExpires(time int64) {
 	k.expires = time
 }
 
-// Seckey returns the secret key portion of this key.
+// Seckey returns the secret key portion of this key. For RSA, this is
+// the private exponent.
 func (k *EncryptKey) Seckey() []byte {
-	return k.Key[:32]
+	if k.rsa != nil {
+		return rsaSeckey(k.rsa)
+	}
+	return k.keyBytes()[:k.SeedSize()]
 }
 
-// Pubkey returns the public key portion of this key.
+// Pubkey returns the public key portion of this key. For RSA, this is
+// the modulus.
 func (k *EncryptKey) Pubkey() []byte {
-	return k.Key[32:]
+	if k.rsa != nil {
+		return k.rsa.N.Bytes()
+	}
+	return k.keyBytes()[k.SeedSize():]
+}
+
+// Returns the public-key algorithm ID of this version 4 key.
+func (k *EncryptKey) pkAlgo() byte {
+	if k.rsa != nil {
+		return 1 // RSA
+	}
+	return 18 // ECDH
 }
 
 // PubPacket returns an OpenPGP public key packet for this key.
 func (k *EncryptKey) PubPacket() []byte {
-	packet := make([]byte, EncryptKeyPubLen, 256)
+	if k.rsa != nil {
+		p := Packet{Tag: 14, Body: rsaPubBody(k.created, &k.rsa.PublicKey)}
+		return p.Encode()
+	}
+	if k.version == 6 {
+		packet := make([]byte, 12, 256)
+		packet[0] = 0xc0 | 14 // packet header, Public-Subkey packet (14)
+		packet[2] = 0x06      // packet version (6)
+		binary.BigEndian.PutUint32(packet[3:], uint32(k.created))
+		packet[7] = 25 // algorithm, X25519
+		if k.algo == Curve448 {
+			packet[7] = 26 // algorithm, X448
+		}
+		binary.BigEndian.PutUint32(packet[8:], uint32(len(k.Pubkey())))
+		packet = append(packet, k.Pubkey()...)
+		packet[1] = byte(len(packet) - 2)
+		return packet
+	}
+
+	packet := make([]byte, 8, 256)
 	packet[0] = 0xc0 | 14 // packet header, Public-Subkey packet (14)
 	packet[2] = 0x04      // packet version, new (4)
 
 	binary.BigEndian.PutUint32(packet[3:7], uint32(k.created))
 	packet[7] = 18 // algorithm, Elliptic Curve
-	packet[8] = 10 // OID length
-	// OID (1.3.6.1.4.1.3029.1.5.1)
-	oid := []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
-	copy(packet[9:19], oid)
+	oid := k.oid()
+	packet = append(packet, byte(len(oid)))
+	packet = append(packet, oid...)
 
-	// public key length (always 263 bits)
-	binary.BigEndian.PutUint16(packet[19:21], 263)
-	packet[21] = 0x40 // MPI prefix
-	copy(packet[22:54], k.Pubkey())
+	// Public key, e.g. 263 bits for X25519 and 515 bits for P-256
+	packet = append(packet, mpi(k.algo.point(k.Pubkey()))...)
 
 	// KDF parameters
-	packet[54] = 3 // length
-	packet[55] = 1 // reserved (1)
-	packet[56] = 8 // SHA-256
-	packet[57] = 9 // AES-256
+	packet = append(packet, k.kdfParams()...)
 
 	packet[1] = byte(len(packet) - 2) // packet length
 	return packet
 }
 
+// Returns the curve OID of this key in version 4 packets.
+func (k *EncryptKey) oid() []byte {
+	switch k.algo {
+	case Curve448:
+		return oidX448
+	case P256:
+		return oidP256
+	case P384:
+		return oidP384
+	}
+	return oidCurve25519
+}
+
+// Returns the encoded KDF parameters of this key.
+func (k *EncryptKey) kdfParams() []byte {
+	switch {
+	case k.kdf != nil:
+		return []byte{3, 1, k.kdf[0], k.kdf[1]}
+	case k.algo == Curve448:
+		return []byte{3, 1, 10, 9} // SHA-512, AES-256
+	case k.algo == P256:
+		return []byte{3, 1, 8, 7} // SHA-256, AES-128
+	case k.algo == P384:
+		return []byte{3, 1, 9, 8} // SHA-384, AES-192
+	}
+	return []byte{3, 1, 8, 9} // SHA-256, AES-256
+}
+
+// Returns the secret key as stored in a version 4 packet. X25519 keys
+// are stored big-endian, as GnuPG does, and the others natively.
+func (k *EncryptKey) packetSeckey() []byte {
+	if k.algo != Curve25519 {
+		return k.Seckey()
+	}
+	return reverse(k.Seckey())
+}
+
 // Packet returns the OpenPGP packet encoding this key.
 func (k *EncryptKey) Packet() []byte {
+	if k.rsa != nil {
+		return rsaSecretPacket(7, k.pubBody(), k.rsa, nil)
+	}
 	packet := k.PubPacket()
 	packet[0] = 0xc0 | 7 // packet header, Secret-Subkey Packet (7)
 
 	packet = append(packet, 0) // string-to-key, unencrypted
-	mpikey := mpi(reverse(k.Seckey()))
+	if k.version == 6 {
+		// Version 6 keys are raw and have no checksum
+		packet = append(packet, k.Seckey()...)
+		packet[1] = byte(len(packet) - 2) // packet length
+		return packet
+	}
+	mpikey := mpi(k.packetSeckey())
 	packet = append(packet, mpikey...)
 	packet = packet[:len(packet)+2]
 	binary.BigEndian.PutUint16(packet[len(packet)-2:], checksum(mpikey))
@@ -109,16 +319,32 @@ func (k *EncryptKey) Packet() []byte {
 
 // EncPacket returns a protected secret key packet.
 func (k *EncryptKey) EncPacket(passphrase []byte) []byte {
-	packet := k.PubPacket()
-	packet[0] = 0xc0 | 7 // packet header, Secret-Subkey Packet (7)
-	packet = s2kEncryptKey(packet, reverse(k.Seckey()), passphrase)
-	packet[1] = byte(len(packet) - 2) // packet length
-	return packet
+	if k.rsa != nil {
+		return rsaSecretPacket(7, k.pubBody(), k.rsa, passphrase)
+	}
+	body := s2kEncryptKey(k.pubBody(), k.packetSeckey(), passphrase)
+	// A P-384 key outgrows a one-octet length
+	p := Packet{Tag: 7, Body: body} // Secret-Subkey Packet (7)
+	return p.Encode()
+}
+
+// Returns the body of this key's public key packet.
+func (k *EncryptKey) pubBody() []byte {
+	p, _, _ := ParsePacket(k.PubPacket())
+	return p.Body
+}
+
+// KeyID returns the Key ID (fingerprint) for this key.
+func (k *EncryptKey) KeyID() []byte {
+	return keyFingerprint(k.PubPacket())
 }
 
 // Load key material from packet body. If the error is DecryptKeyErr,
 // then either the passphrase was nil or the passphrase is wrong. To use
 // an empty passphrase, pass an empty but non-nil passphrase.
+//
+// A public subkey packet loads only the public key, which is suitable
+// for encrypting but not for decrypting.
 func (k *EncryptKey) Load(packet Packet, passphrase []byte) (err error) {
 	defer func() {
 		if recover() != nil {
@@ -127,37 +353,131 @@ func (k *EncryptKey) Load(packet Packet, passphrase []byte) (err error) {
 	}()
 
 	switch packet.Tag {
-	case 7:
+	case 7, 14:
 		// Ok
-	case 14:
-		// TODO: Support loading public key packets
-		return ErrUnsupportedPacket
 	default:
 		// Wrong packet type
 		return ErrInvalidPacket
 	}
 
-	// Check various static bytes
 	body := packet.Body
-	if body[0] != 0x04 || !bytes.Equal(body[5:17], []byte{
-		18, 10,
-		0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01,
-	}) {
+	if body[0] == 0x06 {
+		return k.loadV6(packet)
+	}
+	if body[0] == 0x04 && body[5] == 1 { // RSA
+		key, algo, created, err := loadRSA(packet, passphrase)
+		if err != nil {
+			return err
+		}
+		k.algo, k.kdf = algo, nil
+		k.setKey(nil)
+		k.rsa = key
+		k.SetCreated(created)
+		k.SetVersion(4)
+		return nil
+	}
+
+	// Check various static bytes
+	if body[0] != 0x04 || body[5] != 18 {
+		return ErrUnsupportedPacket
+	}
+	oid := body[7 : 7+int(body[6])]
+	switch {
+	case bytes.Equal(oid, oidCurve25519):
+		k.algo = Curve25519
+	case bytes.Equal(oid, oidX448):
+		k.algo = Curve448
+	case bytes.Equal(oid, oidP256):
+		k.algo = P256
+	case bytes.Equal(oid, oidP384):
+		k.algo = P384
+	default:
+		return ErrUnsupportedPacket
+	}
+	size := k.SeedSize()
+	pubkey, rest := k.algo.decodePoint(body[7+len(oid):], size)
+	if pubkey == nil {
 		return ErrUnsupportedPacket
 	}
 
-	pubkey := body[20:52]
 	created := int64(binary.BigEndian.Uint32(body[1:]))
 	k.SetCreated(created)
 
-	// KDF parameters
-	secbody := body[53+body[52]:] // skip KDF parameters
+	// KDF parameters, which GnuPG may choose differently
+	kdf := rest[:4]
+	if kdf[0] != 3 || kdf[1] != 1 || // length, reserved
+		kdf[2] < 8 || kdf[2] > 10 || // SHA-256, SHA-384, SHA-512
+		aesKeySizes[kdf[3]] == 0 {
+		return ErrUnsupportedPacket
+	}
+	k.version = 4
+	k.kdf = nil
+	if !bytes.Equal(kdf, k.kdfParams()) {
+		k.kdf = []byte{kdf[2], kdf[3]}
+	}
+
+	if packet.Tag == 14 {
+		// Public key only, so leave the secret key zeroed
+		k.setKey(append(make([]byte, size), pubkey...))
+		return nil
+	}
+
+	secbody := rest[4:]
 	seckey, err := s2kDecryptKey(secbody, passphrase)
 	if err != nil {
 		return err
 	}
+	if len(seckey) < size {
+		// Leading zeros were dropped from the MPI
+		seckey = append(make([]byte, size-len(seckey)), seckey...)
+	}
+
+	switch k.algo {
+	case Curve448:
+		k.Seed(seckey)
+	case P256, P384:
+		pubkey := nistPublic(k.algo.nist(), seckey)
+		k.setKey(append(append([]byte{}, seckey...), pubkey...))
+	default:
+		k.Seed(reverse(seckey))
+	}
+	if !bytes.Equal(k.Pubkey(), pubkey) {
+		return ErrInvalidPacket
+	}
+	return nil
+}
+
+// Load a version 6 X25519 or X448 key packet, which holds the raw
+// keys. Only unprotected secret keys are supported.
+func (k *EncryptKey) loadV6(packet Packet) error {
+	body := packet.Body
+	switch {
+	case bytes.Equal(body[5:10], []byte{25, 0, 0, 0, 32}):
+		k.algo = Curve25519
+	case bytes.Equal(body[5:10], []byte{26, 0, 0, 0, 56}):
+		k.algo = Curve448
+	default:
+		return ErrUnsupportedPacket
+	}
 
-	k.Seed(reverse(seckey))
+	size := k.SeedSize()
+	pubkey := body[10 : 10+size]
+	created := int64(binary.BigEndian.Uint32(body[1:]))
+	k.SetCreated(created)
+	k.SetVersion(6)
+	k.kdf = nil
+
+	if packet.Tag == 14 {
+		// Public key only, so leave the secret key zeroed
+		k.setKey(append(make([]byte, size), pubkey...))
+		return nil
+	}
+
+	end := 10 + size
+	if body[end] != 0 || len(body) != end+1+size {
+		return ErrUnsupportedPacket
+	}
+	k.Seed(body[end+1:])
 	if !bytes.Equal(k.Pubkey(), pubkey) {
 		return ErrInvalidPacket
 	}
@@ -0,0 +1,58 @@
+//go:build gofuzz
+// +build gofuzz
+
+package openpgp
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Fuzz is a go-fuzz target for the packet parser and everything built
+// on it. Parsed packets must re-encode to the same packet.
+//
+//	$ go-fuzz-build nullprogram.com/x/passphrase2pgp/openpgp
+//	$ go-fuzz -bin openpgp-fuzz.zip -workdir fuzz
+func Fuzz(data []byte) int {
+	ParseKeyRing(data)
+	Dearmor(data)
+
+	valid := 0
+	for buf := data; len(buf) > 0; {
+		packet, rest, err := ParsePacket(buf)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidPacket) {
+				panic("error does not wrap ErrInvalidPacket")
+			}
+			return 0
+		}
+		if len(rest) >= len(buf) {
+			panic("parser made no progress")
+		}
+		buf = rest
+
+		again, tail, err := ParsePacket(packet.Encode())
+		if err != nil || len(tail) != 0 || again.Tag != packet.Tag ||
+			!bytes.Equal(again.Body, packet.Body) {
+			panic("packet does not survive re-encoding")
+		}
+
+		switch packet.Tag {
+		case 2: // Signature Packet
+			ParseSignature(packet)
+		case 5, 6, 7, 14: // key packets
+			var sk SignKey
+			sk.Load(packet, nil)
+			var ek EncryptKey
+			ek.Load(packet, nil)
+		case 13: // User ID Packet
+			var userid UserID
+			userid.Load(packet)
+		case 17: // User Attribute Packet
+			var photo PhotoID
+			photo.Load(packet)
+		}
+		valid = 1
+	}
+	return valid
+}
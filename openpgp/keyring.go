@@ -0,0 +1,200 @@
+package openpgp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// TransferableKey is a complete public or secret key as exported by
+// GnuPG (RFC 9580, Sections 10.1 and 10.2): a primary key, its
+// user IDs, user attributes, and subkeys, each followed by its
+// signatures. Packets are kept as-is, so keys and signatures using
+// algorithms this package doesn't support are still parsed, and the
+// key can be re-encoded.
+type TransferableKey struct {
+	// Primary is the Public-Key (6) or Secret-Key (5) Packet.
+	Primary Packet
+	// Signatures are direct-key signatures and revocations.
+	Signatures []Packet
+	UserIDs    []KeyUserID
+	Attributes []KeyComponent // User Attribute Packets (17)
+	// Subkeys are Public-Subkey (14) or Secret-Subkey (7) Packets.
+	Subkeys []KeyComponent
+}
+
+// KeyComponent is a packet in a transferable key followed by its
+// signatures, such as a subkey and its binding signature.
+type KeyComponent struct {
+	Packet     Packet
+	Signatures []Packet
+}
+
+// KeyUserID is a user ID in a transferable key followed by its
+// self-signatures and certifications.
+type KeyUserID struct {
+	UserID
+	Signatures []Packet
+}
+
+// IsSecret reports whether this is a secret key.
+func (k *TransferableKey) IsSecret() bool {
+	return k.Primary.Tag == 5
+}
+
+// Encode returns the key re-encoded as packets, in order, such that it
+// can be parsed again.
+func (k *TransferableKey) Encode() []byte {
+	var buf bytes.Buffer
+	write := func(packets ...Packet) {
+		for _, p := range packets {
+			buf.Write(p.Encode())
+		}
+	}
+	write(k.Primary)
+	write(k.Signatures...)
+	for _, u := range k.UserIDs {
+		write(Packet{Tag: 13, Body: u.ID})
+		write(u.Signatures...)
+	}
+	for _, c := range k.Attributes {
+		write(c.Packet)
+		write(c.Signatures...)
+	}
+	for _, c := range k.Subkeys {
+		write(c.Packet)
+		write(c.Signatures...)
+	}
+	return buf.Bytes()
+}
+
+// ParseSignatures returns the supported signatures among the given
+// signature packets, skipping the rest.
+func ParseSignatures(packets []Packet) []*Signature {
+	var sigs []*Signature
+	for _, packet := range packets {
+		if sig, err := ParseSignature(packet); err == nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// ParseKeyRing parses a sequence of packets into transferable keys,
+// such as the output of "gpg --export". Trust and marker packets are
+// ignored.
+func ParseKeyRing(buf []byte) ([]*TransferableKey, error) {
+	var keys []*TransferableKey
+	var key *TransferableKey
+	var sigs *[]Packet // where the next signature belongs
+	for len(buf) > 0 {
+		var packet Packet
+		var err error
+		packet, buf, err = ParsePacket(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if packet.Tag == 10 || packet.Tag == 12 {
+			continue // Marker or Trust Packet
+		}
+		if packet.Tag == 5 || packet.Tag == 6 {
+			key = &TransferableKey{Primary: packet}
+			keys = append(keys, key)
+			sigs = &key.Signatures
+			continue
+		}
+		if key == nil {
+			return nil, ErrInvalidPacket
+		}
+
+		switch packet.Tag {
+		case 2: // Signature Packet
+			*sigs = append(*sigs, packet)
+		case 13: // User ID Packet
+			userid := KeyUserID{UserID: UserID{ID: packet.Body}}
+			key.UserIDs = append(key.UserIDs, userid)
+			sigs = &key.UserIDs[len(key.UserIDs)-1].Signatures
+		case 17: // User Attribute Packet
+			key.Attributes = append(key.Attributes, KeyComponent{Packet: packet})
+			sigs = &key.Attributes[len(key.Attributes)-1].Signatures
+		case 7, 14: // Secret-Subkey or Public-Subkey Packet
+			key.Subkeys = append(key.Subkeys, KeyComponent{Packet: packet})
+			sigs = &key.Subkeys[len(key.Subkeys)-1].Signatures
+		default:
+			return nil, ErrInvalidPacket
+		}
+	}
+	return keys, nil
+}
+
+// ReadKeyRing reads every transferable key from r, binary or ASCII
+// armored.
+func ReadKeyRing(r io.Reader) ([]*TransferableKey, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, ErrNoData
+	}
+	if buf[0] < 0x80 {
+		// Armored keys may be concatenated, each in its own block
+		var raw []byte
+		for _, block := range armorBlocks(buf) {
+			armorType, data, err := DearmorReader(bytes.NewReader(block))
+			if err != nil {
+				return nil, err
+			}
+			if armorType != ArmorPublicKey && armorType != ArmorPrivateKey {
+				return nil, ErrArmorType
+			}
+			raw = append(raw, data...)
+		}
+		buf = raw
+	}
+	return ParseKeyRing(buf)
+}
+
+// Split text into pieces each beginning with an armor opening line,
+// dropping anything before the first. With no opening line, the text is
+// one piece, left for DearmorReader to reject.
+func armorBlocks(buf []byte) [][]byte {
+	begin := []byte("-----BEGIN ")
+	var starts []int
+	for i := 0; i < len(buf); {
+		j := bytes.Index(buf[i:], begin)
+		if j < 0 {
+			break
+		}
+		if i+j == 0 || buf[i+j-1] == '\n' {
+			starts = append(starts, i+j)
+		}
+		i += j + len(begin)
+	}
+	if starts == nil {
+		return [][]byte{buf}
+	}
+	blocks := make([][]byte, len(starts))
+	for n, start := range starts {
+		end := len(buf)
+		if n+1 < len(starts) {
+			end = starts[n+1]
+		}
+		blocks[n] = buf[start:end]
+	}
+	return blocks
+}
+
+// ReadTransferableKey reads exactly one transferable key from r, binary
+// or ASCII armored.
+func ReadTransferableKey(r io.Reader) (*TransferableKey, error) {
+	keys, err := ReadKeyRing(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, ErrInvalidPacket
+	}
+	return keys[0], nil
+}
@@ -0,0 +1,221 @@
+package openpgp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"math/big"
+)
+
+// ECDSA signatures and ECDH key agreement over the NIST curves (RFC
+// 6637), for environments that require them. Secret keys are scalars
+// rather than seeds, so a seed is hashed into a scalar. Signatures use
+// deterministic nonces (RFC 6979), so like EdDSA signatures they're
+// reproducible.
+
+// Curve OIDs in version 4 key packets.
+var (
+	oidP256 = []byte{0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+	oidP384 = []byte{0x2b, 0x81, 0x04, 0x00, 0x22}
+)
+
+// Returns the NIST curve of this algorithm, or nil if it's not one.
+func (a Algorithm) nist() elliptic.Curve {
+	switch a {
+	case P256:
+		return elliptic.P256()
+	case P384:
+		return elliptic.P384()
+	}
+	return nil
+}
+
+// Returns the version 4 MPI encoding of a public key on this curve:
+// prefixed with 0x40 for the Edwards and Montgomery curves, or as is,
+// an uncompressed point, for the NIST curves.
+func (a Algorithm) point(pubkey []byte) []byte {
+	if a.nist() != nil {
+		return pubkey
+	}
+	return append([]byte{0x40}, pubkey...)
+}
+
+// Decodes an MPI-encoded public key on this curve for a key whose
+// secret key has the given size, returning nil if it's invalid.
+func (a Algorithm) decodePoint(buf []byte, size int) (pubkey, rest []byte) {
+	n, prefix := 1+size, byte(0x40)
+	if a.nist() != nil {
+		n, prefix = 1+2*size, 0x04
+	}
+	point, rest := mpiDecode(buf, n)
+	if len(point) != n || point[0] != prefix {
+		return nil, nil
+	}
+	if a.nist() != nil {
+		return point, rest
+	}
+	return point[1:], rest
+}
+
+// Returns the size of a secret scalar on a NIST curve.
+func nistSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+// Derives a secret scalar on a NIST curve from a seed of the same size
+// by rejection sampling: the seed and a counter are hashed, counting up
+// until the digest is a valid scalar, in [1, N-1]. The first candidate
+// is nearly always accepted.
+func nistScalar(curve elliptic.Curve, seed []byte) []byte {
+	var h hash.Hash
+	switch nistSize(curve) {
+	case 32:
+		h = sha256.New()
+	case 48:
+		h = sha512.New384()
+	}
+	n := curve.Params().N
+	for i := uint32(0); ; i++ {
+		h.Reset()
+		h.Write(seed)
+		h.Write(marshal32be(i))
+		d := h.Sum(nil)
+		if v := new(big.Int).SetBytes(d); v.Sign() > 0 && v.Cmp(n) < 0 {
+			return d
+		}
+	}
+}
+
+// Returns the uncompressed public key for a secret scalar.
+func nistPublic(curve elliptic.Curve, seckey []byte) []byte {
+	x, y := curve.ScalarBaseMult(seckey)
+	return elliptic.Marshal(curve, x, y)
+}
+
+// Returns a deterministic ECDSA signature (RFC 6979) of a digest made
+// with the given hash, r || s at the scalar size.
+func nistSign(curve elliptic.Curve, h crypto.Hash, seckey, digest []byte) []byte {
+	n := curve.Params().N
+	d := new(big.Int).SetBytes(seckey)
+	e := bits2int(digest, n.BitLen())
+	nonces := newRFC6979(n, h, seckey, digest)
+	for {
+		k := nonces.next()
+		x, _ := curve.ScalarBaseMult(k.FillBytes(make([]byte, nistSize(curve))))
+		r := x.Mod(x, n)
+		if r.Sign() == 0 {
+			continue
+		}
+		// s = k^-1 (e + r d) mod n
+		s := new(big.Int).Mul(r, d)
+		s.Add(s, e)
+		s.Mul(s, k.ModInverse(k, n))
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+		size := nistSize(curve)
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig
+	}
+}
+
+// Interprets a bit string as an integer of at most qlen bits, keeping
+// the leftmost bits (RFC 6979 section 2.3.2).
+func bits2int(b []byte, qlen int) *big.Int {
+	v := new(big.Int).SetBytes(b)
+	if excess := len(b)*8 - qlen; excess > 0 {
+		v.Rsh(v, uint(excess))
+	}
+	return v
+}
+
+// rfc6979 generates the nonces for ECDSA signatures with HMAC-DRBG, as
+// in RFC 6979 section 3.2, seeded by the secret key and the digest.
+type rfc6979 struct {
+	n    *big.Int
+	hash crypto.Hash
+	k, v []byte
+}
+
+func newRFC6979(n *big.Int, h crypto.Hash, seckey, digest []byte) *rfc6979 {
+	rlen := (n.BitLen() + 7) / 8
+	x := new(big.Int).SetBytes(seckey).FillBytes(make([]byte, rlen))
+	z := bits2int(digest, n.BitLen())
+	if z.Cmp(n) >= 0 {
+		z.Sub(z, n)
+	}
+	h1 := z.FillBytes(make([]byte, rlen))
+
+	g := &rfc6979{n: n, hash: h}
+	g.v = make([]byte, h.Size())
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+	g.k = make([]byte, h.Size())
+	for _, b := range []byte{0x00, 0x01} {
+		g.k = g.mac(g.v, []byte{b}, x, h1)
+		g.v = g.mac(g.v)
+	}
+	wipe(x)
+	return g
+}
+
+// Returns HMAC_K of the concatenated data.
+func (g *rfc6979) mac(data ...[]byte) []byte {
+	m := hmac.New(g.hash.New, g.k)
+	for _, d := range data {
+		m.Write(d)
+	}
+	return m.Sum(nil)
+}
+
+// Returns the next candidate nonce in [1, n-1].
+func (g *rfc6979) next() *big.Int {
+	rlen := (g.n.BitLen() + 7) / 8
+	for {
+		var t []byte
+		for len(t) < rlen {
+			g.v = g.mac(g.v)
+			t = append(t, g.v...)
+		}
+		k := bits2int(t, g.n.BitLen())
+		// Prepare for another candidate, as if this one is rejected
+		g.k = g.mac(g.v, []byte{0x00})
+		g.v = g.mac(g.v)
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			return k
+		}
+	}
+}
+
+// Reports if sig, r || s of equal length, is a valid ECDSA signature of
+// a digest by an uncompressed public key.
+func nistVerify(curve elliptic.Curve, pubkey, digest, sig []byte) bool {
+	x, y := elliptic.Unmarshal(curve, pubkey)
+	if x == nil {
+		return false
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+	return ecdsa.Verify(pub, digest, r, s)
+}
+
+// Returns the ECDH shared secret, the x-coordinate of the product of a
+// secret scalar and an uncompressed public key.
+func nistDH(curve elliptic.Curve, seckey, pubkey []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(curve, pubkey)
+	if x == nil {
+		return nil, errors.New("bad input point: not on curve")
+	}
+	x, _ = curve.ScalarMult(x, y, seckey)
+	return x.FillBytes(make([]byte, nistSize(curve))), nil
+}
@@ -2,6 +2,16 @@ package openpgp
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -74,3 +84,1979 @@ func TestCRC24(t *testing.T) {
 		}
 	}
 }
+
+// Returns the hashed subpackets of a signature packet keyed by type.
+func hashedSubpackets(t *testing.T, sig []byte) map[byte][]byte {
+	packet, _, err := ParsePacket(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := packet.Body
+	n := int(body[4])<<8 | int(body[5])
+	hashed := body[6 : 6+n]
+	subpackets := make(map[byte][]byte)
+	for len(hashed) > 0 {
+		length := int(hashed[0])
+		subpackets[hashed[1]] = hashed[2 : 1+length]
+		hashed = hashed[1+length:]
+	}
+	return subpackets
+}
+
+func TestFeatures(t *testing.T) {
+	var key SignKey
+	key.Seed(make([]byte, 32))
+	userid := UserID{ID: []byte("John Doe <john.doe@example.com>")}
+
+	suites := DefaultPreferences.Ciphersuites
+	table := []struct {
+		flags    int
+		features []byte
+		aead     []byte
+		suites   []byte
+	}{
+		{0, nil, nil, nil},
+		{FlagMDC, []byte{0x01}, nil, nil},
+		{FlagMDC | FlagAEAD, []byte{0x03}, []byte{2, 1}, nil},
+		{FlagMDC | FlagSEIPDv2, []byte{0x09}, nil, suites},
+		{FlagMDC | FlagAEAD | FlagSEIPDv2, []byte{0x0b}, []byte{2, 1}, suites},
+	}
+
+	for _, row := range table {
+		subpackets := hashedSubpackets(t, key.SelfSign(&userid, 0, row.flags))
+		if got := subpackets[30]; !bytes.Equal(got, row.features) {
+			t.Errorf("SelfSign(%d) features, got %#v, want %#v",
+				row.flags, got, row.features)
+		}
+		if got := subpackets[34]; !bytes.Equal(got, row.aead) {
+			t.Errorf("SelfSign(%d) AEAD prefs, got %#v, want %#v",
+				row.flags, got, row.aead)
+		}
+		if got := subpackets[39]; !bytes.Equal(got, row.suites) {
+			t.Errorf("SelfSign(%d) ciphersuites, got %#v, want %#v",
+				row.flags, got, row.suites)
+		}
+	}
+
+	// Without ciphersuites, only the feature is advertised
+	key.SetPreferences(Preferences{})
+	subpackets := hashedSubpackets(t, key.SelfSign(&userid, 0, FlagSEIPDv2))
+	if _, ok := subpackets[39]; ok {
+		t.Errorf("SelfSign() ciphersuites, got a subpacket, want none")
+	}
+}
+
+func TestPrimary(t *testing.T) {
+	var key SignKey
+	key.Seed(make([]byte, 32))
+	userid := UserID{ID: []byte("John Doe <john.doe@example.com>")}
+
+	subpackets := hashedSubpackets(t, key.SelfSign(&userid, 0, FlagPrimary))
+	if got := subpackets[25]; !bytes.Equal(got, []byte{1}) {
+		t.Errorf("SelfSign(FlagPrimary), got %#v, want %#v", got, []byte{1})
+	}
+	subpackets = hashedSubpackets(t, key.SelfSign(&userid, 0, 0))
+	if got, ok := subpackets[25]; ok {
+		t.Errorf("SelfSign(0), got %#v, want none", got)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	var key SignKey
+	key.Seed(make([]byte, 32))
+	key.SetCreated(1000)
+	var subkey EncryptKey
+	subkey.Seed(make([]byte, 32))
+	subkey.SetCreated(1000)
+	userid := UserID{ID: []byte("John Doe <john.doe@example.com>")}
+
+	table := []struct {
+		expires, sigExpires int64
+		keyExp, sigExp      []byte
+	}{
+		{0, 0, nil, nil},
+		{0x01001000, 0, []byte{0x01, 0x00, 0x0c, 0x18}, nil},
+		{0, 0x02002000, nil, []byte{0x02, 0x00, 0x18, 0x30}},
+		{0x01001000, 0x02002000,
+			[]byte{0x01, 0x00, 0x0c, 0x18}, []byte{0x02, 0x00, 0x18, 0x30}},
+	}
+
+	for _, row := range table {
+		key.SetExpires(row.expires)
+		key.SetSigExpires(row.sigExpires)
+		subkey.SetExpires(row.expires)
+
+		sigs := map[string][]byte{
+			"SelfSign": key.SelfSign(&userid, 2000, 0),
+			"Bind":     key.Bind(&subkey, 2000),
+		}
+		for name, sig := range sigs {
+			subpackets := hashedSubpackets(t, sig)
+			if got := subpackets[9]; !bytes.Equal(got, row.keyExp) {
+				t.Errorf("%s key expiration, got %#v, want %#v",
+					name, got, row.keyExp)
+			}
+			if got := subpackets[3]; !bytes.Equal(got, row.sigExp) {
+				t.Errorf("%s sig expiration, got %#v, want %#v",
+					name, got, row.sigExp)
+			}
+		}
+	}
+}
+
+func TestCanonicalizeText(t *testing.T) {
+	table := []struct {
+		input, want string
+	}{
+		{"", ""},
+		{"hello", "hello"},
+		{"hello\n", "hello\r\n"},
+		{"a\nb\nc\n", "a\r\nb\r\nc\r\n"},
+		{"a\r\nb\r\nc\r\n", "a\r\nb\r\nc\r\n"},
+		{"a\nb\r\nc\nd", "a\r\nb\r\nc\r\nd"},
+		{"a  \nb\t\r\nc \t", "a  \r\nb\t\r\nc \t"},
+		{"\n\r\n \n", "\r\n\r\n \r\n"},
+		{"-- dash \t\n", "-- dash \t\r\n"},
+	}
+
+	for _, row := range table {
+		var buf bytes.Buffer
+		err := canonicalizeText(&buf, strings.NewReader(row.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != row.want {
+			t.Errorf("canonicalizeText(%q), got %q, want %q",
+				row.input, got, row.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var key, other SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	other.Seed(bytes.Repeat([]byte{2}, 32))
+
+	const message = "hello world\n"
+	packet, err := key.Sign(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _, err := ParsePacket(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sig.IssuedBy(key.KeyID()) {
+		t.Errorf("IssuedBy(%X), got false, want true", key.KeyID())
+	}
+	if sig.IssuedBy(other.KeyID()) {
+		t.Errorf("IssuedBy(%X), got true, want false", other.KeyID())
+	}
+
+	table := []struct {
+		key     *SignKey
+		message string
+		want    error
+	}{
+		{&key, message, nil},
+		{&key, message + "!", ErrBadSignature},
+		{&other, message, ErrBadSignature},
+	}
+	for _, row := range table {
+		got := row.key.Verify(strings.NewReader(row.message), sig)
+		if got != row.want {
+			t.Errorf("Verify(%q), got %v, want %v", row.message, got, row.want)
+		}
+	}
+}
+
+func TestClearsign(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+
+	const (
+		input = "hello  \n- dash\r\n-----BEGIN\nbye\t"
+		text  = "hello\n- - dash\n- -----BEGIN\nbye\n"
+		plain = "hello\n- dash\n-----BEGIN\nbye"
+		head  = "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n"
+	)
+	r := key.Clearsign(strings.NewReader(input))
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+
+	if !strings.HasPrefix(got, head+text) {
+		t.Fatalf("Clearsign(%q), got %q, want prefix %q",
+			input, got, head+text)
+	}
+	armored := got[len(head+text):]
+	raw, err := Dearmor([]byte(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, _, err := ParsePacket(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Type != 0x01 {
+		t.Errorf("Clearsign signature type, got %#x, want 0x01", sig.Type)
+	}
+	if err := key.Verify(strings.NewReader(plain), sig); err != nil {
+		t.Errorf("Clearsign(%q) does not verify: %v", input, err)
+	}
+}
+
+func TestClearsignAll(t *testing.T) {
+	var a, b SignKey
+	a.Seed(bytes.Repeat([]byte{1}, 32))
+	b.Seed(bytes.Repeat([]byte{2}, 32))
+
+	const (
+		input = "statement\n"
+		head  = "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n"
+	)
+	r := ClearsignAll(strings.NewReader(input), &a, &b)
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.HasPrefix(got, head+input) {
+		t.Fatalf("ClearsignAll(), got %q", got)
+	}
+	raw, err := Dearmor([]byte(got[len(head+input):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []*SignKey{&a, &b} {
+		packet, rest, err := ParsePacket(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = rest
+		sig, err := ParseSignature(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := key.Verify(strings.NewReader("statement"), sig); err != nil {
+			t.Errorf("ClearsignAll() signature %d does not verify: %v", i, err)
+		}
+	}
+	if len(raw) != 0 {
+		t.Errorf("ClearsignAll(), %d trailing bytes", len(raw))
+	}
+}
+
+func TestAESKeyWrap(t *testing.T) {
+	// Test vector from RFC 3394, 4.6
+	kek := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+		0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17,
+		0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f,
+	}
+	key := []byte{
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	}
+	want := []byte{
+		0x28, 0xc9, 0xf4, 0x04, 0xc4, 0xb8, 0x10, 0xf4,
+		0xcb, 0xcc, 0xb3, 0x5c, 0xfb, 0x87, 0xf8, 0x26,
+		0x3f, 0x57, 0x86, 0xe2, 0xd8, 0x0e, 0xd3, 0x26,
+		0xcb, 0xc7, 0xf0, 0xe7, 0x1a, 0x99, 0xf4, 0x3b,
+		0xfb, 0x98, 0x8b, 0x9b, 0x7a, 0x02, 0xdd, 0x21,
+	}
+
+	got := aesKeyWrap(kek, key)
+	if !bytes.Equal(got, want) {
+		t.Errorf("aesKeyWrap(), got %#v, want %#v", got, want)
+	}
+	got = aesKeyUnwrap(kek, want)
+	if !bytes.Equal(got, key) {
+		t.Errorf("aesKeyUnwrap(), got %#v, want %#v", got, key)
+	}
+	want[0] ^= 1
+	if got := aesKeyUnwrap(kek, want); got != nil {
+		t.Errorf("aesKeyUnwrap(), got %#v, want nil", got)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	var key, other EncryptKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	other.Seed(bytes.Repeat([]byte{2}, 32))
+	message := "Hello, world!\n"
+
+	msg, err := key.Encrypt(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := key.Decrypt(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != message {
+		t.Errorf("Decrypt(), got %q, want %q", got, message)
+	}
+
+	if _, err := other.Decrypt(msg); err != ErrNotRecipient {
+		t.Errorf("Decrypt() wrong key, got %v, want %v",
+			err, ErrNotRecipient)
+	}
+
+	msg[len(msg)-1] ^= 1
+	if _, err := key.Decrypt(msg); err != ErrModified {
+		t.Errorf("Decrypt() modified, got %v, want %v", err, ErrModified)
+	}
+}
+
+func TestPartialLength(t *testing.T) {
+	// Literal Data split into a 4-byte partial chunk and a final chunk
+	buf := []byte{0xc0 | 11, 0xe2, 1, 2, 3, 4, 2, 5, 6, 0xff}
+	p, rest, err := ParsePacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{1, 2, 3, 4, 5, 6}
+	if p.Tag != 11 || !bytes.Equal(p.Body, want) {
+		t.Errorf("ParsePacket(), got %d %v, want 11 %v", p.Tag, p.Body, want)
+	}
+	if !bytes.Equal(rest, []byte{0xff}) {
+		t.Errorf("ParsePacket() rest, got %v, want [255]", rest)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	body := bytes.Repeat([]byte{'x'}, 300)
+	tests := []struct {
+		in   []byte
+		tag  byte
+		body []byte
+		err  string
+	}{
+		{[]byte{0xcd, 1, 'x'}, 13, body[:1], ""},
+		{append([]byte{0xcd, 0xc0, 0x6c}, body...), 13, body, ""},
+		{append([]byte{0xcd, 0xff, 0, 0, 1, 0x2c}, body...), 13, body, ""},
+		{[]byte{0xb4, 1, 'x'}, 13, body[:1], ""},                     // old, 1-octet
+		{append([]byte{0xb5, 1, 0x2c}, body...), 13, body, ""},       // old, 2-octet
+		{append([]byte{0xb6, 0, 0, 1, 0x2c}, body...), 13, body, ""}, // old, 4-octet
+		{[]byte{0xaf, 'x', 'y'}, 11, []byte("xy"), ""},               // old, indeterminate
+		{[]byte{0xcd}, 0, nil, "truncated packet header"},
+		{[]byte{0x4d, 1, 'x'}, 0, nil, "not a packet header"},
+		{[]byte{0xc0, 1, 'x'}, 0, nil, "reserved packet tag 0"},
+		{[]byte{0x80, 1, 'x'}, 0, nil, "reserved packet tag 0"},
+		{[]byte{0xcd, 0xc0}, 0, nil, "truncated packet header"},
+		{[]byte{0xcd, 0xff, 0, 0}, 0, nil, "truncated packet header"},
+		{[]byte{0xb6, 0, 0}, 0, nil, "truncated packet header"},
+		{[]byte{0xcd, 2, 'x'}, 0, nil, "truncated packet body"},
+		{[]byte{0xcd, 0xff, 0xff, 0xff, 0xff, 0xff}, 0, nil, "packet too large"},
+		{[]byte{0xcd, 0xe1, 'x', 'y', 0}, 0, nil, "partial length on non-data packet"},
+		{[]byte{0xcb, 0xe1, 'x', 'y'}, 0, nil, "truncated packet header"},
+		{[]byte{0xcb, 0xfe, 'x'}, 0, nil, "truncated packet body"},
+	}
+	for _, test := range tests {
+		p, rest, err := ParsePacket(test.in)
+		if test.err != "" {
+			if !errors.Is(err, ErrInvalidPacket) ||
+				!strings.HasSuffix(err.Error(), test.err) {
+				t.Errorf("ParsePacket(%X), got %v, want %s", test.in, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePacket(%X), got %v", test.in, err)
+			continue
+		}
+		if p.Tag != test.tag || !bytes.Equal(p.Body, test.body) || len(rest) != 0 {
+			t.Errorf("ParsePacket(%X), got %d %q %X", test.in, p.Tag, p.Body, rest)
+		}
+	}
+
+	// Long user IDs need multi-octet lengths
+	userid := UserID{ID: body}
+	p, _, err := ParsePacket(userid.Packet())
+	if err != nil || !bytes.Equal(p.Body, body) {
+		t.Errorf("UserID.Packet(), got %v %q", err, p.Body)
+	}
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	sig, err := ParseSignature(mustParse(t, key.SelfSign(&userid, 0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Certifications hash the user ID the same way
+	certsig := key.Certify(key.PubPacket(), userid.Packet(), 0)
+	certified, err := ParseSignature(mustParse(t, certsig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.VerifyUserID(&userid, sig) != nil ||
+		key.VerifyUserID(&userid, certified) != nil {
+		t.Errorf("VerifyUserID(long user ID), bad signature")
+	}
+}
+
+func TestProtect(t *testing.T) {
+	var key SignKey
+	var subkey EncryptKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	subkey.Seed(bytes.Repeat([]byte{2}, 32))
+	passphrase := []byte("passphrase")
+
+	packet, _, err := ParsePacket(key.EncPacket(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded SignKey
+	if err := loaded.Load(packet, nil); err != ErrDecryptKey {
+		t.Errorf("Load() no passphrase, got %v, want %v", err, ErrDecryptKey)
+	}
+	err = loaded.Load(packet, []byte("wrong"))
+	if err != ErrDecryptKey {
+		t.Errorf("Load() wrong passphrase, got %v, want %v",
+			err, ErrDecryptKey)
+	}
+	if err := loaded.Load(packet, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Key, key.Key) {
+		t.Errorf("Load(), got %x, want %x", loaded.Key, key.Key)
+	}
+
+	packet, _, err = ParsePacket(subkey.EncPacket(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loadedSub EncryptKey
+	if err := loadedSub.Load(packet, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loadedSub.Key, subkey.Key) {
+		t.Errorf("Load() subkey, got %x, want %x", loadedSub.Key, subkey.Key)
+	}
+}
+
+func TestStubPacket(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+
+	stub := key.StubPacket()
+	packet, _, err := ParsePacket(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Tag != 5 {
+		t.Errorf("StubPacket() tag, got %d, want 5", packet.Tag)
+	}
+	pub := key.PubPacket()
+	if !bytes.Equal(packet.Body[:len(pub)-2], pub[2:]) {
+		t.Errorf("StubPacket() public key, got %x", packet.Body)
+	}
+	want := []byte{254, 7, 101, 2, 'G', 'N', 'U', 1}
+	if !bytes.Equal(packet.Body[len(pub)-2:], want) {
+		t.Errorf("StubPacket() S2K, got %x, want %x",
+			packet.Body[len(pub)-2:], want)
+	}
+	var loaded SignKey
+	err = loaded.Load(packet, []byte("passphrase"))
+	if err != ErrUnsupportedPacket {
+		t.Errorf("Load() stub, got %v, want %v", err, ErrUnsupportedPacket)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	comment := "Replaced by a new key"
+
+	sig := key.Revoke(RevokeSuperseded, comment, 1500000000)
+	subpackets := hashedSubpackets(t, sig)
+	want := append([]byte{RevokeSuperseded}, comment...)
+	if got := subpackets[29]; !bytes.Equal(got, want) {
+		t.Errorf("Revoke() reason, got %q, want %q", got, want)
+	}
+
+	armored := string(Armor(sig))
+	prefix := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n"
+	if !strings.HasPrefix(armored, prefix) {
+		t.Errorf("Armor(Revoke()), got %q, want prefix %q",
+			armored[:strings.IndexByte(armored, '\n')+1], prefix)
+	}
+}
+
+func TestDesignateRevokers(t *testing.T) {
+	var key, other SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	other.Seed(bytes.Repeat([]byte{2}, 32))
+	revokers := []Revoker{
+		{Algorithm: 22, Fingerprint: other.KeyID()},
+		{Algorithm: 1, Fingerprint: bytes.Repeat([]byte{0xab}, 20)},
+	}
+
+	buf := key.DesignateRevokers(revokers, 1500000000)
+	packet, _, err := ParsePacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Type != 0x1f {
+		t.Errorf("Type, got %#x, want 0x1f", sig.Type)
+	}
+	if len(sig.Revokers) != len(revokers) {
+		t.Fatalf("Revokers, got %d, want %d", len(sig.Revokers), len(revokers))
+	}
+	for i, want := range revokers {
+		got := sig.Revokers[i]
+		if got.Algorithm != want.Algorithm ||
+			!bytes.Equal(got.Fingerprint, want.Fingerprint) {
+			t.Errorf("Revokers[%d], got %d:%X, want %d:%X", i,
+				got.Algorithm, got.Fingerprint,
+				want.Algorithm, want.Fingerprint)
+		}
+	}
+
+	if err := key.VerifyKey(sig); err != nil {
+		t.Errorf("VerifyKey(), got %v", err)
+	}
+	if err := other.VerifyKey(sig); err == nil {
+		t.Errorf("VerifyKey() by the wrong key, got nil")
+	}
+}
+
+func TestBindAuth(t *testing.T) {
+	var key, authkey SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	authkey.Seed(bytes.Repeat([]byte{2}, 32))
+
+	subpackets := hashedSubpackets(t, key.BindAuth(&authkey, 0))
+	if got := subpackets[27]; !bytes.Equal(got, []byte{0x20}) {
+		t.Errorf("BindAuth() key flags, got %#v, want %#v",
+			got, []byte{0x20})
+	}
+
+	packet, _, err := ParsePacket(authkey.SubPacket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded SignKey
+	if err := loaded.Load(packet, nil); err != nil {
+		t.Fatal(err)
+	}
+	if packet.Tag != 7 || !bytes.Equal(loaded.Key, authkey.Key) {
+		t.Errorf("Load(SubPacket()), got %d %x, want 7 %x",
+			packet.Tag, loaded.Key, authkey.Key)
+	}
+}
+
+func TestKeyFlags(t *testing.T) {
+	var key SignKey
+	var subkey EncryptKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	subkey.Seed(bytes.Repeat([]byte{2}, 32))
+	userid := UserID{ID: []byte("test")}
+
+	flags := func(buf []byte) byte {
+		packet, _, err := ParsePacket(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ParseSignature(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig.KeyFlags
+	}
+
+	if got := flags(key.SelfSign(&userid, 0, 0)); got != 0x03 {
+		t.Errorf("SelfSign() flags, got %#x, want 0x3", got)
+	}
+	if got := flags(key.Bind(&subkey, 0)); got != 0x0c {
+		t.Errorf("Bind() flags, got %#x, want 0xc", got)
+	}
+
+	key.SetKeyFlags(KeyFlagCertify)
+	subkey.SetKeyFlags(KeyFlagEncryptStorage)
+	if got := flags(key.SelfSign(&userid, 0, 0)); got != KeyFlagCertify {
+		t.Errorf("SelfSign() flags, got %#x, want %#x", got, KeyFlagCertify)
+	}
+	if got := flags(key.Bind(&subkey, 0)); got != KeyFlagEncryptStorage {
+		t.Errorf("Bind() flags, got %#x, want %#x", got, KeyFlagEncryptStorage)
+	}
+}
+
+func TestBindSign(t *testing.T) {
+	var key, signsub SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	signsub.Seed(bytes.Repeat([]byte{2}, 32))
+
+	binding := key.BindSign(&signsub, 0)
+	packet, _, err := ParsePacket(binding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Type != 0x18 || sig.KeyFlags != 0x02 {
+		t.Errorf("BindSign(), got type %#x flags %#x, want 0x18 0x2",
+			sig.Type, sig.KeyFlags)
+	}
+
+	// The embedded signature is made by the subkey
+	embedded := hashedSubpackets(t, binding)[32]
+	backsig, err := ParseSignature(Packet{Tag: 2, Body: embedded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backsig.Type != 0x19 || !backsig.IssuedBy(signsub.KeyID()) {
+		t.Errorf("BindSign() embedded, got type %#x issuer %X",
+			backsig.Type, backsig.IssuerID())
+	}
+}
+
+func TestVerifySelf(t *testing.T) {
+	var key, signsub, other SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	signsub.Seed(bytes.Repeat([]byte{2}, 32))
+	other.Seed(bytes.Repeat([]byte{3}, 32))
+	var subkey EncryptKey
+	subkey.Seed(bytes.Repeat([]byte{4}, 32))
+
+	parse := func(buf []byte) *Signature {
+		packet, _, err := ParsePacket(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ParseSignature(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+	sig := parse(key.SelfSign(&userid, 0, 0))
+	if err := key.VerifyUserID(&userid, sig); err != nil {
+		t.Errorf("VerifyUserID(), got %v", err)
+	}
+	tampered := UserID{ID: []byte("Bar <foo@example.com>")}
+	if err := key.VerifyUserID(&tampered, sig); err != ErrBadSignature {
+		t.Errorf("VerifyUserID(tampered), got %v", err)
+	}
+	if err := other.VerifyUserID(&userid, sig); err != ErrBadSignature {
+		t.Errorf("VerifyUserID(other key), got %v", err)
+	}
+
+	sig = parse(key.Bind(&subkey, 0))
+	if err := key.VerifyBinding(subkey.PubPacket(), sig); err != nil {
+		t.Errorf("VerifyBinding(), got %v", err)
+	}
+	if err := key.VerifyBinding(signsub.SubPubPacket(), sig); err == nil {
+		t.Errorf("VerifyBinding(wrong subkey), got nil")
+	}
+
+	sig = parse(key.BindSign(&signsub, 0))
+	if err := key.VerifyBinding(signsub.SubPubPacket(), sig); err != nil {
+		t.Errorf("VerifyBinding(signing), got %v", err)
+	}
+	// A signing subkey binding without cross-certification is invalid
+	sig = parse(key.bind(signsub.SubPubPacket(), 0x02, 0, 0))
+	if err := key.VerifyBinding(signsub.SubPubPacket(), sig); err == nil {
+		t.Errorf("VerifyBinding(no backsig), got nil")
+	}
+}
+
+func TestKDFParams(t *testing.T) {
+	// GnuPG's cv25519 subkeys use SHA-256 and AES-128 for the KDF
+	var orig EncryptKey
+	orig.Seed(bytes.Repeat([]byte{1}, 32))
+	buf := orig.Packet()
+	buf[56] = 8 // SHA-256
+	buf[57] = 7 // AES-128
+	packet, _, err := ParsePacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var key EncryptKey
+	if err := key.Load(packet, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := key.PubPacket(); !bytes.Equal(got[54:58], buf[54:58]) {
+		t.Errorf("PubPacket() KDF, got %X, want %X", got[54:58], buf[54:58])
+	}
+
+	message := "Hello, world!\n"
+	msg, err := key.Encrypt(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := key.Decrypt(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != message {
+		t.Errorf("Decrypt(), got %q, want %q", got, message)
+	}
+}
+
+func TestDearmor(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	packet := key.PubPacket()
+	armored := string(Armor(packet))
+
+	armorType, raw, err := DearmorReader(strings.NewReader(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if armorType != ArmorPublicKey || !bytes.Equal(raw, packet) {
+		t.Errorf("DearmorReader(), got %q %X, want %q %X",
+			armorType, raw, ArmorPublicKey, packet)
+	}
+
+	tail := strings.Replace(armored, "END PGP PUBLIC", "END PGP PRIVATE", 1)
+	if _, err := Dearmor([]byte(tail)); err != ErrInvalidArmor {
+		t.Errorf("Dearmor(mismatched tail), got %v, want %v",
+			err, ErrInvalidArmor)
+	}
+
+	unknown := strings.Replace(armored, "PUBLIC KEY BLOCK", "FOO", 2)
+	if _, err := Dearmor([]byte(unknown)); err != ErrArmorType {
+		t.Errorf("Dearmor(unknown type), got %v, want %v", err, ErrArmorType)
+	}
+
+	i := strings.Index(armored, "\n\n") + 2
+	corrupt := armored[:i] + "A" + armored[i+1:]
+	if _, err := Dearmor([]byte(corrupt)); err != ErrArmorCRC {
+		t.Errorf("Dearmor(corrupt), got %v, want %v", err, ErrArmorCRC)
+	}
+}
+
+func TestKeyFromSeed(t *testing.T) {
+	seed := bytes.Repeat([]byte{1}, 32)
+	var key SignKey
+	key.Seed(seed)
+	key.SetCreated(1234)
+	got := KeyFromSeed(seed, 1234)
+	if !bytes.Equal(got.KeyID(), key.KeyID()) {
+		t.Errorf("KeyFromSeed() Key ID, got %X, want %X",
+			got.KeyID(), key.KeyID())
+	}
+	if got.Created() != 1234 {
+		t.Errorf("KeyFromSeed() created, got %d, want 1234", got.Created())
+	}
+
+	var subkey EncryptKey
+	subkey.Seed(seed)
+	subkey.SetCreated(1234)
+	sub := EncryptKeyFromSeed(seed, 1234)
+	if !bytes.Equal(sub.PubPacket(), subkey.PubPacket()) {
+		t.Errorf("EncryptKeyFromSeed(), packets differ")
+	}
+}
+
+func TestIssuerFingerprint(t *testing.T) {
+	var key, signsub SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	signsub.Seed(bytes.Repeat([]byte{2}, 32))
+	var subkey EncryptKey
+	subkey.Seed(bytes.Repeat([]byte{3}, 32))
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+	detached, err := key.Sign(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append([]byte{0x04}, key.KeyID()...)
+	sigs := map[string][]byte{
+		"SelfSign": key.SelfSign(&userid, 0, 0),
+		"Bind":     key.Bind(&subkey, 0),
+		"BindSign": key.BindSign(&signsub, 0),
+		"Revoke":   key.Revoke(RevokeNoReason, "", 0),
+		"Certify":  key.Certify(key.PubPacket(), userid.Packet(), 0),
+		"Sign":     detached,
+	}
+	for name, sig := range sigs {
+		got := hashedSubpackets(t, sig)[33]
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s issuer fingerprint, got %X, want %X",
+				name, got, want)
+		}
+	}
+}
+
+func TestNotation(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	long := strings.Repeat("x", 300) // needs a two-octet subpacket length
+	key.AddNotation("build@example.com", "1234")
+	key.AddNotation("policy@example.com", long)
+
+	buf, err := key.Sign(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, _, err := ParsePacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Notation{
+		{"build@example.com", "1234"},
+		{"policy@example.com", long},
+	}
+	if len(sig.Notations) != len(want) {
+		t.Fatalf("Notations, got %d, want %d", len(sig.Notations), len(want))
+	}
+	for i := range want {
+		if sig.Notations[i] != want[i] {
+			t.Errorf("Notations[%d], got %q, want %q",
+				i, sig.Notations[i].Name, want[i].Name)
+		}
+	}
+	if err := key.Verify(strings.NewReader("hello"), sig); err != nil {
+		t.Errorf("Verify(), got %v", err)
+	}
+
+	// Self-signatures never carry notations
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+	if _, ok := hashedSubpackets(t, key.SelfSign(&userid, 0, 0))[20]; ok {
+		t.Errorf("SelfSign() has a notation")
+	}
+}
+
+func TestPolicyURI(t *testing.T) {
+	const policy = "https://example.com/policy"
+	const server = "hkps://keys.example.com"
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	key.SetPolicyURI(policy)
+	key.SetKeyServer(server)
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+
+	data, err := key.Sign(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := []struct {
+		name   string
+		sig    []byte
+		server string
+	}{
+		{"SelfSign", key.SelfSign(&userid, 0, 0), server},
+		{"Certify", key.Certify(key.PubPacket(), userid.Packet(), 0), server},
+		{"Sign", data, ""}, // key server is a property of the key
+	}
+	for _, row := range table {
+		sig, err := ParseSignature(mustParse(t, row.sig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.PolicyURI != policy {
+			t.Errorf("%s() policy, got %q, want %q",
+				row.name, sig.PolicyURI, policy)
+		}
+		if sig.KeyServer != row.server {
+			t.Errorf("%s() key server, got %q, want %q",
+				row.name, sig.KeyServer, row.server)
+		}
+	}
+
+	key.SetPolicyURI("")
+	key.SetKeyServer("")
+	subpackets := hashedSubpackets(t, key.SelfSign(&userid, 0, 0))
+	if _, ok := subpackets[26]; ok {
+		t.Errorf("SelfSign() has a policy URI after clearing it")
+	}
+	if _, ok := subpackets[24]; ok {
+		t.Errorf("SelfSign() has a key server after clearing it")
+	}
+}
+
+func TestSignerUID(t *testing.T) {
+	const uid = "Foo <foo@example.com>"
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	key.SetSignerUID(uid)
+
+	binary, err := key.Sign(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := key.SignText(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := []struct {
+		name string
+		sig  []byte
+	}{
+		{"Sign", binary},
+		{"SignText", text},
+	}
+	for _, row := range table {
+		sig, err := ParseSignature(mustParse(t, row.sig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.SignerUID != uid {
+			t.Errorf("%s() signer, got %q, want %q", row.name, sig.SignerUID, uid)
+		}
+	}
+
+	// Only data signatures name the signer
+	userid := UserID{ID: []byte(uid)}
+	if _, ok := hashedSubpackets(t, key.SelfSign(&userid, 0, 0))[28]; ok {
+		t.Errorf("SelfSign() has a signer's user ID")
+	}
+}
+
+func TestPhotoID(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+
+	// Sizes needing each subpacket length encoding
+	for _, size := range []int{100, 1000, 10000} {
+		jpeg := append([]byte{0xff, 0xd8, 0xff}, make([]byte, size)...)
+		photo := PhotoID{JPEG: jpeg}
+
+		var loaded PhotoID
+		if err := loaded.Load(mustParse(t, photo.Packet())); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded.JPEG, jpeg) {
+			t.Errorf("Load(%d), JPEG mismatch", size)
+		}
+
+		buf := key.SelfSignPhoto(&photo, 0, 0)
+		sig, err := ParseSignature(mustParse(t, buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := key.VerifyPhoto(&loaded, sig); err != nil {
+			t.Errorf("VerifyPhoto(%d), got %v", size, err)
+		}
+		other := PhotoID{JPEG: jpeg[:len(jpeg)-1]}
+		if err := key.VerifyPhoto(&other, sig); err == nil {
+			t.Errorf("VerifyPhoto(%d) of another photo, got nil", size)
+		}
+	}
+
+	// Other user attributes are unsupported
+	var photo PhotoID
+	other := Packet{Tag: 17, Body: []byte{2, 100, 0}}
+	if err := photo.Load(other); err != ErrUnsupportedPacket {
+		t.Errorf("Load(other), got %v, want %v", err, ErrUnsupportedPacket)
+	}
+	truncated := Packet{Tag: 17, Body: []byte{20, 1, 0x10}}
+	if err := photo.Load(truncated); err != ErrInvalidPacket {
+		t.Errorf("Load(truncated), got %v, want %v", err, ErrInvalidPacket)
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+
+	subpackets := hashedSubpackets(t, key.SelfSign(&userid, 0, 0))
+	want := map[byte][]byte{
+		11: DefaultPreferences.Symmetric,
+		21: DefaultPreferences.Hash,
+		22: DefaultPreferences.Compression,
+	}
+	for typ, prefs := range want {
+		if got := subpackets[typ]; !bytes.Equal(got, prefs) {
+			t.Errorf("SelfSign() subpacket %d, got %v, want %v",
+				typ, got, prefs)
+		}
+	}
+
+	key.SetPreferences(Preferences{Symmetric: []byte{7}})
+	subpackets = hashedSubpackets(t, key.SelfSign(&userid, 0, 0))
+	if got := subpackets[11]; !bytes.Equal(got, []byte{7}) {
+		t.Errorf("SetPreferences() symmetric, got %v, want [7]", got)
+	}
+	if _, ok := subpackets[21]; ok {
+		t.Errorf("SetPreferences() hash, got a subpacket, want none")
+	}
+}
+
+// Sample v6 secret key from RFC 9580, Appendix A.4.
+const rfc9580SecretKey = `
+xUsGY4d/4xsAAAAg+U2nu0jWCmHlZ3BqZYfQMxmZu52JGggkLq2EVD34laMAGXKB
+exK+cH6NX1hs5hNhIB00TrJmosgv3mg1ditlsLfCsQYfGwoAAABCBYJjh3/jAwsJ
+BwUVCg4IDAIWAAKbAwIeCSIhBssYbE8GCaaX5NUt+mxyKwwfHifBilZwj2Ul7Ce6
+2azJBScJAgcCAAAAAK0oIBA+LX0ifsDm185Ecds2v8lwgyU2kCcUmKfvBXbAf6rh
+RYWzuQOwEn7E/aLwIwRaLsdry0+VcallHhSu4RN6HWaEQsiPlR4zxP/TP7mhfVEe
+7XWPxtnMUMtf15OyA51YBMdLBmOHf+MZAAAAIIaTJINn+eUBXbki+PSAld2nhJh/
+LVmFsS+60WyvXkQ1AE1gCk95TUR3XFeibg/u/tVY6a//1q0NWC1X+yui3O24wpsG
+GBsKAAAALAWCY4d/4wKbDCIhBssYbE8GCaaX5NUt+mxyKwwfHifBilZwj2Ul7Ce6
+2azJAAAAAAQBIKbpGG2dWTX8j+VjFM21J0hqWlEg+bdiojWnKfA5AQpWUWtnNwDE
+M0g12vYxoWM8Y81W+bHBw805I8kWVkXU6vFOi+HWvv/ira7ofJu16NnoUkhclkUr
+k0mXubZvyl4GBg==`
+
+func TestV6(t *testing.T) {
+	buf, err := base64.StdEncoding.DecodeString(
+		strings.Replace(rfc9580SecretKey, "\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []Packet
+	for len(buf) > 0 {
+		var packet Packet
+		packet, buf, err = ParsePacket(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+
+	var key SignKey
+	if err := key.Load(packets[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "CB186C4F0609A697E4D52DFA6C722B0C1F1E27C18A56708F6525EC27BAD9ACC9"
+	if got := fmt.Sprintf("%X", key.KeyID()); got != want {
+		t.Errorf("KeyID(), got %s, want %s", got, want)
+	}
+	var subkey EncryptKey
+	if err := subkey.Load(packets[2], nil); err != nil {
+		t.Fatal(err)
+	}
+	if subkey.Version() != 6 {
+		t.Errorf("Version(), got %d, want 6", subkey.Version())
+	}
+	sig, err := ParseSignature(packets[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.VerifyBinding(subkey.PubPacket(), sig); err != nil {
+		t.Errorf("VerifyBinding(), got %v", err)
+	}
+
+	// Round trip through our own version 6 packets and signatures
+	if !bytes.Equal(key.Packet(), packets[0].Encode()) {
+		t.Errorf("Packet(), got %X", key.Packet())
+	}
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+	sig, err = ParseSignature(mustParse(t, key.SelfSign(&userid, 0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.VerifyUserID(&userid, sig); err != nil {
+		t.Errorf("VerifyUserID(), got %v", err)
+	}
+	if !sig.IssuedBy(key.KeyID()) {
+		t.Errorf("IssuedBy(), got false")
+	}
+	var signsub SignKey
+	signsub.Seed(bytes.Repeat([]byte{1}, 32))
+	signsub.SetVersion(6)
+	sig, err = ParseSignature(mustParse(t, key.BindSign(&signsub, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.VerifyBinding(signsub.SubPubPacket(), sig); err != nil {
+		t.Errorf("VerifyBinding(signing), got %v", err)
+	}
+	if _, err := subkey.Encrypt(strings.NewReader("")); err != ErrKeyVersion {
+		t.Errorf("Encrypt(), got %v, want %v", err, ErrKeyVersion)
+	}
+}
+
+func mustParse(t *testing.T, buf []byte) Packet {
+	packet, _, err := ParsePacket(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestSymmetric(t *testing.T) {
+	passphrase := []byte("hunter2")
+	msg, err := EncryptSymmetric(passphrase, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecryptSymmetric(passphrase, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("DecryptSymmetric(), got %q, want %q", got, "hello")
+	}
+	if _, err := DecryptSymmetric([]byte("hunter3"), msg); err == nil {
+		t.Errorf("DecryptSymmetric(wrong passphrase), got nil error")
+	}
+}
+
+func TestSignText(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	buf, err := key.SignText(strings.NewReader("hello \nworld\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseSignature(mustParse(t, buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Type != 0x01 {
+		t.Errorf("SignText() type, got %#x, want 0x01", sig.Type)
+	}
+	crlf := strings.NewReader("hello \r\nworld\r\n")
+	if err := key.Verify(crlf, sig); err != nil {
+		t.Errorf("Verify(CRLF), got %v", err)
+	}
+	stripped := strings.NewReader("hello\nworld\n")
+	if err := key.Verify(stripped, sig); err != ErrBadSignature {
+		t.Errorf("Verify(stripped), got %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestSignTimestamp(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	for _, digest := range []bool{false, true} {
+		buf, err := key.SignTimestamp(strings.NewReader("hello"), digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ParseSignature(mustParse(t, buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Type != 0x40 {
+			t.Errorf("SignTimestamp() type, got %#x, want 0x40", sig.Type)
+		}
+		if err := key.Verify(strings.NewReader("hello"), sig); err != nil {
+			t.Errorf("Verify(), got %v", err)
+		}
+
+		var want []Notation
+		if digest {
+			want = []Notation{{DigestNotation,
+				"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}}
+		}
+		if len(sig.Notations) != len(want) ||
+			len(want) > 0 && sig.Notations[0] != want[0] {
+			t.Errorf("SignTimestamp(%v) notations, got %v, want %v",
+				digest, sig.Notations, want)
+		}
+	}
+}
+
+func TestCertifyLevel(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+	for level := 0; level <= 3; level++ {
+		buf := key.CertifyLevel(key.PubPacket(), userid.Packet(), level, 0)
+		sig, err := ParseSignature(mustParse(t, buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := byte(0x10 + level); sig.Type != want {
+			t.Errorf("CertifyLevel(%d) type, got %#x, want %#x",
+				level, sig.Type, want)
+		}
+	}
+}
+
+func TestCertifyTrust(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+	table := []Trust{
+		{Depth: 1, Amount: 120},
+		{Depth: 2, Amount: 60, Regexp: `<[^>]+[@.]example\.com>$`},
+	}
+	for _, want := range table {
+		buf := key.CertifyTrust(key.PubPacket(), userid.Packet(), 0, want, 0)
+		sig, err := ParseSignature(mustParse(t, buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Trust == nil || *sig.Trust != want {
+			t.Errorf("CertifyTrust() trust, got %v, want %v", sig.Trust, want)
+		}
+		if err := key.VerifyUserID(&userid, sig); err != nil {
+			t.Errorf("VerifyUserID(), got %v", err)
+		}
+	}
+
+	// The expression must be critical, or it could be ignored
+	trust := Trust{Depth: 1, Amount: 120, Regexp: "x"}
+	buf := key.CertifyTrust(key.PubPacket(), userid.Packet(), 0, trust, 0)
+	if got := hashedSubpackets(t, buf)[0x86]; string(got) != "x\x00" {
+		t.Errorf("CertifyTrust() regexp, got %q, want %q", got, "x\x00")
+	}
+
+	// Ordinary certifications are not trust signatures
+	buf = key.CertifyLevel(key.PubPacket(), userid.Packet(), 0, 0)
+	sig, err := ParseSignature(mustParse(t, buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Trust != nil {
+		t.Errorf("CertifyLevel() trust, got %v, want nil", sig.Trust)
+	}
+}
+
+func TestArmorWriter(t *testing.T) {
+	msg := make([]byte, 1000)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+
+	// Write in odd-sized pieces to exercise the line wrapping
+	var buf bytes.Buffer
+	w := ArmorWriter(&buf, ArmorMessage)
+	for i := 0; i < len(msg); i += 37 {
+		end := i + 37
+		if end > len(msg) {
+			end = len(msg)
+		}
+		if _, err := w.Write(msg[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	armorType, raw, err := DearmorReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if armorType != ArmorMessage || !bytes.Equal(raw, msg) {
+		t.Errorf("DearmorReader(ArmorWriter()), got %q %X, want %q %X",
+			armorType, raw, ArmorMessage, msg)
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	// Large enough to be split across partial body lengths
+	data := bytes.Repeat([]byte("hello world\n"), 20000)
+	crlf := bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+
+	for _, version := range []int{4, 6} {
+		for _, text := range []bool{false, true} {
+			var key SignKey
+			key.Seed(bytes.Repeat([]byte{1}, 32))
+			key.SetVersion(version)
+
+			var buf bytes.Buffer
+			err := key.SignMessage(&buf, bytes.NewReader(data), text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var packets []Packet
+			rest := buf.Bytes()
+			for len(rest) > 0 {
+				var packet Packet
+				packet, rest, err = ParsePacket(rest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				packets = append(packets, packet)
+			}
+			if len(packets) != 3 || packets[0].Tag != 4 ||
+				packets[1].Tag != 11 || packets[2].Tag != 2 {
+				t.Fatalf("SignMessage(v%d, %v), got %d packets",
+					version, text, len(packets))
+			}
+
+			want := data
+			if text {
+				want = crlf
+			}
+			literal := packets[1].Body[6:]
+			if !bytes.Equal(literal, want) {
+				t.Errorf("SignMessage(v%d, %v) literal data mismatch",
+					version, text)
+			}
+			sig, err := ParseSignature(packets[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = key.Verify(bytes.NewReader(literal), sig)
+			if err != nil {
+				t.Errorf("SignMessage(v%d, %v) Verify(), got %v",
+					version, text, err)
+			}
+		}
+	}
+}
+
+func TestSigTime(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	key.SetSigTime(1705276800)
+	a, err := key.Sign(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := key.Sign(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("Sign() with SetSigTime() not reproducible")
+	}
+	want := marshal32be(1705276800)
+	if got := hashedSubpackets(t, a)[2]; !bytes.Equal(got, want) {
+		t.Errorf("Sign() creation time, got %X, want %X", got, want)
+	}
+}
+
+func TestArmorHeaders(t *testing.T) {
+	var key SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	packet := key.PubPacket()
+	headers := []ArmorHeader{
+		{Name: "Version", Value: "test"},
+		{Name: "Comment", Value: "two\nlines"},
+	}
+	armored := string(Armor(packet, headers...))
+
+	want := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n" +
+		"Version: test\nComment: two lines\n\n"
+	if !strings.HasPrefix(armored, want) {
+		t.Errorf("Armor(headers), got %q, want prefix %q", armored, want)
+	}
+	raw, err := Dearmor([]byte(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, packet) {
+		t.Errorf("Dearmor(Armor(headers)), got %X, want %X", raw, packet)
+	}
+}
+
+func TestKeyRing(t *testing.T) {
+	var key, other SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	other.Seed(bytes.Repeat([]byte{2}, 32))
+	var subkey EncryptKey
+	subkey.Seed(bytes.Repeat([]byte{3}, 32))
+	userid := UserID{ID: []byte("Foo <foo@example.com>")}
+
+	var want bytes.Buffer
+	want.Write(key.PubPacket())
+	want.Write(key.Revoke(0, "", 0))
+	want.Write(userid.Packet())
+	want.Write(key.SelfSign(&userid, 0, 0))
+	want.Write(other.Certify(key.PubPacket(), userid.Packet(), 0))
+	want.Write(subkey.PubPacket())
+	want.Write(key.Bind(&subkey, 0))
+	want.Write(other.Packet())
+
+	// Trust packets are dropped
+	input := append([]byte{}, want.Bytes()...)
+	input = append(input, 0xc0|12, 2, 0, 0)
+	keys, err := ParseKeyRing(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("ParseKeyRing(), got %d keys, want 2", len(keys))
+	}
+
+	k := keys[0]
+	if k.IsSecret() || !keys[1].IsSecret() {
+		t.Errorf("IsSecret(), got %v, %v", k.IsSecret(), keys[1].IsSecret())
+	}
+	if len(k.Signatures) != 1 || len(k.UserIDs) != 1 ||
+		len(k.Attributes) != 0 || len(k.Subkeys) != 1 {
+		t.Fatalf("ParseKeyRing(), got %+v", k)
+	}
+	u := k.UserIDs[0]
+	if string(u.ID) != string(userid.ID) || len(u.Signatures) != 2 {
+		t.Errorf("ParseKeyRing(), got user ID %q with %d signatures",
+			u.ID, len(u.Signatures))
+	}
+	sigs := ParseSignatures(u.Signatures)
+	if len(sigs) != 2 || key.VerifyUserID(&u.UserID, sigs[0]) != nil {
+		t.Errorf("ParseSignatures(), bad self-signature")
+	}
+	sigs = ParseSignatures(k.Subkeys[0].Signatures)
+	if len(sigs) != 1 || key.VerifyBinding(k.Subkeys[0].Packet.Encode(), sigs[0]) != nil {
+		t.Errorf("ParseSignatures(), bad binding signature")
+	}
+
+	got := append(keys[0].Encode(), keys[1].Encode()...)
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("Encode(), got %X, want %X", got, want.Bytes())
+	}
+
+	armored := Armor(key.PubPacket())
+	one, err := ReadTransferableKey(bytes.NewReader(armored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(one.Encode(), key.PubPacket()) {
+		t.Errorf("ReadTransferableKey(), got %X", one.Encode())
+	}
+	if _, err := ReadTransferableKey(bytes.NewReader(want.Bytes())); err == nil {
+		t.Errorf("ReadTransferableKey(two keys), got nil")
+	}
+
+	// Concatenated armored keys, as from cat
+	var cat bytes.Buffer
+	cat.WriteString("comment\n")
+	cat.Write(Armor(key.PubPacket()))
+	cat.Write(Armor(other.PubPacket()))
+	keys, err = ReadKeyRing(&cat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[1].Encode(), other.PubPacket()) {
+		t.Errorf("ReadKeyRing(concatenated), got %d keys", len(keys))
+	}
+	if _, err := ParseKeyRing(userid.Packet()); err != ErrInvalidPacket {
+		t.Errorf("ParseKeyRing(no primary key), got %v", err)
+	}
+}
+
+func TestEd448RFC8032(t *testing.T) {
+	// RFC 8032, section 7.4
+	tests := []struct{ seed, pub, msg, sig string }{
+		{
+			"6c82a562cb808d10d632be89c8513ebf6c929f34ddfa8c9f63c9960ef6e3" +
+				"48a3528c8a3fcc2f044e39a3fc5b94492f8f032e7549a20098f95b",
+			"5fd7449b59b461fd2ce787ec616ad46a1da1342485a70e1f8a0ea75d80e9" +
+				"6778edf124769b46c7061bd6783df1e50f6cd1fa1abeafe8256180",
+			"",
+			"533a37f6bbe457251f023c0d88f976ae2dfb504a843e34d2074fd823d41a" +
+				"591f2b233f034f628281f2fd7a22ddd47d7828c59bd0a21bfd3980ff0d" +
+				"2028d4b18a9df63e006c5d1c2d345b925d8dc00b4104852db99ac5c7cd" +
+				"da8530a113a0f4dbb61149f05a7363268c71d95808ff2e652600",
+		},
+		{
+			"c4eab05d357007c632f3dbb48489924d552b08fe0c353a0d4a1f00acda2c" +
+				"463afbea67c5e8d2877c5e3bc397a659949ef8021e954e0a12274e",
+			"43ba28f430cdff456ae531545f7ecd0ac834a55d9358c0372bfa0c6c6798" +
+				"c0866aea01eb00742802b8438ea4cb82169c235160627b4c3a9480",
+			"03",
+			"26b8f91727bd62897af15e41eb43c377efb9c610d48f2335cb0bd0087810" +
+				"f4352541b143c4b981b7e18f62de8ccdf633fc1bf037ab7cd779805e0d" +
+				"bcc0aae1cbcee1afb2e027df36bc04dcecbf154336c19f0af7e0a64729" +
+				"05e799f1953d2a0ff3348ab21aa4adafd1d234441cf807c03a00",
+		},
+		{
+			"cd23d24f714274e744343237b93290f511f6425f98e64459ff203e898508" +
+				"3ffdf60500553abc0e05cd02184bdb89c4ccd67e187951267eb328",
+			"dcea9e78f35a1bf3499a831b10b86c90aac01cd84b67a0109b55a36e9328" +
+				"b1e365fce161d71ce7131a543ea4cb5f7e9f1d8b00696447001400",
+			"0c3e544074ec63b0265e0c",
+			"1f0a8888ce25e8d458a21130879b840a9089d999aaba039eaf3e3afa090a" +
+				"09d389dba82c4ff2ae8ac5cdfb7c55e94d5d961a29fe0109941e00b8db" +
+				"deea6d3b051068df7254c0cdc129cbe62db2dc957dbb47b51fd3f213fb" +
+				"8698f064774250a5028961c9bf8ffd973fe5d5c206492b140e00",
+		},
+	}
+	for _, test := range tests {
+		seed, _ := hex.DecodeString(test.seed)
+		pub, _ := hex.DecodeString(test.pub)
+		msg, _ := hex.DecodeString(test.msg)
+		sig, _ := hex.DecodeString(test.sig)
+		if got := ed448Public(seed); !bytes.Equal(got, pub) {
+			t.Errorf("ed448Public(%s), got %x, want %x", test.seed, got, pub)
+		}
+		if got := ed448Sign(seed, pub, msg); !bytes.Equal(got, sig) {
+			t.Errorf("ed448Sign(%s), got %x, want %x", test.msg, got, sig)
+		}
+		if !ed448Verify(pub, msg, sig) {
+			t.Errorf("ed448Verify(%s), got false, want true", test.msg)
+		}
+	}
+}
+
+func TestX448RFC7748(t *testing.T) {
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// RFC 7748, section 5.2
+	tests := []struct{ scalar, u, want string }{
+		{
+			"3d262fddf9ec8e88495266fea19a34d28882acef045104d0d1aae121700a" +
+				"779c984c24f8cdd78fbff44943eba368f54b29259a4f1c600ad3",
+			"06fce640fa3487bfda5f6cf2d5263f8aad88334cbd07437f020f08f9814d" +
+				"c031ddbdc38c19c6da2583fa5429db94ada18aa7a7fb4ef8a086",
+			"ce3e4ff95a60dc6697da1db1d85e6afbdf79b50a2412d7546d5f239fe14f" +
+				"baadeb445fc66a01b0779d98223961111e21766282f73dd96b6f",
+		},
+		{
+			"203d494428b8399352665ddca42f9de8fef600908e0d461cb021f8c53834" +
+				"5dd77c3e4806e25f46d3315c44e0a5b4371282dd2c8d5be3095f",
+			"0fbcc2f993cd56d3305b0b7d9e55d4c1a8fb5dbb52f8e9a1e9b6201b165d" +
+				"015894e56c4d3570bee52fe205e28a78b91cdfbde71ce8d157db",
+			"884a02576239ff7a2f2f63b2db6a9ff37047ac13568e1e30fe63c4a7ad1b" +
+				"3ee3a5700df34321d62077e63633c575c1c954514e99da7c179d",
+		},
+	}
+	for _, test := range tests {
+		got := x448(unhex(test.scalar), unhex(test.u))
+		if want := unhex(test.want); !bytes.Equal(got, want) {
+			t.Errorf("x448(%s), got %x, want %x", test.scalar, got, want)
+		}
+	}
+
+	// Iterate k, u = x448(k, u), k from k = u = 5
+	iterations := []struct {
+		n    int
+		want string
+	}{
+		{1, "3f482c8a9f19b01e6c46ee9711d9dc14fd4bf67af30765c2ae2b846a4d23" +
+			"a8cd0db897086239492caf350b51f833868b9bc2b3bca9cf4113"},
+		{1000, "aa3b4749d55b9daf1e5b00288826c467274ce3ebbdd5c17b975e09d4af" +
+			"6c67cf10d087202db88286e2b79fceea3ec353ef54faa26e219f38"},
+	}
+	k := make([]byte, x448Size)
+	k[0] = 5
+	u := append([]byte{}, k...)
+	n := 0
+	for _, it := range iterations {
+		if testing.Short() && it.n > 1 {
+			break
+		}
+		for ; n < it.n; n++ {
+			k, u = x448(k, u), k
+		}
+		if want := unhex(it.want); !bytes.Equal(k, want) {
+			t.Errorf("x448() after %d iterations, got %x, want %x",
+				it.n, k, want)
+		}
+	}
+
+	// Section 6.2
+	a := unhex("9a8f4925d1519f5775cf46b04b5800d4ee9ee8bae8bc5565d498c28d" +
+		"d9c9baf574a9419744897391006382a6f127ab1d9ac2d8c0a598726b")
+	apub := unhex("9b08f7cc31b7e3e67d22d5aea121074a273bd2b83de09c63faa73" +
+		"d2c22c5d9bbc836647241d953d40c5b12da88120d53177f80e532c41fa0")
+	b := unhex("1c306a7ac2a0e2e0990b294470cba339e6453772b075811d8fad0d1d" +
+		"6927c120bb5ee8972b0d3e21374c9c921b09d1b0366f10b65173992d")
+	bpub := unhex("3eb7a829b0cd20f5bcfc0b599b6feccf6da4627107bdb0d4f345b" +
+		"43027d8b972fc3e34fb4232a13ca706dcb57aec3dae07bdc1c67bf33609")
+	shared := unhex("07fff4181ac6cc95ec1c16a94a0f74d12da232ce40a77552281d" +
+		"282bb60c0b56fd2464c335543936521c24403085d59a449a5037514a879d")
+	if got := x448Base(a); !bytes.Equal(got, apub) {
+		t.Errorf("x448Base(a), got %x, want %x", got, apub)
+	}
+	if got := x448Base(b); !bytes.Equal(got, bpub) {
+		t.Errorf("x448Base(b), got %x, want %x", got, bpub)
+	}
+	if got := x448(a, bpub); !bytes.Equal(got, shared) {
+		t.Errorf("x448(a, bpub), got %x, want %x", got, shared)
+	}
+	if got := x448(b, apub); !bytes.Equal(got, shared) {
+		t.Errorf("x448(b, apub), got %x, want %x", got, shared)
+	}
+}
+
+func TestEd448RoundTrip(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		seed := make([]byte, ed448SeedSize)
+		msg := make([]byte, i*7)
+		if _, err := rand.Read(seed); err != nil {
+			t.Fatal(err)
+		}
+		rand.Read(msg)
+
+		pub := ed448Public(seed)
+		sig := ed448Sign(seed, pub, msg)
+		if !ed448Verify(pub, msg, sig) {
+			t.Fatalf("ed448Verify(%x), got false, want true", seed)
+		}
+		// Any flipped bit in the signature invalidates it
+		bit := i * 57
+		sig[bit/8] ^= 1 << uint(bit%8)
+		if ed448Verify(pub, msg, sig) {
+			t.Errorf("ed448Verify(%x) flipped bit %d, got true, want false",
+				seed, bit)
+		}
+	}
+
+	// Scalar arithmetic matches math/big, including at the extremes
+	max := bytes.Repeat([]byte{0xff}, 114)
+	inputs := [][]byte{make([]byte, 114), max}
+	for i := 0; i < 64; i++ {
+		b := make([]byte, 114)
+		rand.Read(b)
+		inputs = append(inputs, b)
+	}
+	le := func(b []byte) *big.Int {
+		return new(big.Int).SetBytes(reverse(b))
+	}
+	for i, in := range inputs {
+		want := new(big.Int).Mod(le(in), ed448L)
+		k := ed448Reduce(in)
+		if le(k).Cmp(want) != 0 {
+			t.Errorf("ed448Reduce(%x), got %x, want %x", in, le(k), want)
+		}
+		s, r := in[:56], ed448Reduce(inputs[(i+1)%len(inputs)])
+		want.Mul(le(k), le(s))
+		want.Add(want, le(r))
+		want.Mod(want, ed448L)
+		if got := ed448MulAdd(k, s, r); le(got).Cmp(want) != 0 {
+			t.Errorf("ed448MulAdd(%x, %x, %x), got %x, want %x",
+				k, s, r, le(got), want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	data := []byte("hello world\n")
+	for _, version := range []int{4, 6} {
+		for _, hash := range []crypto.Hash{crypto.SHA384, crypto.SHA512} {
+			var key SignKey
+			key.Seed(bytes.Repeat([]byte{1}, 32))
+			key.SetVersion(version)
+			key.SetHash(hash)
+
+			var buf bytes.Buffer
+			err := key.SignMessage(&buf, bytes.NewReader(data), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			onepass, rest, err := ParsePacket(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, rest, err = ParsePacket(rest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packet, _, err := ParsePacket(rest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := ParseSignature(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := hashID(hash)
+			if onepass.Body[2] != want || sig.HashID() != want {
+				t.Errorf("SignMessage(v%d, %v) hash, got %d and %d, want %d",
+					version, hash, onepass.Body[2], sig.HashID(), want)
+			}
+			err = key.Verify(bytes.NewReader(data), sig)
+			if err != nil {
+				t.Errorf("SignMessage(v%d, %v) Verify(), got %v",
+					version, hash, err)
+			}
+		}
+	}
+
+	var a, b SignKey
+	a.Seed(bytes.Repeat([]byte{1}, 32))
+	b.Seed(bytes.Repeat([]byte{2}, 32))
+	b.SetHash(crypto.SHA512)
+	var out bytes.Buffer
+	out.ReadFrom(ClearsignAll(strings.NewReader("statement\n"), &a, &b))
+	const head = "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256,SHA512\n\n"
+	if !strings.HasPrefix(out.String(), head) {
+		t.Errorf("ClearsignAll() with two hashes, got %q", out.String())
+	}
+}
+
+func TestCurve448(t *testing.T) {
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// Generated with OpenSSL
+	seed := unhex("11cfdbe77fc38f28966fd914006d90e007eb41f2593355f5bd21ef" +
+		"dd334b206a6b9e45c567831630a8a053356bf1ef6a7431bb9d31fa4ec1f6")
+	pub := unhex("c36ca809a213fa902326d2c5fc8a3880fcb0329d02cc5ea2fc0a24" +
+		"7e5cc3300495a985878cd128ad6982938ab3128dbb2432250bbbfbd9f600")
+	sig := unhex("713fa555397ea7e65358f28a4ab29b0405a352e716cd2eb9316635" +
+		"ad9014a32542adcbcb405af9d1038a6fb1f48d1b303f8a5a8a8ce58b6900" +
+		"35bd6b7f47abf4d46fa742dbe3125cc71e91aeb670cf3a9927268bb567be" +
+		"7c2aee9da9f522281854bf50b11b14c399fd288a29e51355603200")
+	msg := []byte("hello ed448")
+
+	if got := ed448Public(seed); !bytes.Equal(got, pub) {
+		t.Errorf("ed448Public(), got %x, want %x", got, pub)
+	}
+	if got := ed448Sign(seed, pub, msg); !bytes.Equal(got, sig) {
+		t.Errorf("ed448Sign(), got %x, want %x", got, sig)
+	}
+	if !ed448Verify(pub, msg, sig) {
+		t.Errorf("ed448Verify(), got false, want true")
+	}
+	if ed448Verify(pub, []byte("hello ed449"), sig) {
+		t.Errorf("ed448Verify() wrong message, got true, want false")
+	}
+
+	a := unhex("c007978218404a60370ae31071fac5d2155f07cdf9a7d9ed4ae3e087" +
+		"8056b3a0dbde6550bd5f78140e293e77522b4a393f5d50106a89d9f8")
+	apub := unhex("c418bce8191e656cb77a7d599d2805bf71b26efda4590953bc31f" +
+		"fc183ea6ea2492a0511cfb740c1e6b80a33ad3bdd6b592449d826e000bf")
+	bpub := unhex("ca810393ed0e4b2c3b14471f6bbf683db29a753d1ea84f2c4d37c" +
+		"7bb05ebc9147272667582408424e8b149b3beea49bf9cdd714b7e8b19e6")
+	shared := unhex("96af86b3ac9db21256dc0ab2fb94a852d053a767a89d7e02f8" +
+		"123b0951e6282af702aaea86405dc5964fc1b7767f06cfb0ec9d33763fae13")
+	if got := x448Base(a); !bytes.Equal(got, apub) {
+		t.Errorf("x448Base(), got %x, want %x", got, apub)
+	}
+	if got := x448(a, bpub); !bytes.Equal(got, shared) {
+		t.Errorf("x448(), got %x, want %x", got, shared)
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	tests := []struct {
+		name    string
+		algo    Algorithm
+		version int
+		id      byte // signature algorithm ID
+		protect bool // supports protected packets
+	}{
+		{"Ed25519", Curve25519, 4, 22, true},
+		{"Ed448", Curve448, 4, 22, true},
+		{"Ed448 v6", Curve448, 6, 28, false},
+		{"P-256", P256, 4, 19, true},
+		{"P-384", P384, 4, 19, true},
+		{"RSA-2048", RSA2048, 4, 1, true},
+	}
+	for _, test := range tests {
+		name := test.name
+		var key, signsub SignKey
+		var subkey EncryptKey
+		key.SetAlgorithm(test.algo)
+		key.Seed(bytes.Repeat([]byte{1}, key.SeedSize()))
+		key.SetVersion(test.version)
+		signsub.SetAlgorithm(test.algo)
+		signsub.Seed(bytes.Repeat([]byte{2}, signsub.SeedSize()))
+		signsub.SetVersion(test.version)
+		subkey.SetAlgorithm(test.algo)
+		subkey.Seed(bytes.Repeat([]byte{3}, subkey.SeedSize()))
+		subkey.SetVersion(test.version)
+		if test.algo != Curve25519 && (key.Key != nil || subkey.Key != nil) {
+			t.Errorf("Seed(%s) set Key, which is only for Curve25519", name)
+		}
+
+		// Each key loads back from its secret, protected, and public
+		// packets
+		passphrase := []byte("passphrase")
+		raws := [][]byte{key.Packet(), key.PubPacket()}
+		subraw := subkey.Packet()
+		if test.protect {
+			raws = append(raws, key.EncPacket(passphrase))
+			subraw = subkey.EncPacket(passphrase)
+		}
+		for _, raw := range raws {
+			packet, _, err := ParsePacket(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var loaded SignKey
+			if err := loaded.Load(packet, passphrase); err != nil {
+				t.Fatalf("Load(%s), got %v", name, err)
+			}
+			if loaded.Algorithm() != test.algo ||
+				!bytes.Equal(loaded.KeyID(), key.KeyID()) {
+				t.Errorf("Load(%s), wrong key", name)
+			}
+		}
+		packet, _, err := ParsePacket(subraw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loadedSub EncryptKey
+		if err := loadedSub.Load(packet, passphrase); err != nil {
+			t.Fatalf("Load(%s) subkey, got %v", name, err)
+		}
+		if !bytes.Equal(loadedSub.Seckey(), subkey.Seckey()) {
+			t.Errorf("Load(%s) subkey, got %x, want %x",
+				name, loadedSub.Seckey(), subkey.Seckey())
+		}
+		if test.protect {
+			var wrong EncryptKey
+			if err := wrong.Load(packet, []byte("wrong")); err == nil {
+				t.Errorf("Load(%s) subkey with wrong passphrase, got nil",
+					name)
+			}
+		}
+
+		const message = "hello world\n"
+		raw, err := key.Sign(strings.NewReader(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, _, err = ParsePacket(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ParseSignature(packet)
+		if err != nil {
+			t.Fatalf("ParseSignature(%s), got %v", name, err)
+		}
+		if sig.AlgorithmID() != test.id {
+			t.Errorf("Sign(%s) algorithm, got %d, want %d",
+				name, sig.AlgorithmID(), test.id)
+		}
+		if err := key.Verify(strings.NewReader(message), sig); err != nil {
+			t.Errorf("Verify(%s), got %v", name, err)
+		}
+		err = key.Verify(strings.NewReader(message+"!"), sig)
+		if err != ErrBadSignature {
+			t.Errorf("Verify(%s) modified, got %v, want %v",
+				name, err, ErrBadSignature)
+		}
+
+		packet, _, err = ParsePacket(key.BindSign(&signsub, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig, err = ParseSignature(packet); err != nil {
+			t.Fatal(err)
+		}
+		if err := key.VerifyBinding(signsub.SubPubPacket(), sig); err != nil {
+			t.Errorf("VerifyBinding(%s) sign subkey, got %v", name, err)
+		}
+		// Version 4 signatures are deterministic, but version 6
+		// signatures are always salted
+		if test.version == 4 &&
+			!bytes.Equal(key.BindSign(&signsub, 0), key.BindSign(&signsub, 0)) {
+			t.Errorf("BindSign(%s), not deterministic", name)
+		}
+
+		packet, _, err = ParsePacket(key.Bind(&subkey, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig, err = ParseSignature(packet); err != nil {
+			t.Fatal(err)
+		}
+		if err := key.VerifyBinding(subkey.PubPacket(), sig); err != nil {
+			t.Errorf("VerifyBinding(%s) encrypt subkey, got %v", name, err)
+		}
+
+		if test.version == 4 {
+			msg, err := subkey.Encrypt(strings.NewReader(message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadedSub.Decrypt(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != message {
+				t.Errorf("Decrypt(%s), got %q, want %q", name, got, message)
+			}
+		}
+
+		key.Wipe()
+		subkey.Wipe()
+		zero := func(b []byte) bool {
+			for _, v := range b {
+				if v != 0 {
+					return false
+				}
+			}
+			return true
+		}
+		if !zero(key.Seckey()) || !zero(subkey.Seckey()) {
+			t.Errorf("Wipe(%s), secret key not zeroed", name)
+		}
+	}
+}
+
+func TestDefaultHash(t *testing.T) {
+	tests := []struct {
+		algo Algorithm
+		want byte
+	}{
+		{Curve25519, 8}, // SHA-256
+		{Curve448, 10},  // SHA-512, required
+		{P256, 8},       // SHA-256
+		{P384, 9},       // SHA-384
+	}
+	for _, test := range tests {
+		var key SignKey
+		key.SetAlgorithm(test.algo)
+		key.Seed(bytes.Repeat([]byte{1}, key.SeedSize()))
+		raw, err := key.Sign(strings.NewReader("hello world\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, _, err := ParsePacket(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ParseSignature(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.HashID() != test.want {
+			t.Errorf("Sign(%d) hash, got %d, want %d",
+				test.algo, sig.HashID(), test.want)
+		}
+	}
+}
+
+func TestRFC6979(t *testing.T) {
+	// RFC 6979 appendix A.2.5, P-256 with SHA-256 and message "sample"
+	seckey, _ := hex.DecodeString(
+		"c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721")
+	want, _ := hex.DecodeString(
+		"efd48b2aacb6a8fd1140dd9cd45e81d69d2c877b56aaf991c34d0ea84eaf3716" +
+			"f7cb1c942d657c41d436c7a1b6e29f65f3e900dbb9aff4064dc4ab2f843acda8")
+	digest := sha256.Sum256([]byte("sample"))
+	got := nistSign(elliptic.P256(), crypto.SHA256, seckey, digest[:])
+	if !bytes.Equal(got, want) {
+		t.Errorf("nistSign(), got %x, want %x", got, want)
+	}
+}
+
+func TestRSA(t *testing.T) {
+	var key, again SignKey
+	key.SetAlgorithm(RSA2048)
+	key.Seed(bytes.Repeat([]byte{1}, key.SeedSize()))
+	again.SetAlgorithm(RSA2048)
+	again.Seed(bytes.Repeat([]byte{1}, again.SeedSize()))
+
+	// Generation is pinned by its version, so the key never changes
+	const want = "672E76A4AC3B2CAC393B97FD555AC2C3C448BC79"
+	if got := fmt.Sprintf("%X", key.KeyID()); got != want {
+		t.Errorf("Seed(RSA2048), got %s, want %s", got, want)
+	}
+	if !bytes.Equal(again.Packet(), key.Packet()) {
+		t.Errorf("Seed(RSA2048), not deterministic")
+	}
+}
@@ -0,0 +1,65 @@
+package openpgp
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// PhotoID represents a JPEG photo of the key holder, stored in a User
+// Attribute Packet as an Image Attribute subpacket.
+type PhotoID struct {
+	JPEG []byte
+}
+
+// Image header version 1: its little-endian length, version, the JPEG
+// encoding, and reserved zeros.
+var photoHeader = []byte{
+	0x10, 0x00, 0x01, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// Packet returns an OpenPGP packet encoding this photo.
+func (p *PhotoID) Packet() []byte {
+	packet := Packet{Tag: 17, Body: p.body()} // User Attribute Packet (17)
+	return packet.Encode()
+}
+
+// Returns the User Attribute packet body, a single subpacket.
+func (p *PhotoID) body() []byte {
+	body := subpacketLen(1 + len(photoHeader) + len(p.JPEG))
+	body = append(body, 1) // Image Attribute subpacket (type=1)
+	body = append(body, photoHeader...)
+	return append(body, p.JPEG...)
+}
+
+// Load from packet. User attributes other than a single JPEG image are
+// unsupported.
+func (p *PhotoID) Load(packet Packet) error {
+	if packet.Tag != 17 {
+		return ErrInvalidPacket
+	}
+	buf := packet.Body
+	var n int
+	switch {
+	case len(buf) < 1:
+		return ErrInvalidPacket
+	case buf[0] < 192:
+		n, buf = int(buf[0]), buf[1:]
+	case buf[0] < 255 && len(buf) >= 2:
+		n, buf = (int(buf[0])-192)<<8+int(buf[1])+192, buf[2:]
+	case buf[0] == 255 && len(buf) >= 5:
+		n, buf = int(binary.BigEndian.Uint32(buf[1:])), buf[5:]
+	default:
+		return ErrInvalidPacket
+	}
+	if n > len(buf) {
+		return ErrInvalidPacket
+	} else if n < len(buf) {
+		return ErrUnsupportedPacket // not exactly one subpacket
+	}
+	if n < 1+len(photoHeader) || buf[0] != 1 ||
+		!bytes.Equal(buf[1:1+len(photoHeader)], photoHeader) {
+		return ErrUnsupportedPacket
+	}
+	p.JPEG = buf[1+len(photoHeader):]
+	return nil
+}
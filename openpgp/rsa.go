@@ -0,0 +1,277 @@
+package openpgp
+
+import (
+	"crypto/rsa"
+	"encoding/binary"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// Deterministic RSA keys (RFC 4880 algorithm 1, RSA Encrypt or Sign),
+// for infrastructure that accepts nothing else. The standard library
+// deliberately makes rsa.GenerateKey unpredictable, so keys are
+// generated here by a fixed procedure, so that a seed always yields the
+// same key. Any change to it must be a new version, selected like a
+// derivation version, since it would change every key.
+//
+// Version 1: A ChaCha20 stream keyed by the 32-byte seed, with a zero
+// nonce, supplies candidates for p, then q, each bits/2 bits drawn
+// big-endian with the top two bits and the bottom bit set. A candidate
+// is kept if it has no odd factor below 2000, passes Miller-Rabin to
+// each of the first 40 prime bases, and p-1 is coprime to e = 65537. A
+// q within 2^(bits/2-100) of p is rejected. Then p < q by swapping,
+// and d = e^-1 mod (p-1)(q-1).
+
+const rsaExponent = 65537
+
+// Odd primes for trial division, and the first of them, with 2, are
+// the Miller-Rabin bases.
+var rsaSmallPrimes = func() []int64 {
+	var primes []int64
+	for n := int64(3); n < 2000; n += 2 {
+		prime := true
+		for _, p := range primes {
+			if p*p > n {
+				break
+			}
+			if n%p == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			primes = append(primes, n)
+		}
+	}
+	return primes
+}()
+
+// Returns the modulus size of an RSA algorithm, or zero if it's not one.
+func (a Algorithm) rsaBits() int {
+	switch a {
+	case RSA2048:
+		return 2048
+	case RSA4096:
+		return 4096
+	}
+	return 0
+}
+
+// Generates an RSA key of the given modulus size from a 32-byte seed
+// per version 1 of the procedure above.
+func rsaGenerate(seed []byte, bits int) *rsa.PrivateKey {
+	stream, err := chacha20.NewUnauthenticatedCipher(seed, make([]byte, 12))
+	if err != nil {
+		panic(err) // should never happen
+	}
+	r := &rsaStream{stream}
+	p := rsaPrime(r, bits/2)
+	var q *big.Int
+	minDiff := new(big.Int).Lsh(big.NewInt(1), uint(bits/2-100))
+	for {
+		q = rsaPrime(r, bits/2)
+		if new(big.Int).Sub(p, q).CmpAbs(minDiff) > 0 {
+			break
+		}
+	}
+	if p.Cmp(q) > 0 {
+		p, q = q, p
+	}
+
+	one := big.NewInt(1)
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+	e := big.NewInt(rsaExponent)
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: rsaExponent},
+		D:         new(big.Int).ModInverse(e, phi),
+		Primes:    []*big.Int{p, q},
+	}
+	key.Precompute()
+	return key
+}
+
+// An rsaStream reads the ChaCha20 keystream.
+type rsaStream struct {
+	cipher *chacha20.Cipher
+}
+
+func (r *rsaStream) Read(buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	r.cipher.XORKeyStream(buf, buf)
+	return len(buf), nil
+}
+
+// Draws candidates from r until one is a suitable prime of the given
+// size.
+func rsaPrime(r io.Reader, bits int) *big.Int {
+	buf := make([]byte, bits/8)
+	e := big.NewInt(rsaExponent)
+	one := big.NewInt(1)
+	for {
+		r.Read(buf)
+		buf[0] |= 0xc0
+		buf[len(buf)-1] |= 1
+		p := new(big.Int).SetBytes(buf)
+		if !rsaMaybePrime(p) {
+			continue
+		}
+		pm1 := new(big.Int).Sub(p, one)
+		if new(big.Int).Mod(pm1, e).Sign() == 0 {
+			continue // e is prime, so this is gcd(e, p-1) = 1
+		}
+		return p
+	}
+}
+
+// Reports if an odd n, much larger than the small primes, is probably
+// prime: trial division, then Miller-Rabin to fixed bases. Unlike
+// big.Int.ProbablyPrime, this never changes between Go releases.
+func rsaMaybePrime(n *big.Int) bool {
+	m := new(big.Int)
+	for _, p := range rsaSmallPrimes {
+		if m.Mod(n, big.NewInt(p)).Sign() == 0 {
+			return false
+		}
+	}
+
+	one := big.NewInt(1)
+	nm1 := new(big.Int).Sub(n, one)
+	d := new(big.Int).Set(nm1)
+	s := 0
+	for d.Bit(0) == 0 {
+		d.Rsh(d, 1)
+		s++
+	}
+	bases := append([]int64{2}, rsaSmallPrimes[:39]...)
+	x := new(big.Int)
+	for _, a := range bases {
+		x.Exp(big.NewInt(a), d, n)
+		if x.Cmp(one) == 0 || x.Cmp(nm1) == 0 {
+			continue
+		}
+		composite := true
+		for i := 1; i < s; i++ {
+			x.Mul(x, x).Mod(x, n)
+			if x.Cmp(nm1) == 0 {
+				composite = false
+				break
+			}
+		}
+		if composite {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the private exponent of an RSA key at the modulus size, or
+// zeros if the key is public.
+func rsaSeckey(key *rsa.PrivateKey) []byte {
+	seckey := make([]byte, (key.N.BitLen()+7)/8)
+	if key.D != nil {
+		key.D.FillBytes(seckey)
+	}
+	return seckey
+}
+
+// Overwrites the secret values of an RSA key with zeros, leaving it
+// unusable.
+func rsaWipe(key *rsa.PrivateKey) {
+	secrets := []*big.Int{key.D, key.Precomputed.Dp, key.Precomputed.Dq,
+		key.Precomputed.Qinv}
+	for _, v := range append(secrets, key.Primes...) {
+		if v != nil {
+			words := v.Bits()
+			for i := range words {
+				words[i] = 0
+			}
+			v.SetInt64(0)
+		}
+	}
+}
+
+// Returns the body of a version 4 RSA public key packet.
+func rsaPubBody(created int64, key *rsa.PublicKey) []byte {
+	body := []byte{4, 0, 0, 0, 0, 1} // version, created, RSA
+	binary.BigEndian.PutUint32(body[1:], uint32(created))
+	body = append(body, mpi(key.N.Bytes())...)
+	body = append(body, mpi(big.NewInt(int64(key.E)).Bytes())...)
+	return body
+}
+
+// Returns a secret key packet with the given tag, appending the secret
+// part of an RSA key, d, p, q, and u = p^-1 mod q, to the public key
+// packet body. A nil passphrase leaves it unprotected.
+func rsaSecretPacket(tag byte, body []byte, key *rsa.PrivateKey,
+	passphrase []byte) []byte {
+	p, q := key.Primes[0], key.Primes[1]
+	u := new(big.Int).ModInverse(p, q)
+	var mpis []byte
+	for _, v := range []*big.Int{key.D, p, q, u} {
+		mpis = append(mpis, mpi(v.Bytes())...)
+	}
+	if passphrase != nil {
+		body = s2kEncryptMPIs(body, mpis, passphrase)
+	} else {
+		body = append(body, 0) // string-to-key, unencrypted
+		body = append(body, mpis...)
+		body = append(body, 0, 0)
+		binary.BigEndian.PutUint16(body[len(body)-2:], checksum(mpis))
+	}
+	packet := Packet{Tag: tag, Body: body}
+	return packet.Encode()
+}
+
+// Loads a version 4 RSA key packet, returning the key, its algorithm,
+// and its creation date. A public key packet loads only the public key,
+// leaving D nil.
+func loadRSA(packet Packet, passphrase []byte) (*rsa.PrivateKey, Algorithm, int64, error) {
+	body := packet.Body
+	created := int64(binary.BigEndian.Uint32(body[1:]))
+	n, rest := mpiDecode(body[6:], 0)
+	e, rest := mpiDecode(rest, 0)
+	if n == nil || e == nil || len(e) > 4 {
+		return nil, 0, 0, ErrInvalidPacket
+	}
+	key := new(rsa.PrivateKey)
+	key.N = new(big.Int).SetBytes(n)
+	key.E = int(new(big.Int).SetBytes(e).Int64())
+	var algo Algorithm
+	switch key.N.BitLen() {
+	case 2048:
+		algo = RSA2048
+	case 4096:
+		algo = RSA4096
+	default:
+		return nil, 0, 0, ErrUnsupportedPacket
+	}
+
+	if packet.Tag == 6 || packet.Tag == 14 {
+		return key, algo, created, nil
+	}
+
+	mpis, err := s2kDecryptMPIs(rest, passphrase)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var values [3]*big.Int
+	for i := range values {
+		var v []byte
+		v, mpis = mpiDecode(mpis, 0)
+		if v == nil {
+			return nil, 0, 0, ErrInvalidPacket
+		}
+		values[i] = new(big.Int).SetBytes(v)
+	}
+	key.D = values[0]
+	key.Primes = []*big.Int{values[1], values[2]}
+	if key.Validate() != nil {
+		return nil, 0, 0, ErrInvalidPacket
+	}
+	key.Precompute()
+	return key, algo, created, nil
+}
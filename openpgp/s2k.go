@@ -1,11 +1,11 @@
 package openpgp
 
 import (
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha1"
-	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/binary"
 )
@@ -19,9 +19,10 @@ func decodeS2K(c byte) int {
 	return (16 + int(c&15)) << (uint(c>>4) + 6)
 }
 
-// Compute a symmetric protection key via S2K.
-func s2k(passphrase, salt []byte, count int) []byte {
-	h := sha256.New()
+// Compute a symmetric protection key of the given size via S2K. When
+// the hash is too short, additional hash contexts are preloaded with
+// zeros per the standard.
+func s2k(hash crypto.Hash, passphrase, salt []byte, count, size int) []byte {
 	// Note: This implements S2K as it is actually used in practice by
 	// both GnuPG and PGP. The OpenPGP standard (3.7.1.3) is subtly
 	// incorrect in its description, and that algorithm is not used by
@@ -30,58 +31,74 @@ func s2k(passphrase, salt []byte, count int) []byte {
 	full := make([]byte, 8+len(passphrase))
 	copy(full[0:], salt)
 	copy(full[8:], passphrase)
-	iterations := count / len(full)
-	for i := 0; i < iterations; i++ {
-		h.Write(full)
+	if count < len(full) {
+		count = len(full)
 	}
-	tail := count - iterations*len(full)
-	h.Write(full[:tail])
-	return h.Sum(nil)
+
+	var key []byte
+	for preload := 0; len(key) < size; preload++ {
+		h := hash.New()
+		h.Write(make([]byte, preload))
+		iterations := count / len(full)
+		for i := 0; i < iterations; i++ {
+			h.Write(full)
+		}
+		tail := count - iterations*len(full)
+		h.Write(full[:tail])
+		key = h.Sum(key)
+	}
+	return key[:size]
 }
 
-// Encrypt a secret key along with a SHA-1 "MAC".
-func s2kEncrypt(key, iv, seckey []byte) []byte {
-	mpikey := mpi(seckey)
+// Encrypt a secret key's MPIs along with a SHA-1 "MAC".
+func s2kEncrypt(key, iv, mpis []byte) []byte {
 	mac := sha1.New()
-	mac.Write(mpikey)
-	data := mac.Sum(mpikey)
+	mac.Write(mpis)
+	data := mac.Sum(append([]byte{}, mpis...))
 	block, _ := aes.NewCipher(key)
 	stream := cipher.NewCFBEncrypter(block, iv)
 	stream.XORKeyStream(data, data)
 	return data
 }
 
-// Decrypt a secret key and verify its SHA-1 "MAC".
-func s2kDecrypt(key, iv, protected []byte) ([]byte, bool) {
-	block, _ := aes.NewCipher(key)
-	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(protected, protected)
-
-	seckey, check := mpiDecode(protected, 32)
-	if seckey == nil {
+// Decrypt a secret key's MPIs and verify their SHA-1 "MAC". The input
+// is left intact so that decryption may be retried with another key.
+func s2kDecrypt(key, iv, encrypted []byte) ([]byte, bool) {
+	if len(encrypted) < sha1.Size {
 		return nil, false
 	}
+	block, _ := aes.NewCipher(key)
+	stream := cipher.NewCFBDecrypter(block, iv)
+	protected := make([]byte, len(encrypted))
+	stream.XORKeyStream(protected, encrypted)
 
+	mpis := protected[:len(protected)-sha1.Size]
+	check := protected[len(protected)-sha1.Size:]
 	mac := sha1.New()
-	mac.Write(protected[:len(protected)-20])
+	mac.Write(mpis)
 	if subtle.ConstantTimeCompare(mac.Sum(nil), check) == 0 {
 		return nil, false
 	}
-	return seckey, true
+	return mpis, true
 }
 
 // Encrypts an entire secret key, with output suitable for appending to
 // a public key packet to turn it into a secret key packet. Output is
 // appended to the given packet and returned.
 func s2kEncryptKey(packet, seckey, passphrase []byte) []byte {
+	return s2kEncryptMPIs(packet, mpi(seckey), passphrase)
+}
+
+// Like s2kEncryptKey, but for a secret key of any number of MPIs.
+func s2kEncryptMPIs(packet, mpis, passphrase []byte) []byte {
 	var saltIV [24]byte
 	if _, err := rand.Read(saltIV[:]); err != nil {
 		panic(err) // should never happen
 	}
 	salt := saltIV[:8]
 	iv := saltIV[8:]
-	key := s2k(passphrase, salt, decodeS2K(s2kCount))
-	protected := s2kEncrypt(key, iv, seckey)
+	key := s2k(crypto.SHA256, passphrase, salt, decodeS2K(s2kCount), 32)
+	protected := s2kEncrypt(key, iv, mpis)
 
 	packet = append(packet, 254) // encrypted with S2K
 	packet = append(packet, 9)   // AES-256
@@ -96,27 +113,44 @@ func s2kEncryptKey(packet, seckey, passphrase []byte) []byte {
 
 // Decrypts the secret key portion from a secret key packet.
 func s2kDecryptKey(body, passphrase []byte) ([]byte, error) {
+	mpis, err := s2kDecryptMPIs(body, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	seckey, rest := mpiDecode(mpis, 32)
+	if seckey == nil || len(rest) != 0 {
+		return nil, ErrInvalidPacket
+	}
+	return seckey, nil
+}
+
+// Decrypts the secret key portion from a secret key packet, returning
+// its MPIs still encoded.
+func s2kDecryptMPIs(body, passphrase []byte) ([]byte, error) {
 	if body[0] == 0 {
 		// Unencrypted
-		seckey, tail := mpiDecode(body[1:], 32)
-		if len(tail) != 2 {
-			return nil, ErrInvalidPacket
-		}
-		crcA := binary.BigEndian.Uint16(tail)
-		crcB := checksum(body[1 : len(body)-2])
+		mpis := body[1 : len(body)-2]
+		crcA := binary.BigEndian.Uint16(body[len(body)-2:])
+		crcB := checksum(mpis)
 		if crcA != crcB {
 			return nil, ErrInvalidPacket
 		}
-		return seckey, nil
+		return mpis, nil
 
 	} else if body[0] == 254 {
 		// Encrypted
+		if body[2] == 101 {
+			// GnuPG extension, such as a stub without a secret key
+			return nil, ErrUnsupportedPacket
+		}
 		if passphrase == nil {
 			return nil, ErrDecryptKey
 		}
-		if body[1] != 9 || // AES-256
-			body[2] != 3 || // Iterated and Salted S2K
-			body[3] != 8 { // SHA-256
+		// GnuPG exports keys protected with its own defaults, such as
+		// AES-128 and SHA-1, so accept any AES and common hash.
+		size := aesKeySizes[body[1]]
+		hash, ok := hashAlgos[body[3]]
+		if size == 0 || !ok || body[2] != 3 { // Iterated and Salted S2K
 			return nil, ErrUnsupportedPacket
 		}
 
@@ -125,12 +159,12 @@ func s2kDecryptKey(body, passphrase []byte) ([]byte, error) {
 		iv := body[13:29]
 		data := body[29:]
 
-		key := s2k(passphrase, salt, count)
-		seckey, ok := s2kDecrypt(key, iv, data)
+		key := s2k(hash, passphrase, salt, count, size)
+		mpis, ok := s2kDecrypt(key, iv, data)
 		if !ok {
 			return nil, ErrDecryptKey
 		}
-		return seckey, nil
+		return mpis, nil
 
 	} else {
 		return nil, ErrUnsupportedPacket
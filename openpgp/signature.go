@@ -0,0 +1,346 @@
+package openpgp
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	_ "crypto/sha512" // for SHA-384 and SHA-512 signatures
+)
+
+// ErrBadSignature indicates a signature did not verify.
+var ErrBadSignature = errors.New("bad signature")
+
+// Signature represents a parsed version 4 signature packet.
+type Signature struct {
+	// Type is the signature type, e.g. 0x00 for binary documents.
+	Type byte
+	// Created is the signature creation time in unix epoch seconds.
+	Created int64
+	// Issuer is the 8-byte Key ID of the signer, if present.
+	Issuer []byte
+	// IssuerFingerprint is the fingerprint of the signer, if present:
+	// 20 bytes for version 4 keys, or 32 bytes for version 6.
+	IssuerFingerprint []byte
+	// KeyFlags is the first octet of the hashed Key Flags, if present.
+	KeyFlags byte
+	// Embedded is the Embedded Signature, such as a subkey's primary
+	// key binding signature, if present.
+	Embedded *Signature
+	// Notations are the hashed human-readable Notation Data, if any.
+	Notations []Notation
+	// Revokers are the hashed Revocation Keys, if any.
+	Revokers []Revoker
+	// Trust is the hashed Trust Signature and Regular Expression, if
+	// this is a trust signature.
+	Trust *Trust
+	// PolicyURI is the hashed Policy URI, if present.
+	PolicyURI string
+	// KeyServer is the hashed Preferred Key Server, if present.
+	KeyServer string
+	// SignerUID is the hashed Signer's User ID, if present.
+	SignerUID string
+
+	version byte
+	algo    byte
+	hash    crypto.Hash
+	salt    []byte // version 6 only
+	trailer []byte // hashed portion of the packet body
+	preview []byte
+	sig     []byte // r || s, or RSA's m^d mod n
+}
+
+// Map OpenPGP hash algorithm IDs to hash functions.
+var hashAlgos = map[byte]crypto.Hash{
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+// Names of hash functions in cleartext signature Hash headers.
+var hashNames = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA1",
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+	crypto.SHA224: "SHA224",
+}
+
+// Returns the OpenPGP ID of a hash function, or zero if unsupported.
+func hashID(h crypto.Hash) byte {
+	for id, f := range hashAlgos {
+		if f == h {
+			return id
+		}
+	}
+	return 0
+}
+
+// Salt sizes for version 6 signatures by hash function.
+var saltSizes = map[crypto.Hash]int{
+	crypto.SHA256: 16,
+	crypto.SHA384: 24,
+	crypto.SHA512: 32,
+	crypto.SHA224: 16,
+}
+
+// ParseSignature parses a signature packet. Only EdDSA, ECDSA, and RSA
+// signatures are supported, version 4 or version 6.
+func ParseSignature(packet Packet) (sig *Signature, err error) {
+	defer func() {
+		if recover() != nil {
+			sig = nil
+			err = ErrInvalidPacket
+		}
+	}()
+
+	if packet.Tag != 2 {
+		return nil, ErrInvalidPacket
+	}
+	body := packet.Body
+	if body[0] == 0x06 {
+		return parseSignatureV6(body)
+	}
+	if body[0] != 0x04 {
+		return nil, ErrUnsupportedPacket
+	}
+
+	sig = new(Signature)
+	sig.version = body[0]
+	sig.Type = body[1]
+	sig.algo = body[2]
+	hash, ok := hashAlgos[body[3]]
+	if !ok || sig.algo != 22 && sig.algo != 19 && sig.algo != 1 {
+		return nil, ErrUnsupportedPacket
+	}
+	sig.hash = hash
+
+	hashedLen := int(binary.BigEndian.Uint16(body[4:]))
+	hashed := body[6 : 6+hashedLen]
+	sig.trailer = body[:6+hashedLen]
+	rest := body[6+hashedLen:]
+	unhashedLen := int(binary.BigEndian.Uint16(rest))
+	unhashed := rest[2 : 2+unhashedLen]
+	rest = rest[2+unhashedLen:]
+
+	if err := sig.subpackets(hashed, true); err != nil {
+		return nil, err
+	}
+	if err := sig.subpackets(unhashed, false); err != nil {
+		return nil, err
+	}
+
+	sig.preview = rest[:2]
+	if sig.algo == 1 {
+		// RSA, a single MPI
+		m, _ := mpiDecode(rest[2:], 0)
+		if m == nil {
+			return nil, ErrInvalidPacket
+		}
+		sig.sig = m
+		return sig, nil
+	}
+	r, rest := mpiDecode(rest[2:], 32)
+	s, rest := mpiDecode(rest, 32)
+	if r == nil || s == nil {
+		return nil, ErrInvalidPacket
+	}
+	// R and S are 32 octets each for Ed25519, or 57 for Ed448. ECDSA's
+	// r and s, at most 48 octets, are only padded alike.
+	size := 32
+	if len(r) > size || len(s) > size {
+		size = ed448SeedSize
+	}
+	if len(r) > size || len(s) > size {
+		return nil, ErrInvalidPacket
+	}
+	sig.sig = make([]byte, 2*size)
+	copy(sig.sig[size-len(r):], r)
+	copy(sig.sig[2*size-len(s):], s)
+	return sig, nil
+}
+
+// Parse a version 6 Ed25519 or Ed448 signature packet body, which has
+// larger subpacket area lengths, a salt, and a native signature.
+func parseSignatureV6(body []byte) (*Signature, error) {
+	sig := new(Signature)
+	sig.version = body[0]
+	sig.Type = body[1]
+	sig.algo = body[2]
+	hash, ok := hashAlgos[body[3]]
+	var sigSize int
+	switch sig.algo {
+	case 27: // Ed25519
+		sigSize = 64
+	case 28: // Ed448
+		sigSize = ed448SigSize
+	}
+	if !ok || sigSize == 0 || saltSizes[hash] == 0 {
+		return nil, ErrUnsupportedPacket
+	}
+	sig.hash = hash
+
+	hashedLen := int(binary.BigEndian.Uint32(body[4:]))
+	hashed := body[8 : 8+hashedLen]
+	sig.trailer = body[:8+hashedLen]
+	rest := body[8+hashedLen:]
+	unhashedLen := int(binary.BigEndian.Uint32(rest))
+	unhashed := rest[4 : 4+unhashedLen]
+	rest = rest[4+unhashedLen:]
+
+	if err := sig.subpackets(hashed, true); err != nil {
+		return nil, err
+	}
+	if err := sig.subpackets(unhashed, false); err != nil {
+		return nil, err
+	}
+
+	sig.preview = rest[:2]
+	saltLen := int(rest[2])
+	if saltLen != saltSizes[hash] || len(rest) != 3+saltLen+sigSize {
+		return nil, ErrInvalidPacket
+	}
+	sig.salt = rest[3 : 3+saltLen]
+	sig.sig = rest[3+saltLen:]
+	return sig, nil
+}
+
+// Returns a new hash for verifying this signature, including the salt
+// of a version 6 signature.
+func (s *Signature) newHash() hash.Hash {
+	h := s.hash.New()
+	h.Write(s.salt)
+	return h
+}
+
+// Decode a signature subpacket area into the signature. Only the
+// hashed area is trusted for anything other than issuer hints.
+func (s *Signature) subpackets(buf []byte, hashed bool) error {
+	for len(buf) > 0 {
+		var n int
+		switch {
+		case buf[0] < 192:
+			n = int(buf[0])
+			buf = buf[1:]
+		case buf[0] < 255:
+			n = (int(buf[0])-192)<<8 + int(buf[1]) + 192
+			buf = buf[2:]
+		default:
+			n = int(binary.BigEndian.Uint32(buf[1:]))
+			buf = buf[5:]
+		}
+		if n < 1 || n > len(buf) {
+			return ErrInvalidPacket
+		}
+		typ := buf[0] & 0x7f
+		data := buf[1:n]
+		buf = buf[n:]
+
+		switch typ {
+		case 2: // Signature Creation Time
+			if hashed && len(data) == 4 {
+				s.Created = int64(binary.BigEndian.Uint32(data))
+			}
+		case 5: // Trust Signature
+			if hashed && len(data) == 2 {
+				if s.Trust == nil {
+					s.Trust = new(Trust)
+				}
+				s.Trust.Depth, s.Trust.Amount = data[0], data[1]
+			}
+		case 6: // Regular Expression
+			if hashed && len(data) > 0 && data[len(data)-1] == 0 {
+				if s.Trust == nil {
+					s.Trust = new(Trust)
+				}
+				s.Trust.Regexp = string(data[:len(data)-1])
+			}
+		case 12: // Revocation Key
+			if hashed && len(data) == 22 && data[0]&0x80 != 0 {
+				r := Revoker{Algorithm: data[1], Fingerprint: data[2:]}
+				s.Revokers = append(s.Revokers, r)
+			}
+		case 16: // Issuer
+			if len(data) == 8 {
+				s.Issuer = data
+			}
+		case 20: // Notation Data
+			if hashed && len(data) >= 8 && data[0]&0x80 != 0 {
+				nlen := int(binary.BigEndian.Uint16(data[4:]))
+				vlen := int(binary.BigEndian.Uint16(data[6:]))
+				if 8+nlen+vlen != len(data) {
+					return ErrInvalidPacket
+				}
+				name := string(data[8 : 8+nlen])
+				value := string(data[8+nlen:])
+				s.Notations = append(s.Notations, Notation{name, value})
+			}
+		case 24: // Preferred Key Server
+			if hashed {
+				s.KeyServer = string(data)
+			}
+		case 26: // Policy URI
+			if hashed {
+				s.PolicyURI = string(data)
+			}
+		case 27: // Key Flags
+			if hashed && len(data) > 0 {
+				s.KeyFlags = data[0]
+			}
+		case 28: // Signer's User ID
+			if hashed {
+				s.SignerUID = string(data)
+			}
+		case 32: // Embedded Signature
+			embedded, err := ParseSignature(Packet{Tag: 2, Body: data})
+			if err == nil {
+				s.Embedded = embedded
+			}
+		case 33: // Issuer Fingerprint
+			if len(data) == 21 && data[0] == 0x04 ||
+				len(data) == 33 && data[0] == 0x06 {
+				s.IssuerFingerprint = data[1:]
+			}
+		}
+	}
+	return nil
+}
+
+// IssuedBy returns true if this signature claims to be made by the key
+// with the given fingerprint (i.e. SignKey.KeyID()).
+func (s *Signature) IssuedBy(keyid []byte) bool {
+	if s.IssuerFingerprint != nil {
+		return bytes.Equal(s.IssuerFingerprint, keyid)
+	}
+	if s.Issuer != nil && len(keyid) == 20 {
+		return bytes.Equal(s.Issuer, keyid[12:])
+	}
+	if s.Issuer != nil && len(keyid) == 32 {
+		return bytes.Equal(s.Issuer, keyid[:8])
+	}
+	return false
+}
+
+// HashID returns the OpenPGP ID of the hash used by this signature.
+func (s *Signature) HashID() byte {
+	return hashID(s.hash)
+}
+
+// AlgorithmID returns the OpenPGP ID of this signature's public-key
+// algorithm, e.g. 22 for EdDSA.
+func (s *Signature) AlgorithmID() byte {
+	return s.algo
+}
+
+// IssuerID returns the Key ID or fingerprint identifying the signer, or
+// nil if the signature does not identify its signer.
+func (s *Signature) IssuerID() []byte {
+	if s.IssuerFingerprint != nil {
+		return s.IssuerFingerprint
+	}
+	return s.Issuer
+}
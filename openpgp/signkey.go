@@ -3,13 +3,18 @@ package openpgp
 import (
 	"bufio"
 	"bytes"
+	"crypto"
 	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
+	"strings"
 	"time"
 )
 
@@ -18,9 +23,84 @@ const (
 	SignKeyPubLen = 53
 	signKeySecLen = 3 + 32 + 2
 
+	// SignKeyPubLenV6 is the size of the public part of a version 6
+	// OpenPGP packet.
+	SignKeyPubLenV6 = 44
+)
+
+const (
 	// FlagMDC indicates that the identity making a self-signature
 	// prefers to recieve a Modification Detection Code (MDC).
-	FlagMDC = iota
+	FlagMDC = 1 << iota
+
+	// FlagAEAD indicates that the identity making a self-signature
+	// supports receiving AEAD-encrypted (OCB, EAX) messages.
+	FlagAEAD
+
+	// FlagPrimary marks the self-signed user ID as the primary user ID.
+	FlagPrimary
+
+	// FlagSEIPDv2 indicates that the identity making a self-signature
+	// supports receiving RFC 9580 AEAD-encrypted messages (version 2
+	// Symmetrically Encrypted Integrity Protected Data), using the
+	// preferred ciphersuites.
+	FlagSEIPDv2
+)
+
+// Key flags, stating what a key may be used for, for use with
+// SetKeyFlags.
+const (
+	KeyFlagCertify        = 0x01
+	KeyFlagSign           = 0x02
+	KeyFlagEncryptComms   = 0x04
+	KeyFlagEncryptStorage = 0x08
+	KeyFlagAuth           = 0x20
+)
+
+// Reasons for revocation, for use with Revoke.
+const (
+	RevokeNoReason    = 0
+	RevokeSuperseded  = 1
+	RevokeCompromised = 2
+	RevokeRetired     = 3
+)
+
+// Algorithm selects the elliptic curve of a key, for use with
+// SetAlgorithm.
+type Algorithm int
+
+const (
+	// Curve25519 keys are Ed25519 sign keys and X25519 encryption keys,
+	// the default.
+	Curve25519 Algorithm = iota
+
+	// Curve448 keys are Ed448 sign keys and X448 encryption keys. Their
+	// signatures use SHA-512.
+	Curve448
+
+	// P256 keys are NIST P-256 ECDSA sign keys and ECDH encryption
+	// keys. Only version 4 keys are supported.
+	P256
+
+	// P384 keys are NIST P-384 ECDSA sign keys and ECDH encryption
+	// keys, whose signatures use SHA-384 by default. Only version 4
+	// keys are supported.
+	P384
+
+	// RSA2048 keys are 2048-bit RSA keys, generated deterministically
+	// from the seed. Only version 4 keys are supported.
+	RSA2048
+
+	// RSA4096 keys are 4096-bit RSA keys, like RSA2048.
+	RSA4096
+)
+
+// Curve OIDs in version 4 key packets.
+var (
+	oidEd25519    = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+	oidCurve25519 = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+	oidEd448      = []byte{0x2b, 0x65, 0x71} // 1.3.101.113
+	oidX448       = []byte{0x2b, 0x65, 0x6f} // 1.3.101.111
 )
 
 var (
@@ -32,16 +112,199 @@ var (
 	ErrUnsupportedPacket = errors.New("input packet unsupported")
 )
 
-// SignKey represents an Ed25519 sign key (EdDSA).
+// SignKey represents an Ed25519 or Ed448 sign key (EdDSA), a NIST P-256
+// or P-384 sign key (ECDSA), or an RSA key. Key holds an Ed25519 key,
+// and is empty for the other algorithms, whose keys are only accessed
+// through Seckey and Pubkey.
 type SignKey struct {
-	Key     ed25519.PrivateKey
-	created int64
-	expires int64
+	Key        ed25519.PrivateKey // Ed25519 keys only
+	key        []byte             // secret || public key, Ed448 and NIST
+	rsa        *rsa.PrivateKey    // RSA keys only
+	algo       Algorithm
+	created    int64
+	expires    int64
+	sigExpires int64
+	sigTime    int64
+	notations  []Notation
+	policyURI  string
+	keyServer  string
+	signerUID  string
+	prefs      *Preferences
+	keyFlags   byte
+	version    byte
+	hash       crypto.Hash
+}
+
+// Preferences lists the algorithms, by OpenPGP ID, that a key's owner
+// prefers to receive, most preferred first. They're advertised in
+// self-signatures.
+type Preferences struct {
+	Symmetric   []byte
+	Hash        []byte
+	Compression []byte
+	// Ciphersuites are pairs of symmetric and AEAD algorithm IDs,
+	// advertised only with FlagSEIPDv2.
+	Ciphersuites []byte
+}
+
+// DefaultPreferences are the preferences advertised by a key that
+// hasn't set its own: AES-256, SHA-512, no compression, and OCB.
+var DefaultPreferences = Preferences{
+	Symmetric:    []byte{9, 8, 7},    // AES-256, AES-192, AES-128
+	Hash:         []byte{10, 9, 8},   // SHA-512, SHA-384, SHA-256
+	Compression:  []byte{0},          // Uncompressed
+	Ciphersuites: []byte{9, 2, 7, 2}, // AES-256/OCB, AES-128/OCB
+}
+
+// Revoker designates another key that may revoke this one, such as an
+// organization's key.
+type Revoker struct {
+	// Algorithm is the revoker's public-key algorithm ID, e.g. 22 for
+	// EdDSA or 1 for RSA, since some implementations require it.
+	Algorithm byte
+	// Fingerprint is the revoker's 20-byte version 4 fingerprint.
+	Fingerprint []byte
+}
+
+// Trust makes a certification a trust signature, delegating trust to
+// the certified key as an introducer.
+type Trust struct {
+	// Depth is 1 for a trusted introducer, 2 for a meta-introducer that
+	// may name introducers, and so on. Zero means no delegation.
+	Depth byte
+	// Amount is 60 for partial trust, or 120 for complete trust.
+	Amount byte
+	// Regexp, if not empty, limits the delegation to the user IDs it
+	// matches, such as "<[^>]+[@.]example\.com>$".
+	Regexp string
 }
 
-// Seed sets the 32-byte seed for a sign key.
+// Returns the Trust Signature subpacket and, if scoped, the Regular
+// Expression subpacket.
+func (t Trust) subpackets() []subpacket {
+	if t.Depth == 0 {
+		return nil
+	}
+	// Trust Signature subpacket (type=5)
+	subpackets := []subpacket{{Type: 5, Data: []byte{t.Depth, t.Amount}}}
+	if t.Regexp != "" {
+		// Regular Expression subpacket (type=6) [critical, NUL-terminated]
+		data := append([]byte(t.Regexp), 0)
+		subpackets = append(subpackets, subpacket{Type: 0x80 | 6, Data: data})
+	}
+	return subpackets
+}
+
+// Notation is a name=value pair attached to signatures. User-defined
+// names take the form "name@domain".
+type Notation struct {
+	Name  string
+	Value string
+}
+
+// KeyFromSeed returns a sign key derived from a 32-byte seed with the
+// given creation date in unix epoch seconds. The same seed and date
+// always produce the same key and Key ID.
+func KeyFromSeed(seed []byte, created int64) *SignKey {
+	k := new(SignKey)
+	k.Seed(seed)
+	k.SetCreated(created)
+	return k
+}
+
+// Seed sets the seed for a sign key, which must be SeedSize bytes. The
+// secret scalar of a NIST curve key is derived from the seed, and an
+// RSA key is generated from it, which takes a moment.
 func (k *SignKey) Seed(seed []byte) {
-	k.Key = ed25519.NewKeyFromSeed(seed)
+	switch k.algo {
+	case RSA2048, RSA4096:
+		k.setKey(nil)
+		k.rsa = rsaGenerate(seed, k.algo.rsaBits())
+	case Curve448:
+		k.setKey(append(append([]byte{}, seed...), ed448Public(seed)...))
+	case P256, P384:
+		k.setSeckey(nistScalar(k.algo.nist(), seed))
+	default:
+		k.setKey(ed25519.NewKeyFromSeed(seed))
+	}
+}
+
+// Sets the secret key followed by the public key, stored in Key for
+// Ed25519, and clears any RSA key.
+func (k *SignKey) setKey(key []byte) {
+	k.Key, k.key, k.rsa = nil, nil, nil
+	if k.algo == Curve25519 {
+		k.Key = key
+	} else {
+		k.key = key
+	}
+}
+
+// Returns the secret key followed by the public key.
+func (k *SignKey) keyBytes() []byte {
+	if k.algo == Curve25519 {
+		return k.Key
+	}
+	return k.key
+}
+
+// Wipe overwrites the secret key with zeros.
+func (k *SignKey) Wipe() {
+	wipe(k.Key)
+	wipe(k.key)
+	if k.rsa != nil {
+		rsaWipe(k.rsa)
+	}
+}
+
+// Sets the key from its secret key as stored in a packet: the seed, or
+// a NIST curve's secret scalar.
+func (k *SignKey) setSeckey(seckey []byte) {
+	if curve := k.algo.nist(); curve != nil {
+		pubkey := nistPublic(curve, seckey)
+		k.setKey(append(append([]byte{}, seckey...), pubkey...))
+		return
+	}
+	k.Seed(seckey)
+}
+
+// SeedSize returns the size of this key's seed: 32 bytes for Ed25519,
+// P-256, and RSA, 57 bytes for Ed448, or 48 bytes for P-384.
+func (k *SignKey) SeedSize() int {
+	switch k.algo {
+	case Curve448:
+		return ed448SeedSize
+	case P256, P384:
+		return nistSize(k.algo.nist())
+	}
+	return ed25519.SeedSize
+}
+
+// Algorithm returns the key's algorithm, Curve25519 (default),
+// Curve448, P256, P384, RSA2048, or RSA4096.
+func (k *SignKey) Algorithm() Algorithm {
+	return k.algo
+}
+
+// SetAlgorithm selects the key's algorithm, which must precede Seed.
+func (k *SignKey) SetAlgorithm(algo Algorithm) {
+	k.algo = algo
+}
+
+// Version returns the key packet version, 4 (default) or 6.
+func (k *SignKey) Version() int {
+	if k.version == 6 {
+		return 6
+	}
+	return 4
+}
+
+// SetVersion selects the key packet version, 4 (default) or 6, which
+// also determines the version of the signatures made by this key. A
+// version 6 key (RFC 9580) has a different fingerprint than a version
+// 4 key with the same seed, and it cannot be protected by EncPacket.
+func (k *SignKey) SetVersion(version int) {
+	k.version = byte(version)
 }
 
 // Created returns the key's creation date in unix epoch seconds.
@@ -66,9 +329,191 @@ func (k *EncryptKey) SetExpires(time int64) {
 	k.expires = time
 }
 
+// KeyFlags returns the key flags set with SetKeyFlags, or zero if the
+// defaults are used.
+func (k *SignKey) KeyFlags() byte {
+	return k.keyFlags
+}
+
+// SetKeyFlags overrides the key flags stated for this key: in its
+// self-signatures as a primary key, by default certify and sign, or in
+// its binding signature as a subkey, by default sign or authenticate.
+// Zero restores the default.
+func (k *SignKey) SetKeyFlags(flags byte) {
+	k.keyFlags = flags
+}
+
+// Returns this key's key flags, or def if not set.
+func (k *SignKey) keyFlagsOr(def byte) byte {
+	if k.keyFlags == 0 {
+		return def
+	}
+	return k.keyFlags
+}
+
+// SigExpires returns the expiration time of this key's self-signatures
+// in unix epoch seconds. A value of zero means they don't expire.
+func (k *SignKey) SigExpires() int64 {
+	return k.sigExpires
+}
+
+// SetSigExpires sets the expiration time of this key's self-signatures
+// in unix epoch seconds. Unlike the key expiration time, this expires
+// only the self-signatures, which may later be renewed. A value of zero
+// means they don't expire.
+func (k *SignKey) SetSigExpires(time int64) {
+	k.sigExpires = time
+}
+
+// SigTime returns the creation time given to document signatures in
+// unix epoch seconds. A value of zero means the current time.
+func (k *SignKey) SigTime() int64 {
+	return k.sigTime
+}
+
+// SetSigTime fixes the creation time of document signatures, such as
+// from Sign and Clearsign, in unix epoch seconds. Since every supported
+// signature algorithm is deterministic, version 4 signatures of the same
+// data are then identical. (Version 6 signatures are always salted.) A
+// value of zero means the current time.
+func (k *SignKey) SetSigTime(time int64) {
+	k.sigTime = time
+}
+
+// Hash returns the hash function used in this key's signatures.
+func (k *SignKey) Hash() crypto.Hash {
+	switch {
+	case k.hash != 0:
+		return k.hash
+	case k.algo == Curve448:
+		return crypto.SHA512
+	case k.algo == P384:
+		return crypto.SHA384
+	}
+	return crypto.SHA256
+}
+
+// HashID returns the OpenPGP ID of the hash used in this key's
+// signatures, e.g. 8 for SHA-256.
+func (k *SignKey) HashID() byte {
+	return hashID(k.Hash())
+}
+
+// SetHash sets the hash function used in this key's signatures, which
+// must be SHA-256, SHA-384, or SHA-512. The default is SHA-256, except
+// for Ed448, which requires SHA-512, and P-384, which uses SHA-384.
+func (k *SignKey) SetHash(h crypto.Hash) {
+	if h != crypto.SHA256 && h != crypto.SHA384 && h != crypto.SHA512 {
+		panic("openpgp: unsupported signature hash")
+	}
+	k.hash = h
+}
+
+// Returns the creation time for a new document signature.
+func (k *SignKey) sigNow() int64 {
+	if k.sigTime != 0 {
+		return k.sigTime
+	}
+	return time.Now().Unix()
+}
+
+// Preferences returns the algorithm preferences advertised by this
+// key's self-signatures.
+func (k *SignKey) Preferences() Preferences {
+	if k.prefs == nil {
+		return DefaultPreferences
+	}
+	return *k.prefs
+}
+
+// SetPreferences sets the algorithm preferences advertised by this
+// key's self-signatures. An empty list omits that subpacket.
+func (k *SignKey) SetPreferences(prefs Preferences) {
+	k.prefs = &prefs
+}
+
+// AddNotation adds a human-readable notation to the data signatures
+// and certifications made by this key, but not to its self-signatures.
+func (k *SignKey) AddNotation(name, value string) {
+	k.notations = append(k.notations, Notation{name, value})
+}
+
+// SetPolicyURI sets a URI pointing at the policy under which this key
+// makes signatures, included in its self-signatures, certifications,
+// and data signatures. An empty URI omits it.
+func (k *SignKey) SetPolicyURI(uri string) {
+	k.policyURI = uri
+}
+
+// SetKeyServer sets the preferred key server, a URI from which to fetch
+// updates to this key, included in its self-signatures and
+// certifications. An empty URI omits it.
+func (k *SignKey) SetKeyServer(uri string) {
+	k.keyServer = uri
+}
+
+// SetSignerUID sets the user ID, one of this key's, on whose behalf it
+// makes data signatures, so that verifiers can tell which identity
+// signed. An empty user ID omits it.
+func (k *SignKey) SetSignerUID(uid string) {
+	k.signerUID = uid
+}
+
+// Returns the subpackets for this key's data signatures: its notations,
+// Policy URI, and Signer's User ID.
+func (k *SignKey) dataSubpackets() []subpacket {
+	subpackets := k.notationData()
+	if k.signerUID != "" {
+		// Signer's User ID subpacket (type=28)
+		uid := subpacket{Type: 28, Data: []byte(k.signerUID)}
+		subpackets = append(subpackets, uid)
+	}
+	return subpackets
+}
+
+// Returns Notation Data subpackets for this key's notations, and its
+// Policy URI, for data signatures and certifications.
+func (k *SignKey) notationData() []subpacket {
+	var subpackets []subpacket
+	for _, n := range k.notations {
+		subpackets = append(subpackets, n.subpacket())
+	}
+	if k.policyURI != "" {
+		subpackets = append(subpackets, k.policySubpacket())
+	}
+	return subpackets
+}
+
+// Returns the Policy URI subpacket for this key.
+func (k *SignKey) policySubpacket() subpacket {
+	// Policy URI subpacket (type=26)
+	return subpacket{Type: 26, Data: []byte(k.policyURI)}
+}
+
+// Returns the Preferred Key Server subpacket for this key.
+func (k *SignKey) keyServerSubpacket() subpacket {
+	// Preferred Key Server subpacket (type=24)
+	return subpacket{Type: 24, Data: []byte(k.keyServer)}
+}
+
+// Returns a Notation Data subpacket for this notation.
+func (n Notation) subpacket() subpacket {
+	// Notation Data subpacket (type=20) [human-readable]
+	data := []byte{0x80, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(data[4:], uint16(len(n.Name)))
+	binary.BigEndian.PutUint16(data[6:], uint16(len(n.Value)))
+	data = append(data, n.Name...)
+	data = append(data, n.Value...)
+	return subpacket{Type: 20, Data: data}
+}
+
 // Load key material from packet body. If the error is DecryptKeyErr,
 // then either the passphrase was nil or the passphrase is wrong. To use
 // an empty passphrase, pass an empty but non-nil passphrase.
+//
+// A public key packet loads only the public key, which is suitable for
+// verifying signatures but not for creating them. Subkey packets load
+// just like their primary key counterparts.
 func (k *SignKey) Load(packet Packet, passphrase []byte) (err error) {
 	defer func() {
 		if recover() != nil {
@@ -77,69 +522,192 @@ func (k *SignKey) Load(packet Packet, passphrase []byte) (err error) {
 	}()
 
 	switch packet.Tag {
-	case 5:
+	case 5, 6, 7, 14:
 		// Ok
-	case 6:
-		// TODO: Support loading public key packets
-		return ErrUnsupportedPacket
 	default:
 		// Wrong packet type
 		return ErrInvalidPacket
 	}
 
-	// Check various static bytes
 	body := packet.Body
-	if body[0] != 0x04 || !bytes.Equal(body[5:19], []byte{
-		22, 9,
-		0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01,
-		0x01, 0x07, 0x40,
-	}) {
+	if body[0] == 0x06 {
+		return k.loadV6(packet, passphrase)
+	}
+
+	// Check various static bytes
+	if body[0] != 0x04 {
+		return ErrUnsupportedPacket
+	}
+	if body[5] == 1 { // RSA
+		key, algo, created, err := loadRSA(packet, passphrase)
+		if err != nil {
+			return err
+		}
+		k.algo = algo
+		k.setKey(nil)
+		k.rsa = key
+		k.SetCreated(created)
+		k.SetVersion(4)
+		return nil
+	}
+	oid := body[7 : 7+int(body[6])]
+	switch {
+	case body[5] == 22 && bytes.Equal(oid, oidEd25519):
+		k.algo = Curve25519
+	case body[5] == 22 && bytes.Equal(oid, oidEd448):
+		k.algo = Curve448
+	case body[5] == 19 && bytes.Equal(oid, oidP256):
+		k.algo = P256
+	case body[5] == 19 && bytes.Equal(oid, oidP384):
+		k.algo = P384
+	default:
+		return ErrUnsupportedPacket
+	}
+	size := k.SeedSize()
+	pubkey, rest := k.algo.decodePoint(body[7+len(oid):], size)
+	if pubkey == nil {
 		return ErrUnsupportedPacket
 	}
 
-	pubkey := body[19:51]
 	created := int64(binary.BigEndian.Uint32(body[1:]))
 	k.SetCreated(created)
+	k.SetVersion(4)
+
+	if packet.Tag == 6 || packet.Tag == 14 {
+		// Public key only, so leave the secret key zeroed
+		k.setKey(append(make([]byte, size), pubkey...))
+		return nil
+	}
 
-	seckey, err := s2kDecryptKey(body[51:], passphrase)
+	seckey, err := s2kDecryptKey(rest, passphrase)
 	if err != nil {
 		return err
 	}
+	if len(seckey) < size {
+		// Leading zeros were dropped from the MPI
+		seckey = append(make([]byte, size-len(seckey)), seckey...)
+	}
 
-	k.Seed(seckey)
+	k.setSeckey(seckey)
 	if !bytes.Equal(k.Pubkey(), pubkey) {
 		return ErrInvalidPacket
 	}
 	return nil
 }
 
-// Seckey returns the public key part of a sign key.
+// Load a version 6 Ed25519 or Ed448 key packet, which holds the raw
+// keys. Only unprotected secret keys are supported.
+func (k *SignKey) loadV6(packet Packet, passphrase []byte) error {
+	body := packet.Body
+	switch {
+	case bytes.Equal(body[5:10], []byte{27, 0, 0, 0, 32}):
+		k.algo = Curve25519
+	case bytes.Equal(body[5:10], []byte{28, 0, 0, 0, 57}):
+		k.algo = Curve448
+	default:
+		return ErrUnsupportedPacket
+	}
+
+	size := k.SeedSize()
+	pubkey := body[10 : 10+size]
+	created := int64(binary.BigEndian.Uint32(body[1:]))
+	k.SetCreated(created)
+	k.SetVersion(6)
+
+	if packet.Tag == 6 || packet.Tag == 14 {
+		// Public key only, so leave the secret key zeroed
+		k.setKey(append(make([]byte, size), pubkey...))
+		return nil
+	}
+
+	end := 10 + size
+	if body[end] != 0 || len(body) != end+1+size {
+		return ErrUnsupportedPacket
+	}
+	k.Seed(body[end+1:])
+	if !bytes.Equal(k.Pubkey(), pubkey) {
+		return ErrInvalidPacket
+	}
+	return nil
+}
+
+// Seckey returns the secret key part of a sign key. For RSA, this is
+// the private exponent.
 func (k *SignKey) Seckey() []byte {
-	return k.Key[:32]
+	if k.rsa != nil {
+		return rsaSeckey(k.rsa)
+	}
+	return k.keyBytes()[:k.SeedSize()]
 }
 
-// Pubkey returns the public key part of a sign key.
+// Pubkey returns the public key part of a sign key. For RSA, this is
+// the modulus.
 func (k *SignKey) Pubkey() []byte {
-	return k.Key[32:]
+	if k.rsa != nil {
+		return k.rsa.N.Bytes()
+	}
+	return k.keyBytes()[k.SeedSize():]
+}
+
+// AlgorithmID returns the public-key algorithm ID of this key and its
+// signatures, e.g. 22 for EdDSA.
+func (k *SignKey) AlgorithmID() byte {
+	return k.pkAlgo()
+}
+
+// Returns the public-key algorithm ID of this key and its signatures.
+func (k *SignKey) pkAlgo() byte {
+	switch {
+	case k.rsa != nil:
+		return 1 // RSA
+	case k.algo == P256 || k.algo == P384:
+		return 19 // ECDSA
+	case k.version != 6:
+		return 22 // EdDSA
+	case k.algo == Curve448:
+		return 28 // Ed448
+	}
+	return 27 // Ed25519
 }
 
 // PubPacket returns a public key packet for this key.
 func (k *SignKey) PubPacket() []byte {
-	packet := make([]byte, SignKeyPubLen, 256)
+	if k.rsa != nil {
+		p := Packet{Tag: 6, Body: rsaPubBody(k.created, &k.rsa.PublicKey)}
+		return p.Encode()
+	}
+	if k.version == 6 {
+		packet := make([]byte, 12, 256)
+		packet[0] = 0xc0 | 6 // packet header, Public-Key packet (6)
+		packet[2] = 0x06     // packet version (6)
+		binary.BigEndian.PutUint32(packet[3:], uint32(k.created))
+		packet[7] = k.pkAlgo()
+		binary.BigEndian.PutUint32(packet[8:], uint32(len(k.Pubkey())))
+		packet = append(packet, k.Pubkey()...)
+		packet[1] = byte(len(packet) - 2)
+		return packet
+	}
+
+	packet := make([]byte, 8, 256)
 	packet[0] = 0xc0 | 6 // packet header, Public-Key packet (6)
 	packet[2] = 0x04     // packet version, new (4)
 
 	binary.BigEndian.PutUint32(packet[3:], uint32(k.created))
-	packet[7] = 22 // algorithm, EdDSA
-	packet[8] = 9  // OID length
-	// OID (1.3.6.1.4.1.11591.15.1)
-	oid := []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
-	copy(packet[9:], oid)
+	packet[7] = k.pkAlgo()
+	oid := oidEd25519
+	switch k.algo {
+	case Curve448:
+		oid = oidEd448
+	case P256:
+		oid = oidP256
+	case P384:
+		oid = oidP384
+	}
+	packet = append(packet, byte(len(oid)))
+	packet = append(packet, oid...)
 
-	// Public key length (always 263 bits)
-	binary.BigEndian.PutUint16(packet[18:], 263)
-	packet[20] = 0x40               // MPI prefix
-	copy(packet[21:53], k.Pubkey()) // public key (32 bytes)
+	// Public key, e.g. 263 bits for Ed25519 and 515 bits for P-256
+	packet = append(packet, mpi(k.algo.point(k.Pubkey()))...)
 
 	packet[1] = byte(len(packet) - 2) // packet length
 	return packet
@@ -147,10 +715,19 @@ func (k *SignKey) PubPacket() []byte {
 
 // Packet returns an OpenPGP packet for a sign key.
 func (k *SignKey) Packet() []byte {
+	if k.rsa != nil {
+		return rsaSecretPacket(5, k.pubBody(), k.rsa, nil)
+	}
 	packet := k.PubPacket()
 	packet[0] = 0xc0 | 5 // packet header, Secret-Key Packet (5)
 
 	packet = append(packet, 0) // string-to-key, unencrypted
+	if k.version == 6 {
+		// Version 6 keys are raw and have no checksum
+		packet = append(packet, k.Seckey()...)
+		packet[1] = byte(len(packet) - 2) // packet length
+		return packet
+	}
 	mpikey := mpi(k.Seckey())
 	packet = append(packet, mpikey...)
 	packet = packet[:len(packet)+2]
@@ -162,20 +739,62 @@ func (k *SignKey) Packet() []byte {
 
 // EncPacket returns a protected secret key packet.
 func (k *SignKey) EncPacket(passphrase []byte) []byte {
+	if k.rsa != nil {
+		return rsaSecretPacket(5, k.pubBody(), k.rsa, passphrase)
+	}
+	body := s2kEncryptKey(k.pubBody(), k.Seckey(), passphrase)
+	// A P-384 key outgrows a one-octet length
+	p := Packet{Tag: 5, Body: body} // Secret-Key Packet (5)
+	return p.Encode()
+}
+
+// Returns the body of this key's public key packet.
+func (k *SignKey) pubBody() []byte {
+	p, _, _ := ParsePacket(k.PubPacket())
+	return p.Body
+}
+
+// StubPacket returns a secret key packet without the secret key, marked
+// with GnuPG's "gnu-dummy" S2K extension as by gpg --export-secret-subkeys.
+// Alongside secret subkeys, the subkeys remain usable while the primary
+// key cannot certify or sign. Only version 4 keys are supported.
+func (k *SignKey) StubPacket() []byte {
+	body := k.pubBody()
+	body = append(body, 254)              // encrypted with S2K
+	body = append(body, 7)                // AES-128 (unused)
+	body = append(body, 101)              // GnuPG S2K extension
+	body = append(body, 2)                // SHA-1 (unused)
+	body = append(body, 'G', 'N', 'U', 1) // gnu-dummy, no secret key
+
+	p := Packet{Tag: 5, Body: body} // Secret-Key Packet (5)
+	return p.Encode()
+}
+
+// SubPubPacket returns a public subkey packet for this key.
+func (k *SignKey) SubPubPacket() []byte {
 	packet := k.PubPacket()
-	packet[0] = 0xc0 | 5 // packet header, Secret-Key Packet (5)
-	packet = s2kEncryptKey(packet, k.Seckey(), passphrase)
-	packet[1] = byte(len(packet) - 2) // packet length
+	packet[0] = 0xc0 | 14 // packet header, Public-Subkey packet (14)
+	return packet
+}
+
+// SubPacket returns a secret subkey packet for this key.
+func (k *SignKey) SubPacket() []byte {
+	packet := k.Packet()
+	packet[0] = 0xc0 | 7 // packet header, Secret-Subkey Packet (7)
+	return packet
+}
+
+// EncSubPacket returns a protected secret subkey packet.
+func (k *SignKey) EncSubPacket(passphrase []byte) []byte {
+	packet := k.EncPacket(passphrase)
+	packet[0] = 0xc0 | 7 // packet header, Secret-Subkey Packet (7)
 	return packet
 }
 
-// KeyID returns the Key ID for a sign key.
+// KeyID returns the Key ID for a sign key. This is the full
+// fingerprint: 20 bytes for version 4 keys, and 32 bytes for version 6.
 func (k *SignKey) KeyID() []byte {
-	h := sha1.New()
-	packet := k.PubPacket()[2:] // chop off header
-	h.Write([]byte{0x99, 0, byte(len(packet))})
-	h.Write(packet)
-	return h.Sum(nil)
+	return keyFingerprint(k.PubPacket())
 }
 
 type subpacket struct {
@@ -185,53 +804,113 @@ type subpacket struct {
 
 // Bind a subkey to this signing key, returning the signature packet.
 func (k *SignKey) Bind(subkey *EncryptKey, when int64) []byte {
-	const sigtype = 0x18 // Subkey Binding Signature
-	h := sha256.New()
-	pubkey := k.PubPacket()
-	h.Write([]byte{0x99, 0, byte(len(pubkey) - 2)})
-	h.Write(pubkey[2:])
+	// Encrypt communications and storage, by default
+	flags := subkey.keyFlagsOr(KeyFlagEncryptComms | KeyFlagEncryptStorage)
+	pubsubkey := subkey.PubPacket()
+	delta := subkey.expires - subkey.created
+	if subkey.expires == 0 {
+		delta = 0
+	}
+	return k.bind(pubsubkey, flags, delta, when)
+}
+
+// BindSign binds a signing subkey to this signing key, returning the
+// signature packet. The binding includes the subkey's cross-certifying
+// primary key binding signature, which is required of signing subkeys.
+func (k *SignKey) BindSign(subkey *SignKey, when int64) []byte {
+	flags := subkey.keyFlagsOr(KeyFlagSign)
 	pubsubkey := subkey.PubPacket()
-	h.Write([]byte{0x99, 0, byte(len(pubsubkey) - 2)})
-	h.Write(pubsubkey[2:])
+	delta := subkey.expires - subkey.created
+	if subkey.expires == 0 {
+		delta = 0
+	}
+
+	// GnuPG (as of 2.2.40) only accepts an embedded signature dated
+	// after the unix epoch, so nudge it past passphrase2pgp's default
+	// creation date.
+	backWhen := when
+	if backWhen == 0 {
+		backWhen = 1
+	}
+
+	const sigtype = 0x19 // Primary Key Binding Signature
+	h := k.bindingHash(subkey.newHash(), pubsubkey)
+	backsig := subkey.sign(sigInput{h, sigtype, backWhen, nil})
+	packet, _, _ := ParsePacket(backsig)
+	// Embedded Signature subpacket (type=32)
+	embedded := subpacket{Type: 32, Data: packet.Body}
+
+	return k.bind(pubsubkey, flags, delta, when, embedded)
+}
+
+// BindAuth binds an authentication subkey to this signing key,
+// returning the signature packet.
+func (k *SignKey) BindAuth(subkey *SignKey, when int64) []byte {
+	flags := subkey.keyFlagsOr(KeyFlagAuth)
+	pubsubkey := subkey.PubPacket()
+	delta := subkey.expires - subkey.created
+	if subkey.expires == 0 {
+		delta = 0
+	}
+	return k.bind(pubsubkey, flags, delta, when)
+}
+
+// Writes this key and the given subkey's public key packet into h, as
+// signed by binding signatures, and returns h.
+func (k *SignKey) bindingHash(h hash.Hash, sub []byte) hash.Hash {
+	writeKey(h, k.PubPacket())
+	writeKey(h, sub)
+	return h
+}
+
+// Returns a subkey binding signature over the given public key packet
+// with the given key flags and any additional subpackets. A zero
+// expiration delta means the subkey doesn't expire.
+func (k *SignKey) bind(sub []byte, flags byte, delta, when int64,
+	extra ...subpacket) []byte {
+	const sigtype = 0x18 // Subkey Binding Signature
+	h := k.bindingHash(k.newHash(), sub)
 
 	subpackets := []subpacket{
-		// Key Flags subpacket (encrypt)
-		{Type: 27, Data: []byte{0x0c}},
+		// Key Flags subpacket
+		{Type: 27, Data: []byte{flags}},
 	}
-	if subkey.expires != 0 {
+	if delta != 0 {
 		// Key Expiration Time packet
-		delta := uint32(subkey.expires - subkey.created)
-		expires := subpacket{Type: 9, Data: marshal32be(delta)}
+		expires := subpacket{Type: 9, Data: marshal32be(uint32(delta))}
 		subpackets = append(subpackets, expires)
 	}
+	if k.sigExpires != 0 {
+		subpackets = append(subpackets, k.sigExpiration(when))
+	}
+	subpackets = append(subpackets, extra...)
 
 	return k.sign(sigInput{h, sigtype, when, subpackets})
 }
 
 // SelfSign returns a self-signature packer over a user ID.
 func (k *SignKey) SelfSign(userid *UserID, when int64, flags int) []byte {
+	return k.selfSign(k.userIDHash(k.newHash(), userid), when, flags)
+}
+
+// SelfSignPhoto returns a self-signature packet over a photo ID, with
+// the same flags as SelfSign, where FlagPrimary marks the primary photo.
+func (k *SignKey) SelfSignPhoto(photo *PhotoID, when int64, flags int) []byte {
+	return k.selfSign(k.photoHash(k.newHash(), photo), when, flags)
+}
+
+// Returns a positive certification over the hashed identity, carrying
+// this key's properties.
+func (k *SignKey) selfSign(h hash.Hash, when int64, flags int) []byte {
 	const sigtype = 0x13 // Positive certification
-	h := sha256.New()
-	key := k.PubPacket()
-	h.Write([]byte{0x99, 0, byte(len(key) - 2)})
-	h.Write(key[2:])
-	uid := userid.Packet()
-	h.Write([]byte{0xb4, 0, 0, 0, byte(len(uid) - 2)})
-	h.Write(uid[2:])
-
-	// An Issuer Fingerprint subpacket is unnecessary here because this
-	// is a self-signature, and so even the Issuer subpacket is already
-	// redundant. The recipient already knows which key we're talking
-	// about. Technically the Issuer subpacket is optional, but GnuPG
-	// will not import a key without it.
 	var subpackets []subpacket
 
-	// Key Flags subpacket (type=27) [sign and certify]
+	// Key Flags subpacket (type=27) [sign and certify, by default]
 	// This is necessary since some implementations (GitHub) treat
 	// all flags as if they were zero if not present.
 	keyflags := subpacket{
 		Type: 27,
-		Data: []byte{0x03},
+		Data: []byte{k.keyFlagsOr(KeyFlagCertify | KeyFlagSign)},
 	}
 	subpackets = append(subpackets, keyflags)
 
@@ -244,35 +923,159 @@ func (k *SignKey) SelfSign(userid *UserID, when int64, flags int) []byte {
 		subpackets = append(subpackets, expires)
 	}
 
-	if flags&FlagMDC != 0 {
+	if k.sigExpires != 0 {
+		subpackets = append(subpackets, k.sigExpiration(when))
+	}
+
+	prefs := k.Preferences()
+	if len(prefs.Symmetric) > 0 {
+		// Preferred Symmetric Algorithms subpacket (type=11)
+		sym := subpacket{Type: 11, Data: prefs.Symmetric}
+		subpackets = append(subpackets, sym)
+	}
+	if len(prefs.Hash) > 0 {
+		// Preferred Hash Algorithms subpacket (type=21)
+		hash := subpacket{Type: 21, Data: prefs.Hash}
+		subpackets = append(subpackets, hash)
+	}
+	if len(prefs.Compression) > 0 {
+		// Preferred Compression Algorithms subpacket (type=22)
+		comp := subpacket{Type: 22, Data: prefs.Compression}
+		subpackets = append(subpackets, comp)
+	}
+
+	if flags&(FlagMDC|FlagAEAD|FlagSEIPDv2) != 0 {
 		// Features subpacket (type=30)
-		mdc := subpacket{Type: 30, Data: []byte{0x01}}
-		subpackets = append(subpackets, mdc)
+		var features byte
+		if flags&FlagMDC != 0 {
+			features |= 0x01
+		}
+		if flags&FlagAEAD != 0 {
+			features |= 0x02
+		}
+		if flags&FlagSEIPDv2 != 0 {
+			features |= 0x08
+		}
+		feat := subpacket{Type: 30, Data: []byte{features}}
+		subpackets = append(subpackets, feat)
+	}
+
+	if flags&FlagPrimary != 0 {
+		// Primary User ID subpacket (type=25)
+		primary := subpacket{Type: 25, Data: []byte{1}}
+		subpackets = append(subpackets, primary)
+	}
+
+	if flags&FlagAEAD != 0 {
+		// Preferred AEAD Algorithms subpacket (type=34) [OCB, EAX]
+		prefs := subpacket{Type: 34, Data: []byte{2, 1}}
+		subpackets = append(subpackets, prefs)
+	}
+	if flags&FlagSEIPDv2 != 0 && len(prefs.Ciphersuites) > 0 {
+		// Preferred AEAD Ciphersuites subpacket (type=39)
+		suites := subpacket{Type: 39, Data: prefs.Ciphersuites}
+		subpackets = append(subpackets, suites)
+	}
+
+	if k.keyServer != "" {
+		subpackets = append(subpackets, k.keyServerSubpacket())
+	}
+	if k.policyURI != "" {
+		subpackets = append(subpackets, k.policySubpacket())
 	}
 
 	return k.sign(sigInput{h, sigtype, when, subpackets})
 }
 
+// Writes this key and the given user ID into h, as signed by
+// certifications, and returns h.
+func (k *SignKey) userIDHash(h hash.Hash, userid *UserID) hash.Hash {
+	writeKey(h, k.PubPacket())
+	prefix := []byte{0xb4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(userid.ID)))
+	h.Write(prefix)
+	h.Write(userid.ID)
+	return h
+}
+
+// Writes this key and the given photo ID into h, as signed by
+// certifications, and returns h.
+func (k *SignKey) photoHash(h hash.Hash, photo *PhotoID) hash.Hash {
+	writeKey(h, k.PubPacket())
+	body := photo.body()
+	prefix := []byte{0xd1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(body)))
+	h.Write(prefix)
+	h.Write(body)
+	return h
+}
+
+// Revoke returns a key revocation signature packet for this key, i.e. a
+// revocation certificate. The comment is a human-readable explanation
+// of the reason, and must be shorter than 190 bytes.
+func (k *SignKey) Revoke(reason byte, comment string, when int64) []byte {
+	const sigtype = 0x20 // Key revocation signature
+	h := k.newHash()
+	writeKey(h, k.PubPacket())
+
+	subpackets := []subpacket{
+		// Reason for Revocation subpacket (type=29)
+		{Type: 29, Data: append([]byte{reason}, comment...)},
+	}
+	return k.sign(sigInput{h, sigtype, when, subpackets})
+}
+
+// DesignateRevokers returns a direct-key self-signature packet
+// authorizing the given keys to revoke this one. It belongs just after
+// the primary key, before any user IDs. Version 6 keys cannot designate
+// revokers.
+func (k *SignKey) DesignateRevokers(revokers []Revoker, when int64) []byte {
+	const sigtype = 0x1f // Direct-key signature
+	h := k.newHash()
+	writeKey(h, k.PubPacket())
+
+	var subpackets []subpacket
+	for _, r := range revokers {
+		// Revocation Key subpacket (type=12) [class 0x80, always set]
+		data := append([]byte{0x80, r.Algorithm}, r.Fingerprint...)
+		subpackets = append(subpackets, subpacket{Type: 12, Data: data})
+	}
+	return k.sign(sigInput{h, sigtype, when, subpackets})
+}
+
 // Certify a pairing of public key and user ID packet, returning the
 // signature packet. This accept byte slices so that arbitrary packets
 // can be certified, not just formats understood by this package.
 func (k *SignKey) Certify(key, uid []byte, when int64) []byte {
-	const sigtype = 0x10 // Generic certification
-	h := sha256.New()
+	return k.CertifyLevel(key, uid, 0, when)
+}
+
+// CertifyLevel is like Certify, but states how carefully the user ID
+// was checked, from 0 to 3: a generic (0x10), persona (0x11), casual
+// (0x12), or positive (0x13) certification.
+func (k *SignKey) CertifyLevel(key, uid []byte, level int, when int64) []byte {
+	return k.CertifyTrust(key, uid, level, Trust{}, when)
+}
+
+// CertifyTrust is like CertifyLevel, but also makes a trust signature,
+// so that the certified key may itself introduce other keys.
+func (k *SignKey) CertifyTrust(key, uid []byte, level int, trust Trust, when int64) []byte {
+	sigtype := byte(0x10 + level&3)
+	h := k.newHash()
 
-	prefix := []byte{0x99, 0, 0}
 	keypkt, _, _ := ParsePacket(key)
-	binary.BigEndian.PutUint16(prefix[1:], uint16(len(keypkt.Body)))
-	h.Write(prefix)
-	h.Write(keypkt.Body)
+	writeKeyBody(h, keypkt.Body)
 
-	prefix = []byte{0xb4, 0, 0, 0, 0}
+	prefix := []byte{0xb4, 0, 0, 0, 0}
 	uidpkt, _, _ := ParsePacket(uid)
 	binary.BigEndian.PutUint32(prefix[1:], uint32(len(uidpkt.Body)))
 	h.Write(prefix)
 	h.Write(uidpkt.Body)
 
-	subpackets := []subpacket{fingerprint(k.KeyID())}
+	subpackets := append(trust.subpackets(), k.notationData()...)
+	if k.keyServer != "" {
+		subpackets = append(subpackets, k.keyServerSubpacket())
+	}
 	return k.sign(sigInput{h, sigtype, when, subpackets})
 }
 
@@ -280,12 +1083,50 @@ func (k *SignKey) Certify(key, uid []byte, when int64) []byte {
 func (k *SignKey) Sign(src io.Reader) ([]byte, error) {
 	const sigtype = 0x00 // Binary document
 	// Compute digest to be signed
-	h := sha256.New()
+	h := k.newHash()
 	if _, err := io.Copy(h, src); err != nil {
 		return nil, err
 	}
-	subpackets := []subpacket{fingerprint(k.KeyID())}
-	in := sigInput{h, sigtype, time.Now().Unix(), subpackets}
+	in := sigInput{h, sigtype, k.sigNow(), k.dataSubpackets()}
+	return k.sign(in), nil
+}
+
+// SignText signs text with this key using an OpenPGP text signature
+// packet (type 0x01), computed over the canonical form of the text
+// with CRLF line endings. Unlike a binary signature, it still verifies
+// after line endings are converted.
+func (k *SignKey) SignText(src io.Reader) ([]byte, error) {
+	const sigtype = 0x01 // Text document
+	h := k.newHash()
+	if err := canonicalizeText(h, src); err != nil {
+		return nil, err
+	}
+	in := sigInput{h, sigtype, k.sigNow(), k.dataSubpackets()}
+	return k.sign(in), nil
+}
+
+// DigestNotation is the name of the notation holding the hex-encoded
+// SHA-256 digest of a timestamped document.
+const DigestNotation = "sha256@nullprogram.com"
+
+// SignTimestamp returns a timestamp signature (type 0x40) over binary
+// data, attesting only that the data existed at the signature's
+// creation time. If digest is true, the data's SHA-256 digest is also
+// recorded in a DigestNotation notation, so that the document can be
+// identified from the signature alone.
+func (k *SignKey) SignTimestamp(src io.Reader, digest bool) ([]byte, error) {
+	const sigtype = 0x40 // Timestamp signature
+	h := k.newHash()
+	d := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h, d), src); err != nil {
+		return nil, err
+	}
+	subpackets := k.dataSubpackets()
+	if digest {
+		n := Notation{DigestNotation, fmt.Sprintf("%x", d.Sum(nil))}
+		subpackets = append(subpackets, n.subpacket())
+	}
+	in := sigInput{h, sigtype, k.sigNow(), subpackets}
 	return k.sign(in), nil
 }
 
@@ -293,36 +1134,47 @@ func (k *SignKey) Sign(src io.Reader) ([]byte, error) {
 // given reader will be cleartext-signed and wrtten into the returned
 // reader. The returned reader must either be read completely or closed.
 func (k *SignKey) Clearsign(src io.Reader) io.ReadCloser {
+	return ClearsignAll(src, k)
+}
+
+// ClearsignAll is like Clearsign, but the text is signed by every given
+// key, as for a statement made jointly by several keys.
+func ClearsignAll(src io.Reader, keys ...*SignKey) io.ReadCloser {
 	const sigtype = 0x01 // Text document
 	r, w := io.Pipe()
 	go func() {
-		open := []byte("-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n")
+		// The Hash header names each hash used, in order
+		var names []string
+		for _, k := range keys {
+			name := hashNames[k.Hash()]
+			if len(names) == 0 || names[len(names)-1] != name {
+				names = append(names, name)
+			}
+		}
+		open := []byte("-----BEGIN PGP SIGNED MESSAGE-----\nHash: " +
+			strings.Join(names, ",") + "\n\n")
 		crlf := []byte("\r\n")
 		tmp := make([]byte, 128)
 		if _, err := w.Write(open); err != nil {
 			return
 		}
 		s := bufio.NewScanner(src)
-		h := sha256.New()
+		hashes := make([]hash.Hash, len(keys))
+		for i, k := range keys {
+			hashes[i] = k.newHash()
+		}
 		first := true
 		for s.Scan() {
-			line := s.Bytes()
-
-			// Remove trailing whitespace
-			for i := len(line) - 1; i >= 0; i-- {
-				if line[i] == 0x20 || line[i] == 0x09 {
-					line = line[:i]
-				} else {
-					break
-				}
-			}
+			line := canonicalLine(s.Bytes())
 
-			// Append to hash
-			if !first {
-				h.Write(crlf)
+			// Append to hashes
+			for _, h := range hashes {
+				if !first {
+					h.Write(crlf)
+				}
+				h.Write(line)
 			}
 			first = false
-			h.Write(line)
 
 			// Pass through dash-encoded
 			if len(line) > 0 && line[0] == 0x2d {
@@ -342,10 +1194,12 @@ func (k *SignKey) Clearsign(src io.Reader) io.ReadCloser {
 			w.CloseWithError(err)
 		}
 
-		subpackets := []subpacket{fingerprint(k.KeyID())}
-		in := sigInput{h, sigtype, time.Now().Unix(), subpackets}
-		sig := Armor(k.sign(in))
-		if _, err := w.Write(sig); err != nil {
+		var sigs []byte
+		for i, k := range keys {
+			in := sigInput{hashes[i], sigtype, k.sigNow(), k.dataSubpackets()}
+			sigs = append(sigs, k.sign(in)...)
+		}
+		if _, err := w.Write(Armor(sigs)); err != nil {
 			return
 		}
 		w.Close()
@@ -353,9 +1207,251 @@ func (k *SignKey) Clearsign(src io.Reader) io.ReadCloser {
 	return r
 }
 
+// SignMessage writes a complete signed message to w: a One-Pass
+// Signature packet, the data read from src in a Literal Data packet,
+// and finally the signature. The data is streamed rather than held in
+// memory. If text is true, the data is marked as text and given a text
+// signature (type 0x01), otherwise a binary signature (type 0x00).
+func (k *SignKey) SignMessage(w io.Writer, src io.Reader, text bool) error {
+	sigtype, format := byte(0x00), byte('b') // Binary document
+	if text {
+		sigtype, format = 0x01, 't' // Text document
+	}
+	h := k.newHash()
+	if _, err := w.Write(k.onePassSig(h, sigtype)); err != nil {
+		return err
+	}
+
+	// Literal Data header: format, no file name, and date
+	when := k.sigNow()
+	lit := &partialWriter{w: w, tag: 11}
+	header := append([]byte{format, 0}, marshal32be(uint32(when))...)
+	if _, err := lit.Write(header); err != nil {
+		return err
+	}
+	// Text is stored in canonical form, with CRLF line endings
+	var err error
+	if text {
+		err = canonicalizeText(io.MultiWriter(h, lit), src)
+	} else {
+		_, err = io.Copy(io.MultiWriter(h, lit), src)
+	}
+	if err != nil {
+		return err
+	}
+	if err := lit.Close(); err != nil {
+		return err
+	}
+
+	in := sigInput{h, sigtype, when, k.dataSubpackets()}
+	_, err = w.Write(k.sign(in))
+	return err
+}
+
+// Returns a One-Pass Signature packet announcing a signature of the
+// given type, to be computed with h, that follows the signed data.
+func (k *SignKey) onePassSig(h hash.Hash, sigtype byte) []byte {
+	var body []byte
+	if k.version == 6 {
+		salt := h.(saltedHash).salt
+		body = []byte{6, sigtype, hashID(k.Hash()), k.pkAlgo(), byte(len(salt))}
+		body = append(body, salt...)
+		body = append(body, k.KeyID()...)
+	} else {
+		body = []byte{3, sigtype, hashID(k.Hash()), k.pkAlgo()}
+		body = append(body, k.KeyID()[12:20]...)
+	}
+	body = append(body, 1) // last (only) signature
+	p := Packet{Tag: 4, Body: body}
+	return p.Encode()
+}
+
+// Returns a Signature Expiration Time subpacket for a self-signature
+// created at the given time.
+func (k *SignKey) sigExpiration(when int64) subpacket {
+	// Signature Expiration Time subpacket (type=3)
+	delta := uint32(k.sigExpires - when)
+	return subpacket{Type: 3, Data: marshal32be(delta)}
+}
+
+// Verify that sig is a valid signature by this key over the data read
+// from src. Text signatures (type 0x01) are verified over the canonical
+// form of the text, and timestamp signatures (type 0x40) like binary
+// signatures.
+func (k *SignKey) Verify(src io.Reader, sig *Signature) error {
+	h := sig.newHash()
+	switch sig.Type {
+	case 0x00, 0x40: // Binary document, Timestamp
+		if _, err := io.Copy(h, src); err != nil {
+			return err
+		}
+	case 0x01: // Text document
+		if err := canonicalizeText(h, src); err != nil {
+			return err
+		}
+	default:
+		return ErrUnsupportedPacket
+	}
+	return k.verifyHash(h, sig)
+}
+
+// VerifyUserID verifies that sig is a valid self-signature by this key
+// over the given user ID.
+func (k *SignKey) VerifyUserID(userid *UserID, sig *Signature) error {
+	if sig.Type < 0x10 || sig.Type > 0x13 {
+		return ErrBadSignature
+	}
+	return k.verifyHash(k.userIDHash(sig.newHash(), userid), sig)
+}
+
+// VerifyPhoto verifies that sig is a valid self-signature by this key
+// over the given photo ID.
+func (k *SignKey) VerifyPhoto(photo *PhotoID, sig *Signature) error {
+	if sig.Type < 0x10 || sig.Type > 0x13 {
+		return ErrBadSignature
+	}
+	return k.verifyHash(k.photoHash(sig.newHash(), photo), sig)
+}
+
+// VerifyKey verifies a signature made directly over this key, i.e. a
+// direct-key signature (0x1f) or key revocation (0x20).
+func (k *SignKey) VerifyKey(sig *Signature) error {
+	if sig.Type != 0x1f && sig.Type != 0x20 {
+		return ErrBadSignature
+	}
+	h := sig.newHash()
+	writeKey(h, k.PubPacket())
+	return k.verifyHash(h, sig)
+}
+
+// VerifyBinding verifies that sig is a valid binding signature by this
+// key over the given public subkey packet, such as from PubPacket. When
+// the binding grants signing, the subkey's embedded cross-certification
+// is verified too, so sub must then be an EdDSA key.
+func (k *SignKey) VerifyBinding(sub []byte, sig *Signature) error {
+	if sig.Type != 0x18 {
+		return ErrBadSignature
+	}
+	h := k.bindingHash(sig.newHash(), sub)
+	if err := k.verifyHash(h, sig); err != nil {
+		return err
+	}
+	if sig.KeyFlags&0x02 == 0 {
+		return nil
+	}
+
+	back := sig.Embedded
+	if back == nil || back.Type != 0x19 {
+		return ErrBadSignature
+	}
+	var subkey SignKey
+	packet, _, err := ParsePacket(sub)
+	if err != nil {
+		return err
+	}
+	if err := subkey.Load(packet, nil); err != nil {
+		return err
+	}
+	return subkey.verifyHash(k.bindingHash(back.newHash(), sub), back)
+}
+
+// Finish a hash over signed data with the signature's trailers, then
+// check the signature against the result.
+func (k *SignKey) verifyHash(h hash.Hash, sig *Signature) error {
+	h.Write(sig.trailer)
+	final := []byte{sig.version, 0xff, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(final[2:], uint32(len(sig.trailer)))
+	h.Write(final)
+
+	sigsum := h.Sum(nil)
+	if !bytes.Equal(sigsum[:2], sig.preview) || sig.algo != k.pkAlgo() {
+		return ErrBadSignature
+	}
+	var ok bool
+	switch k.algo {
+	case RSA2048, RSA4096:
+		// Leading zeros were dropped from the MPI
+		size := (k.rsa.N.BitLen() + 7) / 8
+		if len(sig.sig) > size {
+			return ErrBadSignature
+		}
+		padded := append(make([]byte, size-len(sig.sig)), sig.sig...)
+		pub := &k.rsa.PublicKey
+		ok = rsa.VerifyPKCS1v15(pub, sig.hash, sigsum, padded) == nil
+	case Curve448:
+		ok = ed448Verify(k.Pubkey(), sigsum, sig.sig)
+	case P256, P384:
+		ok = nistVerify(k.algo.nist(), k.Pubkey(), sigsum, sig.sig)
+	default:
+		ok = ed25519.Verify(ed25519.PublicKey(k.Pubkey()), sigsum, sig.sig)
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
+
 func fingerprint(keyid []byte) subpacket {
-	// Issuer Fingerprint subpacket (length=22, type=33)
-	return subpacket{Type: 33, Data: append([]byte{0x04}, keyid...)}
+	// Issuer Fingerprint subpacket (type=33)
+	version := byte(0x04)
+	if len(keyid) == 32 {
+		version = 0x06
+	}
+	return subpacket{Type: 33, Data: append([]byte{version}, keyid...)}
+}
+
+// Returns the fingerprint of a public key packet.
+func keyFingerprint(packet []byte) []byte {
+	p, _, _ := ParsePacket(packet)
+	h := sha1.New()
+	if p.Body[0] == 0x06 {
+		h = sha256.New()
+	}
+	writeKeyBody(h, p.Body)
+	return h.Sum(nil)
+}
+
+// Writes a public key packet into h as it appears in fingerprints and
+// signatures over keys.
+func writeKey(h hash.Hash, packet []byte) {
+	p, _, _ := ParsePacket(packet)
+	writeKeyBody(h, p.Body)
+}
+
+// Like writeKey, but given just the packet body.
+func writeKeyBody(h hash.Hash, body []byte) {
+	if body[0] == 0x06 {
+		prefix := []byte{0x9b, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(body)))
+		h.Write(prefix)
+	} else {
+		prefix := []byte{0x99, 0, 0}
+		binary.BigEndian.PutUint16(prefix[1:], uint16(len(body)))
+		h.Write(prefix)
+	}
+	h.Write(body)
+}
+
+// A saltedHash is a hash that began with a version 6 signature salt,
+// which must then be included in the signature.
+type saltedHash struct {
+	hash.Hash
+	salt []byte
+}
+
+// Returns a new hash for a signature made by this key. Version 6
+// signatures begin with a random salt.
+func (k *SignKey) newHash() hash.Hash {
+	h := k.Hash().New()
+	if k.version != 6 {
+		return h
+	}
+	salt := make([]byte, saltSizes[k.Hash()])
+	if _, err := rand.Read(salt); err != nil {
+		panic(err) // should never happen
+	}
+	h.Write(salt)
+	return saltedHash{h, salt}
 }
 
 type sigInput struct {
@@ -367,13 +1463,22 @@ type sigInput struct {
 
 func (k *SignKey) sign(in sigInput) []byte {
 	var subpackets []subpacket
+	v6 := k.version == 6
 
-	packet := make([]byte, 8, 257)
+	// Version 6 signatures have four-octet subpacket area lengths
+	start := 8
+	if v6 {
+		start = 10
+	}
+	packet := make([]byte, start, 257)
 	packet[0] = 0xc0 | 2   // packet header, new format, Signature Packet (2)
 	packet[2] = 0x04       // packet version, new (4)
 	packet[3] = in.sigtype // signature type
-	packet[4] = 22         // public-key algorithm, EdDSA
-	packet[5] = 8          // hash algorithm, SHA-256
+	packet[4] = k.pkAlgo() // public-key algorithm
+	packet[5] = hashID(k.Hash())
+	if v6 {
+		packet[2] = 0x06 // packet version (6)
+	}
 
 	// Signature Creation Time subpacket (type=2)
 	sigCreated := subpacket{
@@ -382,47 +1487,95 @@ func (k *SignKey) sign(in sigInput) []byte {
 	}
 	subpackets = append(subpackets, sigCreated)
 
-	// Issuer subpacket (type=16)
-	issuer := subpacket{
-		Type: 16,
-		Data: k.KeyID()[12:20],
+	if !v6 {
+		// Issuer subpacket (type=16)
+		// Version 6 signatures identify the issuer only by fingerprint.
+		issuer := subpacket{
+			Type: 16,
+			Data: k.KeyID()[12:20],
+		}
+		subpackets = append(subpackets, issuer)
 	}
-	subpackets = append(subpackets, issuer)
+
+	// Issuer Fingerprint subpacket (type=33)
+	// The Issuer subpacket is technically optional, and redundant in
+	// self-signatures, but GnuPG will not import a key without it.
+	// Newer implementations prefer the full fingerprint, which isn't
+	// subject to Key ID collisions.
+	subpackets = append(subpackets, fingerprint(k.KeyID()))
 
 	subpackets = append(subpackets, in.subpackets...)
 	for _, subpacket := range subpackets {
-		packet = append(packet, byte(len(subpacket.Data)+1))
+		packet = append(packet, subpacketLen(len(subpacket.Data)+1)...)
 		packet = append(packet, subpacket.Type)
 		packet = append(packet, subpacket.Data...)
 	}
 
-	// Hashed subpacket data length
-	hashedLen := uint16(len(packet) - 8)
-	binary.BigEndian.PutUint16(packet[6:8], hashedLen)
-
-	// Unhashed subpacket data (none)
-	packet = packet[:len(packet)+2]
-	binary.BigEndian.PutUint16(packet[len(packet)-2:], 0)
+	// Hashed subpacket data length, then unhashed subpacket data (none)
+	hashedLen := len(packet) - start
+	hashedEnd := len(packet)
+	if v6 {
+		binary.BigEndian.PutUint32(packet[6:10], uint32(hashedLen))
+		packet = append(packet, 0, 0, 0, 0)
+	} else {
+		binary.BigEndian.PutUint16(packet[6:8], uint16(hashedLen))
+		packet = append(packet, 0, 0)
+	}
 
 	// Write hash trailers
 	h := in.h
-	h.Write(packet[2 : hashedLen+8])                       // trailer
-	h.Write([]byte{4, 0xff, 0, 0, 0, byte(hashedLen + 6)}) // final trailer
+	h.Write(packet[2:hashedEnd])     // trailer
+	h.Write([]byte{packet[2], 0xff}) // final trailer
+	h.Write(marshal32be(uint32(hashedEnd - 2)))
 
 	// Compute hash and sign
 	sigsum := h.Sum(nil)
-	sig := ed25519.Sign(k.Key, sigsum)
+	var sig []byte
+	switch k.algo {
+	case RSA2048, RSA4096:
+		sig, _ = rsa.SignPKCS1v15(nil, k.rsa, k.Hash(), sigsum)
+	case Curve448:
+		sig = ed448Sign(k.Seckey(), k.Pubkey(), sigsum)
+	case P256, P384:
+		sig = nistSign(k.algo.nist(), k.Hash(), k.Seckey(), sigsum)
+	default:
+		sig = ed25519.Sign(k.Key, sigsum)
+	}
 
 	// hash preview
 	packet = append(packet, sigsum[:2]...)
 
-	// signature
-	r := sig[:32]
-	packet = append(packet, mpi(r)...)
-	m := sig[32:]
-	packet = append(packet, mpi(m)...)
+	if v6 {
+		// salt and native signature
+		salt := h.(saltedHash).salt
+		packet = append(packet, byte(len(salt)))
+		packet = append(packet, salt...)
+		packet = append(packet, sig...)
+	} else if k.rsa != nil {
+		// signature, m^d mod n
+		packet = append(packet, mpi(sig)...)
+	} else {
+		// signature, R and S as native octet strings, or ECDSA's r and s
+		r := sig[:len(sig)/2]
+		packet = append(packet, mpi(r)...)
+		m := sig[len(sig)/2:]
+		packet = append(packet, mpi(m)...)
+	}
 
 	// Finalize
-	packet[1] = byte(len(packet)) - 2 // packet length
-	return packet
+	p := Packet{Tag: 2, Body: packet[2:]}
+	return p.Encode()
+}
+
+// Encode a subpacket length, which only occasionally exceeds one octet.
+func subpacketLen(n int) []byte {
+	switch {
+	case n < 192:
+		return []byte{byte(n)}
+	case n < 8384:
+		n -= 192
+		return []byte{byte(n>>8) + 192, byte(n)}
+	default:
+		return append([]byte{255}, marshal32be(uint32(n))...)
+	}
 }
@@ -7,11 +7,8 @@ type UserID struct {
 
 // Packet returns an OpenPGP packet encoding this identity.
 func (u *UserID) Packet() []byte {
-	packet := make([]byte, len(u.ID)+2)
-	packet[0] = 0xc0 | 13       // packet header, User ID Packet (13)
-	packet[1] = byte(len(u.ID)) // packet length
-	copy(packet[2:], u.ID)
-	return packet
+	p := Packet{Tag: 13, Body: u.ID} // User ID Packet (13)
+	return p.Encode()
 }
 
 // Load from packet.
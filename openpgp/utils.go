@@ -1,13 +1,26 @@
 // Package openpgp is a high-level API for creating keys and signatures
 // within a very narrow part of the OpenPGP standard. Only a small set
-// of cryptographic primitives is supported, such as only Curve25519 and
-// not RSA. It's primarily for producing OpenPGP output, not consuming
-// arbitrary OpenPGP input.
+// of cryptographic primitives is supported: Curve25519 by default, and
+// optionally Curve448, NIST P-256 and P-384, or RSA. It's primarily for
+// producing OpenPGP output, not consuming arbitrary OpenPGP input.
+//
+// Keys are deterministic: KeyFromSeed and EncryptKeyFromSeed always
+// derive the same keys from the same seed and creation date. A minimal
+// public key is a key packet followed by a self-signed user ID:
+//
+//	key := openpgp.KeyFromSeed(seed, 0)
+//	userid := openpgp.UserID{ID: []byte("Real Name <name@example.com>")}
+//	pub := append(key.PubPacket(), userid.Packet()...)
+//	pub = append(pub, key.SelfSign(&userid, key.Created(), 0)...)
+//	armored := openpgp.Armor(pub)
 package openpgp
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math/bits"
 )
@@ -30,6 +43,13 @@ func mpi(data []byte) []byte {
 	return append(mpi, data...)
 }
 
+// Overwrite a buffer holding secret key material with zeros.
+func wipe(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
 // Returns the decoded MPI integer and the remaining buffer.
 func mpiDecode(buf []byte, desired int) (i, remain []byte) {
 	bits := int(binary.BigEndian.Uint16(buf))
@@ -62,42 +82,77 @@ type Packet struct {
 	Body   []byte
 }
 
-// ParsePacket returns the header of next packet in the buffer and the
-// bytes following the packet.
+// Largest packet body ParsePacket accepts, far larger than any key or
+// signature, so that hostile lengths cannot force huge allocations.
+const maxPacketSize = 1 << 30
+
+// Returns an error wrapping ErrInvalidPacket with a reason.
+func packetError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPacket, reason)
+}
+
+// Reports whether packets with this tag may use partial body lengths:
+// only compressed, encrypted, and literal data packets.
+func partialAllowed(tag byte) bool {
+	switch tag {
+	case 8, 9, 11, 18, 20:
+		return true
+	}
+	return false
+}
+
+// ParsePacket returns the next packet in the buffer and the bytes
+// following the packet. Both new and old format headers are accepted
+// with every length encoding. Truncated, oversized, or otherwise
+// malformed packets return an error wrapping ErrInvalidPacket.
 func ParsePacket(buf []byte) (Packet, []byte, error) {
 	var p Packet
 
-	if len(buf) < 2 || buf[0]&0x80 == 0 {
-		return p, nil, ErrInvalidPacket
+	if len(buf) < 2 {
+		return p, nil, packetError("truncated packet header")
+	}
+	if buf[0]&0x80 == 0 {
+		return p, nil, packetError("not a packet header")
 	}
 
-	var bodyLen int
+	var bodyLen uint64
 	if buf[0]&0x40 != 0 {
 		// New format
 		p.Tag = buf[0] & 0x3f
+		if p.Tag == 0 {
+			return p, nil, packetError("reserved packet tag 0")
+		}
 
-		n0 := int(buf[1])
-		if n0 < 192 {
+		n0 := buf[1]
+		switch {
+		case n0 < 192:
 			p.HdrLen = 2
-			bodyLen = n0
-		} else if n0 == 0xff {
-			p.HdrLen = 6
+			bodyLen = uint64(n0)
+		case n0 < 224:
+			p.HdrLen = 3
 			if len(buf) < p.HdrLen {
-				return p, nil, ErrInvalidPacket
+				return p, nil, packetError("truncated packet header")
 			}
-			bodyLen = int(binary.BigEndian.Uint32(buf[2:]))
-		} else {
-			p.HdrLen = 3
+			bodyLen = uint64(n0-192)<<8 + uint64(buf[2]) + 192
+		case n0 == 0xff:
+			p.HdrLen = 6
 			if len(buf) < p.HdrLen {
-				return p, nil, ErrInvalidPacket
+				return p, nil, packetError("truncated packet header")
 			}
-			n1 := int(buf[2])
-			bodyLen = ((n0 - 192) << 8) + n1 + 192
+			bodyLen = uint64(binary.BigEndian.Uint32(buf[2:]))
+		default:
+			if !partialAllowed(p.Tag) {
+				return p, nil, packetError("partial length on non-data packet")
+			}
+			return parsePartial(p, buf)
 		}
 
 	} else {
 		// Old format
 		p.Tag = (buf[0] >> 2) & 0x0f
+		if p.Tag == 0 {
+			return p, nil, packetError("reserved packet tag 0")
+		}
 
 		switch buf[0] & 0x03 {
 		case 0:
@@ -107,27 +162,85 @@ func ParsePacket(buf []byte) (Packet, []byte, error) {
 		case 2:
 			p.HdrLen = 5
 		case 3:
-			return p, nil, ErrInvalidPacket // don't bother
+			// Indeterminate length, extending to the end of the input
+			if len(buf)-1 > maxPacketSize {
+				return p, nil, packetError("packet too large")
+			}
+			p.HdrLen = 1
+			p.Body = buf[1:]
+			return p, nil, nil
 		}
 
 		if len(buf) < p.HdrLen {
-			return p, nil, ErrInvalidPacket
+			return p, nil, packetError("truncated packet header")
 		}
 		switch p.HdrLen {
 		case 2:
-			bodyLen = int(buf[1])
+			bodyLen = uint64(buf[1])
 		case 3:
-			bodyLen = int(binary.BigEndian.Uint16(buf[1:]))
+			bodyLen = uint64(binary.BigEndian.Uint16(buf[1:]))
 		case 5:
-			bodyLen = int(binary.BigEndian.Uint32(buf[1:]))
+			bodyLen = uint64(binary.BigEndian.Uint32(buf[1:]))
 		}
 	}
 
-	if len(buf) < p.HdrLen+bodyLen {
-		return p, nil, ErrInvalidPacket
+	if bodyLen > maxPacketSize {
+		return p, nil, packetError("packet too large")
+	}
+	if uint64(len(buf)-p.HdrLen) < bodyLen {
+		return p, nil, packetError("truncated packet body")
+	}
+	end := p.HdrLen + int(bodyLen)
+	p.Body = buf[p.HdrLen:end]
+	return p, buf[end:], nil
+}
+
+// Parse a new format packet with partial body lengths, as produced by
+// streaming implementations like GnuPG. The chunks are joined into a
+// freshly-allocated body, so HdrLen covers only the first header.
+func parsePartial(p Packet, buf []byte) (Packet, []byte, error) {
+	p.HdrLen = 2
+	buf = buf[1:]
+	for {
+		if len(buf) < 1 {
+			return p, nil, packetError("truncated packet header")
+		}
+		n0 := int(buf[0])
+		var hdrLen int
+		var bodyLen uint64
+		partial := false
+		switch {
+		case n0 < 192:
+			hdrLen, bodyLen = 1, uint64(n0)
+		case n0 < 224:
+			if len(buf) < 2 {
+				return p, nil, packetError("truncated packet header")
+			}
+			hdrLen = 2
+			bodyLen = uint64(n0-192)<<8 + uint64(buf[1]) + 192
+		case n0 < 0xff:
+			hdrLen, bodyLen = 1, 1<<uint(n0&0x1f)
+			partial = true
+		default:
+			if len(buf) < 5 {
+				return p, nil, packetError("truncated packet header")
+			}
+			hdrLen = 5
+			bodyLen = uint64(binary.BigEndian.Uint32(buf[1:]))
+		}
+		if uint64(len(p.Body))+bodyLen > maxPacketSize {
+			return p, nil, packetError("packet too large")
+		}
+		if uint64(len(buf)-hdrLen) < bodyLen {
+			return p, nil, packetError("truncated packet body")
+		}
+		end := hdrLen + int(bodyLen)
+		p.Body = append(p.Body, buf[hdrLen:end]...)
+		buf = buf[end:]
+		if !partial {
+			return p, buf, nil
+		}
 	}
-	p.Body = buf[p.HdrLen : p.HdrLen+bodyLen]
-	return p, buf[p.HdrLen+bodyLen:], nil
 }
 
 // Encode returns an encoded version of this packet.
@@ -153,24 +266,45 @@ func (p *Packet) Encode() []byte {
 	}
 }
 
-// Returns the entire next packet from the input. Packets are always at
-// least two bytes long.
-func readPacket(r io.Reader) ([]byte, error) {
-	var buf [257]byte
-	if _, err := r.Read(buf[:2]); err != nil {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
+// partialWriter streams a packet body to w using partial body lengths,
+// so the body length needn't be known in advance. Close writes the
+// final chunk, ending the packet.
+type partialWriter struct {
+	w       io.Writer
+	tag     byte
+	buf     []byte
+	started bool
+}
+
+const partialChunk = 1 << 16
+
+func (p *partialWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for len(p.buf) > partialChunk {
+		hdr := []byte{0xc0 | p.tag, 224 + 16}
+		if p.started {
+			hdr = hdr[1:]
 		}
-		return nil, err
-	}
-	packet := buf[:2+buf[1]]
-	if _, err := r.Read(packet[2:]); err != nil {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
+		p.started = true
+		if _, err := p.w.Write(hdr); err != nil {
+			return 0, err
+		}
+		if _, err := p.w.Write(p.buf[:partialChunk]); err != nil {
+			return 0, err
 		}
-		return nil, err
+		p.buf = append(p.buf[:0], p.buf[partialChunk:]...)
+	}
+	return len(data), nil
+}
+
+func (p *partialWriter) Close() error {
+	last := Packet{Tag: p.tag, Body: p.buf}
+	buf := last.Encode()
+	if p.started {
+		buf = buf[1:] // only a length for the final chunk
 	}
-	return packet, nil
+	_, err := p.w.Write(buf)
+	return err
 }
 
 // Return a 4-byte buffer encoding a uint32.
@@ -179,3 +313,48 @@ func marshal32be(v uint32) []byte {
 	binary.BigEndian.PutUint32(data, v)
 	return data
 }
+
+// Returns the line stripped of its line ending and trailing whitespace.
+func canonicalLine(line []byte) []byte {
+	for len(line) > 0 {
+		switch line[len(line)-1] {
+		case 0x0a, 0x0d, 0x20, 0x09:
+			line = line[:len(line)-1]
+		default:
+			return line
+		}
+	}
+	return line
+}
+
+// Copy text from src to dst in the canonical form used for text
+// signatures (RFC 4880, 5.2.4): line endings are converted to CRLF,
+// including mixed LF and CRLF input. Unlike the cleartext signature
+// framework, trailing whitespace is significant and left intact. An
+// unterminated final line remains unterminated.
+func canonicalizeText(dst io.Writer, src io.Reader) error {
+	crlf := []byte("\r\n")
+	r := bufio.NewReader(src)
+	for {
+		line, err := r.ReadBytes(0x0a)
+		if len(line) > 0 {
+			eol := line[len(line)-1] == 0x0a
+			if eol {
+				line = bytes.TrimSuffix(line[:len(line)-1], []byte{0x0d})
+			}
+			if _, err := dst.Write(line); err != nil {
+				return err
+			}
+			if eol {
+				if _, err := dst.Write(crlf); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
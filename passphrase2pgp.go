@@ -5,60 +5,201 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto"
 	"crypto/ed25519"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
 	stdpem "encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"math/big"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/text/unicode/norm"
 	"nullprogram.com/x/optparse"
 	"nullprogram.com/x/passphrase2pgp/openpgp"
 )
 
 const (
-	kdfTime   = 8
-	kdfMemory = 1024 * 1024 // 1 GB
-	sshRounds = 64          // bcrypt_pbkdf rounds
+	kdfTime    = 8
+	kdfMemory  = 1024 * 1024 // 1 GB
+	kdfVersion = 1
+	kdfIters   = 210000 // PBKDF2-SHA512 iterations
+	sshRounds  = 64     // bcrypt_pbkdf rounds
+
+	signifyRounds    = 42      // bcrypt_pbkdf rounds
+	minisignOpslimit = 1 << 25 // scrypt, as libsodium's "sensitive"
+	minisignMemlimit = 1 << 30
 
 	defaultExpires = "2y"
 
 	cmdKey = iota
 	cmdSign
 	cmdClearsign
+	cmdVerify
+	cmdEncrypt
+	cmdDecrypt
+	cmdRevoke
+	cmdCertify
+	cmdBench
+	cmdAgent
+	cmdTransition
+	cmdRelease
 
 	formatPGP = iota
 	formatSSH
 	formatX509
+	formatMinisign
+	formatSignify
+	formatAge
+	formatPEM
+	formatJWK
 )
 
 var version = "1.2.0"
 
-// Print the message like fmt.Printf() and then os.Exit(1).
+// Exit statuses for each class of failure, so that scripts can tell,
+// say, a wrong passphrase from a full disk. Anything else is 1.
+const (
+	exitFailure    = 1
+	exitUsage      = 2 // invalid command line or configuration
+	exitPrompt     = 3 // would have prompted (--batch, --quiet)
+	exitPassphrase = 4 // wrong or mismatched passphrase
+	exitKDF        = 5 // key derivation failed
+	exitIO         = 6 // reading or writing a file failed
+	exitVerify     = 7 // bad signature, or no key to check it
+	exitCheck      = 8 // Key ID does not match --check
+)
+
+// Returns the exit status for the class of an error.
+func errorStatus(err error) int {
+	var pathErr *os.PathError
+	var sysErr *os.SyscallError
+	switch {
+	case errors.Is(err, errNoTerminal):
+		return exitPrompt
+	case errors.Is(err, errMismatch), errors.Is(err, openpgp.ErrDecryptKey):
+		return exitPassphrase
+	case errors.Is(err, openpgp.ErrBadSignature):
+		return exitVerify
+	case errors.As(err, &pathErr), errors.As(err, &sysErr):
+		return exitIO
+	}
+	return exitFailure
+}
+
+// Print the message like fmt.Printf() and then exit. The status is
+// chosen by the first error among the arguments, if any.
 func fatal(format string, args ...interface{}) {
+	code := exitFailure
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			code = errorStatus(err)
+			break
+		}
+	}
+	fatalCode(code, format, args...)
+}
+
+// Like fatal(), but for an invalid command line or configuration.
+func fatalUsage(format string, args ...interface{}) {
+	fatalCode(exitUsage, format, args...)
+}
+
+// Like fatal(), but exit with the given status.
+func fatalCode(code int, format string, args ...interface{}) {
 	buf := bytes.NewBufferString("passphrase2pgp: ")
 	fmt.Fprintf(buf, format, args...)
 	buf.WriteRune('\n')
 	os.Stderr.Write(buf.Bytes())
-	os.Exit(1)
+	wipeSecrets()
+	os.Exit(code)
+}
+
+// Fail if --quiet or --batch forbids prompting for what.
+func noPrompt(config *config, what string) {
+	switch {
+	case config.batch:
+		fatalCode(exitPrompt, "--batch cannot prompt for %s", what)
+	case config.quiet:
+		fatalCode(exitPrompt, "--quiet cannot prompt for %s", what)
+	}
+}
+
+// Overwrite a buffer holding sensitive data with zeros.
+func wipe(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
+
+// Functions wiping secrets before exiting, run by wipeSecrets. Fatal
+// errors exit from deep in the call stack, skipping deferred calls, so
+// secrets are wiped through here instead.
+var exitWipes []func()
+
+// Have f wipe secrets before exiting, even on a fatal error.
+func wipeOnExit(f func()) {
+	exitWipes = append(exitWipes, f)
+}
+
+// Lock a secret into memory and wipe it before exiting.
+func keepSecret(buf []byte) {
+	lockMemory(buf)
+	wipeOnExit(func() { wipe(buf) })
+}
+
+// Run the registered wipes, most recent first.
+func wipeSecrets() {
+	for i := len(exitWipes) - 1; i >= 0; i-- {
+		exitWipes[i]()
+	}
+	exitWipes = nil
 }
 
 // Read and confirm the passphrase per the user's preference.
 func readPassphrase(pinentry, hint string, repeat int) ([]byte, error) {
+	var passphrase []byte
+	var err error
 	if pinentry != "" {
-		return pinentryPassphrase(pinentry, hint, repeat)
+		passphrase, err = pinentryPassphrase(pinentry, hint, repeat)
+	} else {
+		passphrase, err = terminalPassphrase(hint, repeat)
+	}
+	keepSecret(passphrase)
+	return passphrase, err
+}
+
+// Returns a function asking the user to touch a FIDO2 device.
+func touchPrompt(config *config) func(device string) {
+	return func(device string) {
+		if !config.quiet {
+			fmt.Fprintf(os.Stderr, "Touch the security key (%s)\n", device)
+		}
 	}
-	return terminalPassphrase(hint, repeat)
 }
 
 // Returns the first line of a file not including \r or \n. Does not
@@ -69,7 +210,12 @@ func firstLine(filename string) ([]byte, error) {
 		return nil, err
 	}
 	defer f.Close()
-	s := bufio.NewScanner(f)
+	return readLine(f)
+}
+
+// Returns the first line read from r, like firstLine.
+func readLine(r io.Reader) ([]byte, error) {
+	s := bufio.NewScanner(r)
 	if !s.Scan() {
 		if err := s.Err(); err != io.EOF {
 			return nil, err
@@ -79,6 +225,371 @@ func firstLine(filename string) ([]byte, error) {
 	return s.Bytes(), nil
 }
 
+// Read an entire file, or standard input for "-".
+func readAll(filename string) ([]byte, error) {
+	if filename == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// Parse a full or partial Key ID (fingerprint) as printed by GnuPG,
+// optionally with spaces or a "0x" prefix. Version 6 fingerprints are
+// up to 32 bytes.
+func parseCheck(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+	}
+	check, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(check) > 32 {
+		return nil, errors.New("longer than a Key ID")
+	}
+	return check, nil
+}
+
+// Parse a creation date given either as Unix epoch seconds or in
+// RFC 3339 format, e.g. "2024-01-15T00:00:00Z". OpenPGP dates are
+// unsigned 32-bit integers, which limits the range.
+func parseTime(s string) (int64, error) {
+	if t, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return int64(t), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid date: %s", s)
+	}
+	if t.Unix() < 0 || t.Unix() > math.MaxUint32 {
+		return 0, fmt.Errorf("date out of range: %s", s)
+	}
+	return t.Unix(), nil
+}
+
+// Returns the current time, unless $SOURCE_DATE_EPOCH overrides it for
+// a reproducible build. See https://reproducible-builds.org/specs/.
+func currentTime() int64 {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Now().Unix()
+	}
+	t, err := strconv.ParseUint(epoch, 10, 32)
+	if err != nil {
+		fatalUsage("$SOURCE_DATE_EPOCH: %s", err)
+	}
+	return int64(t)
+}
+
+// Parse a positive duration, either as a Go duration such as "1m30s",
+// or as a plain (possibly fractional) number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, serr := strconv.ParseFloat(s, 64)
+		if serr != nil || secs > math.MaxInt32 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
+
+// Parse a NAME=VALUE signature notation. Like GnuPG, the name must be
+// in the user namespace, "name@domain", since the rest is reserved.
+func parseNotation(s string) (openpgp.Notation, error) {
+	var note openpgp.Notation
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return note, errors.New("must be NAME=VALUE")
+	}
+	note.Name, note.Value = s[:i], s[i+1:]
+	if strings.Count(note.Name, "@") != 1 ||
+		strings.HasPrefix(note.Name, "@") ||
+		strings.HasSuffix(note.Name, "@") {
+		return note, fmt.Errorf("name %q must be name@domain", note.Name)
+	}
+	if len(s) > 4096 {
+		return note, errors.New("too long")
+	}
+	return note, nil
+}
+
+// Check that a URI to be embedded in signatures is absolute, since
+// there's nothing for it to be relative to.
+func checkURI(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Host == "" && u.Opaque == "" {
+		return fmt.Errorf("%q is not an absolute URI", s)
+	}
+	return nil
+}
+
+// Build a trust signature regular expression matching email addresses
+// in the domain or its subdomains, exactly as GnuPG's tsign command
+// does. GnuPG only honors expressions of this form, taking the domain
+// literally, so there can be no alternation over several domains.
+func trustRegexp(domain string) (string, error) {
+	const ldh = "abcdefghijklmnopqrstuvwxyz0123456789-"
+	labels := strings.Split(strings.ToLower(domain), ".")
+	for _, label := range labels {
+		if label == "" || strings.Trim(label, ldh) != "" {
+			return "", fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return "<[^>]+[@.]" + strings.Join(labels, `\.`) + ">$", nil
+}
+
+// Public-key algorithm names accepted by --revoker.
+var revokerAlgos = map[string]byte{
+	"rsa":   1,
+	"dsa":   17,
+	"ecdh":  18,
+	"ecdsa": 19,
+	"eddsa": 22,
+}
+
+// Parse an [ALGO:]FINGERPRINT designated revoker, where the fingerprint
+// is a version 4 fingerprint as printed by GnuPG, spaces allowed. The
+// algorithm defaults to EdDSA.
+func parseRevoker(s string) (openpgp.Revoker, error) {
+	r := openpgp.Revoker{Algorithm: 22}
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		algo, ok := revokerAlgos[strings.ToLower(s[:i])]
+		if !ok {
+			return r, fmt.Errorf("unknown algorithm %q", s[:i])
+		}
+		r.Algorithm, s = algo, s[i+1:]
+	}
+	fpr, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil || len(fpr) != 20 {
+		return r, errors.New("fingerprint must be 40 hexadecimal digits")
+	}
+	r.Fingerprint = fpr
+	return r, nil
+}
+
+// Key flag names accepted by --primary-flags and --subkey-flags.
+var keyFlagNames = map[string]byte{
+	"certify":         openpgp.KeyFlagCertify,
+	"sign":            openpgp.KeyFlagSign,
+	"encrypt":         openpgp.KeyFlagEncryptComms | openpgp.KeyFlagEncryptStorage,
+	"encrypt-comms":   openpgp.KeyFlagEncryptComms,
+	"encrypt-storage": openpgp.KeyFlagEncryptStorage,
+	"auth":            openpgp.KeyFlagAuth,
+}
+
+// Parse a list of key flag names such as "certify,sign" into a key
+// flags byte. Names may be separated by commas or spaces.
+func parseKeyFlags(s string) (byte, error) {
+	var flags byte
+	for _, name := range strings.Fields(strings.Replace(s, ",", " ", -1)) {
+		flag, ok := keyFlagNames[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown key flag %q", name)
+		}
+		flags |= flag
+	}
+	if flags == 0 {
+		return 0, errors.New("no key flags given")
+	}
+	return flags, nil
+}
+
+// Algorithm names accepted by --prefs, in the style of GnuPG's
+// preference lists.
+var prefNames = map[string][2]byte{
+	"3DES":         {11, 2},
+	"CAST5":        {11, 3},
+	"BLOWFISH":     {11, 4},
+	"AES":          {11, 7},
+	"AES128":       {11, 7},
+	"AES192":       {11, 8},
+	"AES256":       {11, 9},
+	"TWOFISH":      {11, 10},
+	"CAMELLIA128":  {11, 11},
+	"CAMELLIA192":  {11, 12},
+	"CAMELLIA256":  {11, 13},
+	"SHA1":         {21, 2},
+	"RIPEMD160":    {21, 3},
+	"SHA256":       {21, 8},
+	"SHA384":       {21, 9},
+	"SHA512":       {21, 10},
+	"SHA224":       {21, 11},
+	"UNCOMPRESSED": {22, 0},
+	"ZIP":          {22, 1},
+	"ZLIB":         {22, 2},
+	"BZIP2":        {22, 3},
+}
+
+// Exit if an option requires an Ed25519 key, but the key uses another
+// algorithm.
+func checkAlgorithm(config *config, algo openpgp.Algorithm) {
+	name := algoNames[algo]
+	v4only := algo == openpgp.P256 || algo == openpgp.P384 ||
+		algo == openpgp.RSA2048 || algo == openpgp.RSA4096
+	switch {
+	case algo == openpgp.Curve25519:
+		// Supported everywhere
+	case config.format != formatPGP:
+		fatalUsage("%s keys require pgp format", name)
+	case config.toCard:
+		fatalUsage("%s keys cannot be written to a card (--to-card)", name)
+	case config.agent:
+		fatalUsage("%s keys cannot be added to the SSH agent (--add-to-agent)", name)
+	case config.cmd == cmdAgent:
+		fatalUsage("%s keys cannot be served as an SSH agent", name)
+	case config.agentDir != "":
+		fatalUsage("%s keys cannot be exported to gpg-agent "+
+			"(--export-agent-keys)", name)
+	case v4only && config.v6:
+		fatalUsage("%s keys cannot be version 6 keys (--v6)", name)
+	case algo == openpgp.Curve448 && config.hash != 0 && config.hash != crypto.SHA512:
+		fatalUsage("Ed448 signatures require --hash sha512")
+	case algo == openpgp.P384 && config.hash == crypto.SHA256:
+		fatalUsage("P-384 signatures require --hash sha384 or sha512")
+	}
+}
+
+// Algorithm names accepted by --algo.
+var algoOptions = map[string]openpgp.Algorithm{
+	"ed25519": openpgp.Curve25519,
+	"ed448":   openpgp.Curve448,
+	"p256":    openpgp.P256,
+	"p384":    openpgp.P384,
+	"rsa2048": openpgp.RSA2048,
+	"rsa4096": openpgp.RSA4096,
+}
+
+// Display names of the algorithms.
+var algoNames = map[openpgp.Algorithm]string{
+	openpgp.Curve25519: "Ed25519",
+	openpgp.Curve448:   "Ed448",
+	openpgp.P256:       "P-256",
+	openpgp.P384:       "P-384",
+	openpgp.RSA2048:    "RSA-2048",
+	openpgp.RSA4096:    "RSA-4096",
+}
+
+// Hash names accepted by --hash.
+var hashOptions = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// AEAD algorithm names accepted by --prefs in ciphersuites.
+var aeadNames = map[string]byte{
+	"EAX": 1,
+	"OCB": 2,
+	"GCM": 3,
+}
+
+// Parse an algorithm preference list such as "AES256 SHA512 ZLIB",
+// most preferred first. Like GnuPG, raw IDs may be given as S9, H10,
+// Z0, etc. AEAD ciphersuites pair a symmetric algorithm with an AEAD
+// mode, as in "AES256-OCB". Categories not mentioned keep their
+// defaults.
+func parsePrefs(s string) (openpgp.Preferences, error) {
+	prefs := openpgp.DefaultPreferences
+	kinds := map[byte]byte{'S': 11, 'H': 21, 'Z': 22}
+	var sym, hash, comp, suites []byte
+	for _, name := range strings.Fields(strings.Replace(s, ",", " ", -1)) {
+		upper := strings.ToUpper(name)
+		if i := strings.IndexByte(upper, '-'); i >= 0 {
+			cipher, ok := prefNames[upper[:i]]
+			mode, known := aeadNames[upper[i+1:]]
+			if !ok || cipher[0] != 11 || !known {
+				return prefs, fmt.Errorf("unknown ciphersuite %q", name)
+			}
+			suites = append(suites, cipher[1], mode)
+			continue
+		}
+		pref, ok := prefNames[upper]
+		if !ok && kinds[upper[0]] != 0 {
+			id, err := strconv.ParseUint(upper[1:], 10, 8)
+			pref = [2]byte{kinds[upper[0]], byte(id)}
+			ok = err == nil
+		}
+		if !ok {
+			return prefs, fmt.Errorf("unknown algorithm %q", name)
+		}
+		switch pref[0] {
+		case 11:
+			sym = append(sym, pref[1])
+		case 21:
+			hash = append(hash, pref[1])
+		case 22:
+			comp = append(comp, pref[1])
+		}
+	}
+	if sym != nil {
+		prefs.Symmetric = sym
+	}
+	if hash != nil {
+		prefs.Hash = hash
+	}
+	if comp != nil {
+		prefs.Compression = comp
+	}
+	if suites != nil {
+		prefs.Ciphersuites = suites
+	}
+	return prefs, nil
+}
+
+// Returns true if the Key ID begins or ends with the bytes to check.
+// Traditional short and long Key IDs are suffixes, while a prefix is
+// the leading digits of the fingerprint.
+func checkKeyID(keyid, check []byte) bool {
+	return bytes.HasSuffix(keyid, check) || bytes.HasPrefix(keyid, check)
+}
+
+// Search creation dates, counting up from the key's current creation
+// date, for a Key ID (fingerprint) matching the pattern. Sets the key's
+// creation date and returns it, or exits if no date matches.
+func vanity(key *openpgp.SignKey, pattern *regexp.Regexp) int64 {
+	for created := key.Created(); created <= math.MaxUint32; created++ {
+		key.SetCreated(created)
+		if pattern.MatchString(fmt.Sprintf("%X", key.KeyID())) {
+			return created
+		}
+	}
+	fatal("--vanity: no creation date produces a matching Key ID")
+	return 0
+}
+
+// Returns true if buf contains only ASCII characters.
+func isASCII(buf []byte) bool {
+	for _, b := range buf {
+		if b > 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the SHA-256 digest of a file's contents.
+func hashFile(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
 // Returns true if the beginning of the arguments slice matches the
 // pattern, and it has at least once trailing argument. Trailing
 // arguments are ignored.
@@ -94,33 +605,240 @@ func argsEqual(args, pattern []string) bool {
 	return true
 }
 
-// Derive a 64-byte seed from the given passphrase. The scale factor
-// scales up the difficulty proportional to scale*scale.
-func kdf(passphrase, uid []byte, scale int) []byte {
-	time := uint32(kdfTime * scale)
-	memory := uint32(kdfMemory * scale)
-	threads := uint8(1)
+// Derive an independent 32-byte seed for the given purpose from the
+// primary key's seed.
+func subseed(seed []byte, label string) []byte {
+	return expand(seed, label, 32)
+}
+
+// Returns a copy of a 32-byte seed sized for a key that needs a longer
+// seed (Ed448, X448), stretched with HKDF.
+func expandSeed(seed []byte, size int) []byte {
+	if len(seed) == size {
+		return append([]byte(nil), seed...)
+	}
+	return expand(seed, "expand", size)
+}
+
+// Expand a seed to size bytes with HKDF-SHA256, labeled by purpose.
+func expand(seed []byte, label string, size int) []byte {
+	r := hkdf.New(sha256.New, seed, nil, []byte(label))
+	sub := make([]byte, size)
+	if _, err := io.ReadFull(r, sub); err != nil {
+		panic(err) // should never happen
+	}
+	return sub
+}
+
+// Returns the seed for rotation n (--rotate) of a subkey derived from
+// seed. Rotation zero is the original subkey, the seed itself.
+func rotateSeed(seed []byte, n int) []byte {
+	if n == 0 {
+		return seed
+	}
+	return subseed(seed, fmt.Sprintf("rotate/%d", n))
+}
+
+// Argon2 parallelism (lanes) for each derivation version. Versions fix
+// the parallelism so that the number of threads actually computing the
+// derivation (--threads) never changes the key. Version 1 is the
+// original single-lane derivation. Version 3 also derives each key with
+// HKDF (see keySeeds), and version 4 also normalizes the passphrase and
+// user ID (see normalize).
+var kdfLanes = map[int]uint8{
+	1: 1,
+	2: 4,
+	3: 4,
+	4: 4,
+}
+
+// Key derivation functions selectable with --kdf. Argon2id is the
+// default, and the others are for environments where it's not approved.
+const (
+	kdfArgon2id = "argon2id"
+	kdfScrypt   = "scrypt"
+	kdfPBKDF2   = "pbkdf2-sha512"
+)
+
+// kdfParams are the parameters for deriving a key from a passphrase.
+// Each must be reproduced exactly to derive the same key. The memory
+// cost also sets scrypt's N (r=8), and threads its parallelism (p).
+type kdfParams struct {
+	algorithm  string
+	version    int
+	time       uint32
+	memory     uint32 // in KiB
+	threads    uint8  // Argon2 lanes
+	iterations int    // PBKDF2 only
+	index      int    // version 3 and later
+}
+
+// String returns the command line options that select these parameters.
+func (p kdfParams) String() string {
+	var s string
+	switch p.algorithm {
+	case kdfScrypt:
+		s = fmt.Sprintf("--kdf=%s --kdf-version=%d --kdf-memory=%d "+
+			"--kdf-threads=%d",
+			p.algorithm, p.version, p.memory/1024, p.threads)
+	case kdfPBKDF2:
+		s = fmt.Sprintf("--kdf=%s --kdf-version=%d --kdf-iterations=%d",
+			p.algorithm, p.version, p.iterations)
+	default:
+		s = fmt.Sprintf("--kdf-version=%d --kdf-time=%d --kdf-memory=%d "+
+			"--kdf-threads=%d",
+			p.version, p.time, p.memory/1024, p.threads)
+	}
+	if p.version >= 3 {
+		s += fmt.Sprintf(" --key-index=%d", p.index)
+	}
+	return s
+}
+
+// Returns a copy of text in Unicode normalization form NFKC if the
+// derivation version normalizes, otherwise an exact copy. The same
+// visible text may be typed as different bytes on different systems,
+// e.g. precomposed versus combining accents, which NFKC makes equal.
+func normalize(text []byte, params kdfParams) []byte {
+	if params.version < 4 {
+		return append([]byte{}, text...)
+	}
+	return norm.NFKC.Append(nil, text...)
+}
+
+// Derive a 64-byte seed from the given passphrase.
+func kdf(passphrase, uid []byte, params kdfParams) []byte {
+	switch params.algorithm {
+	case kdfScrypt:
+		// Each unit of N uses 128*r bytes, so N is the memory in KiB
+		n := int(params.memory)
+		p := int(params.threads)
+		seed, err := scrypt.Key(passphrase, uid, n, 8, p, 64)
+		if err != nil {
+			fatalCode(exitKDF, "scrypt: %s", err)
+		}
+		return seed
+	case kdfPBKDF2:
+		iter := params.iterations
+		return pbkdf2.Key(passphrase, uid, iter, 64, sha512.New)
+	}
+	time := params.time
+	memory := params.memory
+	threads := params.threads
 	return argon2.IDKey(passphrase, uid, time, memory, threads, 64)
 }
 
+// Returns the primary key and encryption subkey seeds for a derived
+// (or raw) seed. Before version 3, these are the two halves of the
+// seed, limiting a passphrase to one key. Version 3 expands each from
+// the whole seed with HKDF, labeled by purpose and key index, so one
+// passphrase yields any number of independent keys.
+func keySeeds(seed []byte, params kdfParams) (primary, encrypt []byte) {
+	if params.version < 3 {
+		return seed[:32], seed[32:]
+	}
+	primary = subseed(seed, fmt.Sprintf("sign/%d", params.index))
+	encrypt = subseed(seed, fmt.Sprintf("encrypt/%d", params.index))
+	return primary, encrypt
+}
+
 type config struct {
 	cmd  int
 	args []string
 
-	armor    bool
-	check    []byte
-	protect  bool
-	format   int
-	input    string
-	load     string
-	pinentry string
-	public   bool
-	repeat   int
-	subkey   bool
-	created  int64
-	uid      string
-	verbose  bool
-	expires  int64
+	aead      bool
+	seipdv2   bool
+	agent     bool
+	agentDir  string
+	armor     bool
+	auth      bool
+	batch     bool // never use the terminal
+	cache     bool
+	check     []byte
+	comments  []string
+	confirm   bool
+	derived   bool // seed from passphrase
+	dnsRecord bool
+	emitVer   bool
+	exportMn  bool
+	fido2     string // token file
+	fido2Reg  string
+	force     bool   // accept a weak passphrase
+	fprOnly   bool   // --fingerprint
+	fprFormat string // hex, spaced, long, short, or colons
+	protect   bool
+	format    int
+	inline    bool
+	input     *os.File
+	keyfile   []byte // digest
+	keyring   string
+	allowUIDs []string // --allow-uid patterns
+	load      string
+	output    string
+	out       *os.File
+	pubOut    string
+	nspace    string
+	keyServer string
+	policyURI string
+	notes     []openpgp.Notation
+	pinentry  string
+	pgpMIME   bool
+	photo     []byte // JPEG
+	prefs     *openpgp.Preferences
+	hash      crypto.Hash // signature hash, zero for the default
+	preset    string      // apt or rpm repository signing
+	primFlags byte        // key flags, zero for default
+	subFlags  byte
+	public    bool
+	qr        bool
+	qrFpr     bool
+	quiet     bool
+	repeat    int
+	revokers  []openpgp.Revoker
+	rotate    int // subkey rotation
+	seed      []byte
+	sendKey   string
+	shares    []share
+	mnemonic  bool // seed from --from-mnemonic
+	signSub   bool
+	signerUID string
+	noSigner  bool // --no-signer-uid
+	subkey    bool
+	symmetric bool
+	text      bool
+	toCard    bool
+	stamp     bool
+	stampHash bool
+	strip     bool // --strip-primary
+	created   int64
+	uids      []string
+	v6        bool
+	algo      openpgp.Algorithm
+	vanity    *regexp.Regexp
+	wkdDir    string
+	verbose   bool
+	expires   int64
+
+	sigExpires int64
+	sigTime    int64
+	cacheTTL   time.Duration
+	statusFd   int
+	status     *os.File // from statusFd
+	jsonFd     int
+	outBytes   int // key output written, for --json
+	kdf        kdfParams
+	minEntropy int // bits, zero for the default warning
+	certLevel  int
+	trust      openpgp.Trust
+	trustDom   string
+	benchTime  time.Duration
+	threads    int
+	agentLife  uint32
+	splitK     int
+	splitN     int
+
+	revokeReason  byte
+	revokeComment string
 
 	passphrase      []byte
 	protectPassword []byte
@@ -137,105 +855,321 @@ func usage(w io.Writer) {
 	}
 	f("Usage:")
 	f(i, p, "<-u id|-l key> [-hv] [-c id] [-i pwfile] [--pinentry[=cmd]]")
-	f(b, "-K [-anps] [-e[n]] [-f pgp|ssh|x509] [-r n] [-t secs] [-x[spec]]")
-	f(b, "-S [-a] [-r n] [files...]")
-	f(b, "-T [-r n] >doc-signed.txt <doc.txt")
-	f("Commands:")
-	f(i, "-K, --key                 output a key (default)")
-	f(i, "-S, --sign                output detached signatures")
-	f(i, "-T, --clearsign           output a cleartext signature")
+	f(b, "keygen [-anps] [-e[n]] [-f format] [-r n] [-t date] [-x[spec]]")
+	f(b, "sign [-a] [-f format] [--namespace ns] [--text] [-r n] [files...]")
+	f(b, "sign --timestamp[=digest] [-a] [files...]")
+	f(b, "sign --inline [-a] [--text] [files...]")
+	f(b, "clearsign [-r n] >doc-signed.txt <doc.txt")
+	f(b, "verify [--keyring FILE] [--allow-uid PATTERN] sigfile [file]")
+	f(b, "encrypt [-a] [--symmetric] >message.pgp <message.txt")
+	f(b, "decrypt [--symmetric] >message.txt <message.pgp")
+	f(b, "revoke [--revoke=reason] [--revoke-comment text] >revoke.asc")
+	f(b, "certify [-a] [--cert-level n] their-key.asc >signed.asc")
+	f(b, "bench [--bench=target] [--kdf-memory MiB] [--kdf-version n]")
+	f(b, "agent [socket]")
+	f(b, "transition -l old-key.asc -u id [statement.txt] >transition.asc")
+	f(b, "release [-o SHA256SUMS] files...")
+	f("Commands (first argument, or the equivalent option):")
+	f(i, "keygen     -K, --keygen      output a key (default)")
+	f(i, "sign       -S, --sign        output detached signatures")
+	f(i, "clearsign  -T, --clearsign   output a cleartext signature")
+	f(i, "verify     -V, --verify      verify a detached signature")
+	f(i, "encrypt    -E, --encrypt     encrypt a message to the subkey")
+	f(i, "decrypt    -D, --decrypt     decrypt a message with the subkey")
+	f(i, "revoke     --revoke[=REASON] output a revocation certificate")
+	f(i, "certify    --certify         certify another user's public key")
+	f(i, "bench      --bench[=TARGET]  suggest Argon2 costs for a time [10s]")
+	f(i, "agent      --agent           serve the key as an SSH agent")
+	f(i, "transition --transition      sign a statement moving to a new key")
+	f(i, "release    --release         write and sign SHA256SUMS for files")
+	f(i, "help       -h, --help        print this help message")
+	f(i, "version    --version         print version information")
 	f("Options:")
+	f(i, "--add-to-agent[=LIFE]     add key to the SSH agent instead of output")
+	f(i, "--aead                    advertise AEAD support (with -s)")
+	f(i, "--algo NAME               ed25519|ed448|p256|p384|rsa2048|rsa4096 [ed25519]")
+	f(i, "--allow-uid PATTERN       only accept signers with a matching user ID")
 	f(i, "-a, --armor               encode output in ASCII armor")
-	f(i, "-c, --check KEYID         require last Key ID bytes to match")
+	f(i, "--auth-subkey             also output an authentication subkey")
+	f(i, "--batch                   never use the terminal, fail instead")
+	f(i, "--cert-level N            certification level, 0 to 3 [0]")
+	f(i, "--cache[=TTL]             cache the derived seed in the OS keychain [10m]")
+	f(i, "-c, --check KEYID         require Key ID to start or end with this")
+	f(i, "--comment TEXT            add armor comment (repeatable)")
+	f(i, "--confirm                 show the key and ask before output")
+	f(i, "--dns-record              output public key as a DNS OPENPGPKEY record")
+	f(i, "--emit-version            add armor Version header")
+	f(i, "--export-agent-keys DIR   write secret keys as gpg-agent key files")
+	f(i, "--export-mnemonic         output the seed as words for a paper backup")
 	f(i, "-e, --protect[=ASKS]      protect private key with S2K")
-	f(i, "-f, --format pgp|ssh|x509 select key format [pgp]")
-	f(i, "-h, --help                print this help message")
-	f(i, "-i, --input FILE          read passphrase from file")
+	f(i, "--fido2 FILE              also require a security key registered in FILE")
+	f(i, "--fido2-register FILE     register connected security keys in FILE")
+	f(i, "--fingerprint             output only the key's fingerprint")
+	f(i, "--fingerprint-format FMT  hex|spaced|long|short|colons [hex]")
+	f(i, "--force                   accept a passphrase below --min-entropy")
+	f(i, "-f, --format FORMAT       pgp|ssh|x509|pem|jwk|age|minisign|signify [pgp]")
+	f(i, "--from-mnemonic FILE      read seed from mnemonic words (- for stdin)")
+	f(i, "--from-share FILE         read seed shares from FILE (repeatable)")
+	f(i, "--hash NAME               signature hash: sha256|sha384|sha512")
+	f(i, "--inline                  sign as a complete message, not detached")
+	f(i, "-i, --input FILE          read passphrase from file (- for stdin)")
+	f(i, "--json[=FD]               describe the generated key as JSON [2]")
+	f(i, "--kdf NAME                argon2id, scrypt, or pbkdf2-sha512 [argon2id]")
+	f(i, "--kdf-iterations N        PBKDF2 iterations [210000]")
+	f(i, "--kdf-memory MiB          Argon2 or scrypt memory cost [1024]")
+	f(i, "--kdf-threads N           Argon2 lanes or scrypt parallelism [1, or 4 for Argon2 v2+]")
+	f(i, "--kdf-time N              Argon2 time cost (passes) [8]")
+	f(i, "--kdf-version N           derivation version (1 to 4) [1]")
+	f(i, "--key-expires SPEC        same as --expires")
+	f(i, "--key-index N             derive the Nth key (version 3+) [0]")
+	f(i, "--keyfile FILE            also require this file to derive the key")
+	f(i, "--keyring FILE            verify using these public keys")
+	f(i, "--keyserver-url URL       preferred keyserver for this key")
 	f(i, "-l, --load FILE           load key from file instead of generating")
+	f(i, "--min-entropy BITS        refuse weaker passphrases [warn below 60]")
+	f(i, "--namespace NS            SSH signature namespace [file]")
+	f(i, "--no-signer-uid           omit the signer's user ID from signatures")
+	f(i, "--notation NAME=VALUE     add notation to signatures (repeatable)")
 	f(i, "-n, --now                 use current time as creation date")
+	f(i, "-o, --output FILE         write output to FILE (mode 0600)")
+	f(i, "--passphrase-env NAME     read passphrase from environment variable")
+	f(i, "--passphrase-fd N         read passphrase from file descriptor")
+	f(i, "--photo FILE              attach a JPEG photo ID to the key")
+	f(i, "--pgp-mime                sign a mail message as PGP/MIME")
 	f(i, "--pinentry[=CMD]          use pinentry to read the passphrase")
+	f(i, "--policy-url URL          signing policy, included in signatures")
+	f(i, "--prefs LIST              advertised algorithm preferences")
+	f(i, "--preset NAME             sign for a package repository: apt|rpm")
+	f(i, "--primary-flags LIST      primary key usage [certify,sign]")
 	f(i, "-p, --public              only output the public key")
+	f(i, "--public-output FILE      also write the public key to FILE")
+	f(i, "--qr[=fingerprint]        show public key as a QR code (PNG with -o)")
+	f(i, "-q, --quiet               never prompt, print only errors")
 	f(i, "-r, --repeat N            number of repeated passphrase prompts")
+	f(i, "--revoke-comment TEXT     explanation for the revocation")
+	f(i, "--revoker [ALGO:]FPR      designate a revocation key (repeatable)")
+	f(i, "--rotate N                derive the Nth rotation of subkeys [0]")
 	f(i, "-s, --subkey              also output an encryption subkey")
-	f(i, "-t, --time SECONDS        key creation date (unix epoch seconds)")
-	f(i, "-u, --uid USERID          user ID for the key")
+	f(i, "--subkey-flags LIST       encryption subkey usage [encrypt]")
+	f(i, "--sig-expires SPEC        set self-signature expiration")
+	f(i, "--sig-time DATE           signature creation date [now]")
+	f(i, "--symmetric               encrypt or decrypt with just a passphrase")
+	f(i, "--sign-subkey             also output (and sign with) a subkey")
+	f(i, "--signer-uid USERID       user ID named in signatures [first -u]")
+	f(i, "--split K/N               split the seed into N shares, any K recover it")
+	f(i, "--status-fd N             write machine-readable status to N")
+	f(i, "--strip-primary           omit the primary secret key, keeping subkeys")
+	f(i, "--seed HEX                use raw seed instead of a passphrase")
+	f(i, "--seed-file FILE          read raw seed (hex) from file")
+	f(i, "--seipdv2                 advertise RFC 9580 AEAD support (with -s)")
+	f(i, "--send-key KEYSERVER      upload the public key to an HKP(S) keyserver")
+	f(i, "--text                    make canonical text signatures")
+	f(i, "--threads N               CPU threads used for derivation [ncpu]")
+	f(i, "--to-card                 write secret keys to an OpenPGP smartcard")
+	f(i, "--trust-amount N          trust amount, 60 partial or 120 full [120]")
+	f(i, "--trust-depth N           certify as a trusted introducer to depth N")
+	f(i, "--trust-domain DOMAIN     limit introducer to user IDs in DOMAIN")
+	f(i, "-t, --time DATE           key creation date (epoch secs or RFC 3339)")
+	f(i, "--timestamp[=digest]      make timestamp signatures (type 0x40)")
+	f(i, "-u, --uid USERID          user ID for the key (repeatable)")
+	f(i, "--v6                      generate RFC 9580 version 6 keys")
+	f(i, "--vanity PATTERN          search creation dates for a Key ID")
 	f(i, "-v, --verbose             print additional information")
-	f(i, "--version                 print version information")
+	f(i, "--wkd-export DIR          write public key into a Web Key Directory")
 	f(i, "-x, --expires[=SPEC]      set key expiration ["+defaultExpires+"]")
 	bw.Flush()
 }
 
+// Maps each subcommand to its equivalent long option.
+var subcommands = map[string]string{
+	"keygen":     "keygen",
+	"sign":       "sign",
+	"clearsign":  "clearsign",
+	"verify":     "verify",
+	"encrypt":    "encrypt",
+	"decrypt":    "decrypt",
+	"revoke":     "revoke",
+	"certify":    "certify",
+	"bench":      "bench",
+	"agent":      "agent",
+	"transition": "transition",
+	"release":    "release",
+	"help":       "help",
+	"version":    "version",
+}
+
 func parse() *config {
 	conf := config{
 		cmd:    cmdKey,
 		format: formatPGP,
+		nspace: "file",
 		repeat: 1,
+		kdf: kdfParams{
+			algorithm: kdfArgon2id,
+			version:   kdfVersion,
+			time:      kdfTime,
+			memory:    kdfMemory,
+		},
+		threads: runtime.NumCPU(),
+		out:     os.Stdout,
 	}
 
 	options := []optparse.Option{
 		{"sign", 'S', optparse.KindNone},
 		{"keygen", 'K', optparse.KindNone},
 		{"clearsign", 'T', optparse.KindNone},
+		{"verify", 'V', optparse.KindNone},
+		{"encrypt", 'E', optparse.KindNone},
+		{"decrypt", 'D', optparse.KindNone},
+		{"revoke", 0, optparse.KindOptional},
+		{"certify", 0, optparse.KindNone},
+		{"bench", 0, optparse.KindOptional},
+		{"agent", 0, optparse.KindNone},
+		{"transition", 0, optparse.KindNone},
+		{"release", 0, optparse.KindNone},
 
+		{"aead", 0, optparse.KindNone},
+		{"add-to-agent", 0, optparse.KindOptional},
+		{"algo", 0, optparse.KindRequired},
+		{"allow-uid", 0, optparse.KindRequired},
 		{"armor", 'a', optparse.KindNone},
+		{"auth-subkey", 0, optparse.KindNone},
+		{"batch", 0, optparse.KindNone},
+		{"cache", 0, optparse.KindOptional},
+		{"cert-level", 0, optparse.KindRequired},
 		{"check", 'c', optparse.KindRequired},
+		{"comment", 0, optparse.KindRequired},
+		{"confirm", 0, optparse.KindNone},
+		{"dns-record", 0, optparse.KindNone},
+		{"emit-version", 0, optparse.KindNone},
+		{"export-agent-keys", 0, optparse.KindRequired},
+		{"export-mnemonic", 0, optparse.KindNone},
+		{"fingerprint", 0, optparse.KindNone},
+		{"force", 0, optparse.KindNone},
+		{"fingerprint-format", 0, optparse.KindRequired},
 		{"protect", 'e', optparse.KindOptional},
+		{"fido2", 0, optparse.KindRequired},
+		{"fido2-register", 0, optparse.KindRequired},
 		{"format", 'f', optparse.KindRequired},
+		{"from-mnemonic", 0, optparse.KindRequired},
+		{"from-share", 0, optparse.KindRequired},
+		{"hash", 0, optparse.KindRequired},
 		{"help", 'h', optparse.KindNone},
+		{"inline", 0, optparse.KindNone},
 		{"input", 'i', optparse.KindRequired},
+		{"json", 0, optparse.KindOptional},
+		{"kdf", 0, optparse.KindRequired},
+		{"kdf-iterations", 0, optparse.KindRequired},
+		{"kdf-memory", 0, optparse.KindRequired},
+		{"kdf-threads", 0, optparse.KindRequired},
+		{"kdf-time", 0, optparse.KindRequired},
+		{"kdf-version", 0, optparse.KindRequired},
+		{"key-index", 0, optparse.KindRequired},
+		{"key-expires", 0, optparse.KindRequired},
+		{"keyfile", 0, optparse.KindRequired},
+		{"keyring", 0, optparse.KindRequired},
+		{"keyserver-url", 0, optparse.KindRequired},
 		{"load", 'l', optparse.KindRequired},
+		{"min-entropy", 0, optparse.KindRequired},
+		{"namespace", 0, optparse.KindRequired},
+		{"no-signer-uid", 0, optparse.KindNone},
+		{"notation", 0, optparse.KindRequired},
 		{"now", 'n', optparse.KindNone},
+		{"output", 'o', optparse.KindRequired},
 		{"public", 'p', optparse.KindNone},
+		{"passphrase-env", 0, optparse.KindRequired},
+		{"passphrase-fd", 0, optparse.KindRequired},
+		{"photo", 0, optparse.KindRequired},
+		{"pgp-mime", 0, optparse.KindNone},
 		{"pinentry", 0, optparse.KindOptional},
+		{"policy-url", 0, optparse.KindRequired},
+		{"prefs", 0, optparse.KindRequired},
+		{"preset", 0, optparse.KindRequired},
+		{"primary-flags", 0, optparse.KindRequired},
 		{"public", 'p', optparse.KindNone},
+		{"public-output", 0, optparse.KindRequired},
+		{"qr", 0, optparse.KindOptional},
+		{"quiet", 'q', optparse.KindNone},
 		{"repeat", 'r', optparse.KindRequired},
+		{"revoke-comment", 0, optparse.KindRequired},
+		{"revoker", 0, optparse.KindRequired},
+		{"rotate", 0, optparse.KindRequired},
+		{"seed", 0, optparse.KindRequired},
+		{"seed-file", 0, optparse.KindRequired},
+		{"seipdv2", 0, optparse.KindNone},
+		{"send-key", 0, optparse.KindRequired},
+		{"sig-expires", 0, optparse.KindRequired},
+		{"sig-time", 0, optparse.KindRequired},
+		{"sign-subkey", 0, optparse.KindNone},
+		{"signer-uid", 0, optparse.KindRequired},
+		{"split", 0, optparse.KindRequired},
+		{"status-fd", 0, optparse.KindRequired},
+		{"strip-primary", 0, optparse.KindNone},
 		{"subkey", 's', optparse.KindNone},
+		{"subkey-flags", 0, optparse.KindRequired},
+		{"symmetric", 0, optparse.KindNone},
+		{"text", 0, optparse.KindNone},
+		{"threads", 0, optparse.KindRequired},
 		{"time", 't', optparse.KindRequired},
+		{"to-card", 0, optparse.KindNone},
+		{"timestamp", 0, optparse.KindOptional},
+		{"trust-amount", 0, optparse.KindRequired},
+		{"trust-depth", 0, optparse.KindRequired},
+		{"trust-domain", 0, optparse.KindRequired},
 		{"uid", 'u', optparse.KindRequired},
+		{"v6", 0, optparse.KindNone},
+		{"vanity", 0, optparse.KindRequired},
 		{"verbose", 'v', optparse.KindNone},
+		{"wkd-export", 0, optparse.KindRequired},
 		{"version", 0, optparse.KindNone},
 		{"expires", 'x', optparse.KindOptional},
 	}
 
-	var pretendGnuPGSign = []string{
-		"--status-fd=2", "-bsau",
-	}
-	var pretendGnuPGVerify = []string{
-		"--keyid-format=long", "--status-fd=1", "--verify",
+	var pretendSSHKeygenSign = []string{
+		"-Y", "sign",
 	}
 
 	var repeatSeen bool
-	var uidSeen bool
 	var timeSeen bool
+	var lanesSeen bool
+	var memorySeen bool
+	var argonSeen bool // options only for Argon2id
 
 	args := os.Args
-	if argsEqual(args[1:], pretendGnuPGSign) {
+	if gpgArgs, statusFd, verify := gnupgArgs(args); gpgArgs != nil {
 		// Pretend to be GnuPG in order to sign for Git. Unfortunately
 		// this is fragile, but there's no practical way to avoid it.
 		// The Git documentation says it depends on the GnuPG interface
 		// without being specific, so the only robust solution is to
 		// re-implement the entire GnuPG interface.
-		args = []string{args[0], "--sign", "--armor", "--uid", args[3]}
-		os.Stderr.WriteString("\n[GNUPG:] SIG_CREATED ")
-	} else if argsEqual(args[1:], pretendGnuPGVerify) {
-		// Delegate to GnuPG in order to verify for Git. Unfortunately
-		// this is also fragile, but it can't be avoided.
-		cmd := exec.Command("gpg", args[1:]...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(err.(*exec.ExitError).ExitCode())
-		}
-		os.Exit(0)
+		if verify {
+			// Delegate to GnuPG in order to verify for Git. This is
+			// also fragile, but it can't be avoided.
+			delegate("gpg", args[1:])
+		}
+		args = gpgArgs
+		conf.statusFd = statusFd
+	} else if argsEqual(args[1:], pretendSSHKeygenSign) {
+		// Pretend to be ssh-keygen in order to sign for Git when
+		// gpg.format is ssh. The user ID comes from the public key
+		// named by user.signingKey.
+		args = sshKeygenSign(args)
+	} else if len(args) > 2 && args[1] == "-Y" {
+		// Delegate other operations, such as verification, to the real
+		// ssh-keygen.
+		delegate("ssh-keygen", args[1:])
+	} else if len(args) > 1 && subcommands[args[1]] != "" {
+		// A leading subcommand is the same as its long option
+		option := "--" + subcommands[args[1]]
+		args = append([]string{args[0], option}, args[2:]...)
 	}
 
 	results, rest, err := optparse.Parse(options, args)
 	if err != nil {
 		usage(os.Stderr)
-		fatal("%s", err)
+		fatalUsage("%s", err)
 	}
+	results = mergeOptions(configOptions(options), results)
 	for _, result := range results {
 		switch result.Long {
 		case "sign":
@@ -244,13 +1178,120 @@ func parse() *config {
 			conf.cmd = cmdKey
 		case "clearsign":
 			conf.cmd = cmdClearsign
+		case "verify":
+			conf.cmd = cmdVerify
+		case "encrypt":
+			conf.cmd = cmdEncrypt
+		case "decrypt":
+			conf.cmd = cmdDecrypt
+		case "revoke":
+			conf.cmd = cmdRevoke
+			switch result.Optarg {
+			case "", "none", "0":
+				conf.revokeReason = openpgp.RevokeNoReason
+			case "superseded", "1":
+				conf.revokeReason = openpgp.RevokeSuperseded
+			case "compromised", "2":
+				conf.revokeReason = openpgp.RevokeCompromised
+			case "retired", "3":
+				conf.revokeReason = openpgp.RevokeRetired
+			default:
+				fatalUsage("invalid revocation reason: %s", result.Optarg)
+			}
+
+		case "certify":
+			conf.cmd = cmdCertify
+		case "agent":
+			conf.cmd = cmdAgent
+		case "transition":
+			conf.cmd = cmdTransition
+		case "release":
+			conf.cmd = cmdRelease
+		case "bench":
+			conf.cmd = cmdBench
+			conf.benchTime = 10 * time.Second
+			if result.Optarg != "" {
+				target, err := parseDuration(result.Optarg)
+				if err != nil {
+					fatalUsage("--bench: %s", err)
+				}
+				conf.benchTime = target
+			}
 
+		case "add-to-agent":
+			conf.agent = true
+			if result.Optarg != "" {
+				life, err := parseDuration(result.Optarg)
+				if err != nil || life.Seconds() > math.MaxUint32 {
+					fatalUsage("--add-to-agent: invalid lifetime: %s",
+						result.Optarg)
+				}
+				conf.agentLife = uint32(math.Ceil(life.Seconds()))
+			}
+		case "aead":
+			conf.aead = true
+		case "allow-uid":
+			if _, err := path.Match(result.Optarg, ""); err != nil {
+				fatalUsage("--allow-uid: %s: %q", err, result.Optarg)
+			}
+			conf.allowUIDs = append(conf.allowUIDs, result.Optarg)
 		case "armor":
 			conf.armor = true
+		case "auth-subkey":
+			conf.auth = true
+		case "batch":
+			conf.batch = true
+		case "cache":
+			conf.cache = true
+			conf.cacheTTL = 10 * time.Minute
+			switch result.Optarg {
+			case "":
+			case "0":
+				conf.cacheTTL = 0 // forget
+			default:
+				ttl, err := parseDuration(result.Optarg)
+				if err != nil {
+					fatalUsage("--cache: invalid TTL: %s", result.Optarg)
+				}
+				conf.cacheTTL = ttl
+			}
+		case "cert-level":
+			level, err := strconv.Atoi(result.Optarg)
+			if err != nil || level < 0 || level > 3 {
+				fatalUsage("--cert-level: must be 0, 1, 2, or 3")
+			}
+			conf.certLevel = level
+		case "comment":
+			conf.comments = append(conf.comments, result.Optarg)
+		case "confirm":
+			conf.confirm = true
+		case "dns-record":
+			conf.dnsRecord = true
+		case "emit-version":
+			conf.emitVer = true
+		case "export-agent-keys":
+			conf.agentDir = result.Optarg
+		case "export-mnemonic":
+			conf.exportMn = true
+		case "fingerprint":
+			conf.fprOnly = true
+		case "force":
+			conf.force = true
+		case "fingerprint-format":
+			switch result.Optarg {
+			case "hex", "spaced", "long", "short", "colons":
+				conf.fprFormat = result.Optarg
+			default:
+				fatalUsage("--fingerprint-format: invalid format: %s", result.Optarg)
+			}
+		case "fido2":
+			conf.fido2 = result.Optarg
+		case "fido2-register":
+			conf.fido2Reg = result.Optarg
 		case "check":
-			check, err := hex.DecodeString(result.Optarg)
+			check, err := parseCheck(result.Optarg)
 			if err != nil {
-				fatal("%s: %q", err, result.Optarg)
+				fatalUsage("%s: %q", err, result.Optarg)
 			}
 			conf.check = check
 		case "protect":
@@ -258,7 +1299,7 @@ func parse() *config {
 			if result.Optarg != "" {
 				repeat, err := strconv.Atoi(result.Optarg)
 				if err != nil {
-					fatal("--protect (-e): %s", err)
+					fatalUsage("--protect (-e): %s", err)
 				}
 				conf.protectQuery = repeat
 			}
@@ -270,19 +1311,254 @@ func parse() *config {
 				conf.format = formatSSH
 			case "x509":
 				conf.format = formatX509
+			case "minisign":
+				conf.format = formatMinisign
+			case "signify":
+				conf.format = formatSignify
+			case "age":
+				conf.format = formatAge
+			case "pem":
+				conf.format = formatPEM
+			case "jwk":
+				conf.format = formatJWK
 			default:
-				fatal("invalid format: %s", result.Optarg)
+				fatalUsage("invalid format: %s", result.Optarg)
+			}
+		case "from-mnemonic":
+			text, err := readAll(result.Optarg)
+			if err != nil {
+				fatal("--from-mnemonic: %s", err)
+			}
+			seed, err := mnemonicDecode(string(text))
+			wipe(text)
+			if err != nil {
+				fatalUsage("--from-mnemonic: %s", err)
+			}
+			keepSecret(seed)
+			conf.seed = seed
+			conf.mnemonic = true
+		case "from-share":
+			text, err := readAll(result.Optarg)
+			if err != nil {
+				fatal("--from-share: %s", err)
+			}
+			shares, err := parseShares(string(text))
+			wipe(text)
+			if err != nil {
+				fatalUsage("--from-share: %s: %s", result.Optarg, err)
 			}
+			conf.shares = append(conf.shares, shares...)
 		case "help":
 			usage(os.Stdout)
+			wipeSecrets()
 			os.Exit(0)
+		case "inline":
+			conf.inline = true
 		case "input":
-			conf.input = result.Optarg
+			if result.Optarg == "-" {
+				conf.input = os.Stdin
+				break
+			}
+			f, err := os.Open(result.Optarg)
+			if err != nil {
+				fatal("--input (-i): %s", err)
+			}
+			conf.input = f
+		case "kdf":
+			switch result.Optarg {
+			case kdfArgon2id, kdfScrypt, kdfPBKDF2:
+				conf.kdf.algorithm = result.Optarg
+			default:
+				fatalUsage("--kdf: unknown function: %s", result.Optarg)
+			}
+		case "kdf-iterations":
+			iterations, err := strconv.ParseUint(result.Optarg, 10, 31)
+			if err != nil || iterations == 0 {
+				fatalUsage("--kdf-iterations: invalid count: %s", result.Optarg)
+			}
+			conf.kdf.iterations = int(iterations)
+		case "kdf-memory":
+			memorySeen = true
+			// Argon2 counts memory in KiB, limited to 32 bits
+			memory, err := strconv.ParseUint(result.Optarg, 10, 22)
+			if err != nil || memory == 0 {
+				fatalUsage("--kdf-memory: invalid memory cost: %s", result.Optarg)
+			}
+			conf.kdf.memory = uint32(memory * 1024)
+		case "kdf-threads":
+			threads, err := strconv.ParseUint(result.Optarg, 10, 8)
+			if err != nil || threads == 0 {
+				fatalUsage("--kdf-threads: invalid parallelism: %s", result.Optarg)
+			}
+			conf.kdf.threads = uint8(threads)
+			lanesSeen = true
+		case "kdf-time":
+			time, err := strconv.ParseUint(result.Optarg, 10, 32)
+			if err != nil || time == 0 {
+				fatalUsage("--kdf-time: invalid time cost: %s", result.Optarg)
+			}
+			conf.kdf.time = uint32(time)
+			argonSeen = true
+		case "kdf-version":
+			version, err := strconv.Atoi(result.Optarg)
+			if err != nil || kdfLanes[version] == 0 {
+				fatalUsage("--kdf-version: unknown version: %s", result.Optarg)
+			}
+			conf.kdf.version = version
+		case "key-index":
+			index, err := strconv.ParseUint(result.Optarg, 10, 31)
+			if err != nil {
+				fatalUsage("--key-index: invalid index: %s", result.Optarg)
+			}
+			conf.kdf.index = int(index)
+		case "key-expires":
+			conf.expires = timespec(result.Optarg)
+		case "keyfile":
+			digest, err := hashFile(result.Optarg)
+			if err != nil {
+				fatal("--keyfile: %s", err)
+			}
+			conf.keyfile = digest
+		case "keyring":
+			conf.keyring = result.Optarg
+		case "keyserver-url":
+			if err := checkURI(result.Optarg); err != nil {
+				fatalUsage("--keyserver-url: %s", err)
+			}
+			conf.keyServer = result.Optarg
+		case "policy-url":
+			if err := checkURI(result.Optarg); err != nil {
+				fatalUsage("--policy-url: %s", err)
+			}
+			conf.policyURI = result.Optarg
 		case "load":
 			conf.load = result.Optarg
+		case "min-entropy":
+			bits, err := strconv.ParseUint(result.Optarg, 10, 16)
+			if err != nil {
+				fatalUsage("--min-entropy: invalid number of bits: %s", result.Optarg)
+			}
+			conf.minEntropy = int(bits)
+		case "namespace":
+			conf.nspace = result.Optarg
+		case "no-signer-uid":
+			conf.noSigner = true
+		case "signer-uid":
+			conf.signerUID = result.Optarg
+		case "prefs":
+			prefs, err := parsePrefs(result.Optarg)
+			if err != nil {
+				fatalUsage("--prefs: %s", err)
+			}
+			conf.prefs = &prefs
+		case "hash":
+			hash, ok := hashOptions[strings.ToLower(result.Optarg)]
+			if !ok {
+				fatalUsage("--hash: unknown hash: %s", result.Optarg)
+			}
+			conf.hash = hash
+		case "preset":
+			switch result.Optarg {
+			case "apt", "rpm":
+				conf.preset = result.Optarg
+			default:
+				fatalUsage("--preset: unknown preset: %s", result.Optarg)
+			}
+		case "notation":
+			note, err := parseNotation(result.Optarg)
+			if err != nil {
+				fatalUsage("--notation: %s", err)
+			}
+			conf.notes = append(conf.notes, note)
+		case "primary-flags":
+			flags, err := parseKeyFlags(result.Optarg)
+			if err != nil {
+				fatalUsage("--primary-flags: %s", err)
+			}
+			const allowed = openpgp.KeyFlagCertify | openpgp.KeyFlagSign |
+				openpgp.KeyFlagAuth
+			if flags&^allowed != 0 {
+				fatalUsage("--primary-flags: primary key cannot encrypt")
+			}
+			conf.primFlags = flags
+		case "subkey-flags":
+			flags, err := parseKeyFlags(result.Optarg)
+			if err != nil {
+				fatalUsage("--subkey-flags: %s", err)
+			}
+			const allowed = openpgp.KeyFlagEncryptComms |
+				openpgp.KeyFlagEncryptStorage
+			if flags&^allowed != 0 {
+				fatalUsage("--subkey-flags: encryption subkey can only encrypt")
+			}
+			conf.subFlags = flags
+		case "rotate":
+			n, err := strconv.ParseUint(result.Optarg, 10, 16)
+			if err != nil {
+				fatalUsage("--rotate: invalid rotation: %s", result.Optarg)
+			}
+			conf.rotate = int(n)
+		case "revoker":
+			revoker, err := parseRevoker(result.Optarg)
+			if err != nil {
+				fatalUsage("--revoker: %s", err)
+			}
+			conf.revokers = append(conf.revokers, revoker)
+		case "output":
+			conf.output = result.Optarg
+		case "public-output":
+			conf.pubOut = result.Optarg
 		case "now":
-			conf.created = time.Now().Unix()
+			conf.created = currentTime()
 			timeSeen = true
+		case "json":
+			conf.jsonFd = 2
+			if result.Optarg != "" {
+				fd, err := strconv.ParseUint(result.Optarg, 10, 31)
+				if err != nil || fd == 0 {
+					fatalUsage("--json: invalid file descriptor %s", result.Optarg)
+				}
+				conf.jsonFd = int(fd)
+			}
+		case "passphrase-env":
+			name := result.Optarg
+			passphrase, ok := os.LookupEnv(name)
+			if !ok {
+				fatalUsage("--passphrase-env: $%s is not set", name)
+			}
+			// Keep it from child processes, like pinentry and gpg
+			os.Unsetenv(name)
+			// Read it through a pipe just like --passphrase-fd
+			r, w, err := os.Pipe()
+			if err != nil {
+				fatal("--passphrase-env: %s", err)
+			}
+			go func() {
+				w.WriteString(passphrase + "\n")
+				w.Close()
+			}()
+			conf.input = r
+		case "passphrase-fd":
+			fd, err := strconv.ParseUint(result.Optarg, 10, 31)
+			if err != nil {
+				fatalUsage("--passphrase-fd: %s", err)
+			}
+			f := os.NewFile(uintptr(fd), "passphrase-fd")
+			if _, err := f.Stat(); err != nil {
+				fatalUsage("--passphrase-fd: invalid file descriptor %d", fd)
+			}
+			conf.input = f
+		case "photo":
+			jpeg, err := ioutil.ReadFile(result.Optarg)
+			if err != nil {
+				fatal("--photo: %s", err)
+			}
+			if !bytes.HasPrefix(jpeg, []byte{0xff, 0xd8, 0xff}) {
+				fatalUsage("--photo: not a JPEG image: %s", result.Optarg)
+			}
+			conf.photo = jpeg
+		case "pgp-mime":
+			conf.pgpMIME = true
 		case "pinentry":
 			if result.Optarg != "" {
 				conf.pinentry = result.Optarg
@@ -291,63 +1567,555 @@ func parse() *config {
 			}
 		case "public":
 			conf.public = true
-		case "repeat":
-			repeat, err := strconv.Atoi(result.Optarg)
+		case "qr":
+			conf.qr = true
+			switch result.Optarg {
+			case "", "key":
+			case "fingerprint", "fpr":
+				conf.qrFpr = true
+			default:
+				fatalUsage("invalid --qr: %s", result.Optarg)
+			}
+		case "quiet":
+			conf.quiet = true
+		case "repeat":
+			repeat, err := strconv.Atoi(result.Optarg)
 			if err != nil {
-				fatal("--repeat (-r): %s", err)
+				fatalUsage("--repeat (-r): %s", err)
 			}
 			conf.repeat = repeat
 			repeatSeen = true
+		case "revoke-comment":
+			if len(result.Optarg) >= 190 {
+				fatalUsage("--revoke-comment must be shorter than 190 bytes")
+			}
+			if !utf8.ValidString(result.Optarg) {
+				fatalUsage("--revoke-comment must be valid UTF-8")
+			}
+			conf.revokeComment = result.Optarg
+		case "seed":
+			seed, err := hex.DecodeString(result.Optarg)
+			if err != nil {
+				fatalUsage("--seed: %s", err)
+			}
+			keepSecret(seed)
+			conf.seed = seed
+		case "seed-file":
+			line, err := firstLine(result.Optarg)
+			if err != nil {
+				fatal("--seed-file: %s", err)
+			}
+			seed, err := hex.DecodeString(string(line))
+			wipe(line)
+			if err != nil {
+				fatalUsage("--seed-file: %s", err)
+			}
+			keepSecret(seed)
+			conf.seed = seed
+		case "seipdv2":
+			conf.seipdv2 = true
+		case "strip-primary":
+			conf.strip = true
+		case "status-fd":
+			fd, err := strconv.Atoi(result.Optarg)
+			if err != nil || fd < 1 {
+				fatalUsage("--status-fd: invalid file descriptor")
+			}
+			conf.statusFd = fd
+		case "send-key":
+			if _, err := keyserverURL(result.Optarg); err != nil {
+				fatalUsage("--send-key: %s", err)
+			}
+			conf.sendKey = result.Optarg
+		case "sig-expires":
+			conf.sigExpires = timespec(result.Optarg)
+		case "sig-time":
+			sigTime, err := parseTime(result.Optarg)
+			if err != nil {
+				fatalUsage("--sig-time: %s", err)
+			}
+			conf.sigTime = sigTime
+		case "split":
+			var k, n int
+			_, err := fmt.Sscanf(result.Optarg, "%d/%d", &k, &n)
+			if err != nil || k < 2 || k > n || n > 255 {
+				fatalUsage("--split: want K/N with 2 <= K <= N <= 255: %s",
+					result.Optarg)
+			}
+			conf.splitK = k
+			conf.splitN = n
+		case "sign-subkey":
+			conf.signSub = true
 		case "subkey":
 			conf.subkey = true
+		case "symmetric":
+			conf.symmetric = true
+		case "text":
+			conf.text = true
+		case "timestamp":
+			switch result.Optarg {
+			case "":
+			case "digest":
+				conf.stampHash = true
+			default:
+				fatalUsage("--timestamp: invalid argument: %s", result.Optarg)
+			}
+			conf.stamp = true
+		case "threads":
+			threads, err := strconv.Atoi(result.Optarg)
+			if err != nil || threads < 1 {
+				fatalUsage("--threads: invalid thread count: %s", result.Optarg)
+			}
+			conf.threads = threads
+		case "trust-amount":
+			amount, err := strconv.Atoi(result.Optarg)
+			if err != nil || amount < 1 || amount > 255 {
+				fatalUsage("--trust-amount: must be 1 to 255")
+			}
+			conf.trust.Amount = byte(amount)
+		case "trust-depth":
+			depth, err := strconv.Atoi(result.Optarg)
+			if err != nil || depth < 1 || depth > 255 {
+				fatalUsage("--trust-depth: must be 1 to 255")
+			}
+			conf.trust.Depth = byte(depth)
+		case "trust-domain":
+			conf.trustDom = result.Optarg
 		case "time":
-			time, err := strconv.ParseUint(result.Optarg, 10, 32)
+			created, err := parseTime(result.Optarg)
 			if err != nil {
-				fatal("--time (-t): %s", err)
+				fatalUsage("--time (-t): %s", err)
 			}
-			conf.created = int64(time)
+			conf.created = created
 			timeSeen = true
 		case "uid":
-			conf.uid = result.Optarg
-			if len(conf.uid) > 255 {
-				fatal("user ID length must be <= 255 bytes")
+			// Commas are common in names, so only semicolons separate
+			for _, uid := range strings.Split(result.Optarg, ";") {
+				uid = strings.TrimSpace(uid)
+				if uid == "" {
+					continue // ignore empty user IDs
+				}
+				if err := validateUID(uid); err != nil {
+					fatalUsage("invalid user ID %q: %s", uid, err)
+				}
+				dup := false
+				for _, seen := range conf.uids {
+					dup = dup || seen == uid
+				}
+				if !dup {
+					conf.uids = append(conf.uids, uid)
+				}
+			}
+		case "vanity":
+			pattern := result.Optarg
+			if _, err := hex.DecodeString(pattern); err == nil {
+				pattern = "^" + pattern // plain hex is a prefix
+			}
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				fatalUsage("--vanity: %s", err)
 			}
-			if !utf8.ValidString(conf.uid) {
-				fatal("user ID must be valid UTF-8")
+			conf.vanity = re
+		case "v6":
+			conf.v6 = true
+		case "algo":
+			algo, ok := algoOptions[strings.ToLower(result.Optarg)]
+			if !ok {
+				fatalUsage("--algo: unknown algorithm: %s", result.Optarg)
 			}
-			uidSeen = true
+			conf.algo = algo
+		case "to-card":
+			conf.toCard = true
+		case "wkd-export":
+			conf.wkdDir = result.Optarg
 		case "verbose":
 			conf.verbose = true
 		case "version":
 			fmt.Println("passphrase2pgp", version)
+			wipeSecrets()
 			os.Exit(0)
 		case "expires":
 			conf.expires = timespec(result.Optarg)
 		}
 	}
 
-	if !uidSeen && conf.load == "" {
+	if !lanesSeen {
+		conf.kdf.threads = kdfLanes[conf.kdf.version]
+		if conf.kdf.algorithm != kdfArgon2id {
+			conf.kdf.threads = 1
+		}
+	}
+	// A loaded key extended with -s derives its subkey again, and a
+	// transition derives the new key
+	extend := conf.load != "" &&
+		(conf.cmd == cmdKey && conf.subkey || conf.cmd == cmdTransition)
+	if conf.kdf.index != 0 {
+		if conf.kdf.version < 3 {
+			fatalUsage("--key-index requires --kdf-version=3 or later")
+		}
+		if conf.load != "" && !extend {
+			fatalUsage("--key-index cannot be used with --load (except keygen -s or transition)")
+		}
+	}
+	if conf.rotate != 0 && conf.load != "" {
+		fatalUsage("--rotate cannot be used with --load")
+	}
+	switch conf.kdf.algorithm {
+	case kdfArgon2id:
+		if conf.kdf.iterations != 0 {
+			fatalUsage("--kdf-iterations requires --kdf=%s", kdfPBKDF2)
+		}
+	case kdfScrypt:
+		if argonSeen || conf.kdf.iterations != 0 {
+			fatalUsage("--kdf=%s cannot use --kdf-time or --kdf-iterations",
+				kdfScrypt)
+		}
+		if m := conf.kdf.memory; m&(m-1) != 0 {
+			fatalUsage("--kdf=%s requires --kdf-memory to be a power of two",
+				kdfScrypt)
+		}
+	case kdfPBKDF2:
+		if argonSeen || memorySeen || lanesSeen {
+			fatalUsage("--kdf=%s cannot use Argon2 or scrypt costs", kdfPBKDF2)
+		}
+		if conf.kdf.iterations == 0 {
+			conf.kdf.iterations = kdfIters
+		}
+	}
+
+	if conf.text && (conf.cmd != cmdSign || conf.format != formatPGP) {
+		fatalUsage("--text requires sign in pgp format")
+	}
+	if conf.stamp && (conf.cmd != cmdSign || conf.format != formatPGP) {
+		fatalUsage("--timestamp requires sign in pgp format")
+	}
+	if conf.stamp && conf.text {
+		fatalUsage("--timestamp cannot be used with --text")
+	}
+	if conf.inline && (conf.cmd != cmdSign || conf.format != formatPGP) {
+		fatalUsage("--inline requires sign in pgp format")
+	}
+	if conf.inline && conf.stamp {
+		fatalUsage("--inline cannot be used with --timestamp")
+	}
+	if conf.allowUIDs != nil && conf.cmd != cmdVerify {
+		fatalUsage("--allow-uid requires verify")
+	}
+	if conf.pgpMIME {
+		if conf.cmd != cmdSign || conf.format != formatPGP {
+			fatalUsage("--pgp-mime requires sign in pgp format")
+		}
+		if conf.inline || conf.text || conf.stamp || conf.preset != "" {
+			fatalUsage("--pgp-mime cannot be used with --inline, --text, " +
+				"--timestamp, or --preset")
+		}
+	}
+	if conf.preset != "" {
+		if conf.cmd != cmdSign || conf.format != formatPGP {
+			fatalUsage("--preset requires sign in pgp format")
+		}
+		if conf.inline || conf.text || conf.stamp {
+			fatalUsage("--preset cannot be used with --inline, --text, " +
+				"or --timestamp")
+		}
+		if conf.v6 {
+			// Neither apt's gpgv nor rpm verifies version 6 signatures
+			fatalUsage("--preset cannot be used with --v6")
+		}
+	}
+	if conf.exportMn && (conf.cmd != cmdKey || conf.load != "") {
+		fatalUsage("--export-mnemonic requires keygen without --load")
+	}
+	if conf.splitN > 0 && (conf.cmd != cmdKey || conf.load != "") {
+		fatalUsage("--split requires keygen without --load")
+	}
+	if conf.splitN > 0 && conf.exportMn {
+		fatalUsage("--split cannot be used with --export-mnemonic")
+	}
+	if conf.shares != nil {
+		if conf.seed != nil {
+			fatalUsage("--from-share cannot be used with --seed or --from-mnemonic")
+		}
+		seed, err := shamirCombine(conf.shares)
+		for _, s := range conf.shares {
+			wipe(s.y)
+		}
+		if err != nil {
+			fatalUsage("--from-share: %s", err)
+		}
+		conf.seed = seed
+		conf.mnemonic = true
+	}
+	if conf.qr && (conf.cmd != cmdKey || conf.format != formatPGP) {
+		fatalUsage("--qr requires keygen in pgp format")
+	}
+	if conf.sendKey != "" && (conf.cmd != cmdKey || conf.format != formatPGP) {
+		fatalUsage("--send-key requires keygen in pgp format")
+	}
+	if conf.revokers != nil && (conf.cmd != cmdKey || conf.format != formatPGP) {
+		fatalUsage("--revoker requires keygen in pgp format")
+	}
+	if conf.photo != nil && (conf.cmd != cmdKey || conf.format != formatPGP) {
+		fatalUsage("--photo requires keygen in pgp format")
+	}
+	if conf.primFlags != 0 && (conf.cmd != cmdKey || conf.format != formatPGP) {
+		fatalUsage("--primary-flags requires keygen in pgp format")
+	}
+	if conf.subFlags != 0 && (conf.cmd != cmdKey || conf.format != formatPGP) {
+		fatalUsage("--subkey-flags requires keygen in pgp format")
+	}
+	if conf.subFlags != 0 && !conf.subkey {
+		fatalUsage("--subkey-flags requires --subkey")
+	}
+	if conf.keyServer != "" &&
+		(conf.cmd != cmdKey && conf.cmd != cmdCertify || conf.format != formatPGP) {
+		fatalUsage("--keyserver-url requires keygen or certify in pgp format")
+	}
+	if conf.policyURI != "" && conf.format != formatPGP {
+		fatalUsage("--policy-url requires pgp format")
+	}
+	if conf.hash != 0 && conf.format != formatPGP {
+		fatalUsage("--hash requires pgp format")
+	}
+	if conf.signerUID != "" && conf.noSigner {
+		fatalUsage("--signer-uid cannot be used with --no-signer-uid")
+	}
+	if conf.agent {
+		if conf.cmd != cmdKey {
+			fatalUsage("--add-to-agent requires keygen")
+		}
+		if conf.output != "" || conf.pubOut != "" || conf.qr ||
+			conf.wkdDir != "" || conf.dnsRecord || conf.agentDir != "" ||
+			conf.toCard {
+			fatalUsage("--add-to-agent cannot be used with other outputs")
+		}
+	}
+	if conf.toCard {
+		if conf.cmd != cmdKey || conf.format != formatPGP {
+			fatalUsage("--to-card requires keygen in pgp format")
+		}
+		if conf.protect {
+			fatalUsage("--to-card cannot be used with --protect")
+		}
+		if conf.pubOut != "" || conf.wkdDir != "" || conf.dnsRecord ||
+			conf.agentDir != "" {
+			fatalUsage("--to-card cannot be used with --public-output, " +
+				"--wkd-export, --dns-record, or --export-agent-keys")
+		}
+		// Secret keys only go to the card, the public key to gpg
+		conf.public = true
+	}
+	if conf.agentDir != "" {
+		if conf.cmd != cmdKey || conf.format != formatPGP {
+			fatalUsage("--export-agent-keys requires keygen in pgp format")
+		}
+		if conf.protect {
+			fatalUsage("--export-agent-keys cannot be used with --protect")
+		}
+		if conf.pubOut != "" || conf.wkdDir != "" || conf.dnsRecord {
+			fatalUsage("--export-agent-keys cannot be used with " +
+				"--public-output, --wkd-export, or --dns-record")
+		}
+		// Secret keys only go to gpg-agent, the public key to gpg
+		conf.public = true
+	}
+	if conf.dnsRecord {
+		if conf.cmd != cmdKey || conf.format != formatPGP {
+			fatalUsage("--dns-record requires keygen in pgp format")
+		}
+		if conf.pubOut != "" || conf.qr || conf.wkdDir != "" {
+			fatalUsage("--dns-record cannot be used with --public-output, " +
+				"--qr, or --wkd-export")
+		}
+		conf.public = true
+	}
+	if conf.confirm && conf.cmd != cmdKey {
+		fatalUsage("--confirm requires keygen")
+	}
+	if conf.fprOnly {
+		if conf.cmd != cmdKey || conf.format != formatPGP {
+			fatalUsage("--fingerprint requires keygen in pgp format")
+		}
+		if conf.pubOut != "" || conf.qr || conf.wkdDir != "" ||
+			conf.dnsRecord || conf.agentDir != "" || conf.toCard ||
+			conf.agent {
+			fatalUsage("--fingerprint cannot be used with other outputs")
+		}
+	}
+	if conf.wkdDir != "" {
+		if conf.cmd != cmdKey || conf.format != formatPGP {
+			fatalUsage("--wkd-export requires keygen in pgp format")
+		}
+		if conf.output != "" || conf.pubOut != "" || conf.qr {
+			fatalUsage("--wkd-export cannot be used with --output, " +
+				"--public-output, or --qr")
+		}
+		conf.public = true
+	}
+	if conf.qr && conf.pubOut != "" {
+		fatalUsage("--qr cannot be used with --public-output")
+	}
+	if conf.qr {
+		// Only the public key is ever shown as a QR code
+		conf.public = true
+		conf.armor = true
+	}
+	if conf.strip {
+		if conf.cmd != cmdKey || conf.format != formatPGP {
+			fatalUsage("--strip-primary requires keygen in pgp format")
+		}
+		if conf.public {
+			fatalUsage("--strip-primary requires secret key output")
+		}
+	}
+
+	if conf.symmetric {
+		if conf.cmd != cmdEncrypt && conf.cmd != cmdDecrypt {
+			fatalUsage("--symmetric requires encrypt or decrypt")
+		}
+		if conf.seed != nil || conf.load != "" || conf.keyfile != nil ||
+			conf.fido2 != "" {
+			fatalUsage("--symmetric cannot be used with --seed, --load, " +
+				"--keyfile, or --fido2")
+		}
+	}
+
+	needKey := conf.cmd != cmdVerify || conf.keyring == ""
+	needKey = needKey && !conf.symmetric && conf.cmd != cmdBench
+	needKey = needKey && conf.fido2Reg == ""
+	if len(conf.uids) == 0 && conf.load == "" && needKey {
 		// Using os.Getenv instead of os.LookupEnv because empty is just
 		// as good as not set. It means a user can do something like:
 		// $ EMAIL= passphrase2pgp ...
 		if email := os.Getenv("EMAIL"); email != "" {
 			if realname := os.Getenv("REALNAME"); realname != "" {
-				conf.uid = fmt.Sprintf("%s <%s>", realname, email)
+				uid := fmt.Sprintf("%s <%s>", realname, email)
+				if err := validateUID(uid); err != nil {
+					fatalUsage("invalid user ID %q: %s", uid, err)
+				}
+				conf.uids = append(conf.uids, uid)
+			}
+		}
+		if len(conf.uids) == 0 {
+			fatalUsage("--uid or --load required (or $REALNAME and $EMAIL)")
+		}
+	}
+
+	if (conf.cmd == cmdEncrypt || conf.cmd == cmdDecrypt) && !conf.symmetric {
+		// Messages are encrypted to the subkey
+		conf.subkey = true
+	}
+	if conf.format == formatAge {
+		// The age identity is the subkey
+		if conf.protect {
+			fatalUsage("--protect cannot be used with --format age")
+		}
+		conf.subkey = true
+	}
+
+	if conf.pubOut != "" && conf.cmd != cmdTransition &&
+		(conf.cmd != cmdKey || conf.public) {
+		fatalUsage("--public-output requires secret key generation or transition")
+	}
+
+	if conf.vanity != nil && conf.load != "" {
+		fatalUsage("--vanity cannot be used with --load")
+	}
+
+	if conf.v6 && conf.load != "" {
+		fatalUsage("--v6 cannot be used with --load")
+	}
+	if conf.algo != openpgp.Curve25519 && conf.load != "" {
+		// The loaded key determines the algorithm
+		fatalUsage("--algo cannot be used with --load")
+	}
+	checkAlgorithm(&conf, conf.algo)
+
+	if conf.keyfile != nil && (conf.seed != nil || conf.load != "" && !extend) {
+		fatalUsage("--keyfile cannot be used with --seed or --load (except keygen -s or transition)")
+	}
+	if conf.fido2 != "" && (conf.seed != nil || conf.load != "" && !extend) {
+		fatalUsage("--fido2 cannot be used with --seed or --load (except keygen -s or transition)")
+	}
+	if conf.jsonFd != 0 && conf.cmd != cmdKey {
+		fatalUsage("--json requires keygen")
+	}
+	if conf.cache && (conf.seed != nil || conf.load != "" || conf.symmetric) {
+		fatalUsage("--cache cannot be used with --seed, --load, or --symmetric")
+	}
+	if conf.fido2Reg != "" && conf.cmd != cmdKey {
+		fatalUsage("--fido2-register requires keygen")
+	}
+
+	if conf.seed != nil {
+		if conf.input != nil || conf.pinentry != "" || conf.load != "" {
+			fatalUsage("--seed cannot be used with --input, --pinentry, or --load")
+		}
+		// A whole seed, as exported with -s, works for any key
+		want := seedSize(&conf)
+		if len(conf.seed) != want && len(conf.seed) != 64 {
+			if conf.mnemonic {
+				fatalUsage("seed mnemonic must be exactly %d words",
+					want/32*24)
 			}
+			fatalUsage("--seed must be exactly %d bytes (%d hex digits)",
+				want, want*2)
+		}
+		conf.seed = conf.seed[:want]
+		if conf.protect && conf.protectQuery == 0 {
+			// There's no passphrase to reuse for protection
+			conf.protectQuery = 2
+		}
+	}
+
+	if conf.quiet {
+		// Prompts are chatter, too, so a quiet run must never need one
+		conf.verbose = false
+		if conf.load == "" && conf.input == nil && conf.seed == nil {
+			fatalCode(exitPrompt, "--quiet requires --input, --seed, or --load")
+		}
+		if conf.protectQuery > 0 {
+			noPrompt(&conf, "a protection passphrase")
+		}
+		if conf.confirm {
+			noPrompt(&conf, "confirmation (--confirm)")
+		}
+	}
+
+	if conf.batch {
+		// Unlike --quiet, output is unchanged, but anything that would
+		// wait on a person fails with its own exit status.
+		if conf.input == nil && conf.seed == nil &&
+			(conf.symmetric || conf.load == "" && needKey) {
+			fatalCode(exitPrompt, "--batch requires --input, "+
+				"--passphrase-fd, --passphrase-env, --seed, or --load")
+		}
+		if conf.pinentry != "" {
+			fatalCode(exitPrompt, "--batch cannot be used with --pinentry")
+		}
+		if conf.fido2 != "" || conf.fido2Reg != "" || conf.toCard {
+			fatalCode(exitPrompt, "--batch cannot be used with "+
+				"--fido2, --fido2-register, or --to-card")
+		}
+		if conf.protectQuery > 0 {
+			noPrompt(&conf, "a protection passphrase")
 		}
-		if conf.uid == "" {
-			fatal("--uid or --load required (or $REALNAME and $EMAIL)")
+		if conf.confirm {
+			noPrompt(&conf, "confirmation (--confirm)")
 		}
 	}
 
-	if conf.load != "" && !timeSeen {
-		conf.created = time.Now().Unix()
+	if conf.load != "" && !timeSeen && conf.cmd != cmdTransition {
+		conf.created = currentTime()
 	}
 
 	if conf.check == nil {
-		check, err := hex.DecodeString(os.Getenv("KEYID"))
+		check, err := parseCheck(os.Getenv("KEYID"))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "warning: $KEYID invalid, ignoring it\n")
+			if !conf.quiet {
+				warning := "warning: $KEYID invalid, ignoring it\n"
+				os.Stderr.WriteString(warning)
+			}
 		} else {
 			conf.check = check
 		}
@@ -368,7 +2136,7 @@ func parse() *config {
 			// (keys can expire before they were created) and cutting
 			// the range in half. This is a GnuPG bug, but hopefully
 			// it will be fixed before it becomes a problem.
-			fatal("key expiration too far in the future")
+			fatalUsage("key expiration too far in the future")
 			// Side note: Another GnuPG bug is that it doesn't properly
 			// process expiration dates for keys with a zero creation
 			// date (i.e. passphrase2pgp keys), and instead treats such
@@ -377,23 +2145,364 @@ func parse() *config {
 		}
 	}
 
+	if conf.sigTime == 0 {
+		conf.sigTime = currentTime()
+	}
+
+	if conf.sigExpires != 0 {
+		delta := conf.sigExpires - conf.created
+		if delta <= 0 {
+			fatalUsage("signature expiration must be after creation date")
+		}
+		if delta > 0xffffffff {
+			fatalUsage("signature expiration too far in the future")
+		}
+	}
+
 	conf.args = rest
+	if conf.input != nil && conf.input.Fd() == os.Stdin.Fd() {
+		switch conf.cmd {
+		case cmdSign, cmdClearsign, cmdEncrypt, cmdDecrypt:
+			if len(conf.args) == 0 {
+				fatalUsage("passphrase and data cannot both be read from stdin")
+			}
+		}
+	}
+	if conf.cmd != cmdCertify &&
+		(conf.trust != openpgp.Trust{} || conf.trustDom != "") {
+		fatalUsage("--trust-depth, --trust-amount, and --trust-domain " +
+			"require certify")
+	}
 	switch conf.cmd {
-	case cmdKey:
+	case cmdKey, cmdRevoke:
+		if len(conf.args) > 0 {
+			fatalUsage("too many arguments")
+		}
+	case cmdAgent:
+		if len(conf.args) > 1 {
+			fatalUsage("too many arguments")
+		}
+	case cmdBench:
 		if len(conf.args) > 0 {
-			fatal("too many arguments")
+			fatalUsage("too many arguments")
+		}
+		if conf.kdf.algorithm != kdfArgon2id {
+			fatalUsage("bench only measures --kdf=%s", kdfArgon2id)
 		}
 	case cmdSign:
 		// processed elsewhere
-	case cmdClearsign:
+		if conf.format == formatX509 {
+			fatalUsage("cannot sign in x509 format")
+		}
+		switch conf.format {
+		case formatAge, formatPEM, formatJWK:
+			fatalUsage("cannot sign in age, pem, or jwk format")
+		}
+		if conf.output != "" && len(conf.args) > 0 && conf.preset != "rpm" {
+			fatalUsage("--output (-o) cannot be used when signing files")
+		}
+	case cmdClearsign, cmdEncrypt, cmdDecrypt:
+		if len(conf.args) > 1 {
+			fatalUsage("too many arguments")
+		}
+	case cmdCertify:
+		if conf.format != formatPGP {
+			fatalUsage("can only certify in pgp format")
+		}
+		if conf.trust.Depth == 0 &&
+			(conf.trust.Amount != 0 || conf.trustDom != "") {
+			fatalUsage("--trust-amount and --trust-domain require --trust-depth")
+		}
+		if conf.trust.Amount == 0 {
+			conf.trust.Amount = 120 // complete trust
+		}
+		if conf.trustDom != "" {
+			pattern, err := trustRegexp(conf.trustDom)
+			if err != nil {
+				fatalUsage("--trust-domain: %s", err)
+			}
+			conf.trust.Regexp = pattern
+		}
+		if len(conf.args) < 1 {
+			fatalUsage("missing public key file")
+		}
+		if len(conf.args) > 1 {
+			fatalUsage("too many arguments")
+		}
+	case cmdTransition:
+		if conf.format != formatPGP {
+			fatalUsage("can only make a transition in pgp format")
+		}
+		if conf.load == "" || len(conf.uids) == 0 {
+			fatalUsage("transition requires the old key (--load) " +
+				"and the new key's user IDs (--uid)")
+		}
 		if len(conf.args) > 1 {
-			fatal("too many arguments")
+			fatalUsage("too many arguments")
+		}
+		// The statement is armored, so the keys are, too
+		conf.armor = true
+	case cmdRelease:
+		if conf.format != formatPGP {
+			fatalUsage("can only sign a release in pgp format")
+		}
+		if len(conf.args) < 1 {
+			fatalUsage("missing files to release")
+		}
+		for _, name := range conf.args {
+			// sha256sum would escape these, which few tools understand
+			if strings.ContainsAny(name, "\\\n") {
+				fatalUsage("unsupported file name: %q", name)
+			}
+		}
+		if conf.output == "" {
+			conf.output = "SHA256SUMS"
 		}
+	case cmdVerify:
+		if len(conf.args) < 1 {
+			fatalUsage("missing signature file")
+		}
+		if len(conf.args) > 2 {
+			fatalUsage("too many arguments")
+		}
+	}
+
+	if conf.pgpMIME && len(conf.args) > 0 {
+		fatalUsage("--pgp-mime only signs standard input")
+	}
+	switch conf.preset {
+	case "apt":
+		if len(conf.args) == 0 {
+			fatalUsage("--preset apt requires Release files")
+		}
+	case "rpm":
+		// As run by rpmsign, which names the file and the signature
+		if len(conf.args) != 1 || conf.output == "" {
+			fatalUsage("--preset rpm requires one file and --output (-o)")
+		}
+		// rpm finds the key by the Issuer subpacket and wants little
+		// else, so the signature is binary with only the essentials
+		conf.armor = false
+		conf.noSigner = true
+		conf.notes = nil
+		conf.policyURI = ""
+	}
+
+	if conf.statusFd != 0 {
+		// Wrapped once, since each *os.File closes it when collected
+		conf.status = os.NewFile(uintptr(conf.statusFd), "status-fd")
 	}
 
 	return &conf
 }
 
+// Recognize a GnuPG command line, as run by Git via gpg.program, and
+// translate it into the equivalent passphrase2pgp command line, e.g.
+// "--status-fd=2 -bsau ID" becomes "--sign --armor --uid ID". Returns
+// nil if the arguments aren't a GnuPG detached signature or
+// verification, in which case they're passphrase2pgp's own.
+func gnupgArgs(args []string) (out []string, statusFd int, verify bool) {
+	var gnupg, sign, detach, armor bool
+	var uid string
+	var files []string
+
+	// Returns the value for an option, inline or the next argument
+	value := func(i *int, inline string, hasInline bool) string {
+		if hasInline {
+			return inline
+		}
+		if *i+1 == len(args) {
+			fatalUsage("%s: missing argument", args[*i])
+		}
+		*i++
+		return args[*i]
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		name, inline, hasInline := arg, "", false
+		if eq := strings.IndexByte(arg, '='); eq > 2 {
+			name, inline, hasInline = arg[:eq], arg[eq+1:], true
+		}
+
+		switch {
+		case name == "--status-fd":
+			fd, err := strconv.Atoi(value(&i, inline, hasInline))
+			if err != nil || fd < 1 {
+				fatalUsage("--status-fd: invalid file descriptor")
+			}
+			statusFd = fd
+			gnupg = true
+		case name == "--keyid-format":
+			value(&i, inline, hasInline)
+		case name == "--local-user":
+			uid = value(&i, inline, hasInline)
+		case arg == "--detach-sign":
+			sign, detach, gnupg = true, true, true
+		case arg == "--sign":
+			sign = true
+		case arg == "--armor":
+			armor = true
+		case arg == "--verify":
+			verify = true
+		case arg == "-":
+			// standard input, which is already the default
+		case strings.HasPrefix(arg, "--"):
+			return nil, 0, false
+		case strings.HasPrefix(arg, "-"):
+			for j, c := range arg[1:] {
+				switch c {
+				case 'b':
+					sign, detach, gnupg = true, true, true
+				case 's':
+					sign = true
+				case 'a':
+					armor = true
+				case 'u':
+					if j != len(arg)-2 {
+						return nil, 0, false
+					}
+					uid = value(&i, "", false)
+				default:
+					return nil, 0, false
+				}
+			}
+		default:
+			files = append(files, arg)
+		}
+	}
+
+	switch {
+	case !gnupg:
+		return nil, 0, false
+	case verify:
+		return args, statusFd, true
+	case !sign || !detach:
+		return nil, 0, false
+	}
+	out = []string{args[0], "--sign"}
+	if armor {
+		out = append(out, "--armor")
+	}
+	if uid != "" {
+		out = append(out, "--uid", uid)
+	}
+	return append(out, files...), statusFd, false
+}
+
+// Write GnuPG status lines for a newly-created OpenPGP signature of
+// the given type by signer, as expected by Git. Its class is D for a
+// detached signature, C for cleartext, or S for an inline message.
+func sigCreated(config *config, class byte, signer *openpgp.SignKey, sigtype byte) {
+	status(config, "BEGIN_SIGNING H%d", signer.HashID())
+	status(config, "SIG_CREATED %c %d %d %02X %d %X", class,
+		signer.AlgorithmID(), signer.HashID(), sigtype, signer.SigTime(),
+		signer.KeyID())
+}
+
+// Write a line to the --status-fd file descriptor, if any, in GnuPG's
+// format, for scripts and programs that run passphrase2pgp.
+func status(config *config, format string, args ...interface{}) {
+	if config.status == nil {
+		return
+	}
+	_, err := fmt.Fprintf(config.status, "[GNUPG:] "+format+"\n", args...)
+	if err != nil {
+		fatal("--status-fd: %s", err)
+	}
+}
+
+// Run another program in place of this one, exiting with its status.
+func delegate(program string, args []string) {
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if exit, ok := err.(*exec.ExitError); ok {
+			os.Exit(exit.ExitCode())
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Translate an "ssh-keygen -Y sign" command line, as run by Git, into
+// the equivalent passphrase2pgp command line. Git writes the signature
+// to the input file name plus ".sig", as does -S.
+func sshKeygenSign(args []string) []string {
+	namespace := "file"
+	var keyfile string
+	var files []string
+	for i := 3; i < len(args); i++ {
+		switch args[i] {
+		case "-n", "-f":
+			if i+1 == len(args) {
+				fatalUsage("%s: missing argument", args[i])
+			}
+			if args[i] == "-n" {
+				namespace = args[i+1]
+			} else {
+				keyfile = args[i+1]
+			}
+			i++
+		case "-U":
+			// key is "in an agent," which is true enough
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if keyfile == "" {
+		fatalUsage("-Y sign: missing key file (-f)")
+	}
+
+	line, err := firstLine(keyfile)
+	if err != nil {
+		fatal("%s", err)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 {
+		fatal("%s: no user ID in public key comment", keyfile)
+	}
+	uid := strings.Join(fields[2:], " ")
+
+	return append([]string{
+		args[0], "--sign", "--format", "ssh",
+		"--namespace", namespace, "--uid", uid,
+	}, files...)
+}
+
+// Returns an error if the user ID is malformed. A user ID must have a
+// name, an angle-bracketed email address, or both.
+func validateUID(uid string) error {
+	if len(uid) > 255 {
+		return errors.New("length must be <= 255 bytes")
+	}
+	if !utf8.ValidString(uid) {
+		return errors.New("must be valid UTF-8")
+	}
+
+	beg := strings.IndexByte(uid, '<')
+	end := strings.IndexByte(uid, '>')
+	if beg == -1 && end == -1 {
+		return nil // name only
+	}
+	if beg == -1 || end != len(uid)-1 ||
+		strings.Count(uid, "<") != 1 || strings.Count(uid, ">") != 1 {
+		return errors.New("email address must be <...> at the end")
+	}
+
+	email := uid[beg+1 : end]
+	at := strings.IndexByte(email, '@')
+	if at < 1 || at == len(email)-1 ||
+		strings.Count(email, "@") != 1 ||
+		strings.ContainsAny(email, " \t") {
+		return fmt.Errorf("malformed email address %q", email)
+	}
+	return nil
+}
+
 // Return a key expiration date from the given "timespec" string. See
 // the README for format information.
 func timespec(ts string) int64 {
@@ -401,6 +2510,21 @@ func timespec(ts string) int64 {
 		ts = defaultExpires
 	}
 
+	// Absolute date, either a UTC calendar date or RFC 3339
+	if len(ts) >= 10 && ts[4] == '-' {
+		t, err := time.Parse("2006-01-02", ts)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, ts)
+		}
+		if err != nil {
+			fatalUsage("timespec, invalid date: %s", ts)
+		}
+		if t.Unix() < 0 {
+			fatalUsage("timespec cannot be negative: %s", ts)
+		}
+		return t.Unix()
+	}
+
 	unit := ts[len(ts)-1]
 	value := ts
 	var duration time.Duration
@@ -422,14 +2546,14 @@ func timespec(ts string) int64 {
 
 	t, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		fatal("timespec, %s: %s", err, ts)
+		fatalUsage("timespec, %s: %s", err, ts)
 	}
 	if duration != 0 {
-		t = time.Now().Unix() + int64(duration.Seconds())*t
+		t = currentTime() + int64(duration.Seconds())*t
 	}
 
 	if t < 0 {
-		fatal("timespec cannot be negative: %s", ts)
+		fatalUsage("timespec cannot be negative: %s", ts)
 	}
 	return t
 }
@@ -437,57 +2561,140 @@ func timespec(ts string) int64 {
 func main() {
 	var key openpgp.SignKey
 	var subkey openpgp.EncryptKey
-	var userid openpgp.UserID
+	var authkey openpgp.SignKey
+	var signsub openpgp.SignKey
+	var userids []openpgp.UserID
+	var photos []openpgp.PhotoID
 
 	config := parse()
+	defer wipeSecrets()
+	wipeOnExit(func() {
+		wipe(config.passphrase)
+		wipe(config.protectPassword)
+		wipe(config.seed)
+		key.Wipe()
+		subkey.Wipe()
+		authkey.Wipe()
+		signsub.Wipe()
+	})
 
-	if config.load == "" {
-		if config.verbose {
-			fmt.Fprintf(os.Stderr, "User ID: %s\n", config.uid)
+	if config.cmd == cmdVerify && config.keyring != "" {
+		ring, err := loadKeyring(config.keyring)
+		if err != nil {
+			fatal("%s: %s", err, config.keyring)
 		}
+		verify(config, ring)
+		return
+	}
 
-		// Read the passphrase from the terminal
-		var err error
-		if config.input != "" {
-			config.passphrase, err = firstLine(config.input)
-		} else {
-			pinentry := config.pinentry
-			repeat := config.repeat
-			config.passphrase, err = readPassphrase(pinentry, "", repeat)
-		}
+	if config.symmetric {
+		symmetric(config)
+		return
+	}
+
+	if config.cmd == cmdBench {
+		bench(config)
+		return
+	}
+
+	if config.fido2Reg != "" {
+		n, err := fido2Register(config.fido2Reg, touchPrompt(config))
 		if err != nil {
-			fatal("%s", err)
+			fatal("--fido2-register: %s", err)
+		}
+		if !config.quiet {
+			fmt.Fprintf(os.Stderr, "Registered %d security key(s) in %s\n",
+				n, config.fido2Reg)
 		}
+		return
+	}
 
-		// Run KDF on passphrase
-		scale := 1
-		seed := kdf(config.passphrase, []byte(config.uid), scale)
+	var next *openpgp.SignKey // a transition's new key
+	var nextPub *openpgp.TransferableKey
+	if config.cmd == cmdTransition {
+		next, nextPub = transitionKey(config)
+		wipeOnExit(func() { next.Wipe() })
+	}
 
-		key.Seed(seed[:32])
-		key.SetCreated(config.created)
-		key.SetExpires(config.expires)
-		userid = openpgp.UserID{[]byte(config.uid)}
-		if config.subkey {
-			subkey.Seed(seed[32:])
-			subkey.SetCreated(config.created)
-			subkey.SetExpires(config.expires)
+	if config.load == "" {
+		if config.verbose {
+			for _, uid := range config.uids {
+				fmt.Fprintf(os.Stderr, "User ID: %s\n", uid)
+			}
+		}
+
+		seed := config.seed
+		config.derived = seed == nil
+		var cache string // cache entry name
+		if config.cache && config.cacheTTL > 0 {
+			cache = cacheName(config)
+			var err error
+			seed, err = cacheLoad(cache)
+			if err != nil && !config.quiet {
+				fmt.Fprintf(os.Stderr, "warning: --cache: %s\n", err)
+			}
+			if seed != nil && config.verbose {
+				fmt.Fprintf(os.Stderr, "Seed: from cache\n")
+			}
+		} else if config.cache {
+			// A zero TTL forgets the seed
+			err := cacheClear(cacheName(config))
+			if err != nil && !config.quiet {
+				fmt.Fprintf(os.Stderr, "warning: --cache: %s\n", err)
+			}
+		}
+		if seed == nil {
+			seed = deriveSeed(config, config.uids[0])
+
+			if cache != "" {
+				err := cacheStore(cache, seed, config.cacheTTL)
+				if err != nil && !config.quiet {
+					fmt.Fprintf(os.Stderr, "warning: --cache: %s\n", err)
+				}
+			}
+		}
+
+		if config.exportMn || config.splitN > 0 {
+			exportMnemonic(config, seed)
+			wipe(seed)
+			return
+		}
+
+		keys := completeKey{
+			key:     &key,
+			subkey:  &subkey,
+			authkey: &authkey,
+			signsub: &signsub,
+		}
+		keys.derive(config, seed)
+		for _, uid := range config.uids {
+			userids = append(userids, openpgp.UserID{ID: []byte(uid)})
 		}
+		wipe(seed)
 
 	} else {
 		// Load keys from previous output
-		packets, err := parsePackets(config.load)
+		packets, err := parsePackets(config.load,
+			openpgp.ArmorPrivateKey, openpgp.ArmorPublicKey)
 		if err != nil {
 			fatal("%s", err)
 		}
 		if len(packets) < 3 {
 			fatal("invalid input (too few packets)")
 		}
-		config.created = time.Now().Unix()
+		// Public keys are only good for verification and encryption
+		public := packets[0].Tag == 6 &&
+			(config.cmd == cmdVerify || config.cmd == cmdEncrypt)
+		if packets[0].Tag != 5 && !public {
+			fatal("%s: not a secret key", config.load)
+		}
+		config.created = currentTime()
 
 		if err := key.Load(packets[0], nil); err != nil {
 			if err != openpgp.ErrDecryptKey {
 				fatal("%s", err)
 			}
+			noPrompt(config, "a protection passphrase")
 			pinentry := config.pinentry
 			repeat := config.protectQuery - 1
 			password, err := readPassphrase(pinentry, "protection", repeat)
@@ -500,66 +2707,488 @@ func main() {
 			}
 		}
 
-		if err := userid.Load(packets[1]); err != nil {
-			fatal("%s", err)
+		extendSubkey := config.subkey && config.cmd == cmdKey
+		config.subkey = false
+		authLoaded := false
+		signLoaded := false
+		for i, packet := range packets {
+			if i == 0 {
+				continue // primary key
+			}
+			switch packet.Tag {
+			case 13: // User ID Packet
+				var userid openpgp.UserID
+				if err := userid.Load(packet); err != nil {
+					fatal("%s", err)
+				}
+				verified := false
+				for _, sig := range signatures(packets, i) {
+					if key.VerifyUserID(&userid, sig) == nil {
+						verified = true
+						break
+					}
+				}
+				if !verified {
+					fatalCode(exitVerify, "%s: bad self-signature on user ID %q",
+						config.load, userid.ID)
+				}
+				if config.verbose {
+					fmt.Fprintf(os.Stderr, "User ID: %s\n", userid.ID)
+				}
+				userids = append(userids, userid)
+			case 17: // User Attribute Packet
+				// Only photo IDs are kept, others are dropped
+				var photo openpgp.PhotoID
+				err := photo.Load(packet)
+				if err == openpgp.ErrUnsupportedPacket {
+					continue
+				} else if err != nil {
+					fatal("%s", err)
+				}
+				verified := false
+				for _, sig := range signatures(packets, i) {
+					if key.VerifyPhoto(&photo, sig) == nil {
+						verified = true
+						break
+					}
+				}
+				if !verified {
+					fatalCode(exitVerify,
+						"%s: bad self-signature on photo ID", config.load)
+				}
+				photos = append(photos, photo)
+			case 7, 14: // Secret-Subkey or Public-Subkey Packet
+				// Subkeys using other algorithms, such as those in GnuPG
+				// exports, are skipped.
+				password := config.protectPassword
+				algo := packet.Body[5]
+				if algo == 1 {
+					// RSA serves either purpose, so the key flags in
+					// its binding signature decide
+					var sk openpgp.SignKey
+					err := sk.Load(packet, password)
+					if err == openpgp.ErrUnsupportedPacket {
+						continue
+					} else if err != nil {
+						fatal("%s", err)
+					}
+					sig := binding(config, &key, sk.SubPubPacket(), packets, i)
+					algo = 22
+					if sig.KeyFlags&0x0c != 0 {
+						algo = 18
+					}
+				}
+				switch algo {
+				case 19, 22, 27, 28: // ECDSA, EdDSA, Ed25519, Ed448: signing/auth
+					var sk openpgp.SignKey
+					err := sk.Load(packet, password)
+					if err == openpgp.ErrUnsupportedPacket {
+						continue
+					} else if err != nil {
+						fatal("%s", err)
+					}
+					sub := sk.SubPubPacket()
+					sig := binding(config, &key, sub, packets, i)
+					if sig.KeyFlags&0x02 != 0 {
+						signsub, signLoaded = sk, true
+					} else {
+						authkey, authLoaded = sk, true
+					}
+				case 18, 25, 26: // ECDH, X25519 or X448, an encryption subkey
+					var ek openpgp.EncryptKey
+					err := ek.Load(packet, password)
+					if err == openpgp.ErrUnsupportedPacket {
+						continue
+					} else if err != nil {
+						fatal("%s", err)
+					}
+					binding(config, &key, ek.PubPacket(), packets, i)
+					subkey, config.subkey = ek, true
+				}
+			}
 		}
-		if config.verbose {
-			fmt.Fprintf(os.Stderr, "User ID: %s\n", userid.ID)
+		if config.auth && !authLoaded && !public {
+			// Not present, but it can be derived from the primary key
+			authseed := subseed(key.Seckey(), "auth")
+			authkey.SetAlgorithm(key.Algorithm())
+			seckey := expandSeed(authseed, authkey.SeedSize())
+			authkey.Seed(seckey)
+			wipe(seckey)
+			wipe(authseed)
+			authkey.SetCreated(key.Created())
+			authkey.SetExpires(config.expires)
+			authkey.SetVersion(key.Version())
 		}
-
-		config.subkey = false
-		if len(packets) >= 5 {
-			password := config.protectPassword
-			if err := subkey.Load(packets[3], password); err != nil {
-				fatal("%s", err)
+		config.auth = config.auth || authLoaded
+		if config.signSub && !signLoaded && !public {
+			signseed := subseed(key.Seckey(), "sign")
+			signsub.SetAlgorithm(key.Algorithm())
+			seckey := expandSeed(signseed, signsub.SeedSize())
+			signsub.Seed(seckey)
+			wipe(seckey)
+			wipe(signseed)
+			signsub.SetCreated(key.Created())
+			signsub.SetExpires(config.expires)
+			signsub.SetVersion(key.Version())
+		}
+		config.signSub = config.signSub || signLoaded
+		if len(userids) == 0 {
+			fatal("invalid input (no user ID)")
+		}
+		if extendSubkey && !config.subkey && !public {
+			// Unlike the other subkeys, the encryption subkey isn't
+			// derived from the primary key, so derive it again from
+			// the passphrase, salted with the original user ID.
+			if config.input == nil {
+				noPrompt(config, "a passphrase to derive -s")
 			}
+			seed := deriveSeed(config, string(userids[0].ID))
+			primary, encrypt := keySeeds(seed, config.kdf)
+			var derived openpgp.SignKey
+			derived.SetAlgorithm(key.Algorithm())
+			seckey := expandSeed(primary, derived.SeedSize())
+			derived.Seed(seckey)
+			if !bytes.Equal(derived.Seckey(), key.Seckey()) {
+				fatalCode(exitPassphrase,
+					"%s: passphrase or KDF options do not match this key",
+					config.load)
+			}
+			subkey.SetAlgorithm(key.Algorithm())
+			subseckey := expandSeed(encrypt, subkey.SeedSize())
+			subkey.Seed(subseckey)
+			subkey.SetCreated(key.Created())
+			subkey.SetExpires(config.expires)
+			subkey.SetVersion(key.Version())
 			config.subkey = true
+			derived.Wipe()
+			wipe(seckey)
+			wipe(subseckey)
+			wipe(primary) // may alias seed
+			wipe(encrypt)
+			wipe(seed)
+		}
+		if config.cmd == cmdKey {
+			// Extend the key with any new user IDs
+			for _, uid := range config.uids {
+				dup := false
+				for _, userid := range userids {
+					dup = dup || string(userid.ID) == uid
+				}
+				if !dup {
+					userids = append(userids, openpgp.UserID{ID: []byte(uid)})
+				}
+			}
+		}
+		needSubkey := config.cmd == cmdEncrypt || config.cmd == cmdDecrypt ||
+			config.format == formatAge
+		if needSubkey && !config.subkey {
+			fatal("%s: no encryption subkey", config.load)
+		}
+	}
+
+	checkAlgorithm(config, key.Algorithm())
+	if key.Version() == 6 && config.protect {
+		fatalUsage("version 6 keys cannot be protected (--protect) yet")
+	}
+	if key.Version() == 6 && config.toCard {
+		fatalUsage("version 6 keys cannot be written to a card (--to-card) yet")
+	}
+	if key.Version() == 6 && config.strip {
+		fatalUsage("version 6 keys cannot be stripped (--strip-primary) yet")
+	}
+	if config.strip && !config.subkey && !config.signSub && !config.auth {
+		fatalUsage("--strip-primary requires a subkey (-s, --sign-subkey, " +
+			"or --auth-subkey)")
+	}
+	if key.Version() == 6 && config.preset != "" {
+		fatalUsage("version 6 keys cannot be used with --preset")
+	}
+	if key.Version() == 6 && config.revokers != nil {
+		// RFC 9580 deprecates Revocation Key subpackets for version 6
+		fatalUsage("version 6 keys cannot designate revokers (--revoker)")
+	}
+	if key.Version() == 6 && config.format == formatPGP {
+		switch config.cmd {
+		case cmdEncrypt, cmdDecrypt:
+			fatalUsage("version 6 keys cannot encrypt or decrypt yet")
 		}
 	}
 
+	if config.photo != nil {
+		photos = append(photos, openpgp.PhotoID{JPEG: config.photo})
+	}
+
+	key.SetSigExpires(config.sigExpires)
+	if config.prefs != nil {
+		key.SetPreferences(*config.prefs)
+	}
+	if config.hash != 0 {
+		key.SetHash(config.hash)
+		signsub.SetHash(config.hash)
+		authkey.SetHash(config.hash)
+	}
+
 	keyid := key.KeyID()
 	if config.verbose {
-		fmt.Fprintf(os.Stderr, "Key ID: %X\n", keyid)
+		fmt.Fprintf(os.Stderr, "Key ID: %s\n",
+			formatKeyID(keyid, config.fprFormat))
+	}
+	// Only key output creates a key, and otherwise it's just used
+	if config.cmd != cmdKey || config.load != "" {
+		status(config, "KEY_CONSIDERED %X 0", keyid)
+	} else if config.subkey || config.auth || config.signSub {
+		status(config, "KEY_CREATED B %X", keyid)
+	} else {
+		status(config, "KEY_CREATED P %X", keyid)
+	}
+	if !checkKeyID(keyid, config.check) {
+		fatalCode(exitCheck, "Key ID does not match --check (-c):\n  %X != %X",
+			keyid, config.check)
+	}
+
+	// Data signatures are made by the signing subkey, if present
+	signer := &key
+	if config.signSub {
+		signer = &signsub
+	}
+	sigTime := config.sigTime
+	if sigTime == 0 && config.status != nil {
+		// Status lines report the date, so it must not drift
+		sigTime = time.Now().Unix()
+	}
+	signer.SetSigTime(sigTime)
+
+	// With user IDs from -u, data signatures name the first by default
+	signerUID := config.signerUID
+	if signerUID == "" && config.uids != nil && !config.noSigner {
+		signerUID = string(userids[0].ID)
+	}
+	if signerUID != "" {
+		found := false
+		for _, userid := range userids {
+			found = found || string(userid.ID) == signerUID
+		}
+		if !found {
+			fatalUsage("--signer-uid: not a user ID of this key: %s", signerUID)
+		}
+		signer.SetSignerUID(signerUID)
+	}
+
+	for _, note := range config.notes {
+		key.AddNotation(note.Name, note.Value)
+		if config.signSub {
+			signsub.AddNotation(note.Name, note.Value)
+		}
+	}
+	key.SetPolicyURI(config.policyURI)
+	key.SetKeyServer(config.keyServer)
+	if config.signSub {
+		signsub.SetPolicyURI(config.policyURI)
 	}
-	checked := keyid[len(keyid)-len(config.check):]
-	if !bytes.Equal(config.check, checked) {
-		fatal("Key ID does not match --check (-c):\n  %X != %X",
-			checked, config.check)
+
+	if config.confirm {
+		// Last chance to catch a typo before anything is output
+		var prompt strings.Builder
+		fmt.Fprintf(&prompt, "Key ID: %s\n", formatFingerprint(keyid))
+		for _, userid := range userids {
+			fmt.Fprintf(&prompt, "User ID: %s\n", userid.ID)
+		}
+		prompt.WriteString("proceed?")
+		ok, err := terminalConfirm(prompt.String())
+		if err != nil {
+			fatalCode(exitPrompt, "--confirm: %s", err)
+		}
+		if !ok {
+			fatal("not confirmed, no key output")
+		}
+	}
+
+	if config.output != "" && config.cmd != cmdRelease {
+		config.out = createOutput(config.output, 0600)
 	}
 
 	switch config.cmd {
 	case cmdKey:
-		ck := completeKey{&key, &userid, &subkey}
-		switch config.format {
-		case formatPGP:
-			ck.outputPGP(config)
-		case formatSSH:
-			ck.outputSSH(config)
-		case formatX509:
-			ck.outputX509(config)
+		ck := completeKey{&key, userids, photos, &subkey, &authkey, &signsub}
+		if config.agent {
+			uid := userids[0].ID
+			err := addSSHAgent(key.Pubkey(), key.Seckey(), uid, config.agentLife)
+			if err != nil {
+				fatal("--add-to-agent: %s", err)
+			}
+			if !config.quiet {
+				fmt.Fprintf(os.Stderr, "Identity added: %s\n", uid)
+			}
+		} else if config.wkdDir != "" {
+			// WKD serves binary keys
+			config.armor = false
+			paths, err := wkdExport(config.wkdDir, ck.encodePGP(config), userids)
+			if err != nil {
+				fatal("--wkd-export: %s", err)
+			}
+			if !config.quiet {
+				for _, path := range paths {
+					fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+				}
+			}
+		} else if config.fprOnly {
+			fpr := formatKeyID(key.KeyID(), config.fprFormat)
+			writeOutput(config, []byte(fpr+"\n"))
+		} else if config.dnsRecord {
+			// RDATA is the binary key
+			config.armor = false
+			records, err := dnsRecords(ck.encodePGP(config), userids)
+			if err != nil {
+				fatal("--dns-record: %s", err)
+			}
+			for _, record := range records {
+				if _, err := fmt.Fprintln(config.out, record); err != nil {
+					fatal("%s", err)
+				}
+			}
+		} else if config.agentDir != "" {
+			grips, err := ck.agentExport(config.agentDir, config)
+			if err != nil {
+				fatal("--export-agent-keys: %s", err)
+			}
+			if !config.quiet {
+				for _, grip := range grips {
+					fmt.Fprintf(os.Stderr, "Keygrip = %X\n", grip)
+				}
+			}
+			ck.output(config)
+		} else if config.toCard {
+			serial, err := ck.toCard(config)
+			if err != nil {
+				fatal("--to-card: %s", err)
+			}
+			if !config.quiet {
+				fmt.Fprintf(os.Stderr, "Keys written to card %s\n", serial)
+			}
+			ck.output(config)
+		} else {
+			ck.output(config)
+		}
+		var outputs []jsonOutput
+		if config.outBytes > 0 {
+			name := config.output
+			if name == "" {
+				name = "-"
+			}
+			outputs = append(outputs, jsonOutput{name, config.outBytes})
+		}
+		if config.pubOut != "" {
+			closeOutput(config)
+			config.public = true
+			config.out = createOutput(config.pubOut, 0644)
+			config.outBytes = 0
+			ck.output(config)
+			outputs = append(outputs, jsonOutput{config.pubOut, config.outBytes})
+		}
+		if config.sendKey != "" {
+			config.public = true
+			config.armor = true
+			if err := sendKey(config.sendKey, ck.encodePGP(config)); err != nil {
+				fatal("--send-key: %s", err)
+			}
+			if !config.quiet {
+				fmt.Fprintf(os.Stderr, "Sent key to %s\n", config.sendKey)
+			}
+		}
+		if config.jsonFd != 0 {
+			writeJSON(config, ck.metadata(config, outputs))
+		}
+
+	case cmdEncrypt:
+		in := os.Stdin
+		if len(config.args) == 1 {
+			var err error
+			in, err = os.Open(config.args[0])
+			if err != nil {
+				fatal("%s", err)
+			}
+			defer in.Close()
 		}
+		output, err := subkey.Encrypt(in)
+		if err != nil {
+			fatal("%s", err)
+		}
+		writeMessage(config, output)
+
+	case cmdDecrypt:
+		decrypt(config, &subkey)
+
+	case cmdRevoke:
+		reason := config.revokeReason
+		comment := config.revokeComment
+		sig := key.Revoke(reason, comment, config.sigTime)
+		armored := openpgp.Armor(sig, armorHeaders(config)...)
+		if _, err := config.out.Write(armored); err != nil {
+			fatal("%s", err)
+		}
+
+	case cmdCertify:
+		certify(config, &key)
+
+	case cmdAgent:
+		agent(config, &key, userids[0].ID)
+
+	case cmdTransition:
+		transition(config, &key, next, nextPub)
+
+	case cmdRelease:
+		release(config, signer)
+
+	case cmdVerify:
+		ring := []keyringEntry{{key: key, userids: userids}}
+		if config.signSub {
+			signer := keyringEntry{key: signsub, userids: userids}
+			ring = append(ring, signer)
+		}
+		verify(config, ring)
 
 	case cmdSign:
+		if config.preset == "apt" {
+			signApt(config, signer)
+			break
+		}
+		if config.pgpMIME {
+			signMIME(config, signer)
+			break
+		}
+		sign := func(out io.Writer, in io.Reader, name string) error {
+			return signData(config, &key, signer, out, in, name)
+		}
+
 		if len(config.args) == 0 {
 			// stdin to stdout
-			output, err := key.Sign(os.Stdin)
-			if err != nil {
+			if err := sign(config.out, os.Stdin, ""); err != nil {
 				fatal("%s", err)
 			}
-			if config.armor {
-				output = openpgp.Armor(output)
-			}
-			_, err = os.Stdout.Write(output)
+
+		} else if config.preset == "rpm" {
+			// The one file to the output
+			in, err := os.Open(config.args[0])
 			if err != nil {
 				fatal("%s", err)
 			}
+			err = sign(config.out, in, config.args[0])
+			in.Close()
+			if err != nil {
+				fatal("%s: %s", err, config.args[0])
+			}
 
 		} else {
 			// file by file
 			var ext string
-			if config.armor {
+			switch {
+			case config.armor && config.format == formatPGP:
 				ext = ".asc"
-			} else {
+			case config.inline:
+				ext = ".gpg"
+			case config.format == formatMinisign:
+				ext = ".minisig"
+			default:
 				ext = ".sig"
 			}
 
@@ -577,29 +3206,21 @@ func main() {
 					fatal("%s: %s", err, outfile)
 				}
 
-				// Process input, cleaning up on error
-				output, err := key.Sign(in)
-				if err != nil {
-					out.Close()
-					os.Remove(outfile)
-					fatal("%s: %s", err, infile)
-				}
-				if config.armor {
-					output = openpgp.Armor(output)
+				// Process input into output, cleaning up on error
+				err = sign(out, in, infile)
+				in.Close()
+				if cerr := out.Close(); err == nil {
+					err = cerr
 				}
-
-				// Write output, cleaning up on error
-				_, err = out.Write(output)
-				out.Close()
 				if err != nil {
 					os.Remove(outfile)
-					fatal("%s: %s", err, outfile)
+					fatal("%s: %s", err, infile)
 				}
 			}
 		}
 
 	case cmdClearsign:
-		out := bufio.NewWriter(os.Stdout)
+		out := bufio.NewWriter(config.out)
 		var in io.Reader
 		var f *os.File
 		if len(config.args) == 1 {
@@ -608,9 +3229,9 @@ func main() {
 			if err != nil {
 				fatal("%s", err)
 			}
-			in = key.Clearsign(f)
+			in = signer.Clearsign(f)
 		} else {
-			in = key.Clearsign(os.Stdin)
+			in = signer.Clearsign(os.Stdin)
 		}
 
 		// Pump input through filter
@@ -620,110 +3241,570 @@ func main() {
 		if err := out.Flush(); err != nil {
 			fatal("%s", err)
 		}
+		sigCreated(config, 'C', signer, 0x01)
 
 		if f != nil {
 			f.Close()
 		}
 	}
-}
 
-type completeKey struct {
-	key    *openpgp.SignKey
-	userid *openpgp.UserID
-	subkey *openpgp.EncryptKey
+	closeOutput(config)
 }
 
-func (k *completeKey) outputPGP(config *config) {
-	key := k.key
-	userid := k.userid
-	subkey := k.subkey
+// Read the passphrase and derive the seed for a key whose first user
+// ID is uid.
+func deriveSeed(config *config, uid string) []byte {
+	// Read the passphrase from the terminal
+	var err error
+	if config.input != nil {
+		config.passphrase, err = readLine(config.input)
+		keepSecret(config.passphrase)
+	} else {
+		pinentry := config.pinentry
+		repeat := config.repeat
+		config.passphrase, err = readPassphrase(pinentry, "", repeat)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
 
-	flags := 0
-	if config.subkey {
-		flags |= openpgp.FlagMDC
+	// Before version 4, Unicode text isn't normalized before
+	// derivation, so the same text may have several byte encodings,
+	// and keys.
+	if !config.quiet && config.kdf.version < 4 && !isASCII(config.passphrase) {
+		warning := "warning: passphrase is not ASCII, " +
+			"its exact encoding is significant (see --kdf-version 4)\n"
+		os.Stderr.WriteString(warning)
 	}
 
-	var buf bytes.Buffer
-	if config.public {
-		buf.Write(key.PubPacket())
-		buf.Write(userid.Packet())
-		buf.Write(key.SelfSign(userid, config.created, flags))
-		if config.subkey {
-			buf.Write(subkey.PubPacket())
-			buf.Write(key.Bind(subkey, config.created))
+	// The key is derived from nothing else, so the passphrase must be
+	// strong enough to resist offline guessing
+	bits := passphraseEntropy(config.passphrase)
+	if config.verbose {
+		fmt.Fprintf(os.Stderr, "Passphrase: about %.0f bits\n", bits)
+	}
+	threshold := float64(weakEntropy)
+	if config.minEntropy > 0 {
+		threshold = float64(config.minEntropy)
+	}
+	if bits < threshold {
+		if config.minEntropy > 0 && !config.force {
+			fatalCode(exitPassphrase, "passphrase is too weak, about %.0f bits "+
+				"(--min-entropy %d, or --force)", bits, config.minEntropy)
 		}
-	} else {
-		if config.protect {
-			buf.Write(key.EncPacket(getProtect(config)))
-		} else {
-			buf.Write(key.Packet())
+		if !config.quiet {
+			fmt.Fprintf(os.Stderr, "warning: passphrase is weak, "+
+				"about %.0f bits\n", bits)
 		}
-		buf.Write(userid.Packet())
-		buf.Write(key.SelfSign(userid, config.created, flags))
-		if config.subkey {
-			if config.protect {
-				buf.Write(subkey.EncPacket(config.protectPassword))
-			} else {
-				buf.Write(subkey.Packet())
-			}
-			buf.Write(key.Bind(subkey, config.created))
+	}
+
+	// Run KDF on passphrase
+	if config.verbose {
+		fmt.Fprintf(os.Stderr, "KDF: %s\n", config.kdf)
+	}
+	salt := normalize([]byte(uid), config.kdf)
+	if config.keyfile != nil {
+		// Mix the key file into the salt as a second factor
+		salt = append(salt, config.keyfile...)
+	}
+	if config.fido2 != "" {
+		// Likewise the security key's secret
+		secret, err := fido2Secret(config.fido2, touchPrompt(config))
+		if err != nil {
+			fatalCode(exitKDF, "--fido2: %s", err)
 		}
+		salt = append(salt, secret...)
+		wipe(secret)
 	}
-	output := buf.Bytes()
+	runtime.GOMAXPROCS(config.threads)
+	done := func() {}
+	if !config.quiet {
+		done = progress("Deriving key")
+	}
+	status(config, "KDF_BEGIN %s", config.kdf)
+	passphrase := normalize(config.passphrase, config.kdf)
+	keepSecret(passphrase)
+	seed := kdf(passphrase, salt, config.kdf)
+	wipe(passphrase)
+	keepSecret(seed)
+	status(config, "KDF_END")
+	done()
+	return seed
+}
 
-	if config.armor {
-		output = openpgp.Armor(output)
+// Create an output file with the given permissions, which also apply
+// if the file already exists.
+func createOutput(filename string, perm os.FileMode) *os.File {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	f, err := os.OpenFile(filename, flags, perm)
+	if err != nil {
+		fatal("%s", err)
 	}
-	if _, err := os.Stdout.Write(output); err != nil {
+	if err := f.Chmod(perm); err != nil {
 		fatal("%s", err)
 	}
+	return f
 }
 
-func getProtect(config *config) []byte {
-	if config.protectPassword == nil {
-		if config.protectQuery > 0 {
-			var err error
-			pinentry := config.pinentry
-			repeat := config.protectQuery - 1
-			password, err := readPassphrase(pinentry, "protection", repeat)
-			if err != nil {
-				fatal("%s", err)
-			}
-			config.protectPassword = password
-		} else if config.protectPassword == nil {
-			config.protectPassword = config.passphrase
+// Write key output, counting its length for --json.
+func writeOutput(config *config, b []byte) {
+	if _, err := config.out.Write(b); err != nil {
+		fatal("%s", err)
+	}
+	config.outBytes += len(b)
+}
+
+// Close the output file, if it's not standard output.
+func closeOutput(config *config) {
+	if config.out != os.Stdout {
+		if err := config.out.Close(); err != nil {
+			fatal("%s", err)
 		}
 	}
-	return config.protectPassword
 }
 
-func (k *completeKey) outputSSH(config *config) {
-	pubkey := k.key.Pubkey()
-	seckey := k.key.Seckey()
-	uid := k.userid.ID
-	if !config.public {
-		var b []byte
-		if config.protect {
-			b = secSSH(pubkey, seckey, uid, getProtect(config), sshRounds)
-		} else {
-			b = secSSH(pubkey, seckey, uid, nil, 0)
+// Returns the armor headers requested by the user.
+func armorHeaders(config *config) []openpgp.ArmorHeader {
+	var headers []openpgp.ArmorHeader
+	if config.emitVer {
+		v := "passphrase2pgp " + version
+		headers = append(headers, openpgp.ArmorHeader{Name: "Version", Value: v})
+	}
+	for _, comment := range config.comments {
+		h := openpgp.ArmorHeader{Name: "Comment", Value: comment}
+		headers = append(headers, h)
+	}
+	return headers
+}
+
+// Format a fingerprint (40 or 64 hex digits) like GnuPG, in groups of
+// four hex digits with a wider gap at the halfway point.
+func formatFingerprint(fpr []byte) string {
+	hex := fmt.Sprintf("%X", fpr)
+	var b strings.Builder
+	for i := 0; i < len(hex); i += 4 {
+		if i == len(hex)/2 {
+			b.WriteByte(' ')
 		}
-		if _, err := os.Stdout.Write(b); err != nil {
-			fatal("%s", err)
+		if i > 0 {
+			b.WriteByte(' ')
 		}
+		b.WriteString(hex[i : i+4])
 	}
-	b := pubSSH(pubkey, uid)
-	if _, err := os.Stdout.Write(b); err != nil {
+	return b.String()
+}
+
+// Format a fingerprint per --fingerprint-format: plain hex (default),
+// spaced for reading, as a long (64-bit) or short (32-bit) Key ID, or as
+// a GnuPG --with-colons "fpr" record.
+func formatKeyID(fpr []byte, format string) string {
+	// Version 4 Key IDs are the low bits, version 6 the high bits
+	keyid := fpr[len(fpr)-8:]
+	if len(fpr) == 32 {
+		keyid = fpr[:8]
+	}
+	switch format {
+	case "spaced":
+		return formatFingerprint(fpr)
+	case "long":
+		return fmt.Sprintf("%X", keyid)
+	case "short":
+		return fmt.Sprintf("%X", keyid[4:])
+	case "colons":
+		return fmt.Sprintf("fpr:::::::::%X:", fpr)
+	}
+	return fmt.Sprintf("%X", fpr)
+}
+
+// Write an encrypted message to the output, streaming it through the
+// armor encoder if requested rather than armoring a second copy.
+func writeMessage(config *config, msg []byte) {
+	var w io.Writer = config.out
+	var armor io.WriteCloser
+	if config.armor {
+		headers := armorHeaders(config)
+		armor = openpgp.ArmorWriter(config.out, openpgp.ArmorMessage, headers...)
+		w = armor
+	}
+	if _, err := w.Write(msg); err != nil {
 		fatal("%s", err)
 	}
+	if armor != nil {
+		if err := armor.Close(); err != nil {
+			fatal("%s", err)
+		}
+	}
 }
 
-func (k *completeKey) outputX509(config *config) {
-	key := k.key
-	uid := string(k.userid.ID)
+type completeKey struct {
+	key     *openpgp.SignKey
+	userids []openpgp.UserID
+	photos  []openpgp.PhotoID
+	subkey  *openpgp.EncryptKey
+	authkey *openpgp.SignKey
+	signsub *openpgp.SignKey
+}
 
-	// Serial Number is a truncated SHA-256 digest of the public key.
-	h := sha256.New()
+// Returns the size of the seed needed for the configured keys. Version
+// 3 derives every key from the whole seed, but before then the primary
+// key alone needs only the first half.
+func seedSize(config *config) int {
+	if config.subkey || config.kdf.version >= 3 {
+		return 64
+	}
+	return 32
+}
+
+// Derive the primary key, and any configured subkeys, from the seed.
+func (k *completeKey) derive(config *config, seed []byte) {
+	version := 4
+	if config.v6 {
+		version = 6
+	}
+	primary, encrypt := keySeeds(seed, config.kdf)
+	k.key.SetAlgorithm(config.algo)
+	seckey := expandSeed(primary, k.key.SeedSize())
+	k.key.Seed(seckey)
+	wipe(seckey)
+	k.key.SetCreated(config.created)
+	k.key.SetVersion(version)
+	if config.vanity != nil {
+		config.created = vanity(k.key, config.vanity)
+		if !config.quiet {
+			fmt.Fprintf(os.Stderr, "Vanity: --time=%d\n", config.created)
+		}
+	}
+	k.key.SetExpires(config.expires)
+	// Each rotation is one second newer than the last, so that
+	// implementations prefer the latest subkeys.
+	subCreated := config.created + int64(config.rotate)
+	if config.subkey {
+		rotated := rotateSeed(encrypt, config.rotate)
+		k.subkey.SetAlgorithm(config.algo)
+		subseckey := expandSeed(rotated, k.subkey.SeedSize())
+		k.subkey.Seed(subseckey)
+		wipe(subseckey)
+		wipe(rotated) // may alias encrypt
+		k.subkey.SetCreated(subCreated)
+		k.subkey.SetExpires(config.expires)
+		k.subkey.SetVersion(version)
+	}
+	if config.auth {
+		authseed := subseed(k.key.Seckey(), "auth")
+		rotated := rotateSeed(authseed, config.rotate)
+		k.authkey.SetAlgorithm(config.algo)
+		subseckey := expandSeed(rotated, k.authkey.SeedSize())
+		k.authkey.Seed(subseckey)
+		wipe(subseckey)
+		wipe(rotated)
+		wipe(authseed)
+		k.authkey.SetCreated(subCreated)
+		k.authkey.SetExpires(config.expires)
+		k.authkey.SetVersion(version)
+	}
+	if config.signSub {
+		signseed := subseed(k.key.Seckey(), "sign")
+		rotated := rotateSeed(signseed, config.rotate)
+		k.signsub.SetAlgorithm(config.algo)
+		subseckey := expandSeed(rotated, k.signsub.SeedSize())
+		k.signsub.Seed(subseckey)
+		wipe(subseckey)
+		wipe(rotated)
+		wipe(signseed)
+		k.signsub.SetCreated(subCreated)
+		k.signsub.SetExpires(config.expires)
+		k.signsub.SetVersion(version)
+	}
+	wipe(primary) // may alias seed
+	wipe(encrypt)
+}
+
+// Write the key to the output in the configured format.
+func (k *completeKey) output(config *config) {
+	switch config.format {
+	case formatPGP:
+		k.outputPGP(config)
+	case formatSSH:
+		k.outputSSH(config)
+	case formatX509:
+		k.outputX509(config)
+	case formatMinisign, formatSignify:
+		k.outputMinisign(config)
+	case formatAge:
+		k.outputAge(config)
+	case formatPEM, formatJWK:
+		k.outputRaw(config)
+	}
+}
+
+func (k *completeKey) outputPGP(config *config) {
+	output := k.encodePGP(config)
+	if config.qr {
+		if config.qrFpr {
+			// URI scheme understood by OpenKeychain and others
+			output = []byte(fmt.Sprintf("OPENPGP4FPR:%X", k.key.KeyID()))
+		}
+		writeQR(config, output)
+		return
+	}
+	writeOutput(config, output)
+}
+
+// Encode the key in OpenPGP format, armored if configured.
+func (k *completeKey) encodePGP(config *config) []byte {
+	key := k.key
+	subkey := k.subkey
+	authkey := k.authkey
+	signsub := k.signsub
+	key.SetKeyFlags(config.primFlags)
+	if subkey != nil {
+		subkey.SetKeyFlags(config.subFlags)
+	}
+
+	flags := 0
+	if config.subkey {
+		flags |= openpgp.FlagMDC
+		if config.aead {
+			flags |= openpgp.FlagAEAD
+		}
+		if config.seipdv2 {
+			flags |= openpgp.FlagSEIPDv2
+		}
+	}
+
+	var buf bytes.Buffer
+	revokers := func() {
+		if config.revokers != nil {
+			buf.Write(key.DesignateRevokers(config.revokers, config.created))
+		}
+	}
+	userids := func() {
+		for i := range k.userids {
+			userid := &k.userids[i]
+			uflags := flags
+			if i == 0 && len(k.userids) > 1 {
+				uflags |= openpgp.FlagPrimary
+			}
+			buf.Write(userid.Packet())
+			buf.Write(key.SelfSign(userid, config.created, uflags))
+		}
+		for i := range k.photos {
+			photo := &k.photos[i]
+			buf.Write(photo.Packet())
+			buf.Write(key.SelfSignPhoto(photo, config.created, flags))
+		}
+	}
+
+	if config.public {
+		buf.Write(key.PubPacket())
+		revokers()
+		userids()
+		if config.subkey {
+			buf.Write(subkey.PubPacket())
+			buf.Write(key.Bind(subkey, config.created))
+		}
+		if config.signSub {
+			buf.Write(signsub.SubPubPacket())
+			buf.Write(key.BindSign(signsub, config.created))
+		}
+		if config.auth {
+			buf.Write(authkey.SubPubPacket())
+			buf.Write(key.BindAuth(authkey, config.created))
+		}
+	} else {
+		switch {
+		case config.strip:
+			// Only the subkeys are usable, so the primary is a stub
+			if config.protect {
+				getProtect(config) // for the subkeys
+			}
+			buf.Write(key.StubPacket())
+		case config.protect:
+			buf.Write(key.EncPacket(getProtect(config)))
+		default:
+			buf.Write(key.Packet())
+		}
+		revokers()
+		userids()
+		if config.subkey {
+			if config.protect {
+				buf.Write(subkey.EncPacket(config.protectPassword))
+			} else {
+				buf.Write(subkey.Packet())
+			}
+			buf.Write(key.Bind(subkey, config.created))
+		}
+		if config.signSub {
+			if config.protect {
+				password := config.protectPassword
+				buf.Write(signsub.EncSubPacket(password))
+			} else {
+				buf.Write(signsub.SubPacket())
+			}
+			buf.Write(key.BindSign(signsub, config.created))
+		}
+		if config.auth {
+			if config.protect {
+				password := config.protectPassword
+				buf.Write(authkey.EncSubPacket(password))
+			} else {
+				buf.Write(authkey.SubPacket())
+			}
+			buf.Write(key.BindAuth(authkey, config.created))
+		}
+	}
+	output := buf.Bytes()
+
+	if config.armor {
+		headers := armorHeaders(config)
+		if config.public {
+			fpr := formatFingerprint(k.key.KeyID())
+			headers = append(headers, openpgp.ArmorHeader{Name: "Comment", Value: fpr})
+		}
+		output = openpgp.Armor(output, headers...)
+	}
+	return output
+}
+
+// Write data to the output as a QR code: a PNG image when writing to a
+// file, otherwise block characters for the terminal.
+func writeQR(config *config, data []byte) {
+	modules, err := qrEncode(data)
+	if err != nil {
+		fatal("%s (%d bytes), try --qr=fingerprint", err, len(data))
+	}
+	if config.output != "" {
+		err = qrPNG(config.out, modules)
+	} else {
+		err = qrText(config.out, modules)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+}
+
+func getProtect(config *config) []byte {
+	if config.protectPassword == nil {
+		if config.protectQuery > 0 {
+			var err error
+			pinentry := config.pinentry
+			repeat := config.protectQuery - 1
+			password, err := readPassphrase(pinentry, "protection", repeat)
+			if err != nil {
+				fatal("%s", err)
+			}
+			config.protectPassword = password
+		} else if config.protectPassword == nil {
+			config.protectPassword = config.passphrase
+		}
+	}
+	return config.protectPassword
+}
+
+func (k *completeKey) outputSSH(config *config) {
+	pubkey := k.key.Pubkey()
+	seckey := k.key.Seckey()
+	uid := k.userids[0].ID
+	if !config.public {
+		var b []byte
+		if config.protect {
+			b = secSSH(pubkey, seckey, uid, getProtect(config), sshRounds)
+		} else {
+			b = secSSH(pubkey, seckey, uid, nil, 0)
+		}
+		writeOutput(config, b)
+	}
+	b := pubSSH(pubkey, uid)
+	writeOutput(config, b)
+}
+
+func (k *completeKey) outputMinisign(config *config) {
+	pubkey := k.key.Pubkey()
+	seckey := k.key.Seckey()
+	if !config.public {
+		var password []byte
+		if config.protect {
+			password = getProtect(config)
+		}
+		var b []byte
+		if config.format == formatSignify {
+			b = secSignify(pubkey, seckey, password, signifyRounds)
+		} else {
+			b = secMinisign(pubkey, seckey, password,
+				minisignOpslimit, minisignMemlimit)
+		}
+		writeOutput(config, b)
+	}
+	if config.format == formatSignify {
+		writeOutput(config, pubSignify(pubkey))
+	} else {
+		writeOutput(config, pubMinisign(pubkey))
+	}
+}
+
+func (k *completeKey) outputAge(config *config) {
+	subkey := k.subkey
+	if config.public {
+		writeOutput(config, pubAge(subkey.Pubkey()))
+	} else {
+		pub, sec := subkey.Pubkey(), subkey.Seckey()
+		writeOutput(config, secAge(pub, sec, subkey.Created()))
+	}
+}
+
+// Write the raw primary key, and encryption subkey, as PEM or JWK.
+func (k *completeKey) outputRaw(config *config) {
+	key := k.key
+	if config.format == formatPEM {
+		keys := []pemKey{{
+			pkcs8Ed25519, spkiEd25519, key.Seckey()[:32], key.Pubkey(),
+		}}
+		if config.subkey {
+			subkey := k.subkey
+			keys = append(keys, pemKey{
+				pkcs8X25519, spkiX25519, subkey.Seckey(), subkey.Pubkey(),
+			})
+		}
+		writeOutput(config, encodePEMKeys(keys, config.public))
+		return
+	}
+
+	var sec []byte
+	if !config.public {
+		sec = key.Seckey()[:32]
+	}
+	keys := []jwk{newJWK("Ed25519", "sig", "EdDSA", key.Pubkey(), sec)}
+	if config.subkey {
+		subkey := k.subkey
+		var subsec []byte
+		if !config.public {
+			subsec = subkey.Seckey()
+		}
+		keys = append(keys,
+			newJWK("X25519", "enc", "ECDH-ES", subkey.Pubkey(), subsec))
+	}
+	writeOutput(config, encodeJWKSet(keys))
+}
+
+func (k *completeKey) outputX509(config *config) {
+	writeOutput(config, k.encodeX509(config))
+}
+
+// Encode a self-signed certificate, and the PKCS #8 key unless public.
+// It's suitable for mutual TLS and S/MIME, with each user ID's email
+// address as a subject alternative name.
+func (k *completeKey) encodeX509(config *config) []byte {
+	key := k.key
+	uid := string(k.userids[0].ID)
+	var emails []string
+	for _, userid := range k.userids {
+		if email := uidEmail(string(userid.ID)); email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	// Serial Number is a truncated SHA-256 digest of the public key.
+	h := sha256.New()
 	h.Write(key.Pubkey())
 	var serial big.Int
 	serial.SetBytes(h.Sum(nil)[:16])
@@ -745,6 +3826,12 @@ func (k *completeKey) outputX509(config *config) {
 		NotBefore:             time.Unix(key.Created(), 0),
 		NotAfter:              expires,
 		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageClientAuth,
+			x509.ExtKeyUsageEmailProtection,
+		},
+		EmailAddresses: emails,
 	}
 	pubkey := ed25519.PublicKey(key.Pubkey())
 	derBytes, err := x509.CreateCertificate(nil, &tl, &tl, pubkey, key.Key)
@@ -762,13 +3849,576 @@ func (k *completeKey) outputX509(config *config) {
 		}
 		stdpem.Encode(&out, &stdpem.Block{Type: "PRIVATE KEY", Bytes: pkey})
 	}
+	return out.Bytes()
+}
+
+// Write the seed as a mnemonic, or split into mnemonic shares, from
+// which --from-mnemonic or --from-share reproduces the same keys.
+// Versions 1 and 2 only need the half of the seed used for the primary
+// key unless there's also a subkey.
+func exportMnemonic(config *config, seed []byte) {
+	seed = seed[:seedSize(config)]
+
+	var text string
+	if config.splitN > 0 {
+		var parts []string
+		for _, s := range shamirSplit(seed, config.splitK, config.splitN) {
+			parts = append(parts, s.String())
+			wipe(s.y)
+		}
+		text = strings.Join(parts, "\n")
+	} else {
+		text = mnemonicEncode(seed)
+	}
+
+	if config.output != "" {
+		config.out = createOutput(config.output, 0600)
+	}
+	if _, err := io.WriteString(config.out, text); err != nil {
+		fatal("%s", err)
+	}
+	closeOutput(config)
+}
+
+// Serve the key as an SSH agent on a Unix socket until interrupted.
+// The socket is the argument, or else in $XDG_RUNTIME_DIR or a private
+// temporary directory.
+func agent(config *config, key *openpgp.SignKey, uid []byte) {
+	var path string
+	switch {
+	case len(config.args) > 0:
+		path = config.args[0]
+	case os.Getenv("XDG_RUNTIME_DIR") != "":
+		path = filepath.Join(os.Getenv("XDG_RUNTIME_DIR"),
+			"passphrase2pgp-agent.sock")
+	default:
+		dir, err := ioutil.TempDir("", "passphrase2pgp-")
+		if err != nil {
+			fatal("%s", err)
+		}
+		defer os.Remove(dir)
+		path = filepath.Join(dir, "agent.sock")
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		fatal("%s", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		fatal("%s", err)
+	}
+
+	a := sshAgent{key: ed25519.PrivateKey(key.Key), comment: uid}
+	if config.verbose {
+		a.verbose = func(format string, args ...interface{}) {
+			fmt.Fprintf(os.Stderr, format, args...)
+		}
+	}
+	if !config.quiet {
+		fmt.Fprintf(config.out, "SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", path)
+	}
+
+	// Stop cleanly, removing the socket, when interrupted
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		<-stop
+		close(stopped)
+		l.Close()
+	}()
+
+	err = a.serve(l)
+	select {
+	case <-stopped:
+	default:
+		fatal("%s", err)
+	}
+}
+
+// Decrypt a message from a file or standard input to standard output.
+func decrypt(config *config, subkey *openpgp.EncryptKey) {
+	var msg []byte
+	var err error
+	if len(config.args) == 1 {
+		msg, err = ioutil.ReadFile(config.args[0])
+	} else {
+		msg, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+	if len(msg) > 0 && msg[0] < 128 {
+		msg, err = dearmor(msg, openpgp.ArmorMessage)
+		if err != nil {
+			fatal("%s", err)
+		}
+	}
+
+	plaintext, err := subkey.Decrypt(msg)
+	if err != nil {
+		fatal("%s", err)
+	}
+	if _, err := config.out.Write(plaintext); err != nil {
+		fatal("%s", err)
+	}
+}
+
+// Certify each user ID of another user's public key, writing that key
+// back out with a new certification following each user ID's existing
+// signatures, ready to be imported by its owner.
+func certify(config *config, key *openpgp.SignKey) {
+	filename := config.args[0]
+	f, err := os.Open(filename)
+	if err != nil {
+		fatal("%s", err)
+	}
+	pub, err := openpgp.ReadTransferableKey(f)
+	f.Close()
+	if err != nil {
+		fatal("%s: %s", err, filename)
+	}
+	if pub.IsSecret() {
+		fatal("%s: not a public key", filename)
+	}
+	if len(pub.UserIDs) == 0 {
+		fatal("%s: no user IDs to certify", filename)
+	}
+
+	pubkey := pub.Primary.Encode()
+	now := config.sigTime
+	for i := range pub.UserIDs {
+		userid := &pub.UserIDs[i]
+		if config.verbose {
+			fmt.Fprintf(os.Stderr, "Certify: %s\n", userid.ID)
+		}
+		uid := openpgp.Packet{Tag: 13, Body: userid.ID}
+		sig := key.CertifyTrust(pubkey, uid.Encode(), config.certLevel,
+			config.trust, now)
+		packet, _, _ := openpgp.ParsePacket(sig)
+		userid.Signatures = append(userid.Signatures, packet)
+	}
+
+	output := pub.Encode()
+	if config.armor {
+		output = openpgp.Armor(output, armorHeaders(config)...)
+	}
+	if _, err := config.out.Write(output); err != nil {
+		fatal("%s", err)
+	}
+}
+
+// Default key transition statement, given the date and the old and new
+// fingerprints.
+const transitionStatement = `OpenPGP Key Transition Statement
+
+I am moving to a new OpenPGP key, and this statement is signed by both
+the old and the new key to certify the transition. Please use the new
+key from now on.
+
+Date:    %s
+Old key: %s
+New key: %s
+`
+
+// Derive a transition's new key from the passphrase and --uid, as for
+// keygen, returning it with its public key. This precedes loading the
+// old key, which replaces the subkey options with the old key's.
+func transitionKey(config *config) (*openpgp.SignKey, *openpgp.TransferableKey) {
+	var key, authkey, signsub openpgp.SignKey
+	var subkey openpgp.EncryptKey
+	defer func() {
+		subkey.Wipe()
+		authkey.Wipe()
+		signsub.Wipe()
+	}()
+
+	if config.input == nil {
+		noPrompt(config, "the new key's passphrase")
+	}
+	var userids []openpgp.UserID
+	for _, uid := range config.uids {
+		userids = append(userids, openpgp.UserID{ID: []byte(uid)})
+	}
+	ck := completeKey{&key, userids, nil, &subkey, &authkey, &signsub}
+	seed := deriveSeed(config, config.uids[0])
+	ck.derive(config, seed)
+	wipe(seed)
+	key.SetSigExpires(config.sigExpires)
+	if config.prefs != nil {
+		key.SetPreferences(*config.prefs)
+	}
+	if config.hash != 0 {
+		key.SetHash(config.hash)
+		signsub.SetHash(config.hash)
+		authkey.SetHash(config.hash)
+	}
+	if config.verbose {
+		fmt.Fprintf(os.Stderr, "New Key ID: %s\n",
+			formatKeyID(key.KeyID(), config.fprFormat))
+	}
+
+	pubConfig := *config
+	pubConfig.public = true
+	pubConfig.armor = false
+	pub, err := openpgp.ReadTransferableKey(
+		bytes.NewReader(ck.encodePGP(&pubConfig)))
+	if err != nil {
+		panic(err) // should never happen
+	}
+	return &key, pub
+}
+
+// Output a statement signed by both the old (loaded) key and the new
+// key, and each public key certified by the other.
+func transition(config *config, old, key *openpgp.SignKey,
+	pub *openpgp.TransferableKey) {
+	if bytes.Equal(key.KeyID(), old.KeyID()) {
+		fatal("the new key is the same as the old key")
+	}
+
+	// The old public key as loaded, keeping its self-signatures, but
+	// without its secret subkeys
+	f, err := os.Open(config.load)
+	if err != nil {
+		fatal("%s", err)
+	}
+	oldPub, err := openpgp.ReadTransferableKey(f)
+	f.Close()
+	if err != nil {
+		fatal("%s: %s", err, config.load)
+	}
+	oldPub.Primary, _, _ = openpgp.ParsePacket(old.PubPacket())
+	oldPub.Subkeys = nil
+
+	// Cross-certify each key's user IDs with the other key
+	now := config.sigTime
+	cross := func(signer *openpgp.SignKey, pub *openpgp.TransferableKey) {
+		pubkey := pub.Primary.Encode()
+		for i := range pub.UserIDs {
+			userid := &pub.UserIDs[i]
+			uid := openpgp.Packet{Tag: 13, Body: userid.ID}
+			level := config.certLevel
+			sig := signer.CertifyLevel(pubkey, uid.Encode(), level, now)
+			packet, _, _ := openpgp.ParsePacket(sig)
+			userid.Signatures = append(userid.Signatures, packet)
+		}
+	}
+	cross(old, pub)
+	cross(key, oldPub)
+
+	var statement io.Reader
+	if len(config.args) == 1 {
+		f, err := os.Open(config.args[0])
+		if err != nil {
+			fatal("%s", err)
+		}
+		defer f.Close()
+		statement = f
+	} else {
+		date := time.Unix(now, 0).UTC().Format("2006-01-02")
+		text := fmt.Sprintf(transitionStatement, date,
+			formatFingerprint(old.KeyID()), formatFingerprint(key.KeyID()))
+		statement = strings.NewReader(text)
+	}
+	old.SetSigTime(now)
+	key.SetSigTime(now)
+	signed, err := ioutil.ReadAll(openpgp.ClearsignAll(statement, old, key))
+	if err != nil {
+		fatal("%s", err)
+	}
+	writeOutput(config, signed)
+
+	// The keys follow the statement unless written elsewhere, since
+	// a trailing key block spoils verification of the statement
+	if config.pubOut != "" {
+		closeOutput(config)
+		config.out = createOutput(config.pubOut, 0644)
+	}
+	headers := armorHeaders(config)
+	writeOutput(config, openpgp.Armor(oldPub.Encode(), headers...))
+	writeOutput(config, openpgp.Armor(pub.Encode(), headers...))
+}
+
+// Write a SHA256SUMS file, in sha256sum format, for the files named as
+// arguments, then an armored detached signature over it beside it. The
+// whole release is signed with a single key derivation.
+func release(config *config, signer *openpgp.SignKey) {
+	var sums bytes.Buffer
+	for _, name := range config.args {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal("%s", err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			fatal("%s: %s", err, name)
+		}
+		fmt.Fprintf(&sums, "%x  %s\n", h.Sum(nil), name)
+	}
+
+	sig, err := signer.Sign(bytes.NewReader(sums.Bytes()))
+	if err != nil {
+		fatal("%s", err)
+	}
+	sigCreated(config, 'D', signer, 0x00)
+
+	writePublic(config.output, sums.Bytes())
+	writePublic(config.output+".asc", openpgp.Armor(sig, armorHeaders(config)...))
+	if !config.quiet {
+		fmt.Fprintf(os.Stderr, "Signed %d files in %s\n",
+			len(config.args), config.output)
+	}
+}
+
+// Sign each of an apt repository's Release files twice, as apt looks
+// for either: cleartext signed as InRelease in the same directory, and
+// an armored detached signature as Release.gpg.
+func signApt(config *config, signer *openpgp.SignKey) {
+	for _, release := range config.args {
+		data, err := ioutil.ReadFile(release)
+		if err != nil {
+			fatal("%s", err)
+		}
+
+		inrelease, err := ioutil.ReadAll(signer.Clearsign(bytes.NewReader(data)))
+		if err != nil {
+			fatal("%s: %s", err, release)
+		}
+		sigCreated(config, 'C', signer, 0x01)
+		sig, err := signer.Sign(bytes.NewReader(data))
+		if err != nil {
+			fatal("%s: %s", err, release)
+		}
+		sigCreated(config, 'D', signer, 0x00)
+
+		dir := filepath.Dir(release)
+		writePublic(filepath.Join(dir, "InRelease"), inrelease)
+		writePublic(release+".gpg", openpgp.Armor(sig, armorHeaders(config)...))
+	}
+}
+
+// Sign the mail message on standard input as PGP/MIME.
+func signMIME(config *config, signer *openpgp.SignKey) {
+	msg, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fatal("%s", err)
+	}
+	// RFC 3156 micalg names, e.g. SHA-256 is "pgp-sha256"
+	hash := strings.ReplaceAll(signer.Hash().String(), "-", "")
+	micalg := "pgp-" + strings.ToLower(hash)
+	output, err := pgpMIME(msg, micalg, func(part []byte) ([]byte, error) {
+		sig, err := signer.Sign(bytes.NewReader(part))
+		if err != nil {
+			return nil, err
+		}
+		sigCreated(config, 'D', signer, 0x00)
+		return openpgp.Armor(sig, armorHeaders(config)...), nil
+	})
+	if err != nil {
+		fatal("%s", err)
+	}
+	if _, err := config.out.Write(output); err != nil {
+		fatal("%s", err)
+	}
+}
 
-	if _, err := os.Stdout.Write(out.Bytes()); err != nil {
+// Write a file meant to be published, such as a signature, so unlike
+// key output it's readable by everyone.
+func writePublic(filename string, data []byte) {
+	f := createOutput(filename, 0644)
+	_, err := f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		fatal("%s", err)
 	}
 }
 
-func parsePackets(filename string) ([]openpgp.Packet, error) {
+// Write a signature of the data read from in, named name if it's a
+// file, in the configured format: a detached OpenPGP signature by
+// signer, an inline signed message, or a signature by key in another
+// tool's format.
+func signData(config *config, key, signer *openpgp.SignKey, out io.Writer, in io.Reader, name string) error {
+	if config.inline {
+		if err := signInline(config, signer, out, in); err != nil {
+			return err
+		}
+		sigtype := byte(0x00)
+		if config.text {
+			sigtype = 0x01
+		}
+		sigCreated(config, 'S', signer, sigtype)
+		return nil
+	}
+	var output []byte
+	var err error
+	sigtype := byte(0x00)
+	switch {
+	case config.format == formatSSH:
+		pub, sec := key.Pubkey(), key.Seckey()
+		output, err = sigSSH(pub, sec, config.nspace, in)
+	case config.format == formatMinisign:
+		trusted := fmt.Sprintf("timestamp:%d", config.sigTime)
+		if name != "" {
+			trusted += "\tfile:" + filepath.Base(name)
+		}
+		trusted += "\thashed"
+		pub, sec := key.Pubkey(), key.Seckey()
+		output, err = sigMinisign(pub, sec, trusted, in)
+	case config.format == formatSignify:
+		pub, sec := key.Pubkey(), key.Seckey()
+		output, err = sigSignify(pub, sec, in)
+	case config.stamp:
+		sigtype = 0x40
+		output, err = signer.SignTimestamp(in, config.stampHash)
+	case config.text:
+		sigtype = 0x01
+		output, err = signer.SignText(in)
+	default:
+		output, err = signer.Sign(in)
+	}
+	if err != nil {
+		return err
+	}
+	// Other formats have no GnuPG status
+	if config.format == formatPGP {
+		sigCreated(config, 'D', signer, sigtype)
+		if config.armor {
+			output = openpgp.Armor(output, armorHeaders(config)...)
+		}
+	}
+	_, err = out.Write(output)
+	return err
+}
+
+// Write an inline signed message containing the data read from in,
+// streaming it through the armor encoder if requested.
+func signInline(config *config, signer *openpgp.SignKey, out io.Writer, in io.Reader) error {
+	var armor io.WriteCloser
+	if config.armor {
+		headers := armorHeaders(config)
+		armor = openpgp.ArmorWriter(out, openpgp.ArmorMessage, headers...)
+		out = armor
+	}
+	w := bufio.NewWriter(out)
+	if err := signer.SignMessage(w, in, config.text); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if armor != nil {
+		return armor.Close()
+	}
+	return nil
+}
+
+// Time a single Argon2 pass at the configured memory cost and lanes,
+// then suggest parameters for a derivation taking about the target
+// time. The cost is roughly proportional to both passes and memory, so
+// when even one pass takes too long, memory is halved and the pass
+// time estimated rather than measured again.
+func bench(config *config) {
+	params := config.kdf
+	params.time = 1
+	runtime.GOMAXPROCS(config.threads)
+	done := func() {}
+	if !config.quiet {
+		done = progress("Measuring")
+	}
+	start := time.Now()
+	kdf([]byte("passphrase"), []byte("salt"), params)
+	pass := time.Since(start)
+	done()
+
+	target := config.benchTime
+	for pass > target && params.memory > 1024 {
+		params.memory /= 2
+		pass /= 2
+	}
+	passes := target / pass
+	if passes < 1 {
+		passes = 1
+	}
+	params.time = uint32(passes)
+
+	fmt.Fprintf(config.out, "One pass: %v with --kdf-memory=%d --kdf-threads=%d\n",
+		pass.Round(time.Millisecond), params.memory/1024, params.threads)
+	fmt.Fprintf(config.out, "Suggested for %v: %s (about %v)\n",
+		target, params, (pass * passes).Round(10*time.Millisecond))
+}
+
+// Encrypt or decrypt a message with only a passphrase, which is read
+// like the key passphrase but is unrelated to any key.
+func symmetric(config *config) {
+	var passphrase []byte
+	var err error
+	if config.input != nil {
+		passphrase, err = readLine(config.input)
+		keepSecret(passphrase)
+	} else {
+		repeat := config.repeat
+		if config.cmd == cmdDecrypt {
+			repeat = 0 // a mistake only fails to decrypt
+		}
+		passphrase, err = readPassphrase(config.pinentry, "message", repeat)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+	defer wipe(passphrase)
+
+	in := os.Stdin
+	if len(config.args) == 1 {
+		in, err = os.Open(config.args[0])
+		if err != nil {
+			fatal("%s", err)
+		}
+		defer in.Close()
+	}
+
+	var output []byte
+	switch config.cmd {
+	case cmdEncrypt:
+		output, err = openpgp.EncryptSymmetric(passphrase, in)
+	case cmdDecrypt:
+		var msg []byte
+		msg, err = ioutil.ReadAll(in)
+		if err == nil && len(msg) > 0 && msg[0] < 128 {
+			msg, err = dearmor(msg, openpgp.ArmorMessage)
+		}
+		if err == nil {
+			output, err = openpgp.DecryptSymmetric(passphrase, msg)
+		}
+		switch err {
+		case openpgp.ErrDecryptKey:
+			fatalCode(exitPassphrase, "wrong passphrase")
+		case openpgp.ErrModified:
+			fatalCode(exitPassphrase, "wrong passphrase, or %s", err)
+		}
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+	if config.output != "" {
+		config.out = createOutput(config.output, 0600)
+	}
+	if config.cmd == cmdEncrypt {
+		writeMessage(config, output)
+	} else if _, err := config.out.Write(output); err != nil {
+		fatal("%s", err)
+	}
+	closeOutput(config)
+}
+
+// Read all packets from a binary or ASCII armored file. Armored input
+// must have one of the given armor types.
+func parsePackets(filename string, types ...string) ([]openpgp.Packet, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
@@ -778,8 +4428,7 @@ func parsePackets(filename string) ([]openpgp.Packet, error) {
 	}
 
 	if data[0] < 128 {
-		var err error
-		data, err = openpgp.Dearmor(data)
+		data, err = dearmor(data, types...)
 		if err != nil {
 			return nil, err
 		}
@@ -797,3 +4446,201 @@ func parsePackets(filename string) ([]openpgp.Packet, error) {
 	}
 	return packets, nil
 }
+
+// Decode ASCII armored data, which must have one of the given types.
+func dearmor(data []byte, types ...string) ([]byte, error) {
+	armorType, raw, err := openpgp.DearmorReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		if t == armorType {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %s", openpgp.ErrArmorType, armorType)
+}
+
+// Returns the supported signatures following the packet at index i,
+// such as self-signatures and certifications following a user ID.
+func signatures(packets []openpgp.Packet, i int) []*openpgp.Signature {
+	var sigs []*openpgp.Signature
+	for _, packet := range packets[i+1:] {
+		switch packet.Tag {
+		case 2: // Signature Packet
+			if sig, err := openpgp.ParseSignature(packet); err == nil {
+				sigs = append(sigs, sig)
+			}
+		case 12: // Trust Packet
+		default:
+			return sigs
+		}
+	}
+	return sigs
+}
+
+// Returns the first valid binding signature following the subkey at
+// index i, which has the given public subkey packet, or exits with an
+// error if there is none.
+func binding(config *config, key *openpgp.SignKey, sub []byte,
+	packets []openpgp.Packet, i int) *openpgp.Signature {
+	for _, sig := range signatures(packets, i) {
+		if key.VerifyBinding(sub, sig) == nil {
+			return sig
+		}
+	}
+	fatalCode(exitVerify, "%s: bad binding signature on subkey", config.load)
+	return nil
+}
+
+// keyringEntry is a public key and its user IDs, primary first.
+type keyringEntry struct {
+	key     openpgp.SignKey
+	userids []openpgp.UserID
+}
+
+// Load every public key from a file of concatenated keys, such as from
+// "gpg --export". Keys using unsupported algorithms are skipped, as are
+// user IDs and subkeys without a valid self-signature.
+func loadKeyring(filename string) ([]keyringEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys, err := openpgp.ReadKeyRing(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var ring []keyringEntry
+	for _, k := range keys {
+		if k.IsSecret() {
+			continue
+		}
+		var entry keyringEntry
+		err := entry.key.Load(k.Primary, nil)
+		if err == openpgp.ErrUnsupportedPacket {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, userid := range k.UserIDs {
+			// --allow-uid trusts these, so they must be the key's own
+			for _, sig := range openpgp.ParseSignatures(userid.Signatures) {
+				if entry.key.VerifyUserID(&userid.UserID, sig) == nil {
+					entry.userids = append(entry.userids, userid.UserID)
+					break
+				}
+			}
+		}
+		ring = append(ring, entry)
+
+		for _, sub := range k.Subkeys {
+			// Only signing subkeys, per the first binding signature
+			sigs := openpgp.ParseSignatures(sub.Signatures)
+			if len(sigs) == 0 || sigs[0].KeyFlags&0x02 == 0 {
+				continue
+			}
+			subentry := keyringEntry{userids: entry.userids}
+			err := subentry.key.Load(sub.Packet, nil)
+			if err == openpgp.ErrUnsupportedPacket {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			bound := false
+			for _, sig := range sigs {
+				subpub := subentry.key.SubPubPacket()
+				if entry.key.VerifyBinding(subpub, sig) == nil {
+					bound = true
+					break
+				}
+			}
+			if bound {
+				ring = append(ring, subentry)
+			}
+		}
+	}
+	return ring, nil
+}
+
+// Reports if any of the signer's user IDs, or its email address, matches
+// any of the patterns, as with path.Match.
+func allowedSigner(signer *keyringEntry, patterns []string) bool {
+	for _, userid := range signer.userids {
+		uid := string(userid.ID)
+		email := uidEmail(uid)
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, uid); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, email); ok && email != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verify a detached signature using the keyring, choosing the key that
+// matches the signature's issuer.
+func verify(config *config, ring []keyringEntry) {
+	sigfile := config.args[0]
+	var infile string
+	if len(config.args) == 2 {
+		infile = config.args[1]
+	} else {
+		ext := filepath.Ext(sigfile)
+		if ext != ".sig" && ext != ".asc" {
+			fatalUsage("cannot determine signed file: %s", sigfile)
+		}
+		infile = strings.TrimSuffix(sigfile, ext)
+	}
+
+	packets, err := parsePackets(sigfile, openpgp.ArmorSignature)
+	if err != nil {
+		fatal("%s: %s", err, sigfile)
+	}
+	sig, err := openpgp.ParseSignature(packets[0])
+	if err != nil {
+		fatal("%s: %s", err, sigfile)
+	}
+
+	var signer *keyringEntry
+	for i := range ring {
+		if sig.IssuedBy(ring[i].key.KeyID()) {
+			signer = &ring[i]
+			break
+		}
+	}
+	if signer == nil {
+		fatalCode(exitVerify, "no public key for key ID %X", sig.IssuerID())
+	}
+
+	in, err := os.Open(infile)
+	if err != nil {
+		fatal("%s", err)
+	}
+	defer in.Close()
+	if err := signer.key.Verify(in, sig); err != nil {
+		fatal("%s: %s", err, infile)
+	}
+	if config.allowUIDs != nil && !allowedSigner(signer, config.allowUIDs) {
+		fatalCode(exitVerify, "key ID %X has no user ID allowed by --allow-uid",
+			signer.key.KeyID())
+	}
+
+	if !config.quiet {
+		// Prefer the signer's claimed identity, if it's really theirs
+		var uid []byte
+		for _, userid := range signer.userids {
+			if uid == nil || string(userid.ID) == sig.SignerUID {
+				uid = userid.ID
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Good signature from \"%s\"\n", uid)
+		fmt.Fprintf(os.Stderr, "Key ID: %s\n",
+			formatKeyID(signer.key.KeyID(), config.fprFormat))
+	}
+}
@@ -1,8 +1,37 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	stdpem "encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+	"nullprogram.com/x/optparse"
+	"nullprogram.com/x/passphrase2pgp/openpgp"
 )
 
 func TestDecode(t *testing.T) {
@@ -62,3 +91,1769 @@ func TestBcryptPBKDF(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateUID(t *testing.T) {
+	table := []struct {
+		uid string
+		ok  bool
+	}{
+		{"John Doe", true},
+		{"emergency", true},
+		{"john.doe@example.com", true},
+		{"<john.doe@example.com>", true},
+		{"John Doe <john.doe@example.com>", true},
+		{"John Doe (work) <john@example.com>", true},
+		{"John Doe <>", false},
+		{"John Doe <john.doe>", false},
+		{"John Doe <@example.com>", false},
+		{"John Doe <john@>", false},
+		{"John Doe <john@@example.com>", false},
+		{"John Doe <john doe@example.com>", false},
+		{"John Doe <john@example.com", false},
+		{"John Doe john@example.com>", false},
+		{"John Doe <john@example.com> x", false},
+		{"<a@example.com> <b@example.com>", false},
+		{"\xff", false},
+	}
+
+	for _, row := range table {
+		err := validateUID(row.uid)
+		if (err == nil) != row.ok {
+			t.Errorf("validateUID(%q), got %v, want ok=%v",
+				row.uid, err, row.ok)
+		}
+	}
+}
+
+func TestSSH(t *testing.T) {
+	seckey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, 32))
+	pubkey := seckey.Public().(ed25519.PublicKey)
+	uid := []byte("Test <test@example.com>")
+	password := []byte("password")
+
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(pubSSH(pubkey, uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != string(uid) {
+		t.Errorf("pubSSH() comment, got %q, want %q", comment, uid)
+	}
+	want := pub.Marshal()
+
+	sec, err := ssh.ParsePrivateKey(secSSH(pubkey, seckey, uid, nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sec.PublicKey().Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("secSSH() public key, got %x, want %x", got, want)
+	}
+
+	enc := secSSH(pubkey, seckey, uid, password, 16)
+	sec, err = ssh.ParsePrivateKeyWithPassphrase(enc, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sec.PublicKey().Marshal(); !bytes.Equal(got, want) {
+		t.Errorf("secSSH() protected public key, got %x, want %x",
+			got, want)
+	}
+}
+
+func TestGnupgArgs(t *testing.T) {
+	table := []struct {
+		args     string
+		want     string
+		statusFd int
+		verify   bool
+	}{
+		{"--status-fd=2 -bsau ID", "--sign --armor --uid ID", 2, false},
+		{"--status-fd 2 --detach-sign --armor --local-user=ID",
+			"--sign --armor --uid ID", 2, false},
+		{"-bs -u ID file", "--sign --uid ID file", 0, false},
+		{"--keyid-format=long --status-fd=1 --verify sig -",
+			"--keyid-format=long --status-fd=1 --verify sig -", 1, true},
+		{"-S -a -u ID", "", 0, false},
+		{"--verify sig file", "", 0, false},
+		{"--status-fd=2 -T", "", 0, false},
+		{"--status-fd=2 --clearsign", "", 0, false},
+	}
+
+	for _, row := range table {
+		args := append([]string{"p"}, strings.Fields(row.args)...)
+		out, statusFd, verify := gnupgArgs(args)
+		var got string
+		if out != nil {
+			got = strings.Join(out[1:], " ")
+		}
+		if got != row.want ||
+			statusFd != row.statusFd || verify != row.verify {
+			t.Errorf("gnupgArgs(%q), got %q %d %v, want %q %d %v",
+				row.args, got, statusFd, verify,
+				row.want, row.statusFd, row.verify)
+		}
+	}
+}
+
+func TestTimespec(t *testing.T) {
+	table := []struct {
+		input string
+		want  int64
+	}{
+		{"0", 0},
+		{"1500000000", 1500000000},
+		{"2030-01-01", 1893456000},
+		{"2030-01-01T00:00:00Z", 1893456000},
+		{"2030-01-01T12:00:00-05:00", 1893456000 + 17*60*60},
+	}
+
+	for _, row := range table {
+		got := timespec(row.input)
+		if got != row.want {
+			t.Errorf("timespec(%q), got %d, want %d",
+				row.input, got, row.want)
+		}
+	}
+}
+
+func TestKDF(t *testing.T) {
+	passphrase := []byte("foobar")
+	uid := []byte("John Doe <john.doe@example.com>")
+	params := kdfParams{version: 1, time: 1, memory: 1024, threads: 1}
+	want := "--kdf-version=1 --kdf-time=1 --kdf-memory=1 --kdf-threads=1"
+	if got := params.String(); got != want {
+		t.Errorf("kdfParams.String(), got %q, want %q", got, want)
+	}
+
+	a := kdf(passphrase, uid, params)
+	b := kdf(passphrase, uid, params)
+	if !bytes.Equal(a, b) {
+		t.Errorf("kdf() is not deterministic")
+	}
+	params.threads = 2
+	if c := kdf(passphrase, uid, params); bytes.Equal(a, c) {
+		t.Errorf("kdf() ignores --kdf-threads")
+	}
+
+	others := []struct {
+		params kdfParams
+		want   string
+	}{
+		{
+			kdfParams{algorithm: kdfScrypt, version: 1, memory: 1024, threads: 1},
+			"--kdf=scrypt --kdf-version=1 --kdf-memory=1 --kdf-threads=1",
+		},
+		{
+			kdfParams{algorithm: kdfPBKDF2, version: 3, iterations: 1000, index: 2},
+			"--kdf=pbkdf2-sha512 --kdf-version=3 --kdf-iterations=1000 --key-index=2",
+		},
+	}
+	for _, row := range others {
+		if got := row.params.String(); got != row.want {
+			t.Errorf("kdfParams.String(), got %q, want %q", got, row.want)
+		}
+		b := kdf(passphrase, uid, row.params)
+		if len(b) != 64 || bytes.Equal(a, b) {
+			t.Errorf("kdf(%s) did not derive its own seed", row.params)
+		}
+		if c := kdf(passphrase, uid, row.params); !bytes.Equal(b, c) {
+			t.Errorf("kdf(%s) is not deterministic", row.params)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	// Precomposed and combining accents, as typed on different systems
+	composed := []byte("caf\u00e9 cr\u00e8me")
+	decomposed := []byte("cafe\u0301 cre\u0300me")
+	uidA := []byte("Ren\u00e9e <renee@example.com>")
+	uidB := []byte("Rene\u0301e <renee@example.com>")
+	derive := func(passphrase, uid []byte, params kdfParams) []byte {
+		p := normalize(passphrase, params)
+		u := normalize(uid, params)
+		return kdf(p, u, params)
+	}
+
+	// Earlier versions derive from the exact bytes, as they always have
+	v3 := kdfParams{algorithm: kdfPBKDF2, version: 3, iterations: 1000}
+	if got := normalize(decomposed, v3); !bytes.Equal(got, decomposed) {
+		t.Errorf("normalize(v3), got %q, want %q", got, decomposed)
+	}
+	a := derive(composed, uidA, v3)
+	b := derive(decomposed, uidB, v3)
+	if !bytes.Equal(a, kdf(composed, uidA, v3)) || bytes.Equal(a, b) {
+		t.Errorf("kdf(v3) normalized its input")
+	}
+
+	v4 := kdfParams{algorithm: kdfPBKDF2, version: 4, iterations: 1000}
+	if got := normalize(decomposed, v4); !bytes.Equal(got, composed) {
+		t.Errorf("normalize(v4), got %q, want %q", got, composed)
+	}
+	a = derive(composed, uidA, v4)
+	b = derive(decomposed, uidB, v4)
+	if !bytes.Equal(a, b) {
+		t.Errorf("kdf(v4), composed and decomposed input differ")
+	}
+}
+
+func TestWipeSecrets(t *testing.T) {
+	seed := []byte("secret seed")
+	keepSecret(seed)
+	var order []int
+	wipeOnExit(func() { order = append(order, 1) })
+	wipeOnExit(func() { order = append(order, 2) })
+	wipeSecrets()
+	if !bytes.Equal(seed, make([]byte, len(seed))) {
+		t.Errorf("wipeSecrets(), got %q", seed)
+	}
+	if len(order) != 2 || order[0] != 2 || order[1] != 1 {
+		t.Errorf("wipeSecrets() order, got %v, want [2 1]", order)
+	}
+	if len(exitWipes) != 0 {
+		t.Errorf("wipeSecrets() left %d wipes registered", len(exitWipes))
+	}
+}
+
+func TestCheckKeyID(t *testing.T) {
+	keyid, _ := parseCheck("2536A19C9C54880A8FEBC812070B00717FCDEE34")
+	table := []struct {
+		input string
+		want  bool
+	}{
+		{"", true},
+		{"7FCDEE34", true},
+		{"070B00717FCDEE34", true},
+		{"0x7fcdee34", true},
+		{"2536 A19C 9C54 880A 8FEB  C812 070B 0071 7FCD EE34", true},
+		{"2536A19C", true},
+		{"7FCDEE35", false},
+		{"A19C9C54", false},
+	}
+	for _, row := range table {
+		check, err := parseCheck(row.input)
+		if err != nil {
+			t.Fatalf("parseCheck(%q), got %v", row.input, err)
+		}
+		if got := checkKeyID(keyid, check); got != row.want {
+			t.Errorf("checkKeyID(%q), got %v, want %v",
+				row.input, got, row.want)
+		}
+	}
+
+	if _, err := parseCheck(hex.EncodeToString(make([]byte, 33))); err == nil {
+		t.Errorf("parseCheck() accepted a 33-byte Key ID")
+	}
+}
+
+func TestVanity(t *testing.T) {
+	var key openpgp.SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	pattern := regexp.MustCompile("(?i)^ab")
+	created := vanity(&key, pattern)
+	if key.Created() != created {
+		t.Errorf("vanity(), key created %d, want %d", key.Created(), created)
+	}
+	if keyid := fmt.Sprintf("%X", key.KeyID()); keyid[:2] != "AB" {
+		t.Errorf("vanity(), got Key ID %s, want prefix AB", keyid)
+	}
+}
+
+func TestParsePrefs(t *testing.T) {
+	def := openpgp.DefaultPreferences
+	table := []struct {
+		input string
+		want  openpgp.Preferences
+	}{
+		{"", def},
+		{"AES256 AES128", openpgp.Preferences{
+			Symmetric: []byte{9, 7}, Hash: def.Hash,
+			Compression: def.Compression, Ciphersuites: def.Ciphersuites,
+		}},
+		{"sha256,zlib, Uncompressed", openpgp.Preferences{
+			Symmetric: def.Symmetric, Hash: []byte{8},
+			Compression: []byte{2, 0}, Ciphersuites: def.Ciphersuites,
+		}},
+		{"S9 H10 Z1", openpgp.Preferences{
+			Symmetric: []byte{9}, Hash: []byte{10},
+			Compression: []byte{1}, Ciphersuites: def.Ciphersuites,
+		}},
+		{"AES256-OCB aes128-gcm", openpgp.Preferences{
+			Symmetric: def.Symmetric, Hash: def.Hash,
+			Compression: def.Compression, Ciphersuites: []byte{9, 2, 7, 3},
+		}},
+	}
+	for _, row := range table {
+		got, err := parsePrefs(row.input)
+		if err != nil {
+			t.Errorf("parsePrefs(%q), got %v", row.input, err)
+			continue
+		}
+		if !bytes.Equal(got.Symmetric, row.want.Symmetric) ||
+			!bytes.Equal(got.Hash, row.want.Hash) ||
+			!bytes.Equal(got.Compression, row.want.Compression) ||
+			!bytes.Equal(got.Ciphersuites, row.want.Ciphersuites) {
+			t.Errorf("parsePrefs(%q), got %v, want %v",
+				row.input, got, row.want)
+		}
+	}
+
+	bad := []string{"AES512", "X1", "S", "S256", "SHA256-OCB", "AES-CBC", "-"}
+	for _, bad := range bad {
+		if _, err := parsePrefs(bad); err == nil {
+			t.Errorf("parsePrefs(%q), got nil error", bad)
+		}
+	}
+}
+
+func TestParseKeyFlags(t *testing.T) {
+	table := []struct {
+		input string
+		want  byte
+	}{
+		{"certify", 0x01},
+		{"certify,sign", 0x03},
+		{"Sign AUTH", 0x22},
+		{"encrypt", 0x0c},
+		{"encrypt-storage", 0x08},
+		{"encrypt-comms, encrypt-storage", 0x0c},
+	}
+	for _, row := range table {
+		got, err := parseKeyFlags(row.input)
+		if err != nil {
+			t.Errorf("parseKeyFlags(%q), got %v", row.input, err)
+		} else if got != row.want {
+			t.Errorf("parseKeyFlags(%q), got %#x, want %#x",
+				row.input, got, row.want)
+		}
+	}
+
+	bad := []string{"", ",", "storage", "certify,foo"}
+	for _, bad := range bad {
+		if _, err := parseKeyFlags(bad); err == nil {
+			t.Errorf("parseKeyFlags(%q), got nil error", bad)
+		}
+	}
+}
+
+func TestParseRevoker(t *testing.T) {
+	const fpr = "0011223344556677889900AABBCCDDEEFF001122"
+	raw, _ := hex.DecodeString(fpr)
+	table := []struct {
+		input string
+		algo  byte
+	}{
+		{fpr, 22},
+		{"0011 2233 4455 6677 8899  00AA BBCC DDEE FF00 1122", 22},
+		{"RSA:" + strings.ToLower(fpr), 1},
+		{"ecdsa:" + fpr, 19},
+	}
+	for _, row := range table {
+		got, err := parseRevoker(row.input)
+		if err != nil {
+			t.Errorf("parseRevoker(%q), got %v", row.input, err)
+			continue
+		}
+		if got.Algorithm != row.algo || !bytes.Equal(got.Fingerprint, raw) {
+			t.Errorf("parseRevoker(%q), got %d:%X, want %d:%s",
+				row.input, got.Algorithm, got.Fingerprint, row.algo, fpr)
+		}
+	}
+
+	bad := []string{"", fpr[:38], fpr + "00", "foo:" + fpr, "x" + fpr[1:]}
+	for _, bad := range bad {
+		if _, err := parseRevoker(bad); err == nil {
+			t.Errorf("parseRevoker(%q), got nil error", bad)
+		}
+	}
+}
+
+func TestFormatKeyID(t *testing.T) {
+	v4, _ := hex.DecodeString("2536A19C9C54880A8FEBC812070B00717FCDEE34")
+	v6 := bytes.Repeat([]byte{0xab}, 32)
+	v6[0] = 0x01
+	table := []struct {
+		fpr    []byte
+		format string
+		want   string
+	}{
+		{v4, "", "2536A19C9C54880A8FEBC812070B00717FCDEE34"},
+		{v4, "hex", "2536A19C9C54880A8FEBC812070B00717FCDEE34"},
+		{v4, "spaced", "2536 A19C 9C54 880A 8FEB  C812 070B 0071 7FCD EE34"},
+		{v4, "long", "070B00717FCDEE34"},
+		{v4, "short", "7FCDEE34"},
+		{v4, "colons", "fpr:::::::::2536A19C9C54880A8FEBC812070B00717FCDEE34:"},
+		{v6, "long", "01ABABABABABABAB"},
+		{v6, "short", "ABABABAB"},
+	}
+	for _, row := range table {
+		got := formatKeyID(row.fpr, row.format)
+		if got != row.want {
+			t.Errorf("formatKeyID(%X, %q), got %q, want %q",
+				row.fpr, row.format, got, row.want)
+		}
+	}
+}
+
+func TestPassphraseEntropy(t *testing.T) {
+	weak := []string{
+		"",
+		"foobar",
+		"aaaaaaaaaaaaaaaa",
+		"1234567890123456",
+		"Password1!",
+		"correct horse battery staple",
+	}
+	for _, p := range weak {
+		if bits := passphraseEntropy([]byte(p)); bits >= weakEntropy {
+			t.Errorf("passphraseEntropy(%q), got %.1f, want < %d",
+				p, bits, weakEntropy)
+		}
+	}
+
+	strong := []string{
+		"boa trusted stew critics dispute asked naming gyms",
+		"x7#Kq9!mZ2@wLp4$",
+	}
+	for _, p := range strong {
+		if bits := passphraseEntropy([]byte(p)); bits < weakEntropy {
+			t.Errorf("passphraseEntropy(%q), got %.1f, want >= %d",
+				p, bits, weakEntropy)
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	_, notExist := os.Open("") // *os.PathError
+	table := []struct {
+		err  error
+		want int
+	}{
+		{errMismatch, exitPassphrase},
+		{openpgp.ErrDecryptKey, exitPassphrase},
+		{openpgp.ErrBadSignature, exitVerify},
+		{errNoTerminal, exitPrompt},
+		{notExist, exitIO},
+		{fmt.Errorf("wrapped: %w", notExist), exitIO},
+		{openpgp.ErrInvalidPacket, exitFailure},
+	}
+	for _, row := range table {
+		if got := errorStatus(row.err); got != row.want {
+			t.Errorf("errorStatus(%v), got %d, want %d",
+				row.err, got, row.want)
+		}
+	}
+}
+
+func TestSignStatus(t *testing.T) {
+	var key openpgp.SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	key.SetSigTime(1565727576)
+	fpr := fmt.Sprintf("%X", key.KeyID())
+
+	table := []struct {
+		format int
+		inline bool
+		want   string
+	}{
+		{formatPGP, false, "SIG_CREATED D 22 8 00 1565727576 " + fpr},
+		{formatPGP, true, "SIG_CREATED S 22 8 00 1565727576 " + fpr},
+		{formatSSH, false, ""},
+		{formatMinisign, false, ""},
+		{formatSignify, false, ""},
+	}
+	for _, row := range table {
+		f, err := ioutil.TempFile("", "status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		conf := &config{
+			format: row.format,
+			inline: row.inline,
+			nspace: "file",
+			status: f,
+		}
+
+		var out bytes.Buffer
+		in := strings.NewReader("hello\n")
+		if err := signData(conf, &key, &key, &out, in, ""); err != nil {
+			t.Fatalf("signData(%d), got %v", row.format, err)
+		}
+		f.Close()
+		got, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if row.want == "" {
+			if len(got) != 0 {
+				t.Errorf("signData(%d) status, got %q, want none",
+					row.format, got)
+			}
+		} else if !strings.Contains(string(got), "[GNUPG:] "+row.want+"\n") {
+			t.Errorf("signData(%d, inline=%v) status, got %q, want %q",
+				row.format, row.inline, got, row.want)
+		}
+	}
+}
+
+func TestCheckURI(t *testing.T) {
+	good := []string{
+		"https://example.com/policy.html",
+		"hkps://keys.openpgp.org",
+		"urn:example:policy",
+	}
+	for _, uri := range good {
+		if err := checkURI(uri); err != nil {
+			t.Errorf("checkURI(%q), got %v", uri, err)
+		}
+	}
+	bad := []string{"", "policy.html", "/policy", "https://", "http://%zz"}
+	for _, uri := range bad {
+		if err := checkURI(uri); err == nil {
+			t.Errorf("checkURI(%q), got nil error", uri)
+		}
+	}
+}
+
+func TestTrustRegexp(t *testing.T) {
+	table := []struct {
+		domain string
+		want   string
+	}{
+		{"example.com", `<[^>]+[@.]example\.com>$`},
+		{"Mail.Example-1.ORG", `<[^>]+[@.]mail\.example-1\.org>$`},
+	}
+	for _, row := range table {
+		got, err := trustRegexp(row.domain)
+		if err != nil {
+			t.Errorf("trustRegexp(%q), got %v", row.domain, err)
+		} else if got != row.want {
+			t.Errorf("trustRegexp(%q), got %q, want %q",
+				row.domain, got, row.want)
+		}
+	}
+
+	for _, bad := range []string{"", ".com", "example..com", "a.com>$|.*"} {
+		if _, err := trustRegexp(bad); err == nil {
+			t.Errorf("trustRegexp(%q), got nil error", bad)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	table := []struct {
+		input string
+		want  int64
+	}{
+		{"0", 0},
+		{"1705276800", 1705276800},
+		{"2024-01-15T00:00:00Z", 1705276800},
+		{"2024-01-15T01:00:00+01:00", 1705276800},
+		{"4294967295", 4294967295},
+	}
+	for _, row := range table {
+		got, err := parseTime(row.input)
+		if err != nil {
+			t.Errorf("parseTime(%q), got %v", row.input, err)
+		} else if got != row.want {
+			t.Errorf("parseTime(%q), got %d, want %d",
+				row.input, got, row.want)
+		}
+	}
+
+	bad := []string{"", "-1", "4294967296", "2024-01-15", "1969-12-31T00:00:00Z"}
+	for _, input := range bad {
+		if _, err := parseTime(input); err == nil {
+			t.Errorf("parseTime(%q), got nil error", input)
+		}
+	}
+}
+
+func TestKeySeeds(t *testing.T) {
+	seed := make([]byte, 64)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+
+	// Earlier versions split the seed in half
+	primary, encrypt := keySeeds(seed, kdfParams{version: 2})
+	if !bytes.Equal(primary, seed[:32]) || !bytes.Equal(encrypt, seed[32:]) {
+		t.Errorf("keySeeds(v2), got %X %X", primary, encrypt)
+	}
+
+	seen := make(map[string]bool)
+	for index := 0; index < 3; index++ {
+		params := kdfParams{version: 3, index: index}
+		primary, encrypt := keySeeds(seed, params)
+		for _, s := range [][]byte{primary, encrypt} {
+			if len(s) != 32 || seen[string(s)] {
+				t.Errorf("keySeeds(v3, %d), got %X", index, s)
+			}
+			seen[string(s)] = true
+		}
+		again, _ := keySeeds(seed, params)
+		if !bytes.Equal(primary, again) {
+			t.Errorf("keySeeds(v3, %d) is not deterministic", index)
+		}
+	}
+}
+
+func TestRotateSeed(t *testing.T) {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+
+	if r := rotateSeed(seed, 0); !bytes.Equal(r, seed) {
+		t.Errorf("rotateSeed(0), got %X", r)
+	}
+	seen := map[string]bool{string(seed): true}
+	for n := 1; n < 4; n++ {
+		r := rotateSeed(seed, n)
+		if len(r) != 32 || seen[string(r)] {
+			t.Errorf("rotateSeed(%d), got %X", n, r)
+		}
+		seen[string(r)] = true
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	table := []struct {
+		input string
+		want  time.Duration
+	}{
+		{"10s", 10 * time.Second},
+		{"1m30s", 90 * time.Second},
+		{"10", 10 * time.Second},
+		{"0.5", 500 * time.Millisecond},
+	}
+	for _, row := range table {
+		got, err := parseDuration(row.input)
+		if err != nil {
+			t.Errorf("parseDuration(%q), got %v", row.input, err)
+		} else if got != row.want {
+			t.Errorf("parseDuration(%q), got %v, want %v",
+				row.input, got, row.want)
+		}
+	}
+
+	for _, bad := range []string{"", "0", "-1s", "ten"} {
+		if _, err := parseDuration(bad); err == nil {
+			t.Errorf("parseDuration(%q), got nil error", bad)
+		}
+	}
+}
+
+func TestFormatFingerprint(t *testing.T) {
+	fpr, _ := hex.DecodeString("2536A19C9C54880A8FEBC812070B00717FCDEE34")
+	want := "2536 A19C 9C54 880A 8FEB  C812 070B 0071 7FCD EE34"
+	if got := formatFingerprint(fpr); got != want {
+		t.Errorf("formatFingerprint(), got %q, want %q", got, want)
+	}
+}
+
+func TestQR(t *testing.T) {
+	want := []string{
+		"#######.##..#.#######",
+		"#.....#..#..#.#.....#",
+		"#.###.#.#.#.#.#.###.#",
+		"#.###.#.#..#..#.###.#",
+		"#.###.#.####..#.###.#",
+		"#.....#.......#.....#",
+		"#######.#.#.#.#######",
+		".........###.........",
+		"####..#.#.#..#..###.#",
+		"...###.##..#...##.#.#",
+		".#..####.....###...##",
+		".....#.....##....#.#.",
+		"#.#..###.#.#####.#...",
+		"........#.###.####.#.",
+		"#######..#.#..#####..",
+		"#.....#..#..##.#.##.#",
+		"#.###.#..#.#....#.##.",
+		"#.###.#.##.##......#.",
+		"#.###.#.#.####...#...",
+		"#.....#.#..##.#.....#",
+		"#######.#.#...#.###..",
+	}
+	modules, err := qrEncode([]byte("passphrase2pgp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y, row := range modules {
+		var got strings.Builder
+		for _, dark := range row {
+			if dark {
+				got.WriteByte('#')
+			} else {
+				got.WriteByte('.')
+			}
+		}
+		if got.String() != want[y] {
+			t.Errorf("row %d: got %s, want %s", y, got.String(), want[y])
+		}
+	}
+
+	// Largest byte-mode payload at level L is 2,953 bytes (version 40)
+	modules, err = qrEncode(make([]byte, 2953))
+	if err != nil || len(modules) != 177 {
+		t.Errorf("qrEncode(2953 bytes) failed: %v", err)
+	}
+	if _, err := qrEncode(make([]byte, 2954)); err != errQRTooLong {
+		t.Errorf("qrEncode(2954 bytes): got %v, want %v", err, errQRTooLong)
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	// Test vectors from BIP39
+	table := []struct {
+		seed byte
+		want string
+	}{
+		{0x00, strings.Repeat("abandon ", 23) + "art"},
+		{0x7f, strings.Repeat("legal winner thank year wave sausage "+
+			"worth useful ", 2) + "legal winner thank year wave " +
+			"sausage worth title"},
+		{0x80, strings.Repeat("letter advice cage absurd amount doctor "+
+			"acoustic avoid ", 2) + "letter advice cage absurd amount " +
+			"doctor acoustic bless"},
+		{0xff, strings.Repeat("zoo ", 23) + "vote"},
+	}
+	for _, row := range table {
+		seed := bytes.Repeat([]byte{row.seed}, 32)
+		got := mnemonicEncode(seed)
+		if strings.Join(strings.Fields(got), " ") != row.want {
+			t.Errorf("mnemonicEncode(%02x...), got %q, want %q",
+				row.seed, got, row.want)
+		}
+		decoded, err := mnemonicDecode(row.want)
+		if err != nil || !bytes.Equal(decoded, seed) {
+			t.Errorf("mnemonicDecode(%q), got %X, %v", row.want, decoded, err)
+		}
+	}
+
+	seed := make([]byte, 64)
+	for i := range seed {
+		seed[i] = byte(i * 7)
+	}
+	words := strings.Fields(mnemonicEncode(seed))
+	if len(words) != 48 {
+		t.Fatalf("mnemonicEncode(64 bytes), got %d words", len(words))
+	}
+
+	// Words may be abbreviated to four letters, in any case
+	for i := range words {
+		if len(words[i]) > 4 {
+			words[i] = strings.ToUpper(words[i][:4])
+		}
+	}
+	decoded, err := mnemonicDecode(strings.Join(words, "\n"))
+	if err != nil || !bytes.Equal(decoded, seed) {
+		t.Errorf("mnemonicDecode(abbreviated), got %X, %v", decoded, err)
+	}
+
+	bad := []string{
+		"",
+		strings.Repeat("abandon ", 24),
+		strings.Repeat("abandon ", 23),
+		strings.Repeat("abandon ", 23) + "artichoke",
+		strings.Repeat("abandon ", 23) + "xyzzy",
+	}
+	for _, s := range bad {
+		if _, err := mnemonicDecode(s); err == nil {
+			t.Errorf("mnemonicDecode(%q), got nil error", s)
+		}
+	}
+}
+
+func TestMnemonicSubkey(t *testing.T) {
+	seed := make([]byte, 64)
+	for i := range seed {
+		seed[i] = byte(i * 7)
+	}
+	var want openpgp.SignKey
+	ck := completeKey{key: &want, subkey: &openpgp.EncryptKey{}}
+	ck.derive(&config{subkey: true}, append([]byte{}, seed...))
+
+	// Exported with -s, the backup is the whole seed
+	dir, err := ioutil.TempDir("", "mnemonic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "backup.txt")
+	exportMnemonic(&config{subkey: true, output: output}, seed)
+	text, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := mnemonicDecode(string(text))
+	if err != nil || !bytes.Equal(restored, seed) {
+		t.Fatalf("mnemonicDecode(-s backup), got %X, %v", restored, err)
+	}
+
+	// Without -s, only the primary key's half is used
+	plain := &config{}
+	restored = restored[:seedSize(plain)]
+	var got openpgp.SignKey
+	ck = completeKey{key: &got}
+	ck.derive(plain, restored)
+	if !bytes.Equal(got.KeyID(), want.KeyID()) {
+		t.Errorf("derive(-s backup without -s), got %X, want %X",
+			got.KeyID(), want.KeyID())
+	}
+}
+
+func TestShamir(t *testing.T) {
+	for x := 1; x < 256; x++ {
+		if gfMultiply(byte(x), gfInverse(byte(x))) != 1 {
+			t.Fatalf("gfInverse(%d) is not an inverse", x)
+		}
+	}
+
+	secret := make([]byte, 64)
+	for i := range secret {
+		secret[i] = byte(i * 13)
+	}
+	shares := shamirSplit(secret, 3, 5)
+
+	// Every combination of three shares, in text form
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				text := shares[k].String() + "\n" +
+					shares[i].String() + shares[j].String()
+				parsed, err := parseShares(text)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := shamirCombine(parsed)
+				if err != nil || !bytes.Equal(got, secret) {
+					t.Errorf("shamirCombine(%d, %d, %d), got %X, %v",
+						i, j, k, got, err)
+				}
+			}
+		}
+	}
+
+	dup := []share{shares[0], shares[1], shares[1]}
+	if _, err := shamirCombine(dup); err == nil {
+		t.Errorf("shamirCombine(duplicate shares), got nil error")
+	}
+	other := shamirSplit(secret[:32], 2, 2)
+	if _, err := shamirCombine([]share{shares[0], other[0]}); err == nil {
+		t.Errorf("shamirCombine(mismatched shares), got nil error")
+	}
+
+	// "share" is also a mnemonic word
+	var words string
+	for _, last := range mnemonicWords {
+		words = strings.Repeat("share ", 23) + last
+		if _, err := mnemonicDecode(words); err == nil {
+			break
+		}
+	}
+	parsed, err := parseShares("share 1, threshold 2\n" + words)
+	if err != nil || len(parsed) != 1 {
+		t.Errorf("parseShares(share words), got %v", err)
+	}
+
+	bad := []string{
+		"",
+		"abandon abandon",
+		"share x, threshold 2\n" + mnemonicEncode(secret),
+		"share 1, threshold 2\nabandon abandon",
+	}
+	for _, text := range bad {
+		if _, err := parseShares(text); err == nil {
+			t.Errorf("parseShares(%q), got nil error", text)
+		}
+	}
+}
+
+func TestKeyserverURL(t *testing.T) {
+	table := []struct {
+		server string
+		want   string
+	}{
+		{"keys.openpgp.org", "https://keys.openpgp.org/pks/add"},
+		{"hkps://keys.openpgp.org", "https://keys.openpgp.org/pks/add"},
+		{"hkp://example.com", "http://example.com:11371/pks/add"},
+		{"hkp://example.com:80", "http://example.com:80/pks/add"},
+		{"https://example.com/ks/", "https://example.com/ks/pks/add"},
+	}
+	for _, row := range table {
+		got, err := keyserverURL(row.server)
+		if err != nil || got != row.want {
+			t.Errorf("keyserverURL(%q), got %q, %v, want %q",
+				row.server, got, err, row.want)
+		}
+	}
+	for _, bad := range []string{"ftp://example.com", "hkps://"} {
+		if _, err := keyserverURL(bad); err == nil {
+			t.Errorf("keyserverURL(%q), got nil error", bad)
+		}
+	}
+}
+
+func TestSendKey(t *testing.T) {
+	key := []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n...")
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/pks/add" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			got = r.FormValue("keytext")
+		}))
+	defer srv.Close()
+
+	if err := sendKey(srv.URL, key); err != nil {
+		t.Fatal(err)
+	}
+	if got != string(key) {
+		t.Errorf("sendKey(), server got %q, want %q", got, key)
+	}
+	if err := sendKey(srv.URL+"/other", key); err == nil {
+		t.Errorf("sendKey(rejected), got nil error")
+	}
+}
+
+func TestWKD(t *testing.T) {
+	// Example from draft-koch-openpgp-webkey-service
+	want := filepath.Join(".well-known", "openpgpkey", "example.org", "hu",
+		"iy9q119eutrkn8s1mk4r39qejnbu3n5q")
+	if got := wkdPath("Joe.Doe@Example.ORG"); got != want {
+		t.Errorf("wkdPath(), got %q, want %q", got, want)
+	}
+
+	dir, err := ioutil.TempDir("", "wkd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userids := []openpgp.UserID{
+		{ID: []byte("Joe Doe <Joe.Doe@Example.ORG>")},
+		{ID: []byte("Joe Doe")},
+	}
+	paths, err := wkdExport(dir, []byte("key"), userids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != filepath.Join(dir, want) {
+		t.Errorf("wkdExport(), got %q", paths)
+	}
+	policy := filepath.Join(dir, ".well-known", "openpgpkey",
+		"example.org", "policy")
+	if _, err := os.Stat(policy); err != nil {
+		t.Errorf("wkdExport() policy file: %s", err)
+	}
+	if _, err := wkdExport(dir, nil, userids[1:]); err == nil {
+		t.Errorf("wkdExport(no email), got nil error")
+	}
+}
+
+func TestDNSRecords(t *testing.T) {
+	// Example from RFC 7929
+	want := "c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6" +
+		"._openpgpkey.example.com."
+	if got := dnsOwner("hugh@example.com"); got != want {
+		t.Errorf("dnsOwner(), got %q, want %q", got, want)
+	}
+
+	userids := []openpgp.UserID{
+		{ID: []byte("Hugh <Hugh@Example.com>")},
+		{ID: []byte("Hugh")},
+	}
+	records, err := dnsRecords([]byte{1, 2, 3}, userids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0] != want+" IN OPENPGPKEY AQID" {
+		t.Errorf("dnsRecords(), got %q", records)
+	}
+	if _, err := dnsRecords(nil, userids[1:]); err == nil {
+		t.Errorf("dnsRecords(no email), got nil error")
+	}
+}
+
+func TestAllowedSigner(t *testing.T) {
+	signer := keyringEntry{userids: []openpgp.UserID{
+		{ID: []byte("Release Bot")},
+		{ID: []byte("Ops <ops@example.com>")},
+	}}
+	table := []struct {
+		patterns []string
+		want     bool
+	}{
+		{[]string{"*@example.com"}, true},
+		{[]string{"Release *"}, true},
+		{[]string{"ops@example.org", "Ops <*>"}, true},
+		{[]string{"*@example.org"}, false},
+		{[]string{"Release"}, false},
+	}
+	for _, row := range table {
+		if got := allowedSigner(&signer, row.patterns); got != row.want {
+			t.Errorf("allowedSigner(%q), got %v, want %v",
+				row.patterns, got, row.want)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	msg := "From: a@example.com\r\nSubject: folded\r\n line\r\n\r\nbody\r\n"
+	fields, body := splitMessage([]byte(msg))
+	want := []string{"From: a@example.com", "Subject: folded\n line"}
+	if strings.Join(fields, "|") != strings.Join(want, "|") {
+		t.Errorf("splitMessage(), got fields %q, want %q", fields, want)
+	}
+	if body != "body\n" {
+		t.Errorf("splitMessage(), got body %q", body)
+	}
+
+	fields, body = splitMessage([]byte("just a body\n"))
+	if fields != nil || body != "just a body\n" {
+		t.Errorf("splitMessage(body), got %q, %q", fields, body)
+	}
+}
+
+func TestPGPMIME(t *testing.T) {
+	var key openpgp.SignKey
+	key.Seed(bytes.Repeat([]byte{3}, 32))
+	sign := func(part []byte) ([]byte, error) {
+		sig, err := key.Sign(bytes.NewReader(part))
+		return openpgp.Armor(sig), err
+	}
+
+	msg := "To: b@example.com\nContent-Type: text/plain\n\nFrom here, na\xc3\xafve  \n"
+	output, err := pgpMIME([]byte(msg), "pgp-sha256", sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(output)
+	if !strings.HasPrefix(text, "To: b@example.com\nMIME-Version: 1.0\n") {
+		t.Errorf("pgpMIME(), outer header not kept:\n%s", text)
+	}
+	boundary := regexp.MustCompile(`boundary="([^"]+)"`).FindStringSubmatch(text)
+	if boundary == nil {
+		t.Fatalf("pgpMIME(), no boundary:\n%s", text)
+	}
+	parts := strings.Split(text, "\n--"+boundary[1])
+	if len(parts) != 4 {
+		t.Fatalf("pgpMIME(), got %d parts, want 4", len(parts))
+	}
+	part := parts[1][strings.IndexByte(parts[1], '\n')+1:]
+	if !mailSafe(part) {
+		t.Errorf("pgpMIME(), signed part not mail-safe:\n%s", part)
+	}
+
+	armored := parts[2][strings.Index(parts[2], "\n\n")+2:]
+	raw, err := dearmor([]byte(armored), openpgp.ArmorSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, _, err := openpgp.ParsePacket(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := openpgp.ParseSignature(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := strings.ReplaceAll(part, "\n", "\r\n")
+	if err := key.Verify(strings.NewReader(canonical), sig); err != nil {
+		t.Errorf("pgpMIME(), signature: %v", err)
+	}
+}
+
+func TestAgentExport(t *testing.T) {
+	// Files and keygrips written by GnuPG 2.2 for the same keys
+	files := map[string]string{
+		"425F4905E9ED0A8C5F730A844D6EE4F5A36A66BB.key": "" +
+			"Key: (private-key (ecc (curve Ed25519)(flags eddsa)(q\n" +
+			"  #404CD5D335EC6300495034B0E115A03EA697551BFF2C4B86C51E52633902DC89ED#)\n" +
+			" (d #550A223B0896A9BD05851A54096C319F02852B06163F5FC64510ABE2C4F0A803#)\n" +
+			" ))\n",
+		"63D6084EA0A8C58E2A08E227DE1F8B46F35FC54F.key": "" +
+			"Key: (private-key (ecc (curve Curve25519)(flags djb-tweak)(q\n" +
+			"  #400FA4D0B317AC14F14F94559A017D777826AD7280BC7357B6779965D9C563AC03#)\n" +
+			" (d #5C77BE9E41E4DE6E8D83FDBE48C817BB6A4CD9B0EF24FF21E40E74F4DEF5C9A0#)\n" +
+			" ))\n",
+	}
+
+	var key openpgp.SignKey
+	seed, _ := hex.DecodeString(
+		"550A223B0896A9BD05851A54096C319F02852B06163F5FC64510ABE2C4F0A803")
+	key.Seed(seed)
+	var subkey openpgp.EncryptKey
+	seed, _ = hex.DecodeString(
+		"A0C9F5DEF4740EE421FF24EFB0D94C6ABB17C848BEFD838D6EDEE4419EBE775C")
+	subkey.Seed(seed)
+
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ck := completeKey{key: &key, subkey: &subkey}
+	grips, err := ck.agentExport(dir, &config{subkey: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grips) != len(files) {
+		t.Fatalf("agentExport(), got %d keygrips, want %d", len(grips), len(files))
+	}
+	for _, grip := range grips {
+		name := fmt.Sprintf("%X.key", grip)
+		want, ok := files[name]
+		if !ok {
+			t.Errorf("agentExport(), unexpected keygrip %X", grip)
+			continue
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("agentExport(), got %q, want %q", got, want)
+		}
+	}
+
+	// Never overwrite a key gpg-agent may have since protected
+	if _, err := ck.agentExport(dir, &config{}); err == nil {
+		t.Errorf("agentExport(existing), got nil error")
+	}
+}
+
+func TestWriteCard(t *testing.T) {
+	var key openpgp.SignKey
+	key.Seed(bytes.Repeat([]byte{1}, 32))
+	key.SetCreated(1234567890)
+	var subkey openpgp.EncryptKey
+	subkey.Seed(bytes.Repeat([]byte{2}, 32))
+	subkey.SetCreated(1234567890)
+	ck := completeKey{key: &key, subkey: &subkey}
+	keys := ck.cardKeys(&config{subkey: true})
+
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake gpg-agent recording commands, with a card that accepts all
+	client, server := net.Pipe()
+	var commands []string
+	go func() {
+		defer server.Close()
+		fmt.Fprintf(server, "OK Pleased to meet you\n")
+		r := bufio.NewReader(server)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSuffix(line, "\n")
+			commands = append(commands, command)
+			switch {
+			case command == "SCD SERIALNO openpgp":
+				fmt.Fprintf(server, "S SERIALNO D2760001240103040006123456780000 0\n")
+			case strings.HasPrefix(command, "KEYTOCARD "):
+				grip := strings.Fields(command)[1]
+				if _, err := os.Stat(filepath.Join(dir, grip+".key")); err != nil {
+					fmt.Fprintf(server, "ERR 67108891 No secret key <GPG Agent>\n")
+					continue
+				}
+			case strings.HasPrefix(command, "SCD APDU "):
+				fmt.Fprintf(server, "D %%90%%00\n")
+			}
+			fmt.Fprintf(server, "OK\n")
+		}
+	}()
+	agent, err := newGPGAgent(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := writeCard(agent, dir, keys)
+	agent.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if serial != "D2760001240103040006123456780000" {
+		t.Errorf("writeCard(), got serial %q", serial)
+	}
+	want := []string{
+		"SCD SERIALNO openpgp",
+		fmt.Sprintf("KEYTOCARD %X D2760001240103040006123456780000 "+
+			"OPENPGP.1 20090213T233130", keys[0].key.grip),
+		fmt.Sprintf("SCD APDU 00DA00C714%X", key.KeyID()),
+		fmt.Sprintf("KEYTOCARD %X D2760001240103040006123456780000 "+
+			"OPENPGP.2 20090213T233130", keys[1].key.grip),
+		fmt.Sprintf("SCD APDU 00DA00C814%X", subkey.KeyID()),
+		"SCD KILLSCD",
+		"LEARN --force",
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("writeCard(), got commands:\n%s\nwant:\n%s",
+			strings.Join(commands, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Secret keys must not be left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("writeCard(), left %d key files", len(files))
+	}
+}
+
+func TestFido2(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake libfido2 tools are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "fido2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Fake libfido2 tools with one device holding one credential
+	tools := map[string]string{
+		"fido2-token": `echo "/dev/fake0: vendor=0x0000, product=0x0000 (Fake)"`,
+		"fido2-cred": `read cdh; read rp; read user; read id
+echo "$cdh"; echo "$rp"; echo packed; echo AA==; echo Y3JlZA==; echo AA==`,
+		"fido2-assert": `read cdh; read rp; read cred; read salt
+if [ "$cred" != Y3JlZA== ] || [ "$salt" != ` + base64.StdEncoding.EncodeToString(fido2Salt[:]) + ` ]; then
+  echo "fido2-assert: FIDO_ERR_NO_CREDENTIALS" >&2; exit 1
+fi
+echo "$cdh"; echo "$rp"; echo AA==; echo AA==
+echo AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=`,
+	}
+	for name, script := range tools {
+		path := filepath.Join(dir, name)
+		script = "#!/bin/sh\n" + script + "\n"
+		if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := os.Getenv("PATH")
+	defer os.Setenv("PATH", old)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+old)
+
+	touches := 0
+	touch := func(string) { touches++ }
+	file := filepath.Join(dir, "tokens")
+	if n, err := fido2Register(file, touch); err != nil || n != 1 {
+		t.Fatalf("fido2Register(), got %d, %v", n, err)
+	}
+	secret, err := fido2Secret(file, touch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Registering again keeps the secret
+	if n, err := fido2Register(file, touch); err != nil || n != 1 {
+		t.Fatalf("fido2Register(existing), got %d, %v", n, err)
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := parseFido2Tokens(string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Errorf("fido2Register(existing), got %d tokens, want 2", len(tokens))
+	}
+	again, err := fido2Secret(file, touch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, again) {
+		t.Errorf("fido2Secret(), secret changed, got %X, want %X", again, secret)
+	}
+	if touches != 7 {
+		t.Errorf("touch prompts, got %d, want 7", touches)
+	}
+
+	// A token file for some other security key
+	other := fido2Token{[]byte("other"), make([]byte, 32)}
+	if err := ioutil.WriteFile(file, []byte(other.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fido2Secret(file, touch); err == nil {
+		t.Errorf("fido2Secret(unregistered), got nil error")
+	}
+}
+
+func TestCache(t *testing.T) {
+	seed := bytes.Repeat([]byte{0xab}, 64)
+	value := cacheEncode(seed, 1234567890)
+	got, expires, ok := cacheDecode(value)
+	if !ok || expires != 1234567890 || !bytes.Equal(got, seed) {
+		t.Errorf("cacheDecode(), got %X, %d, %v", got, expires, ok)
+	}
+	for _, bad := range []string{"", "123", "x:abab", "123:", "123:abx"} {
+		if _, _, ok := cacheDecode([]byte(bad)); ok {
+			t.Errorf("cacheDecode(%q), got ok", bad)
+		}
+	}
+
+	// The real credential store, if available
+	name := fmt.Sprintf("passphrase2pgp-test-%d", os.Getpid())
+	if err := cacheStore(name, seed, time.Minute); err != nil {
+		t.Skip(err)
+	}
+	defer cacheClear(name)
+	got, err := cacheLoad(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, seed) {
+		t.Errorf("cacheLoad(), got %X, want %X", got, seed)
+	}
+	if err := cacheClear(name); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cacheLoad(name); got != nil || err != nil {
+		t.Errorf("cacheLoad(cleared), got %X, %v", got, err)
+	}
+
+	// Expired entries are never returned
+	if err := cacheStore(name, seed, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cacheLoad(name); got != nil || err != nil {
+		t.Errorf("cacheLoad(expired), got %X, %v", got, err)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# comment\n\nuid = A <a@example.com>\n" +
+		"  uid=B <b@example.com>  \narmor\nkdf-memory = 2048\n")
+	f.Close()
+
+	args, err := configArgs(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"--uid=A <a@example.com>",
+		"--uid=B <b@example.com>",
+		"--armor",
+		"--kdf-memory=2048",
+	}
+	if strings.Join(args, "\n") != strings.Join(want, "\n") {
+		t.Errorf("configArgs(), got %q, want %q", args, want)
+	}
+
+	// Command line options replace all config entries of that option
+	config := []optparse.Result{
+		{Option: optparse.Option{Long: "uid"}, Optarg: "A"},
+		{Option: optparse.Option{Long: "armor"}},
+		{Option: optparse.Option{Long: "uid"}, Optarg: "B"},
+	}
+	cmdline := []optparse.Result{
+		{Option: optparse.Option{Long: "uid"}, Optarg: "C"},
+	}
+	merged := mergeOptions(config, cmdline)
+	var got []string
+	for _, result := range merged {
+		got = append(got, result.Long+"="+result.Optarg)
+	}
+	if strings.Join(got, " ") != "armor= uid=C" {
+		t.Errorf("mergeOptions(), got %q", got)
+	}
+}
+
+func TestMinisign(t *testing.T) {
+	var key openpgp.SignKey
+	key.Seed(bytes.Repeat([]byte{7}, 32))
+	pub, sec := key.Pubkey(), key.Seckey()
+	seckey := ed25519.NewKeyFromSeed(sec[:32])
+	keynum := minisignKeynum(pub)
+
+	// Parse the base64 line following each untrusted comment
+	decode := func(b []byte) [][]byte {
+		var lines [][]byte
+		text := strings.TrimSuffix(string(b), "\n")
+		for i, line := range strings.Split(text, "\n") {
+			if i%2 == 0 {
+				continue // comments
+			}
+			raw, err := base64.StdEncoding.DecodeString(line)
+			if err != nil {
+				t.Fatalf("%q: %s", b, err)
+			}
+			lines = append(lines, raw)
+		}
+		return lines
+	}
+
+	n, r, p := minisignScryptParams(minisignOpslimit, minisignMemlimit)
+	if n != 1<<20 || r != 8 || p != 1 {
+		t.Errorf("minisignScryptParams(), got %d %d %d", n, r, p)
+	}
+	n, r, p = minisignScryptParams(1<<19, 1<<24) // "interactive"
+	if n != 1<<14 || r != 8 || p != 1 {
+		t.Errorf("minisignScryptParams(), got %d %d %d", n, r, p)
+	}
+
+	want := append(append([]byte("Ed"), keynum...), pub...)
+	for _, b := range [][]byte{pubMinisign(pub), pubSignify(pub)} {
+		if got := decode(b)[0]; !bytes.Equal(got, want) {
+			t.Errorf("public key, got %X, want %X", got, want)
+		}
+	}
+	id := fmt.Sprintf("%02X", []byte{keynum[7], keynum[6], keynum[5],
+		keynum[4], keynum[3], keynum[2], keynum[1], keynum[0]})
+	if !strings.Contains(string(pubMinisign(pub)), "public key "+id+"\n") {
+		t.Errorf("pubMinisign(), missing key ID %s", id)
+	}
+
+	// Secret keys, plain and protected with cheap parameters
+	for _, password := range [][]byte{nil, []byte("hunter2")} {
+		raw := decode(secMinisign(pub, sec, password, 1, 1<<20))[0]
+		body := raw[54:]
+		if password != nil {
+			if string(raw[2:4]) != "Sc" {
+				t.Fatalf("secMinisign(), got KDF %q", raw[2:4])
+			}
+			ops := binary.LittleEndian.Uint64(raw[38:])
+			mem := binary.LittleEndian.Uint64(raw[46:])
+			n, r, p := minisignScryptParams(ops, mem)
+			stream, _ := scrypt.Key(password, raw[6:38], n, r, p, len(body))
+			for i := range body {
+				body[i] ^= stream[i]
+			}
+		}
+		h, _ := blake2b.New256(nil)
+		h.Write([]byte("Ed"))
+		h.Write(body[:72])
+		if !bytes.Equal(body[:8], keynum) ||
+			!bytes.Equal(body[8:72], seckey) ||
+			!bytes.Equal(body[72:], h.Sum(nil)) {
+			t.Errorf("secMinisign(), got %X", raw)
+		}
+
+		raw = decode(secSignify(pub, sec, password, 1))[0]
+		body = raw[40:]
+		if password != nil {
+			stream := bcryptPBKDF(password, raw[8:24], len(body), 1)
+			for i := range body {
+				body[i] ^= stream[i]
+			}
+		}
+		sum := sha512.Sum512(body)
+		if string(raw[:4]) != "EdBK" ||
+			!bytes.Equal(raw[24:32], sum[:8]) ||
+			!bytes.Equal(raw[32:40], keynum) ||
+			!bytes.Equal(body, seckey) {
+			t.Errorf("secSignify(), got %X", raw)
+		}
+	}
+
+	message := []byte("hello world\n")
+	trusted := "timestamp:0\tfile:hello.txt\thashed"
+	out, err := sigMinisign(pub, sec, trusted, bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "\ntrusted comment: "+trusted+"\n") {
+		t.Errorf("sigMinisign(), got %q", out)
+	}
+	lines := decode(out)
+	digest := blake2b.Sum512(message)
+	sig := lines[0][10:]
+	if string(lines[0][:2]) != "ED" || !bytes.Equal(lines[0][2:10], keynum) ||
+		!ed25519.Verify(pub, digest[:], sig) ||
+		!ed25519.Verify(pub, append(sig, trusted...), lines[1]) {
+		t.Errorf("sigMinisign(), got %q", out)
+	}
+
+	out, err = sigSignify(pub, sec, bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := decode(out)[0]
+	if string(raw[:2]) != "Ed" || !bytes.Equal(raw[2:10], keynum) ||
+		!ed25519.Verify(pub, message, raw[10:]) {
+		t.Errorf("sigSignify(), got %q", out)
+	}
+}
+
+func TestAge(t *testing.T) {
+	// BIP 173 test vectors
+	data, _ := hex.DecodeString("00443214c74254b635cf84653a56d7c675be77df")
+	tests := []struct {
+		hrp  string
+		data []byte
+		want string
+	}{
+		{"a", nil, "a12uel5l"},
+		{"abcdef", data, "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw"},
+	}
+	for _, test := range tests {
+		if got := bech32Encode(test.hrp, test.data); got != test.want {
+			t.Errorf("bech32Encode(%q), got %s, want %s", test.hrp, got, test.want)
+		}
+	}
+
+	// Identity from age-keygen's documentation
+	sec := bytes.Repeat([]byte{'B'}, 32)
+	pub, err := curve25519.X25519(sec, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# created: 2019-12-20T19:55:34Z\n" +
+		"# public key: " + ageRecipient(pub) + "\n" +
+		"AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX\n"
+	if got := string(secAge(pub, sec, 1576871734)); got != want {
+		t.Errorf("secAge(), got %q, want %q", got, want)
+	}
+	if got := ageRecipient(pub); !strings.HasPrefix(got, "age1") || len(got) != 62 {
+		t.Errorf("ageRecipient(), got %s", got)
+	}
+}
+
+func TestRawKeys(t *testing.T) {
+	// RFC 8037, Appendix A
+	pub, _ := hex.DecodeString(
+		"d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	sec, _ := hex.DecodeString(
+		"9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	k := newJWK("Ed25519", "sig", "EdDSA", pub, sec)
+	if k.X != "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo" ||
+		k.D != "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A" ||
+		k.Kid != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("newJWK(), got %+v", k)
+	}
+
+	keys := []pemKey{{pkcs8Ed25519, spkiEd25519, sec, pub}}
+	rest := encodePEMKeys(keys, false)
+	var blocks []*stdpem.Block
+	for {
+		var block *stdpem.Block
+		block, rest = stdpem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("encodePEMKeys(), got %d blocks, want 2", len(blocks))
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(blocks[0].Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(priv.(ed25519.PrivateKey).Seed(), sec) {
+		t.Errorf("encodePEMKeys(), wrong private key")
+	}
+	public, err := x509.ParsePKIXPublicKey(blocks[1].Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(public.(ed25519.PublicKey), pub) {
+		t.Errorf("encodePEMKeys(), wrong public key")
+	}
+}
+
+func TestX509(t *testing.T) {
+	var key openpgp.SignKey
+	key.Seed(bytes.Repeat([]byte{3}, 32))
+	key.SetCreated(1577836800)
+	key.SetExpires(1609459200)
+	userids := []openpgp.UserID{
+		{ID: []byte("Real Name <name@example.com>")},
+		{ID: []byte("Other <other@example.org>")},
+	}
+	ck := completeKey{key: &key, userids: userids}
+
+	b := ck.encodeX509(&config{})
+	block, rest := stdpem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("encodeX509(), got %q", b)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cert.CheckSignature(cert.SignatureAlgorithm,
+		cert.RawTBSCertificate, cert.Signature)
+	if err != nil {
+		t.Errorf("encodeX509(), not self-signed: %s", err)
+	}
+	if cert.Subject.CommonName != "Real Name <name@example.com>" ||
+		strings.Join(cert.EmailAddresses, " ") !=
+			"name@example.com other@example.org" {
+		t.Errorf("encodeX509(), got subject %q, emails %q",
+			cert.Subject.CommonName, cert.EmailAddresses)
+	}
+	if cert.NotBefore.Unix() != 1577836800 || cert.NotAfter.Unix() != 1609459200 {
+		t.Errorf("encodeX509(), got validity %s to %s",
+			cert.NotBefore, cert.NotAfter)
+	}
+	if !bytes.Equal(cert.PublicKey.(ed25519.PublicKey), key.Pubkey()) {
+		t.Errorf("encodeX509(), wrong public key")
+	}
+
+	block, _ = stdpem.Decode(rest)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("encodeX509(), missing private key")
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(priv.(ed25519.PrivateKey).Seed(), key.Seckey()[:32]) {
+		t.Errorf("encodeX509(), wrong private key")
+	}
+
+	// Public output is only the certificate
+	b = ck.encodeX509(&config{public: true})
+	if _, rest := stdpem.Decode(b); len(rest) != 0 {
+		t.Errorf("encodeX509(), got %q after certificate", rest)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	var key openpgp.SignKey
+	key.Seed(make([]byte, 32))
+	key.SetCreated(1577836800)
+	var subkey openpgp.EncryptKey
+	subkey.Seed(bytes.Repeat([]byte{1}, 32))
+	subkey.SetCreated(1577836800)
+	userid := openpgp.UserID{ID: []byte("A <a@example.com>")}
+	ck := completeKey{&key, []openpgp.UserID{userid}, nil, &subkey, nil, nil}
+
+	conf := &config{subkey: true, derived: true}
+	conf.kdf = kdfParams{algorithm: kdfPBKDF2, version: 1, iterations: 1000}
+	outputs := []jsonOutput{{"-", 100}}
+	m := ck.metadata(conf, outputs)
+
+	fpr := fmt.Sprintf("%X", key.KeyID())
+	if m.Fingerprint != fpr || m.KeyID != fpr[24:] {
+		t.Errorf("metadata(), got %s %s, want %s", m.Fingerprint, m.KeyID, fpr)
+	}
+	if m.Created != 1577836800 || m.Usage != "certify,sign" {
+		t.Errorf("metadata(), got created %d, usage %q", m.Created, m.Usage)
+	}
+	if len(m.Subkeys) != 1 || m.Subkeys[0].Algorithm != "cv25519" ||
+		m.Subkeys[0].Fingerprint != fmt.Sprintf("%X", subkey.KeyID()) {
+		t.Errorf("metadata(), got subkeys %+v", m.Subkeys)
+	}
+	if m.Derivation == nil || m.Derivation.Iterations != 1000 ||
+		m.Derivation.MemoryKiB != 0 {
+		t.Errorf("metadata(), got derivation %+v", m.Derivation)
+	}
+
+	// Keys not derived from a passphrase have no derivation
+	conf.derived = false
+	b, err := json.Marshal(ck.metadata(conf, outputs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"derivation":null`)) ||
+		!bytes.Contains(b, []byte(`"outputs":[{"name":"-","bytes":100}]`)) {
+		t.Errorf("metadata(), got %s", b)
+	}
+}
+
+func TestAddSSHAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	old := os.Getenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", old)
+	os.Setenv("SSH_AUTH_SOCK", sock)
+
+	// Accept one request, replying success, and capture it
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer conn.Close()
+		var n [4]byte
+		io.ReadFull(conn, n[:])
+		msg := make([]byte, binary.BigEndian.Uint32(n[:]))
+		io.ReadFull(conn, msg)
+		conn.Write([]byte{0, 0, 0, 1, sshAgentSuccess})
+		got <- msg
+	}()
+
+	seed := bytes.Repeat([]byte{7}, 32)
+	seckey := ed25519.NewKeyFromSeed(seed)
+	pub := seckey.Public().(ed25519.PublicKey)
+	if err := addSSHAgent(pub, seed, []byte("uid"), 60); err != nil {
+		t.Fatal(err)
+	}
+
+	var want bytes.Buffer
+	want.WriteByte(sshAgentAddConstrained)
+	for _, field := range [][]byte{[]byte("ssh-ed25519"), pub, seckey,
+		[]byte("uid")} {
+		binary.Write(&want, binary.BigEndian, uint32(len(field)))
+		want.Write(field)
+	}
+	want.Write([]byte{sshAgentConstrainExpiry, 0, 0, 0, 60})
+	if msg := <-got; !bytes.Equal(msg, want.Bytes()) {
+		t.Errorf("addSSHAgent() sent %X, want %X", msg, want.Bytes())
+	}
+}
+
+func TestSSHAgent(t *testing.T) {
+	seed := bytes.Repeat([]byte{9}, 32)
+	a := sshAgent{key: ed25519.NewKeyFromSeed(seed), comment: []byte("uid")}
+	server, conn := net.Pipe()
+	go a.handle(server)
+	defer conn.Close()
+	client := sshagent.NewClient(conn)
+
+	keys, err := client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "uid" ||
+		!bytes.Equal(keys[0].Blob, a.blob()) {
+		t.Fatalf("List(), got %v", keys)
+	}
+
+	data := []byte("hello")
+	sig, err := client.Sign(keys[0], data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys[0].Verify(data, sig); err != nil {
+		t.Errorf("Sign(), %s", err)
+	}
+
+	// Only the one key can be used, and it cannot be removed
+	other, _ := ssh.NewPublicKey(ed25519.NewKeyFromSeed(make([]byte, 32)).Public())
+	if _, err := client.Sign(other, data); err == nil {
+		t.Errorf("Sign(other key), got nil error")
+	}
+	if err := client.RemoveAll(); err == nil {
+		t.Errorf("RemoveAll(), got nil error")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
@@ -16,8 +17,8 @@ var (
 	errPinentryProtocol = errors.New("pinentry protocol error")
 	// errPinentryCancel means the user canceled the input.
 	errPinentryCancel = errors.New("pinentry input canceled")
-	// errPinentryMismatch means the confirmation passphrase did not match.
-	errPinentryMismatch = errors.New("passphrases do not match")
+	// errMismatch means the confirmation passphrase did not match.
+	errMismatch = errors.New("passphrases do not match")
 )
 
 // pinentry represents a running, interactive pinentry process used for
@@ -160,8 +161,11 @@ func pinentryPassphrase(command, hint string, repeat int) ([]byte, error) {
 	passphrase := pe.Send("GETPIN")
 	for i := 0; i < repeat; i++ {
 		again := pe.Send("GETPIN")
-		if !bytes.Equal(passphrase, again) {
-			return nil, errPinentryMismatch
+		match := subtle.ConstantTimeCompare(passphrase, again) == 1
+		wipe(again)
+		if !match {
+			wipe(passphrase)
+			return nil, errMismatch
 		}
 	}
 	return passphrase, pe.err
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	stdpem "encoding/pem"
+)
+
+// PKCS #8 and SubjectPublicKeyInfo encodings of Ed25519 and X25519 keys
+// (RFC 8410) are a fixed DER prefix followed by the 32-byte key, which
+// also covers X25519, unsupported by crypto/x509.
+
+var (
+	pkcs8Ed25519 = []byte{
+		0x30, 0x2e, 0x02, 0x01, 0x00, 0x30, 0x05, 0x06,
+		0x03, 0x2b, 0x65, 0x70, 0x04, 0x22, 0x04, 0x20,
+	}
+	pkcs8X25519 = []byte{
+		0x30, 0x2e, 0x02, 0x01, 0x00, 0x30, 0x05, 0x06,
+		0x03, 0x2b, 0x65, 0x6e, 0x04, 0x22, 0x04, 0x20,
+	}
+	spkiEd25519 = []byte{
+		0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65,
+		0x70, 0x03, 0x21, 0x00,
+	}
+	spkiX25519 = []byte{
+		0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65,
+		0x6e, 0x03, 0x21, 0x00,
+	}
+)
+
+// A key pair to encode as PEM, with its PKCS #8 and SPKI prefixes.
+type pemKey struct {
+	pkcs8, spki []byte
+	sec, pub    []byte
+}
+
+// Encode private keys (unless public), then public keys, as PEM.
+func encodePEMKeys(keys []pemKey, public bool) []byte {
+	var out bytes.Buffer
+	if !public {
+		for _, k := range keys {
+			der := append(append([]byte(nil), k.pkcs8...), k.sec...)
+			stdpem.Encode(&out, &stdpem.Block{Type: "PRIVATE KEY", Bytes: der})
+			wipe(der)
+		}
+	}
+	for _, k := range keys {
+		der := append(append([]byte(nil), k.spki...), k.pub...)
+		stdpem.Encode(&out, &stdpem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+	return out.Bytes()
+}
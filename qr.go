@@ -0,0 +1,553 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// A minimal QR Code encoder: byte mode, error correction level L, and
+// versions 1 through 40. This is just enough to move a public key or
+// fingerprint to a phone without a third-party dependency.
+
+const (
+	qrQuiet = 4 // quiet zone width in modules
+	qrScale = 8 // PNG pixels per module
+)
+
+var errQRTooLong = errors.New("data too long for a QR code")
+
+// Error correction codewords per block at level L, indexed by version.
+var qrECCPerBlock = [41]int{
+	0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24,
+	28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30,
+	30, 30, 30, 30, 30, 30, 30,
+}
+
+// Number of error correction blocks at level L, indexed by version.
+var qrBlocks = [41]int{
+	0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9,
+	9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24,
+	25,
+}
+
+type qrCode struct {
+	size     int
+	modules  [][]bool // true is dark, indexed [y][x]
+	function [][]bool // modules not available for data
+}
+
+// Encode data as a QR Code, returning the module matrix indexed by
+// row then column, where true is a dark module.
+func qrEncode(data []byte) ([][]bool, error) {
+	version := 1
+	for ; ; version++ {
+		if version > 40 {
+			return nil, errQRTooLong
+		}
+		if qrDataBits(data, version) <= qrDataCodewords(version)*8 {
+			break
+		}
+	}
+
+	// Mode indicator, character count, data, and terminator
+	var bits qrBits
+	bits.append(4, 4)
+	if version < 10 {
+		bits.append(len(data), 8)
+	} else {
+		bits.append(len(data), 16)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := qrDataCodewords(version) * 8
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capacity; pad ^= 0xec ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		codewords[i/8] |= bit << uint(7-i%8)
+	}
+
+	qr := newQRCode(version)
+	qr.drawCodewords(qrAddECC(codewords, version))
+
+	// Choose the mask with the lowest penalty
+	best := 0
+	bestPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormat(mask)
+		penalty := qr.penalty()
+		if bestPenalty < 0 || penalty < bestPenalty {
+			best = mask
+			bestPenalty = penalty
+		}
+		qr.applyMask(mask) // undo
+	}
+	qr.applyMask(best)
+	qr.drawFormat(best)
+	return qr.modules, nil
+}
+
+type qrBits []byte
+
+func (b *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, byte(v>>uint(i)&1))
+	}
+}
+
+// Number of bits needed to encode data in byte mode at a version.
+func qrDataBits(data []byte, version int) int {
+	count := 8
+	if version >= 10 {
+		count = 16
+	}
+	if len(data) >= 1<<uint(count) {
+		return 1 << 30
+	}
+	return 4 + count + len(data)*8
+}
+
+// Number of modules available for codewords, including remainder bits.
+func qrRawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func qrDataCodewords(version int) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[version]*qrBlocks[version]
+}
+
+// Split the data into blocks, compute error correction for each block,
+// and interleave the result.
+func qrAddECC(data []byte, version int) []byte {
+	nblocks := qrBlocks[version]
+	ecclen := qrECCPerBlock[version]
+	raw := qrRawModules(version) / 8
+	nshort := nblocks - raw%nblocks
+	shortlen := raw/nblocks - ecclen
+
+	divisor := qrDivisor(ecclen)
+	blocks := make([][]byte, nblocks)
+	eccs := make([][]byte, nblocks)
+	for i := range blocks {
+		n := shortlen
+		if i >= nshort {
+			n++
+		}
+		blocks[i] = data[:n]
+		eccs[i] = qrRemainder(blocks[i], divisor)
+		data = data[n:]
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i <= shortlen; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < ecclen; i++ {
+		for _, ecc := range eccs {
+			result = append(result, ecc[i])
+		}
+	}
+	return result
+}
+
+// Multiply in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func qrMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ z>>7*0x1d
+		z ^= (y >> uint(i) & 1) * x
+	}
+	return z
+}
+
+// Reed-Solomon generator polynomial of the given degree, highest
+// coefficient first, with the leading 1 omitted.
+func qrDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	var root byte = 1
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = qrMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = qrMultiply(root, 2)
+	}
+	return result
+}
+
+func qrRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= qrMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// Create a code with all function patterns drawn and reserved.
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	qr := &qrCode{size: size}
+	qr.modules = make([][]bool, size)
+	qr.function = make([][]bool, size)
+	for y := range qr.modules {
+		qr.modules[y] = make([]bool, size)
+		qr.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		qr.set(6, i, i%2 == 0)
+		qr.set(i, 6, i%2 == 0)
+	}
+
+	qr.drawFinder(3, 3)
+	qr.drawFinder(size-4, 3)
+	qr.drawFinder(3, size-4)
+
+	align := qrAlignment(version)
+	last := len(align) - 1
+	for i, x := range align {
+		for j, y := range align {
+			corner := i == 0 && j == 0 ||
+				i == 0 && j == last ||
+				i == last && j == 0
+			if !corner {
+				qr.drawAlignment(x, y)
+			}
+		}
+	}
+
+	qr.drawFormat(0) // reserve
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ rem>>11*0x1f25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>uint(i)&1 == 1
+			a := size - 11 + i%3
+			b := i / 3
+			qr.set(a, b, dark)
+			qr.set(b, a, dark)
+		}
+	}
+	return qr
+}
+
+// Alignment pattern center coordinates for a version.
+func qrAlignment(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	result := make([]int, n)
+	result[0] = 6
+	pos := version*4 + 17 - 7
+	for i := n - 1; i >= 1; i-- {
+		result[i] = pos
+		pos -= step
+	}
+	return result
+}
+
+// Set a function module.
+func (qr *qrCode) set(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+func (qr *qrCode) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= qr.size || y < 0 || y >= qr.size {
+				continue
+			}
+			d := qrChebyshev(dx, dy)
+			qr.set(x, y, d != 2 && d != 4)
+		}
+	}
+}
+
+func (qr *qrCode) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			qr.set(cx+dx, cy+dy, qrChebyshev(dx, dy) != 1)
+		}
+	}
+}
+
+func qrChebyshev(dx, dy int) int {
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	if dx > dy {
+		return dx
+	}
+	return dy
+}
+
+// Draw both copies of the format information for level L.
+func (qr *qrCode) drawFormat(mask int) {
+	data := 1<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ rem>>9*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return bits>>uint(i)&1 == 1
+	}
+
+	for i := 0; i <= 5; i++ {
+		qr.set(8, i, bit(i))
+	}
+	qr.set(8, 7, bit(6))
+	qr.set(8, 8, bit(7))
+	qr.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.set(14-i, 8, bit(i))
+	}
+
+	size := qr.size
+	for i := 0; i < 8; i++ {
+		qr.set(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.set(8, size-15+i, bit(i))
+	}
+	qr.set(8, size-8, true)
+}
+
+// Place codewords in the zigzag pattern, skipping function modules.
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !qr.function[y][x] && i < len(data)*8 {
+					qr.modules[y][x] = data[i/8]>>uint(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// XOR a mask pattern over the data modules. Applying it twice undoes it.
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !qr.function[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// Compute the mask penalty score per ISO/IEC 18004 section 7.8.3.
+func (qr *qrCode) penalty() int {
+	size := qr.size
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	finder := []bool{true, false, true, true, true, false, true}
+	penalty := 0
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < size; y++ {
+			// Runs of five or more modules of the same color
+			run := 1
+			for x := 1; x <= size; x++ {
+				if x < size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			// Finder-like patterns with four light modules on a side
+			for x := 0; x+len(finder) <= size; x++ {
+				match := true
+				for i, dark := range finder {
+					if at(x+i, y, transpose) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				light := func(from, to int) bool {
+					if from < 0 || to > size {
+						return false
+					}
+					for i := from; i < to; i++ {
+						if at(i, y, transpose) {
+							return false
+						}
+					}
+					return true
+				}
+				if light(x-4, x) || light(x+7, x+11) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := qr.modules[y][x]
+			if c {
+				dark++
+			}
+			if x+1 < size && y+1 < size &&
+				c == qr.modules[y][x+1] &&
+				c == qr.modules[y+1][x] &&
+				c == qr.modules[y+1][x+1] {
+				penalty += 3
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	total := size * size
+	diff := dark*20 - total*10
+	if diff < 0 {
+		diff = -diff
+	}
+	penalty += ((diff+total-1)/total - 1) * 10
+	return penalty
+}
+
+// Render a QR Code for a terminal using half block characters, two
+// rows per line. Light modules are drawn as blocks, assuming light text
+// on a dark background.
+func qrText(w io.Writer, modules [][]bool) error {
+	size := len(modules)
+	dark := func(x, y int) bool {
+		x -= qrQuiet
+		y -= qrQuiet
+		if x < 0 || x >= size || y < 0 || y >= size {
+			return false
+		}
+		return modules[y][x]
+	}
+
+	var b strings.Builder
+	for y := 0; y < size+qrQuiet*2; y += 2 {
+		for x := 0; x < size+qrQuiet*2; x++ {
+			top := !dark(x, y)
+			bottom := !dark(x, y+1) && y+1 < size+qrQuiet*2
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Render a QR Code as a black and white PNG image.
+func qrPNG(w io.Writer, modules [][]bool) error {
+	size := (len(modules) + qrQuiet*2) * qrScale
+	palette := color.Palette{color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			px := (x + qrQuiet) * qrScale
+			py := (y + qrQuiet) * qrScale
+			for dy := 0; dy < qrScale; dy++ {
+				for dx := 0; dx < qrScale; dx++ {
+					img.SetColorIndex(px+dx, py+dy, 1)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Shamir's secret sharing over GF(2^8), each byte of the secret split
+// independently with the AES polynomial x^8 + x^4 + x^3 + x + 1. A
+// share is its x coordinate (1 to 255), the threshold, and the y
+// coordinate for every byte, written as a header line followed by the
+// mnemonic encoding of the y bytes:
+//
+//     share 2, threshold 3
+//     word word word ...
+
+var errShareMismatch = errors.New("shares are from different splits")
+
+type share struct {
+	index     int // x coordinate
+	threshold int
+	y         []byte
+}
+
+// Multiply in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1.
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ z>>7*0x1b
+		z ^= (y >> uint(i) & 1) * x
+	}
+	return z
+}
+
+// Multiplicative inverse in GF(2^8), x^254, for non-zero x.
+func gfInverse(x byte) byte {
+	var z byte = 1
+	for i := 0; i < 254; i++ {
+		z = gfMultiply(z, x)
+	}
+	return z
+}
+
+// Split a secret into n shares such that any k recover it.
+func shamirSplit(secret []byte, k, n int) []share {
+	shares := make([]share, n)
+	for i := range shares {
+		shares[i] = share{i + 1, k, make([]byte, len(secret))}
+		lockMemory(shares[i].y)
+	}
+
+	coeffs := make([]byte, k-1)
+	lockMemory(coeffs)
+	for b, s := range secret {
+		if _, err := rand.Read(coeffs); err != nil {
+			panic(err) // should never happen
+		}
+		for i := range shares {
+			// Horner's method, highest coefficient first
+			x := byte(shares[i].index)
+			var y byte
+			for j := len(coeffs) - 1; j >= 0; j-- {
+				y = gfMultiply(y^coeffs[j], x)
+			}
+			shares[i].y[b] = y ^ s
+		}
+	}
+	wipe(coeffs)
+	return shares
+}
+
+// Recover the secret from at least a threshold of shares.
+func shamirCombine(shares []share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+	k := shares[0].threshold
+	var use []share
+	seen := make(map[int]bool)
+	for _, s := range shares {
+		if s.threshold != k || len(s.y) != len(shares[0].y) {
+			return nil, errShareMismatch
+		}
+		if !seen[s.index] && len(use) < k {
+			seen[s.index] = true
+			use = append(use, s)
+		}
+	}
+	if len(use) < k {
+		return nil, fmt.Errorf("need %d distinct shares, have %d",
+			k, len(use))
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, len(use[0].y))
+	keepSecret(secret)
+	for i, si := range use {
+		var num, den byte = 1, 1
+		for j, sj := range use {
+			if i != j {
+				num = gfMultiply(num, byte(sj.index))
+				den = gfMultiply(den, byte(si.index^sj.index))
+			}
+		}
+		basis := gfMultiply(num, gfInverse(den))
+		for b, y := range si.y {
+			secret[b] ^= gfMultiply(y, basis)
+		}
+	}
+	return secret, nil
+}
+
+// String encodes the share in its text format.
+func (s share) String() string {
+	return fmt.Sprintf("share %d, threshold %d\n%s",
+		s.index, s.threshold, mnemonicEncode(s.y))
+}
+
+// Parse one or more shares in text format.
+func parseShares(text string) ([]share, error) {
+	var shares []share
+	var words []string
+	finish := func() error {
+		if len(shares) == 0 {
+			if len(words) > 0 {
+				return errors.New("words before the first share header")
+			}
+			return nil
+		}
+		y, err := mnemonicDecode(strings.Join(words, " "))
+		if err != nil {
+			return fmt.Errorf("share %d: %s", shares[len(shares)-1].index, err)
+		}
+		keepSecret(y)
+		shares[len(shares)-1].y = y
+		words = words[:0]
+		return nil
+	}
+
+	for _, line := range strings.Split(strings.ToLower(text), "\n") {
+		// "share" is also a mnemonic word, but never followed by a number
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "share" ||
+			fields[1][0] < '0' || fields[1][0] > '9' {
+			words = append(words, fields...)
+			continue
+		}
+		if err := finish(); err != nil {
+			return nil, err
+		}
+		var s share
+		_, err := fmt.Sscanf(line, "share %d, threshold %d",
+			&s.index, &s.threshold)
+		if err != nil || s.index < 1 || s.index > 255 || s.threshold < 1 {
+			return nil, fmt.Errorf("invalid share header: %q", line)
+		}
+		shares = append(shares, s)
+	}
+	if len(shares) == 0 {
+		return nil, errors.New("no shares found")
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
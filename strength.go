@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Passphrases estimated below this many bits draw a warning, unless
+// --min-entropy sets another threshold.
+const weakEntropy = 60
+
+// Character classes for passphraseEntropy.
+const (
+	classLetter = iota
+	classDigit
+	classSpace
+	classOther
+)
+
+func charClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r):
+		return classLetter
+	case unicode.IsDigit(r):
+		return classDigit
+	case unicode.IsSpace(r):
+		return classSpace
+	}
+	return classOther
+}
+
+// Estimate a passphrase's entropy in bits, roughly in the style of
+// zxcvbn: the passphrase is split into runs of letters, digits, and
+// other characters, and each run is scored by how an attacker would
+// guess it. Words cost a dictionary lookup rather than their letters,
+// and repeated or sequential characters ("aaa", "123") add little. It
+// errs low, and is only a guide, not a guarantee.
+func passphraseEntropy(passphrase []byte) float64 {
+	var bits float64
+	runes := []rune(string(passphrase))
+	for i := 0; i < len(runes); {
+		class := charClass(runes[i])
+		j := i + 1
+		for j < len(runes) && charClass(runes[j]) == class {
+			j++
+		}
+		bits += runEntropy(runes[i:j], class)
+		i = j
+	}
+	return bits
+}
+
+// Estimate the entropy of a run of characters of one class.
+func runEntropy(run []rune, class int) float64 {
+	var pool float64
+	switch class {
+	case classSpace:
+		return 0 // separators are predictable
+	case classDigit:
+		pool = 10
+	case classOther:
+		pool = 33
+	case classLetter:
+		var lower, upper, other bool
+		for _, r := range run {
+			switch {
+			case r > unicode.MaxASCII:
+				other = true
+			case unicode.IsUpper(r):
+				upper = true
+			default:
+				lower = true
+			}
+		}
+		pool = 26
+		if lower && upper {
+			pool = 52
+		}
+		if other {
+			pool = 100
+		}
+	}
+
+	var bits float64
+	for i, r := range run {
+		if i > 0 && (r == run[i-1] || r == run[i-1]+1 || r == run[i-1]-1) {
+			bits++ // repeated or sequential
+			continue
+		}
+		bits += math.Log2(pool)
+	}
+
+	if class == classLetter && len(run) >= 3 {
+		// Might be a word: one of the BIP39 list, or from a large
+		// dictionary, perhaps capitalized
+		word := strings.ToLower(string(run))
+		wordBits := 16.0
+		i, ok := mnemonicIndex[mnemonicKey(word)]
+		if ok && mnemonicWords[i] == word {
+			wordBits = 11
+		}
+		if word != string(run) {
+			wordBits++
+		}
+		bits = math.Min(bits, wordBits)
+	}
+	return bits
+}
@@ -1,27 +1,35 @@
 package main
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+var errNoTerminal = errors.New(
+	"no terminal for a passphrase prompt, use --input or --pinentry")
+
 // Read, confirm, and return a passphrase from the user via terminal.
 func terminalPassphrase(hint string, repeat int) ([]byte, error) {
 	fd := int(syscall.Stdin)
 	var out io.Writer = os.Stderr
 	if !terminal.IsTerminal(fd) {
-		tty, err := os.Create("/dev/tty") // O_RDWR
+		in, tty, err := openTerminal()
 		if err != nil {
-			fatal("failed to open /dev/tty")
+			return nil, errNoTerminal
+		}
+		defer in.Close()
+		if tty != in {
+			defer tty.Close()
 		}
-		defer tty.Close()
-		fd = int(tty.Fd())
+		fd = int(in.Fd())
 		out = tty
 	}
 
@@ -51,9 +59,85 @@ func terminalPassphrase(hint string, repeat int) ([]byte, error) {
 			return nil, err
 		}
 		out.Write(tail)
-		if !bytes.Equal(again, passphrase) {
-			return nil, errors.New("passphrases do not match")
+		match := subtle.ConstantTimeCompare(again, passphrase) == 1
+		wipe(again)
+		if !match {
+			wipe(passphrase)
+			return nil, errMismatch
 		}
 	}
 	return passphrase, nil
 }
+
+// Ask the user to confirm, via terminal, after displaying the prompt.
+// Anything but "y" or "yes" is a no.
+func terminalConfirm(prompt string) (bool, error) {
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stderr
+	if !terminal.IsTerminal(int(syscall.Stdin)) {
+		tin, tty, err := openTerminal()
+		if err != nil {
+			return false, errors.New("no terminal for a confirmation prompt")
+		}
+		defer tin.Close()
+		if tty != tin {
+			defer tty.Close()
+		}
+		in = tin
+		out = tty
+	}
+
+	fmt.Fprintf(out, "%s [y/N] ", prompt)
+	// One byte at a time, so as not to consume input past the line
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := in.Read(b)
+		if n == 0 || b[0] == '\n' {
+			if err != nil && err != io.EOF {
+				return false, err
+			}
+			break
+		}
+		line = append(line, b[0])
+	}
+	answer := strings.ToLower(strings.TrimSpace(string(line)))
+	return answer == "y" || answer == "yes", nil
+}
+
+// Display a spinner and the elapsed time on standard error, if it's a
+// terminal, until the returned function is called. Key derivation is
+// otherwise silent for long enough to be mistaken for a hang.
+func progress(label string) (done func()) {
+	if !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		const spinner = `-\|/`
+		start := time.Now()
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		var width int
+		for i := 0; ; i++ {
+			secs := int(time.Since(start).Seconds())
+			line := fmt.Sprintf("%s %c %ds", label, spinner[i%4], secs)
+			width = len(line)
+			fmt.Fprintf(os.Stderr, "\r%s", line)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				// Erase the line for whatever output follows
+				blank := strings.Repeat(" ", width)
+				fmt.Fprintf(os.Stderr, "\r%s\r", blank)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
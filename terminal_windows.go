@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+)
+
+// Open the console for reading a passphrase and writing prompts, for
+// when standard input isn't a console, such as when it's redirected.
+// Console input must be opened read-write in order to disable echo.
+func openTerminal() (in, out *os.File, err error) {
+	in, err = os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err = os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
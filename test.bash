@@ -9,6 +9,7 @@ set -euo pipefail
 export REALNAME="John Doe"
 export EMAIL="john.doe@example.com"
 export KEYID="2536A19C9C54880A8FEBC812070B00717FCDEE34"
+export PASSPHRASE2PGP_CONFIG=/dev/null  # ignore the user's config file
 passphrase="foobar"
 
 go test
@@ -40,6 +41,12 @@ echo message > $homedir/message
 $gpgv $homedir/message.sig $homedir/message
 ./passphrase2pgp -S --load $homedir/seckey.asc --armor $homedir/message
 $gpgv $homedir/message.asc $homedir/message
+echo second > $homedir/second
+./passphrase2pgp -S --load $homedir/seckey.asc $homedir/message $homedir/second
+$gpgv $homedir/message.sig $homedir/message
+$gpgv $homedir/second.sig $homedir/second
+./passphrase2pgp -S --load $homedir/seckey.asc --inline $homedir/message
+$gpgv $homedir/message.gpg
 
 echo === Testing Protected PGP Keys ===
 ./passphrase2pgp -K --input <(echo $passphrase) \
@@ -68,6 +75,20 @@ $gpg --trust-model always \
 $gpg --import $homedir/secsub.asc
 $gpg --decrypt $homedir/message.txt.gpg
 
+echo === Testing Extended Keys ===
+./passphrase2pgp -K --load $homedir/seckey.asc \
+                    --input <(echo $passphrase) \
+                    --subkey \
+                    --uid "John Doe <jdoe@example.org>" \
+                    --armor \
+    | tee $homedir/secext.asc
+$gpg --import $homedir/secext.asc
+$gpg --trust-model always \
+     --recipient jdoe@example.org \
+     --output $homedir/message.ext.gpg \
+     --encrypt $homedir/message.txt
+$gpg --decrypt $homedir/message.ext.gpg
+
 echo === Testing SSH Keys ===
 ./passphrase2pgp -K --uid doe@exmaple.com \
                     --check '' \
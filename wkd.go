@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nullprogram.com/x/passphrase2pgp/openpgp"
+)
+
+// Web Key Directory (draft-koch-openpgp-webkey-service) layout for the
+// advanced method, served from https://openpgpkey.DOMAIN/.
+
+const zbase32 = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+// Encode data in z-base-32, as used for WKD hashes.
+func zbase32Encode(data []byte) string {
+	var b strings.Builder
+	var acc, nbits uint
+	for _, c := range data {
+		acc = acc<<8 | uint(c)
+		nbits += 8
+		for nbits >= 5 {
+			nbits -= 5
+			b.WriteByte(zbase32[acc>>nbits&31])
+		}
+	}
+	if nbits > 0 {
+		b.WriteByte(zbase32[acc<<(5-nbits)&31])
+	}
+	return b.String()
+}
+
+// Returns the email address in a user ID, or empty if it has none.
+func uidEmail(uid string) string {
+	beg := strings.IndexByte(uid, '<')
+	end := strings.LastIndexByte(uid, '>')
+	if beg == -1 || end < beg {
+		return ""
+	}
+	return uid[beg+1 : end]
+}
+
+// Returns the WKD path of an email address relative to the web root:
+// the domain, then the hashed, lowercased local part.
+func wkdPath(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	local := strings.ToLower(email[:at])
+	domain := strings.ToLower(email[at+1:])
+	sum := sha1.Sum([]byte(local))
+	hu := filepath.Join(".well-known", "openpgpkey", domain, "hu")
+	return filepath.Join(hu, zbase32Encode(sum[:]))
+}
+
+// Write a binary public key into a WKD tree under dir for each user
+// ID with an email address, returning the paths written.
+func wkdExport(dir string, key []byte, userids []openpgp.UserID) ([]string, error) {
+	var paths []string
+	for _, userid := range userids {
+		email := uidEmail(string(userid.ID))
+		if email == "" {
+			continue
+		}
+		path := filepath.Join(dir, wkdPath(email))
+		hu := filepath.Dir(path)
+		if err := os.MkdirAll(hu, 0755); err != nil {
+			return paths, err
+		}
+
+		// The advanced method requires a policy file, even if empty
+		policy := filepath.Join(filepath.Dir(hu), "policy")
+		f, err := os.OpenFile(policy, os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return paths, err
+		}
+		f.Close()
+
+		if err := ioutil.WriteFile(path, key, 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	if paths == nil {
+		return nil, errors.New("no user ID has an email address")
+	}
+	return paths, nil
+}